@@ -3,6 +3,7 @@
 // This file:
 //   - Calls cli.Run with command-line arguments
 //   - Exits with code 1 on error
+//   - Exits with the child's code when 'varnish run' reports one
 //
 // Build with version:
 //
@@ -10,6 +11,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -18,6 +20,10 @@ import (
 
 func main() {
 	if err := cli.Run(os.Args[1:]); err != nil {
+		var exitErr *cli.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
@@ -6,5 +6,6 @@ require gopkg.in/yaml.v3 v3.0.1
 
 require (
 	golang.org/x/crypto v0.47.0
+	golang.org/x/term v0.39.0
 	golang.org/x/sys v0.40.0 // indirect
 )
@@ -0,0 +1,327 @@
+// Package agent keeps derived store keys in memory for a session, like
+// ssh-agent does for SSH keys.
+//
+// This package is used by:
+//   - store/agent.go: asks for a key before falling back to the password
+//   - cli/agent.go: "varnish agent", "varnish unlock" and "varnish lock"
+//
+// The agent is a background process listening on ~/.varnish/agent.sock, a
+// Unix socket that only the user can reach (the socket is 0600 inside the
+// 0700 ~/.varnish directory). Each connection carries one JSON request and
+// one JSON response. Keys are identified by the salt and KDF parameters
+// they were derived with, so a key can only ever decrypt the file it was
+// unlocked for.
+//
+// When no key has been asked for within the idle timeout, or on "varnish
+// lock", the agent overwrites its keys and exits.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+)
+
+// Operations.
+const (
+	opAdd    = "add"
+	opGet    = "get"
+	opLock   = "lock"
+	opStatus = "status"
+)
+
+// ErrNotRunning is returned when no agent is listening.
+var ErrNotRunning = errors.New("agent not running")
+
+// callTimeout bounds a request to the agent.
+var callTimeout = 2 * time.Second
+
+type request struct {
+	Op      string        `json:"op"`
+	ID      string        `json:"id,omitempty"`
+	Key     []byte        `json:"key,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+type response struct {
+	Key     []byte    `json:"key,omitempty"`
+	Found   bool      `json:"found,omitempty"`
+	Status  *Status   `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Status describes a running agent.
+type Status struct {
+	PID     int           `json:"pid"`
+	Keys    int           `json:"keys"`
+	Timeout time.Duration `json:"timeout"`
+	Expires time.Time     `json:"expires"` // when the keys are forgotten unless used
+}
+
+// Server holds the keys.
+type Server struct {
+	mu      sync.Mutex
+	keys    map[string][]byte
+	timeout time.Duration
+	expires time.Time
+	timer   *time.Timer
+	stop    context.CancelFunc
+	stderr  io.Writer // where request errors are logged
+}
+
+// Listen creates the agent socket. Fails if an agent is already running;
+// a socket left behind by one that died is replaced.
+func Listen() (net.Listener, error) {
+	if err := config.EnsureVarnishDir(); err != nil {
+		return nil, err
+	}
+	path, err := config.AgentSocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := GetStatus(); err == nil {
+		return nil, fmt.Errorf("an agent is already running on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, config.PermSecure); err != nil {
+		return nil, errors.Join(err, ln.Close())
+	}
+	return ln, nil
+}
+
+// Serve answers requests on ln until ctx is done, the keys go unused for
+// timeout, or a lock request arrives. The keys are wiped and ln closed
+// before it returns. Errors answering a single request are logged to
+// stderr; they don't stop the agent.
+func Serve(ctx context.Context, ln net.Listener, timeout time.Duration, stderr io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &Server{keys: make(map[string][]byte), timeout: timeout, stop: cancel, stderr: stderr}
+	s.mu.Lock()
+	s.timer = time.AfterFunc(timeout, func() {
+		s.wipe()
+		cancel()
+	})
+	s.expires = time.Now().Add(timeout)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		if err := ln.Close(); err != nil {
+			s.logf("close listener: %v", err)
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.wipe()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers one request.
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			s.logf("close connection: %v", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(callTimeout)); err != nil {
+		s.logf("set deadline: %v", err)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	resp := s.answer(req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.logf("answer %s request: %v", req.Op, err)
+	}
+}
+
+// logf logs an error answering a request.
+func (s *Server) logf(format string, args ...any) {
+	fmt.Fprintf(s.stderr, "varnish agent: "+format+"\n", args...)
+}
+
+func (s *Server) answer(req request) response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Op {
+	case opAdd:
+		if req.ID == "" || len(req.Key) == 0 {
+			return response{Error: "add needs an id and a key"}
+		}
+		if req.Timeout > 0 {
+			s.timeout = req.Timeout
+		}
+		s.keys[req.ID] = req.Key
+		s.touch()
+		return response{Expires: s.expires}
+	case opGet:
+		key, ok := s.keys[req.ID]
+		if !ok {
+			return response{}
+		}
+		s.touch()
+		return response{Key: key, Found: true}
+	case opLock:
+		s.clearKeys()
+		s.stop()
+		return response{}
+	case opStatus:
+		return response{Status: &Status{
+			PID:     os.Getpid(),
+			Keys:    len(s.keys),
+			Timeout: s.timeout,
+			Expires: s.expires,
+		}}
+	default:
+		return response{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// touch restarts the idle timeout. The caller holds s.mu.
+func (s *Server) touch() {
+	s.timer.Reset(s.timeout)
+	s.expires = time.Now().Add(s.timeout)
+}
+
+// wipe overwrites and forgets the keys.
+func (s *Server) wipe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer.Stop()
+	s.clearKeys()
+}
+
+// clearKeys overwrites and forgets the keys. The caller holds s.mu.
+func (s *Server) clearKeys() {
+	for id, key := range s.keys {
+		clear(key)
+		delete(s.keys, id)
+	}
+}
+
+// call sends one request to the agent.
+func call(req request) (_ *response, err error) {
+	path, err := config.AgentSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, callTimeout)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("agent: %w", closeErr)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(callTimeout)); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("agent: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// Get returns the key with the given ID, if an agent holds it.
+func Get(id string) ([]byte, bool) {
+	resp, err := call(request{Op: opGet, ID: id})
+	if err != nil || !resp.Found {
+		return nil, false
+	}
+	return resp.Key, true
+}
+
+// Add gives the agent a key. A positive timeout replaces the agent's idle
+// timeout. Returns when the keys will be forgotten unless used.
+func Add(id string, key []byte, timeout time.Duration) (time.Time, error) {
+	resp, err := call(request{Op: opAdd, ID: id, Key: key, Timeout: timeout})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return resp.Expires, nil
+}
+
+// Lock tells the agent to wipe its keys and exit. Returns ErrNotRunning if
+// there is no agent.
+func Lock() error {
+	_, err := call(request{Op: opLock})
+	return err
+}
+
+// GetStatus describes the running agent, or returns ErrNotRunning.
+func GetStatus() (*Status, error) {
+	resp, err := call(request{Op: opStatus})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == nil {
+		return nil, errors.New("agent: no status in response")
+	}
+	return resp.Status, nil
+}
+
+// Start runs "<executable> agent --timeout <timeout>" in the background,
+// detached from the terminal, and waits for it to answer.
+func Start(executable string, timeout time.Duration) error {
+	cmd := exec.Command(executable, "agent", "--timeout", timeout.String())
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start agent: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := GetStatus(); err == nil {
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("agent exited at startup: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return errors.New("agent did not start in time")
+}
@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+)
+
+// shortHome points HOME at a new temp directory. Unix socket paths are
+// limited to about 100 bytes, so it avoids the long names of t.TempDir.
+func shortHome(t *testing.T) {
+	t.Helper()
+	home, err := os.MkdirTemp("", "va")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	t.Setenv("HOME", home)
+}
+
+// startAgent serves an agent in the background with the given idle
+// timeout. The returned channel receives Serve's result.
+func startAgent(t *testing.T, timeout time.Duration) <-chan error {
+	t.Helper()
+	shortHome(t)
+
+	ln, err := Listen()
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, ln, timeout, io.Discard)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return done
+}
+
+func TestNotRunning(t *testing.T) {
+	shortHome(t)
+
+	if _, err := GetStatus(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("GetStatus() error = %v, want ErrNotRunning", err)
+	}
+	if err := Lock(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Lock() error = %v, want ErrNotRunning", err)
+	}
+	if _, ok := Get("id"); ok {
+		t.Error("Get() without an agent should report false")
+	}
+}
+
+func TestAddGetStatus(t *testing.T) {
+	startAgent(t, time.Minute)
+
+	if _, ok := Get("store"); ok {
+		t.Error("Get() before Add should report false")
+	}
+
+	expires, err := Add("store", []byte("0123456789abcdef"), 0)
+	if err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if until := time.Until(expires); until <= 0 || until > time.Minute {
+		t.Errorf("expires in %s, want within a minute", until)
+	}
+
+	key, ok := Get("store")
+	if !ok || string(key) != "0123456789abcdef" {
+		t.Errorf("Get() = %q, %v", key, ok)
+	}
+
+	st, err := GetStatus()
+	if err != nil {
+		t.Fatalf("GetStatus() error: %v", err)
+	}
+	if st.PID != os.Getpid() || st.Keys != 1 || st.Timeout != time.Minute {
+		t.Errorf("status = %+v", st)
+	}
+
+	// A timeout given with a key replaces the agent's
+	if _, err := Add("history", []byte("key"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := GetStatus(); st.Keys != 2 || st.Timeout != time.Hour {
+		t.Errorf("status after second Add = %+v", st)
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	startAgent(t, time.Minute)
+
+	if _, err := Add("", []byte("key"), 0); err == nil {
+		t.Error("Add() without an id should fail")
+	}
+	if _, err := Add("store", nil, 0); err == nil {
+		t.Error("Add() without a key should fail")
+	}
+}
+
+func TestLock(t *testing.T) {
+	done := startAgent(t, time.Minute)
+
+	if _, err := Add("store", []byte("key"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := Lock(); err != nil {
+		t.Fatalf("Lock() error: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop after Lock")
+	}
+	if _, ok := Get("store"); ok {
+		t.Error("key should be gone after Lock")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	done := startAgent(t, 100*time.Millisecond)
+
+	if _, err := Add("store", []byte("key"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop after the idle timeout")
+	}
+	if _, err := GetStatus(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("GetStatus() after timeout error = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestListenTwice(t *testing.T) {
+	startAgent(t, time.Minute)
+
+	if _, err := Listen(); err == nil {
+		t.Error("Listen() with an agent running should fail")
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	shortHome(t)
+	if err := config.EnsureVarnishDir(); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := config.AgentSocketPath()
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := Listen()
+	if err != nil {
+		t.Fatalf("Listen() over a stale socket error: %v", err)
+	}
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != config.PermSecure {
+		t.Errorf("socket permissions = %o, want %o", perm, config.PermSecure)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package agent
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Process creation flags, from the Windows API.
+const (
+	detachedProcess       = 0x00000008
+	createNewProcessGroup = 0x00000200
+)
+
+// detach starts cmd without a console, so it outlives the terminal.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: detachedProcess | createNewProcessGroup}
+}
@@ -0,0 +1,276 @@
+// agent.go implements "varnish agent", "varnish unlock" and "varnish lock".
+//
+// This file is used by:
+//   - cli/root.go: dispatches "agent", "unlock" and "lock" commands here
+//
+// Deriving the key of an encrypted store takes a noticeable moment (Argon2id
+// with 64 MiB by default), which adds up when scripts call varnish many
+// times. "varnish unlock" asks for the password once, derives the keys of
+// the store and its history, and hands them to a background agent (see
+// agent/agent.go). Later commands get the keys from the agent instead:
+//
+//	varnish unlock                 # Start the agent if needed, add the keys
+//	varnish unlock --timeout 1h    # Forget the keys after an hour unused
+//	varnish unlock -p myapp        # Add the key of a project encrypted separately
+//	varnish agent status           # Is an agent running, until when
+//	varnish lock                   # Wipe the keys now
+//	varnish agent                  # Run the agent in the foreground
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dk/varnish/internal/agent"
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+func runAgent(args []string, stdout, stderr io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "status":
+			return runAgentStatus(args[1:], stdout, stderr)
+		case "help", "-h", "--help":
+			printAgentUsage(stdout)
+			return nil
+		}
+	}
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeoutFlag := fs.Duration("timeout", 0, "forget keys unused for this long (default: config.yaml, else 15m)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		printAgentUsage(stderr)
+		return fmt.Errorf("unknown agent subcommand: %s", fs.Arg(0))
+	}
+
+	timeout, err := agentTimeout(*timeoutFlag)
+	if err != nil {
+		return err
+	}
+
+	ln, err := agent.Listen()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(stderr, "varnish agent listening on %s (pid %d, idle timeout %s)\n", ln.Addr(), os.Getpid(), timeout)
+	return agent.Serve(ctx, ln, timeout, stderr)
+}
+
+func printAgentUsage(w io.Writer) {
+	fmt.Fprintln(w, `Usage: varnish agent [--timeout <duration>]
+       varnish agent status
+
+Runs the unlock agent in the foreground. The agent keeps the derived keys
+of an encrypted store in memory, so commands don't derive them again each
+time. It is normally started by 'varnish unlock' and stopped by 'varnish
+lock', or once its keys go unused for the idle timeout.
+
+Flags:
+  --timeout    Forget keys unused for this long (default: agent.timeout in
+               config.yaml, else 15m)
+
+Subcommands:
+  status       Show whether an agent is running and when it forgets its keys`)
+}
+
+func runAgentStatus(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("agent status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	st, err := agent.GetStatus()
+	if errors.Is(err, agent.ErrNotRunning) {
+		fmt.Fprintln(stdout, "agent not running (store locked)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "agent running (pid %d), %d key(s)\n", st.PID, st.Keys)
+	if st.Keys > 0 {
+		fmt.Fprintf(stdout, "keys forgotten at %s unless used (idle timeout %s)\n",
+			st.Expires.Local().Format("15:04:05"), st.Timeout)
+	}
+	return nil
+}
+
+func runUnlock(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeoutFlag := fs.Duration("timeout", 0, "forget keys unused for this long (default: config.yaml, else 15m)")
+	projectFlag := fs.String("project", "", "unlock this encrypted project instead of the store")
+	fs.StringVar(projectFlag, "p", "", "unlock this encrypted project instead of the store (shorthand)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	timeout, err := agentTimeout(*timeoutFlag)
+	if err != nil {
+		return err
+	}
+
+	what := "store"
+	var keys map[string][]byte
+	if *projectFlag != "" {
+		name, err := resolveProjectRef(*projectFlag)
+		if err != nil {
+			return err
+		}
+		what = "project " + name
+		if keys, err = projectKeys(name); err != nil {
+			return err
+		}
+	} else if keys, err = storeKeys(); err != nil {
+		return err
+	}
+
+	// Start an agent unless one is running
+	if _, err := agent.GetStatus(); errors.Is(err, agent.ErrNotRunning) {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("find varnish executable: %w", err)
+		}
+		if err := agent.Start(exe, timeout); err != nil {
+			return err
+		}
+	}
+
+	var expires time.Time
+	for id, key := range keys {
+		if expires, err = agent.Add(id, key, timeout); err != nil {
+			return err
+		}
+		clear(key)
+	}
+
+	fmt.Fprintf(stdout, "%s unlocked until %s unless used (idle timeout %s)\n",
+		what, expires.Local().Format("15:04:05"), timeout)
+	fmt.Fprintln(stdout, "lock it again with: varnish lock")
+	return nil
+}
+
+// storeKeys derives and checks the keys of the store and its history.
+func storeKeys() (map[string][]byte, error) {
+	storePath, err := config.StorePath()
+	if err != nil {
+		return nil, err
+	}
+	historyPath, err := config.HistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(storePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read store: %w", err)
+	}
+	if !crypto.IsEncrypted(data) {
+		return nil, fmt.Errorf("store is not encrypted (nothing to unlock; encrypted projects unlock with --project)")
+	}
+	if h, err := crypto.ParseHeader(data); err == nil && h.Version == crypto.VersionRecipients {
+		return nil, fmt.Errorf("store is encrypted to recipients and opens with your identity (nothing to unlock)")
+	}
+
+	password, err := crypto.GetPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]byte)
+	for _, path := range []string{storePath, historyPath} {
+		data, err := os.ReadFile(path)
+		if err != nil || !crypto.IsEncrypted(data) {
+			continue
+		}
+		id, key, err := crypto.UnlockKey(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("unlock %s: %w", filepath.Base(path), err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// projectKeys derives and checks the key of a project encrypted separately.
+func projectKeys(name string) (map[string][]byte, error) {
+	data, err := os.ReadFile(config.SegmentPathFor(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("project %s is not encrypted separately (nothing to unlock)", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read project %s: %w", name, err)
+	}
+	password, err := crypto.ProjectPassword(name)
+	if err != nil {
+		return nil, err
+	}
+	id, key, err := crypto.UnlockKey(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("unlock project %s: %w", name, err)
+	}
+	return map[string][]byte{id: key}, nil
+}
+
+func runLock(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	err := agent.Lock()
+	if errors.Is(err, agent.ErrNotRunning) {
+		fmt.Fprintln(stdout, "no agent running (store already locked)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "store locked (agent keys wiped)")
+	return nil
+}
+
+// agentTimeout returns the --timeout flag if set, else the idle timeout
+// from config.yaml.
+func agentTimeout(flagValue time.Duration) (time.Duration, error) {
+	if flagValue < 0 {
+		return 0, fmt.Errorf("--timeout must be positive")
+	}
+	if flagValue > 0 {
+		return flagValue, nil
+	}
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return 0, err
+	}
+	return settings.AgentTimeout()
+}
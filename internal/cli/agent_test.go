@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dk/varnish/internal/agent"
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/store"
+)
+
+// serveAgent runs an unlock agent in the test process, since unlock can't
+// start the test binary as one.
+func serveAgent(t *testing.T) {
+	t.Helper()
+	ln, err := agent.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		if err := agent.Serve(ctx, ln, time.Minute, io.Discard); err != nil {
+			t.Errorf("agent.Serve() error: %v", err)
+		}
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRunUnlockAndLock(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedStoreCLI(t, "password")
+	serveAgent(t)
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"unlock", "--timeout", "1h"}, &stdout, &stderr); err != nil {
+		t.Fatalf("unlock error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store unlocked until") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	// The store is readable and writable without a password now
+	unsetenv(t, crypto.PasswordEnvVar)
+	st, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() while unlocked error: %v", err)
+	}
+	if v, _ := st.Get("app.name"); v != "demo" {
+		t.Errorf("app.name = %q, want demo", v)
+	}
+	st.Set("app.name", "changed")
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save() while unlocked error: %v", err)
+	}
+
+	stdout.Reset()
+	if err := run([]string{"agent", "status"}, &stdout, &stderr); err != nil {
+		t.Fatalf("agent status error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "agent running") || !strings.Contains(stdout.String(), "idle timeout 1h0m0s") {
+		t.Errorf("unexpected status: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"lock"}, &stdout, &stderr); err != nil {
+		t.Fatalf("lock error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store locked") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+	if _, err := store.Load(); err == nil {
+		t.Error("Load() after lock should need the password")
+	}
+
+	// The saved store still opens with the password
+	t.Setenv(crypto.PasswordEnvVar, "password")
+	st, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load() with password error: %v", err)
+	}
+	if v, _ := st.Get("app.name"); v != "changed" {
+		t.Errorf("app.name = %q, want changed", v)
+	}
+}
+
+func TestRunUnlockWrongPassword(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedStoreCLI(t, "password")
+	serveAgent(t)
+
+	t.Setenv(crypto.PasswordEnvVar, "wrong")
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"unlock"}, &stdout, &stderr); err == nil {
+		t.Fatal("unlock with the wrong password should fail")
+	}
+	if st, err := agent.GetStatus(); err != nil || st.Keys != 0 {
+		t.Errorf("agent should hold no keys, got %+v, %v", st, err)
+	}
+}
+
+func TestRunUnlockNotEncrypted(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"unlock"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Errorf("expected not-encrypted error, got %v", err)
+	}
+}
+
+func TestRunLockNoAgent(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"lock"}, &stdout, &stderr); err != nil {
+		t.Fatalf("lock error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "no agent running") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"agent", "status"}, &stdout, &stderr); err != nil {
+		t.Fatalf("agent status error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "not running") {
+		t.Errorf("unexpected status: %s", stdout.String())
+	}
+}
+
+func TestRunAgentInvalidTimeout(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"agent", "--timeout", "-1m"}, &stdout, &stderr); err == nil {
+		t.Error("agent with a negative timeout should fail")
+	}
+	if err := run([]string{"agent", "bogus"}, &stdout, &stderr); err == nil {
+		t.Error("agent with an unknown subcommand should fail")
+	}
+}
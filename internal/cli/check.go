@@ -7,27 +7,32 @@
 //   - .varnish.yaml syntax is valid
 //   - All required variables are present in the store
 //   - No circular dependencies in computed values
+//   - Values satisfy the schema rules
+//   - Outputs have a path, a known format and a known profile
 //
 // Usage:
 //
 //	varnish check           # Validate current project
 //	varnish check --strict  # Fail if any variables are missing
+//	varnish check --profile test  # Validate with a profile applied
 package cli
 
 import (
 	"flag"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/dk/varnish/internal/format"
 	"github.com/dk/varnish/internal/project"
 	"github.com/dk/varnish/internal/resolver"
-	"github.com/dk/varnish/internal/store"
 )
 
 func runCheck(args []string, stdout, stderr io.Writer) error {
 	fs := flag.NewFlagSet("check", flag.ContinueOnError)
 	fs.SetOutput(stderr)
 	strict := fs.Bool("strict", false, "fail if any variables are missing")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
@@ -50,15 +55,29 @@ func runCheck(args []string, stdout, stderr io.Writer) error {
 	}
 	fmt.Fprintf(stdout, "✓ .varnish.yaml is valid (project: %s)\n", cfg.Project)
 
+	// Apply the active profile, if any
+	cfg, err = applyProfile(cfg, *profile)
+	if err != nil {
+		return err
+	}
+	if cfg.Profile != "" {
+		fmt.Fprintf(stdout, "✓ profile '%s' applied\n", cfg.Profile)
+	}
+
+	// Parent projects were loaded (and checked for cycles) with the config
+	if len(cfg.Extends) > 0 {
+		fmt.Fprintf(stdout, "✓ extends: %s\n", strings.Join(cfg.Extends, ", "))
+	}
+
 	// Check 2: Validate include patterns
-	if len(cfg.Include) == 0 {
+	if len(cfg.Include) == 0 && len(cfg.Extends) == 0 {
 		warnings = append(warnings, "no include patterns defined - no variables will be resolved")
 	} else {
 		fmt.Fprintf(stdout, "✓ %d include pattern(s) defined\n", len(cfg.Include))
 	}
 
 	// Check 3: Load store
-	st, err := store.Load()
+	st, err := loadStoreFor(cfg)
 	if err != nil {
 		return fmt.Errorf("cannot load store: %w", err)
 	}
@@ -81,22 +100,53 @@ func runCheck(args []string, stdout, stderr io.Writer) error {
 		fmt.Fprintln(stdout, "✓ all variables are present")
 	}
 
-	// Check 5: Validate computed values can be interpolated
-	if len(cfg.Computed) > 0 {
-		vars := res.Resolve()
-		// Build a map for interpolation check
-		resolved := make(map[string]string)
+	// Check 5: Resolve everything, which orders computed values by their
+	// dependencies and fails on cycles
+	vars, err := res.Resolve()
+	if err != nil {
+		errors = append(errors, err.Error())
+	} else if computed := res.Computed(); len(computed) > 0 {
+		// The resolver records the ${key} references it could not satisfy
 		for _, v := range vars {
-			resolved[v.Key] = v.Value
+			if v.Source == "computed" && len(v.Provenance.Unresolved) > 0 {
+				warnings = append(warnings, fmt.Sprintf("computed %s has unresolved variables: %s",
+					v.EnvName, strings.Join(v.Provenance.Unresolved, ", ")))
+			}
 		}
+		fmt.Fprintf(stdout, "✓ %d computed value(s) checked, no circular dependencies\n", len(computed))
+	}
 
-		for envName, template := range cfg.Computed {
-			// Check for unresolved ${...} patterns
-			if containsUnresolvedVar(template, resolved) {
-				warnings = append(warnings, fmt.Sprintf("computed %s may have unresolved variables", envName))
+	// Check 6: Validate the schema rules (even if resolving failed), then
+	// the values against them
+	invalid := res.CheckSchema()
+	for _, v := range invalid {
+		errors = append(errors, fmt.Sprintf("schema: %s", v))
+	}
+	if err == nil && len(invalid) == 0 {
+		if schema := res.Schema(); len(schema) > 0 {
+			violations := res.Validate(vars)
+			for _, v := range violations {
+				errors = append(errors, fmt.Sprintf("schema: %s", v))
 			}
+			if len(violations) == 0 {
+				fmt.Fprintf(stdout, "✓ %d schema rule(s) satisfied\n", len(schema))
+			}
+		}
+	}
+
+	// Check 7: Outputs written by a plain "varnish env"
+	if len(cfg.Outputs) > 0 {
+		valid := 0
+		for _, o := range cfg.Outputs {
+			if problem := checkOutput(cfg, o); problem != "" {
+				errors = append(errors, problem)
+				continue
+			}
+			valid++
+		}
+		if valid == len(cfg.Outputs) {
+			fmt.Fprintf(stdout, "✓ %d output(s) defined\n", valid)
 		}
-		fmt.Fprintf(stdout, "✓ %d computed value(s) checked\n", len(cfg.Computed))
 	}
 
 	// Print warnings
@@ -120,25 +170,18 @@ func runCheck(args []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
-// containsUnresolvedVar checks if template has ${var} patterns that aren't in resolved.
-func containsUnresolvedVar(template string, resolved map[string]string) bool {
-	// Simple check: look for ${...} patterns
-	// This is a basic implementation - could be more sophisticated
-	inVar := false
-	varStart := 0
-
-	for i := 0; i < len(template)-1; i++ {
-		if template[i] == '$' && template[i+1] == '{' {
-			inVar = true
-			varStart = i + 2
-		} else if inVar && template[i] == '}' {
-			varName := template[varStart:i]
-			if _, ok := resolved[varName]; !ok {
-				return true
-			}
-			inVar = false
+// checkOutput returns what is wrong with an output, or "".
+func checkOutput(cfg *project.Config, o *project.Output) string {
+	if err := o.Check(); err != nil {
+		return err.Error()
+	}
+	if o.Format != "" {
+		if _, err := format.Lookup(o.Format); err != nil {
+			return fmt.Sprintf("output %s: %v", o.Path, err)
 		}
 	}
-
-	return false
+	if o.Profile != "" && !cfg.HasProfile(o.Profile) {
+		return fmt.Sprintf("output %s: unknown profile %q", o.Path, o.Profile)
+	}
+	return ""
 }
@@ -295,6 +295,86 @@ func TestRunCheckComputedValues(t *testing.T) {
 	}
 }
 
+func TestRunCheckComputedCycle(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, err := os.MkdirTemp("", "varnish-project-*")
+	if err != nil {
+		t.Fatalf("failed to create project dir: %v", err)
+	}
+	defer os.RemoveAll(projectDir)
+
+	reg, _ := registry.Load()
+	reg.Register(projectDir, "checkcycle")
+	if err := reg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	cfg := project.New()
+	cfg.Project = "checkcycle"
+	cfg.Include = []string{"db.*"}
+	cfg.Computed = map[string]string{
+		"A_URL": "${B_URL}/a",
+		"B_URL": "${a.url}/b",
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = runCheck([]string{}, &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected check to fail on a computed cycle")
+	}
+	if !strings.Contains(stderr.String(), "circular dependency in computed values: A_URL -> B_URL -> A_URL") {
+		t.Errorf("expected cycle in errors, got: %s", stderr.String())
+	}
+}
+
+func TestRunCheckOutputs(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForCheck(t, "checkoutputs")
+	defer cleanupProject()
+
+	cfg, err := project.LoadByName("checkoutputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Outputs = []*project.Output{
+		{Path: ".env"},
+		{Path: "config.xml", Format: "xml"},
+		{Path: ".env.test", Profile: "test"},
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runCheck([]string{}, &stdout, &stderr); err == nil {
+		t.Fatal("expected check to fail on bad outputs")
+	}
+	for _, want := range []string{`output config.xml: unknown format "xml"`, `output .env.test: unknown profile "test"`} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected %q in errors, got: %s", want, stderr.String())
+		}
+	}
+}
+
 func TestRunCheckHelp(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	err := runCheck([]string{"-h"}, &stdout, &stderr)
@@ -304,47 +384,100 @@ func TestRunCheckHelp(t *testing.T) {
 	// Help should be shown without error
 }
 
-func TestContainsUnresolvedVar(t *testing.T) {
-	tests := []struct {
-		template string
-		resolved map[string]string
-		expected bool
-	}{
-		{
-			"postgres://${db.user}@${db.host}/${db.name}",
-			map[string]string{"db.user": "u", "db.host": "h", "db.name": "n"},
-			false,
-		},
-		{
-			"postgres://${db.user}@${db.host}/${db.name}",
-			map[string]string{"db.host": "h", "db.name": "n"},
-			true, // missing db.user
-		},
-		{
-			"simple string no vars",
-			map[string]string{},
-			false,
-		},
-		{
-			"value is ${missing} here",
-			map[string]string{},
-			true,
-		},
-		{
-			"prefix${found}suffix",
-			map[string]string{"found": "value"},
-			false,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.template, func(t *testing.T) {
-			result := containsUnresolvedVar(tt.template, tt.resolved)
-			if result != tt.expected {
-				t.Errorf("containsUnresolvedVar(%q, %v) = %v, want %v",
-					tt.template, tt.resolved, result, tt.expected)
-			}
-		})
+func TestRunCheckComputedUnresolved(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForCheck(t, "checkchild")
+	defer cleanupProject()
+
+	base := project.New()
+	base.Project = "checkbase"
+	base.Computed = map[string]string{"GREETING": "hello"}
+	if err := base.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	child, _ := project.LoadByName("checkchild")
+	child.Extends = []string{"checkbase"}
+	child.Include = []string{"db.*"}
+	child.Computed = map[string]string{
+		"DATABASE_URL": "postgres://${db.user}@${db.host}/${NAME_UPPER}",
+		"NAME_UPPER":   "${db.name | upper}",
+	}
+	if err := child.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	store, _ := store.Load()
+	store.Set("checkchild.db.host", "localhost")
+	store.Set("checkchild.db.name", "mydb")
+	if err := store.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runCheck([]string{}, &stdout, &stderr); err != nil {
+		t.Fatalf("runCheck error: %v", err)
+	}
+
+	output := stdout.String()
+	if !strings.Contains(output, "✓ 3 computed value(s) checked") {
+		t.Errorf("expected inherited computed values to be counted, got: %s", output)
+	}
+	if !strings.Contains(output, "computed DATABASE_URL has unresolved variables: db.user") {
+		t.Errorf("expected db.user to be reported, got: %s", output)
+	}
+	if strings.Contains(output, "computed NAME_UPPER") || strings.Contains(output, "db.user, ") {
+		t.Errorf("resolved references should not be reported, got: %s", output)
+	}
+}
+
+func TestRunCheckInvalidSchema(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForCheck(t, "checkschema")
+	defer cleanupProject()
+
+	base := project.New()
+	base.Project = "checkschemabase"
+	base.Schema = map[string]*project.Rule{"api.key": {Regex: "sk_("}}
+	if err := base.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	cfg, _ := project.LoadByName("checkschema")
+	cfg.Extends = []string{"checkschemabase"}
+	cfg.Schema = map[string]*project.Rule{"LOG_LEVEL": {Type: "level"}}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// Neither key has a value; the rules are reported anyway
+	var stdout, stderr bytes.Buffer
+	if err := runCheck([]string{}, &stdout, &stderr); err == nil {
+		t.Fatal("expected check to fail on invalid schema rules")
+	}
+
+	errOut := stderr.String()
+	if !strings.Contains(errOut, `schema: LOG_LEVEL: unknown schema type "level"`) {
+		t.Errorf("expected unknown type error, got: %s", errOut)
+	}
+	if !strings.Contains(errOut, `schema: api.key: invalid regex "sk_("`) {
+		t.Errorf("expected inherited invalid regex error, got: %s", errOut)
 	}
 }
 
@@ -71,9 +71,20 @@ _varnish_completions() {
     local cur prev words cword
     _init_completion || return
 
-    local commands="init store env list check project completion version help"
-    local store_commands="set get list ls delete rm import encrypt"
+    local commands="init store env compose render run export hook list explain check project snapshot restore unlock lock agent key completion version help"
+    local store_commands="set get list ls delete rm import encrypt rekey decrypt recipients history rollback"
     local project_commands="name list delete"
+    local snapshot_commands="list ls create diff"
+
+    # Global password flags, accepted with any command
+    if [[ "${prev}" == --password-file ]]; then
+        _filedir
+        return
+    fi
+    if [[ "${cur}" == --password-* ]]; then
+        COMPREPLY=($(compgen -W "--password-file --password-fd" -- "${cur}"))
+        return
+    fi
 
     case "${cword}" in
         1)
@@ -87,20 +98,50 @@ _varnish_completions() {
                 project)
                     COMPREPLY=($(compgen -W "${project_commands}" -- "${cur}"))
                     ;;
+                snapshot)
+                    COMPREPLY=($(compgen -W "${snapshot_commands}" -- "${cur}"))
+                    ;;
+                unlock)
+                    COMPREPLY=($(compgen -W "--timeout --project -p" -- "${cur}"))
+                    ;;
+                agent)
+                    COMPREPLY=($(compgen -W "status --timeout" -- "${cur}"))
+                    ;;
+                key)
+                    COMPREPLY=($(compgen -W "generate" -- "${cur}"))
+                    ;;
                 completion)
                     COMPREPLY=($(compgen -W "bash zsh fish" -- "${cur}"))
                     ;;
                 init)
                     COMPREPLY=($(compgen -W "--project -p --from -f --no-import --sync -s --force --encrypt --password" -- "${cur}"))
                     ;;
+                compose)
+                    COMPREPLY=($(compgen -W "--file -f --output --map --dry-run --reveal --force" -- "${cur}"))
+                    ;;
+                render)
+                    COMPREPLY=($(compgen -W "--dry-run --reveal --force --profile" -- "${cur}"))
+                    ;;
                 env)
-                    COMPREPLY=($(compgen -W "--dry-run --force --output" -- "${cur}"))
+                    COMPREPLY=($(compgen -W "--dry-run --force --output --format --name --namespace --label --profile --reveal" -- "${cur}"))
+                    ;;
+                run)
+                    COMPREPLY=($(compgen -W "--clean --profile" -- "${cur}"))
+                    ;;
+                export)
+                    COMPREPLY=($(compgen -W "--shell --hook --profile" -- "${cur}"))
+                    ;;
+                hook)
+                    COMPREPLY=($(compgen -W "bash zsh fish powershell" -- "${cur}"))
                     ;;
                 list)
-                    COMPREPLY=($(compgen -W "--missing --json" -- "${cur}"))
+                    COMPREPLY=($(compgen -W "--missing --json --profile --reveal" -- "${cur}"))
+                    ;;
+                explain)
+                    COMPREPLY=($(compgen -W "--json --profile --reveal" -- "${cur}"))
                     ;;
                 check)
-                    COMPREPLY=($(compgen -W "--strict" -- "${cur}"))
+                    COMPREPLY=($(compgen -W "--strict --profile" -- "${cur}"))
                     ;;
                 *)
                     ;;
@@ -110,17 +151,42 @@ _varnish_completions() {
             case "${words[1]}" in
                 store)
                     case "${prev}" in
-                        set|get|delete|rm)
-                            COMPREPLY=($(compgen -W "--project -p --global -g --stdin" -- "${cur}"))
+                        set)
+                            COMPREPLY=($(compgen -W "--project -p --global -g --profile --stdin --desc --tag --secret" -- "${cur}"))
+                            ;;
+                        get|delete|rm)
+                            COMPREPLY=($(compgen -W "--project -p --global -g --profile --stdin" -- "${cur}"))
                             ;;
                         list|ls)
-                            COMPREPLY=($(compgen -W "--pattern --project -p --global -g --json" -- "${cur}"))
+                            COMPREPLY=($(compgen -W "--pattern --project -p --global -g --profile --json --tag --reveal" -- "${cur}"))
                             ;;
                         import)
                             COMPREPLY=($(compgen -f -- "${cur}"))
                             ;;
                         encrypt)
-                            COMPREPLY=($(compgen -W "--password" -- "${cur}"))
+                            COMPREPLY=($(compgen -W "--password --kdf-memory --kdf-time --project -p" -- "${cur}"))
+                            ;;
+                        rekey)
+                            COMPREPLY=($(compgen -W "--new-password-file --project -p" -- "${cur}"))
+                            ;;
+                        decrypt)
+                            COMPREPLY=($(compgen -W "--yes -y --project -p" -- "${cur}"))
+                            ;;
+                        recipients)
+                            COMPREPLY=($(compgen -W "list ls add remove rm" -- "${cur}"))
+                            ;;
+                        history)
+                            COMPREPLY=($(compgen -W "--project -p --global -g --profile --json --reveal" -- "${cur}"))
+                            ;;
+                        rollback)
+                            COMPREPLY=($(compgen -W "--project -p --global -g --profile --to" -- "${cur}"))
+                            ;;
+                    esac
+                    ;;
+                key)
+                    case "${prev}" in
+                        generate)
+                            COMPREPLY=($(compgen -W "--output --force" -- "${cur}"))
                             ;;
                     esac
                     ;;
@@ -131,6 +197,33 @@ _varnish_completions() {
                             ;;
                     esac
                     ;;
+                snapshot)
+                    case "${prev}" in
+                        list|ls)
+                            COMPREPLY=($(compgen -W "--json" -- "${cur}"))
+                            ;;
+                        create)
+                            COMPREPLY=($(compgen -W "--reason" -- "${cur}"))
+                            ;;
+                        diff)
+                            COMPREPLY=($(compgen -W "--reveal latest" -- "${cur}"))
+                            ;;
+                    esac
+                    ;;
+                env)
+                    case "${prev}" in
+                        --format)
+                            COMPREPLY=($(compgen -W "dotenv dotenv-posix dotenv-compose docker json yaml toml properties k8s-secret k8s-configmap kustomize" -- "${cur}"))
+                            ;;
+                    esac
+                    ;;
+                export)
+                    case "${prev}" in
+                        --shell)
+                            COMPREPLY=($(compgen -W "bash zsh fish powershell nu" -- "${cur}"))
+                            ;;
+                    esac
+                    ;;
             esac
             ;;
     esac
@@ -142,15 +235,27 @@ complete -F _varnish_completions varnish
 const zshCompletion = `#compdef varnish
 
 _varnish() {
-    local -a commands store_commands project_commands
+    local -a commands store_commands project_commands snapshot_commands
 
     commands=(
         'init:Initialize project with .varnish.yaml'
         'store:Manage central variable store'
         'env:Generate .env file'
+        'compose:Write a docker-compose override'
+        'render:Render templates into config files'
+        'run:Run a command with resolved variables'
+        'export:Print shell export statements'
+        'hook:Print shell hook for per-directory loading'
         'list:Show resolved variables'
+        'explain:Explain where a variable value came from'
         'check:Validate config and check for missing variables'
         'project:Show/manage project info'
+        'snapshot:List, create and compare snapshots'
+        'restore:Restore a snapshot'
+        'unlock:Keep the store keys in an agent for this session'
+        'lock:Wipe the agent keys'
+        'agent:Run the unlock agent or show its status'
+        'key:Generate an identity for shared stores'
         'completion:Generate shell completion'
         'version:Show version'
         'help:Show help'
@@ -165,6 +270,18 @@ _varnish() {
         'rm:Remove a variable (alias)'
         'import:Import from .env file'
         'encrypt:Enable store encryption'
+        'rekey:Change the store password'
+        'decrypt:Turn store encryption off'
+        'recipients:Manage public keys the store is encrypted to'
+        'history:Show change history'
+        'rollback:Undo changes to a variable'
+    )
+
+    snapshot_commands=(
+        'list:List snapshots'
+        'ls:List snapshots (alias)'
+        'create:Take a snapshot'
+        'diff:Show changes since a snapshot'
     )
 
     project_commands=(
@@ -173,18 +290,41 @@ _varnish() {
         'delete:Delete project variables'
     )
 
+    # Global password flags, accepted with any command
+    if [[ "${words[CURRENT-1]}" == --password-file ]]; then
+        _files
+        return
+    fi
+    if [[ "${PREFIX}" == --password-* ]]; then
+        compadd -- --password-file --password-fd
+        return
+    fi
+
     case "${words[2]}" in
         store)
             if (( CURRENT == 3 )); then
                 _describe -t commands 'store commands' store_commands
             else
                 case "${words[3]}" in
-                    set|get|delete|rm)
+                    set)
                         _arguments \
                             '-p[Project namespace]:project:' \
                             '--project[Project namespace]:project:' \
                             '-g[Bypass project auto-detection]' \
                             '--global[Bypass project auto-detection]' \
+                            '--profile[Profile namespace]:profile:' \
+                            '--stdin[Read value from stdin]' \
+                            '--desc[Description]:description:' \
+                            '*--tag[Tag]:tag:' \
+                            '--secret[Mark as secret]'
+                        ;;
+                    get|delete|rm)
+                        _arguments \
+                            '-p[Project namespace]:project:' \
+                            '--project[Project namespace]:project:' \
+                            '-g[Bypass project auto-detection]' \
+                            '--global[Bypass project auto-detection]' \
+                            '--profile[Profile namespace]:profile:' \
                             '--stdin[Read value from stdin]'
                         ;;
                     list|ls)
@@ -194,7 +334,29 @@ _varnish() {
                             '--project[Project namespace]:project:' \
                             '-g[Show all variables]' \
                             '--global[Show all variables]' \
-                            '--json[Output as JSON]'
+                            '--profile[Profile namespace]:profile:' \
+                            '--json[Output as JSON]' \
+                            '--tag[Only variables with a tag]:tag:' \
+                            '--reveal[Show secret values]'
+                        ;;
+                    history)
+                        _arguments \
+                            '-p[Project namespace]:project:' \
+                            '--project[Project namespace]:project:' \
+                            '-g[Bypass project auto-detection]' \
+                            '--global[Bypass project auto-detection]' \
+                            '--profile[Profile namespace]:profile:' \
+                            '--json[Output as JSON]' \
+                            '--reveal[Show secret values]'
+                        ;;
+                    rollback)
+                        _arguments \
+                            '-p[Project namespace]:project:' \
+                            '--project[Project namespace]:project:' \
+                            '-g[Bypass project auto-detection]' \
+                            '--global[Bypass project auto-detection]' \
+                            '--profile[Profile namespace]:profile:' \
+                            '--to[Change number or time]:change:'
                         ;;
                     import)
                         _arguments \
@@ -204,7 +366,31 @@ _varnish() {
                         ;;
                     encrypt)
                         _arguments \
-                            '--password[Encryption password]:password:'
+                            '--password[Encryption password]:password:' \
+                            '--kdf-memory[Argon2id memory in MiB]:MiB:' \
+                            '--kdf-time[Argon2id passes]:passes:' \
+                            '-p[Encrypt only this project]:project:' \
+                            '--project[Encrypt only this project]:project:'
+                        ;;
+                    rekey)
+                        _arguments \
+                            '--new-password-file[Read the new password from a file]:file:_files' \
+                            '-p[Change the password of this project]:project:' \
+                            '--project[Change the password of this project]:project:'
+                        ;;
+                    decrypt)
+                        _arguments \
+                            '-y[Do not ask for confirmation]' \
+                            '--yes[Do not ask for confirmation]' \
+                            '-p[Move this project back into the store]:project:' \
+                            '--project[Move this project back into the store]:project:'
+                        ;;
+                    recipients)
+                        if (( CURRENT == 4 )); then
+                            _values 'recipients command' list ls add remove rm
+                        else
+                            _arguments '--force[Remove even if none of your identities is left]'
+                        fi
                         ;;
                 esac
             fi
@@ -220,6 +406,45 @@ _varnish() {
                 esac
             fi
             ;;
+        snapshot)
+            if (( CURRENT == 3 )); then
+                _describe -t commands 'snapshot commands' snapshot_commands
+            else
+                case "${words[3]}" in
+                    list|ls)
+                        _arguments '--json[Output as JSON]'
+                        ;;
+                    create)
+                        _arguments '--reason[Why the snapshot was taken]:reason:'
+                        ;;
+                    diff)
+                        _arguments '--reveal[Show secret values]'
+                        ;;
+                esac
+            fi
+            ;;
+        unlock)
+            _arguments \
+                '--timeout[Forget keys unused for this long]:duration:' \
+                '-p[Unlock this encrypted project]:project:' \
+                '--project[Unlock this encrypted project]:project:'
+            ;;
+        key)
+            if (( CURRENT == 3 )); then
+                _values 'key command' generate
+            else
+                _arguments \
+                    '--output[Where to write the identity]:file:_files' \
+                    '--force[Replace an existing identity file]'
+            fi
+            ;;
+        agent)
+            if (( CURRENT == 3 )); then
+                _values 'agent command' status --timeout
+            else
+                _arguments '--timeout[Forget keys unused for this long]:duration:'
+            fi
+            ;;
         init)
             _arguments \
                 '-p[Project name]:project:' \
@@ -233,20 +458,70 @@ _varnish() {
                 '--encrypt[Enable store encryption]' \
                 '--password[Encryption password]:password:'
             ;;
+        compose)
+            _arguments \
+                '-f[Compose file]:file:_files' \
+                '--file[Compose file]:file:_files' \
+                '--output[Override file]:file:_files' \
+                '*--map[Map a service to a project]:service=project:' \
+                '--dry-run[Preview without writing]' \
+                '--reveal[Show secrets in dry-run output]' \
+                '--force[Overwrite a hand-written override]'
+            ;;
+        render)
+            _arguments \
+                '--dry-run[Preview without writing]' \
+                '--reveal[Show secrets in dry-run output]' \
+                '--force[Overwrite files varnish did not render]' \
+                '--profile[Profile to apply]:profile:' \
+                '*:template:_files'
+            ;;
         env)
             _arguments \
                 '--dry-run[Preview without writing]' \
                 '--force[Overwrite existing .env]' \
-                '--output[Output path]:file:_files'
+                '--output[Output path]:file:_files' \
+                '--format[Output format]:format:(dotenv dotenv-posix dotenv-compose docker json yaml toml properties k8s-secret k8s-configmap kustomize)' \
+                '--name[Kubernetes resource name]:name:' \
+                '--namespace[Kubernetes namespace]:namespace:' \
+                '*--label[Kubernetes label key=value]:label:' \
+                '--profile[Profile to apply]:profile:' \
+                '--reveal[Show secrets in dry-run output]'
+            ;;
+        run)
+            _arguments \
+                '--clean[Do not inherit the parent environment]' \
+                '--profile[Profile to apply]:profile:' \
+                '*::command:_normal'
+            ;;
+        export)
+            _arguments \
+                '--shell[Target shell]:shell:(bash zsh fish powershell nu)' \
+                '--hook[Prompt-hook mode]' \
+                '--profile[Profile to apply]:profile:'
+            ;;
+        hook)
+            if (( CURRENT == 3 )); then
+                _values 'shell' bash zsh fish powershell
+            fi
             ;;
         list)
             _arguments \
                 '--missing[Show missing variables]' \
-                '--json[Output as JSON]'
+                '--json[Output as JSON]' \
+                '--profile[Profile to apply]:profile:' \
+                '--reveal[Show secret values]'
+            ;;
+        explain)
+            _arguments \
+                '--json[Output as JSON]' \
+                '--profile[Profile to apply]:profile:' \
+                '--reveal[Show secret values]'
             ;;
         check)
             _arguments \
-                '--strict[Fail if any variables are missing]'
+                '--strict[Fail if any variables are missing]' \
+                '--profile[Profile to apply]:profile:'
             ;;
         completion)
             if (( CURRENT == 3 )); then
@@ -269,13 +544,29 @@ const fishCompletion = `# varnish fish completion
 # Disable file completion by default
 complete -c varnish -f
 
+# Global password flags
+complete -c varnish -l password-file -r -F -d "Read store password from file"
+complete -c varnish -l password-fd -x -d "Read store password from file descriptor"
+
 # Main commands
 complete -c varnish -n "__fish_use_subcommand" -a "init" -d "Initialize project"
 complete -c varnish -n "__fish_use_subcommand" -a "store" -d "Manage variable store"
 complete -c varnish -n "__fish_use_subcommand" -a "env" -d "Generate .env file"
+complete -c varnish -n "__fish_use_subcommand" -a "compose" -d "Write a docker-compose override"
+complete -c varnish -n "__fish_use_subcommand" -a "render" -d "Render templates into config files"
+complete -c varnish -n "__fish_use_subcommand" -a "run" -d "Run command with variables"
+complete -c varnish -n "__fish_use_subcommand" -a "export" -d "Print shell exports"
+complete -c varnish -n "__fish_use_subcommand" -a "hook" -d "Print shell hook"
 complete -c varnish -n "__fish_use_subcommand" -a "list" -d "Show resolved variables"
+complete -c varnish -n "__fish_use_subcommand" -a "explain" -d "Explain a variable's value"
 complete -c varnish -n "__fish_use_subcommand" -a "check" -d "Validate config"
 complete -c varnish -n "__fish_use_subcommand" -a "project" -d "Project info"
+complete -c varnish -n "__fish_use_subcommand" -a "snapshot" -d "Manage snapshots"
+complete -c varnish -n "__fish_use_subcommand" -a "restore" -d "Restore a snapshot"
+complete -c varnish -n "__fish_use_subcommand" -a "unlock" -d "Keep store keys in an agent"
+complete -c varnish -n "__fish_use_subcommand" -a "lock" -d "Wipe agent keys"
+complete -c varnish -n "__fish_use_subcommand" -a "agent" -d "Run or inspect the unlock agent"
+complete -c varnish -n "__fish_use_subcommand" -a "key" -d "Generate an identity"
 complete -c varnish -n "__fish_use_subcommand" -a "completion" -d "Generate completions"
 complete -c varnish -n "__fish_use_subcommand" -a "version" -d "Show version"
 complete -c varnish -n "__fish_use_subcommand" -a "help" -d "Show help"
@@ -287,18 +578,54 @@ complete -c varnish -n "__fish_seen_subcommand_from store" -a "list ls" -d "List
 complete -c varnish -n "__fish_seen_subcommand_from store" -a "delete rm" -d "Delete variable"
 complete -c varnish -n "__fish_seen_subcommand_from store" -a "import" -d "Import from file"
 complete -c varnish -n "__fish_seen_subcommand_from store" -a "encrypt" -d "Enable encryption"
+complete -c varnish -n "__fish_seen_subcommand_from store" -a "rekey" -d "Change password"
+complete -c varnish -n "__fish_seen_subcommand_from store" -a "decrypt" -d "Disable encryption"
+complete -c varnish -n "__fish_seen_subcommand_from store" -a "recipients" -d "Manage recipients"
+complete -c varnish -n "__fish_seen_subcommand_from store" -a "history" -d "Show change history"
+complete -c varnish -n "__fish_seen_subcommand_from store" -a "rollback" -d "Undo changes"
 
 # store flags
 complete -c varnish -n "__fish_seen_subcommand_from store" -s p -l project -d "Project namespace"
 complete -c varnish -n "__fish_seen_subcommand_from store" -s g -l global -d "Bypass project detection"
+complete -c varnish -n "__fish_seen_subcommand_from store" -l profile -d "Profile namespace"
 complete -c varnish -n "__fish_seen_subcommand_from store" -l stdin -d "Read value from stdin"
+complete -c varnish -n "__fish_seen_subcommand_from store" -l desc -d "Variable description"
+complete -c varnish -n "__fish_seen_subcommand_from store" -l tag -d "Variable tag"
+complete -c varnish -n "__fish_seen_subcommand_from store" -l secret -d "Mark as secret"
+complete -c varnish -n "__fish_seen_subcommand_from encrypt" -l kdf-memory -x -d "Argon2id memory in MiB"
+complete -c varnish -n "__fish_seen_subcommand_from encrypt" -l kdf-time -x -d "Argon2id passes"
+complete -c varnish -n "__fish_seen_subcommand_from rekey" -l new-password-file -r -F -d "Read new password from file"
+complete -c varnish -n "__fish_seen_subcommand_from decrypt" -s y -l yes -d "Don't ask for confirmation"
+complete -c varnish -n "__fish_seen_subcommand_from encrypt rekey decrypt" -s p -l project -x -d "Only this project, with its own password"
+complete -c varnish -n "__fish_seen_subcommand_from recipients" -a "list ls" -d "List recipients"
+complete -c varnish -n "__fish_seen_subcommand_from recipients" -a "add" -d "Add public keys"
+complete -c varnish -n "__fish_seen_subcommand_from recipients" -a "remove rm" -d "Remove public keys"
+complete -c varnish -n "__fish_seen_subcommand_from recipients" -l force -d "Remove even your own key"
 complete -c varnish -n "__fish_seen_subcommand_from store" -l password -d "Encryption password"
+complete -c varnish -n "__fish_seen_subcommand_from store" -l to -d "Change number or time to roll back to"
 
 # project subcommands
 complete -c varnish -n "__fish_seen_subcommand_from project" -a "name" -d "Show project name"
 complete -c varnish -n "__fish_seen_subcommand_from project" -a "list" -d "List all projects"
 complete -c varnish -n "__fish_seen_subcommand_from project" -a "delete" -d "Delete project vars"
 
+# snapshot subcommands
+complete -c varnish -n "__fish_seen_subcommand_from snapshot" -a "list ls" -d "List snapshots"
+complete -c varnish -n "__fish_seen_subcommand_from snapshot" -a "create" -d "Take a snapshot"
+complete -c varnish -n "__fish_seen_subcommand_from snapshot" -a "diff" -d "Show changes since a snapshot"
+complete -c varnish -n "__fish_seen_subcommand_from snapshot" -l reason -d "Why the snapshot was taken"
+complete -c varnish -n "__fish_seen_subcommand_from snapshot" -l json -d "Output as JSON"
+
+# unlock agent
+complete -c varnish -n "__fish_seen_subcommand_from unlock agent" -l timeout -x -d "Forget keys unused for this long"
+complete -c varnish -n "__fish_seen_subcommand_from unlock" -s p -l project -x -d "Unlock this encrypted project"
+complete -c varnish -n "__fish_seen_subcommand_from agent" -a "status" -d "Show agent status"
+
+# key
+complete -c varnish -n "__fish_seen_subcommand_from key" -a "generate" -d "Create an identity"
+complete -c varnish -n "__fish_seen_subcommand_from generate" -l output -r -F -d "Where to write the identity"
+complete -c varnish -n "__fish_seen_subcommand_from generate" -l force -d "Replace an existing identity"
+
 # completion shells
 complete -c varnish -n "__fish_seen_subcommand_from completion" -a "bash zsh fish"
 
@@ -311,15 +638,47 @@ complete -c varnish -n "__fish_seen_subcommand_from init" -l force -d "Overwrite
 complete -c varnish -n "__fish_seen_subcommand_from init" -l encrypt -d "Enable encryption"
 complete -c varnish -n "__fish_seen_subcommand_from init" -l password -d "Encryption password"
 
+# compose flags
+complete -c varnish -n "__fish_seen_subcommand_from compose" -s f -l file -d "Compose file"
+complete -c varnish -n "__fish_seen_subcommand_from compose" -l output -d "Override file"
+complete -c varnish -n "__fish_seen_subcommand_from compose" -l map -d "Map a service to a project" -x
+complete -c varnish -n "__fish_seen_subcommand_from compose" -l dry-run -d "Preview only"
+complete -c varnish -n "__fish_seen_subcommand_from compose" -l force -d "Overwrite a hand-written override"
+
+# render flags
+complete -c varnish -n "__fish_seen_subcommand_from render" -l dry-run -d "Preview only"
+complete -c varnish -n "__fish_seen_subcommand_from render" -l force -d "Overwrite files varnish did not render"
+
 # env flags
 complete -c varnish -n "__fish_seen_subcommand_from env" -l dry-run -d "Preview only"
 complete -c varnish -n "__fish_seen_subcommand_from env" -l force -d "Overwrite .env"
 complete -c varnish -n "__fish_seen_subcommand_from env" -l output -d "Output path"
+complete -c varnish -n "__fish_seen_subcommand_from env" -l format -d "Output format" -xa "dotenv dotenv-posix dotenv-compose docker json yaml toml properties k8s-secret k8s-configmap kustomize"
+complete -c varnish -n "__fish_seen_subcommand_from env" -l name -d "Kubernetes resource name" -x
+complete -c varnish -n "__fish_seen_subcommand_from env" -l namespace -d "Kubernetes namespace" -x
+complete -c varnish -n "__fish_seen_subcommand_from env" -l label -d "Kubernetes label key=value" -x
+
+# run flags
+complete -c varnish -n "__fish_seen_subcommand_from run" -l clean -d "Don't inherit environment"
+
+# export flags
+complete -c varnish -n "__fish_seen_subcommand_from export" -l shell -d "Target shell" -xa "bash zsh fish powershell nu"
+complete -c varnish -n "__fish_seen_subcommand_from export" -l hook -d "Prompt-hook mode"
+
+# hook shells
+complete -c varnish -n "__fish_seen_subcommand_from hook" -a "bash zsh fish powershell"
+
+# profile flag
+complete -c varnish -n "__fish_seen_subcommand_from env render run export list explain check" -l profile -d "Profile to apply"
+complete -c varnish -n "__fish_seen_subcommand_from env compose render list explain store snapshot" -l reveal -d "Show secret values"
 
 # list flags
 complete -c varnish -n "__fish_seen_subcommand_from list" -l missing -d "Show missing vars"
 complete -c varnish -n "__fish_seen_subcommand_from list" -l json -d "JSON output"
 
+# explain flags
+complete -c varnish -n "__fish_seen_subcommand_from explain" -l json -d "JSON output"
+
 # check flags
 complete -c varnish -n "__fish_seen_subcommand_from check" -l strict -d "Fail if missing vars"
 `
@@ -0,0 +1,276 @@
+// compose.go implements "varnish compose", which writes a Docker Compose
+// override file with each service's environment.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "compose" command here
+//
+// Each service in the compose file is mapped to a varnish project (and
+// optionally a profile), the first of:
+//
+//  1. --map service=project[@profile]
+//  2. x-varnish: project[@profile] on the service in the compose file
+//  3. a project named like the service
+//  4. a profile named like the service in the current directory's project
+//
+// A project may be written as @profile for the current directory's
+// project. Services matching none of these are left out, so databases and
+// other third-party images keep their own environment:
+//
+//	varnish compose                          # Write docker-compose.override.yml
+//	varnish compose --map web=myapp@docker   # Choose the project of a service
+//	varnish compose --dry-run                # Preview (secrets masked)
+//
+// The override file holds real secret values, so it is written 0600. An
+// existing file is only replaced if varnish generated it, or with --force.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dk/varnish/internal/compose"
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/format"
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/secret"
+	"github.com/dk/varnish/internal/store"
+)
+
+// composeService is a service mapped to a project, with its variables.
+type composeService struct {
+	name      string
+	namespace string // project or project@profile
+	vars      []resolver.ResolvedVar
+}
+
+func runCompose(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("compose", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("file", "", "compose file (default: compose.yaml or docker-compose.yml here)")
+	fs.StringVar(file, "f", "", "compose file (shorthand)")
+	output := fs.String("output", "", "override file (default: <compose file>.override.yml)")
+	var maps stringList
+	fs.Var(&maps, "map", "map a service to a project: service=project[@profile] (repeatable)")
+	dryRun := fs.Bool("dry-run", false, "print to stdout instead of writing file")
+	reveal := fs.Bool("reveal", false, "show secret values in --dry-run output")
+	force := fs.Bool("force", false, "overwrite an override file varnish didn't generate")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+
+	path := *file
+	if path == "" {
+		found, err := compose.Find(".")
+		if err != nil {
+			return err
+		}
+		path = found
+	}
+	services, err := compose.Load(path)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = compose.OverridePath(path)
+	}
+
+	mapped := make(map[string]string)
+	for _, m := range maps {
+		service, ref, ok := strings.Cut(m, "=")
+		if !ok || service == "" || ref == "" {
+			return fmt.Errorf("invalid --map %q (use service=project[@profile])", m)
+		}
+		if !hasService(services, service) {
+			return fmt.Errorf("--map %s: no service %s in %s", m, service, filepath.Base(path))
+		}
+		mapped[service] = ref
+	}
+
+	// The current directory's project, for @profile references and
+	// services named like one of its profiles
+	current, err := project.Load()
+	if err != nil {
+		return fmt.Errorf("load project config: %w", err)
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+
+	var resolved []composeService
+	var skipped []string
+	for _, s := range services {
+		ns, err := composeNamespace(s, mapped, current)
+		if err != nil {
+			return err
+		}
+		if ns == "" {
+			skipped = append(skipped, s.Name)
+			continue
+		}
+		vars, err := resolveService(st, s.Name, ns, stderr)
+		if err != nil {
+			return err
+		}
+		resolved = append(resolved, composeService{name: s.Name, namespace: ns, vars: vars})
+	}
+	if len(resolved) == 0 {
+		return fmt.Errorf("no service in %s maps to a varnish project (use --map <service>=<project>)", filepath.Base(path))
+	}
+
+	// Dry-run output is for reading, so secrets are masked there unless
+	// --reveal is given
+	masked := 0
+	var envs []compose.ServiceEnv
+	for _, s := range resolved {
+		env := compose.ServiceEnv{Service: s.name}
+		for _, v := range s.vars {
+			value := v.Value
+			if *dryRun && v.Sensitive && !*reveal {
+				value = secret.Mask
+				masked++
+			}
+			env.Vars = append(env.Vars, format.Var{Name: v.EnvName, Value: value, Sensitive: v.Sensitive})
+		}
+		envs = append(envs, env)
+	}
+
+	var sb strings.Builder
+	header := []string{generatedHeader, "Regenerate with: " + composeCommand(*file, *output, path, maps)}
+	if err := compose.WriteOverride(&sb, envs, header); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Fprint(stdout, sb.String())
+		if masked > 0 {
+			fmt.Fprintf(stderr, "note: %d secret value(s) masked (use --reveal to show)\n", masked)
+		}
+	} else {
+		if exists, generated := generatedFile(*output); exists && !generated && !*force {
+			return fmt.Errorf("%s exists and was not generated by varnish (use --force to overwrite)", *output)
+		}
+		if err := config.AtomicWrite(*output, []byte(sb.String()), config.PermSecure); err != nil {
+			return fmt.Errorf("write %s: %w", *output, err)
+		}
+		fmt.Fprintf(stdout, "wrote %s\n", *output)
+		for _, s := range resolved {
+			fmt.Fprintf(stdout, "  %-16s %-20s %d variables\n", s.name, s.namespace, len(s.vars))
+		}
+		fmt.Fprintln(stdout, "it holds secret values: keep it out of version control")
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintf(stderr, "note: skipped %s (no varnish project; use --map <service>=<project>)\n", strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// composeNamespace returns the project (or project@profile) a service
+// maps to, or "" if none.
+func composeNamespace(s compose.Service, mapped map[string]string, current *project.Config) (string, error) {
+	ref := mapped[s.Name]
+	if ref == "" {
+		ref = s.Varnish
+	}
+	if ref != "" {
+		proj, profile := project.SplitNamespace(ref)
+		if proj == "" {
+			if current == nil {
+				return "", fmt.Errorf("service %s: %s needs a project (no .varnish.yaml here)", s.Name, ref)
+			}
+			proj = current.Project
+		}
+		if !project.Exists(proj) {
+			return "", fmt.Errorf("service %s: project %s not found", s.Name, proj)
+		}
+		return project.Namespace(proj, profile), nil
+	}
+
+	if project.Exists(s.Name) {
+		return s.Name, nil
+	}
+	if current != nil && current.HasProfile(s.Name) {
+		return project.Namespace(current.Project, s.Name), nil
+	}
+	return "", nil
+}
+
+// resolveService resolves the variables of the project namespace ns for
+// service, warning about missing variables.
+func resolveService(st *store.Store, service, ns string, stderr io.Writer) ([]resolver.ResolvedVar, error) {
+	proj, profile := project.SplitNamespace(ns)
+	cfg, err := project.LoadByName(proj)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: load project config: %w", service, err)
+	}
+	if cfg, err = cfg.WithProfile(profile); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if err := unlockFor(st, cfg); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+
+	res := resolver.New(st, cfg)
+	vars, err := res.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if missing := res.MissingVars(); len(missing) > 0 {
+		fmt.Fprintf(stderr, "warning: service %s: missing variables in store: %s\n", service, strings.Join(missing, ", "))
+	}
+	if err := reportViolations(stderr, res.Validate(vars)); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	return vars, nil
+}
+
+// hasService reports whether services includes one named name.
+func hasService(services []compose.Service, name string) bool {
+	for _, s := range services {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// composeCommand returns the varnish compose command that writes output
+// again.
+func composeCommand(file, output, path string, maps []string) string {
+	cmd := "varnish compose"
+	if file != "" {
+		cmd += " --file " + file
+	}
+	if output != compose.OverridePath(path) {
+		cmd += " --output " + output
+	}
+	for _, m := range maps {
+		cmd += " --map " + m
+	}
+	return cmd
+}
+
+// generatedFile reports whether path exists, and if so whether varnish
+// generated it: its first line is the generated header.
+func generatedFile(path string) (exists, generated bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return !os.IsNotExist(err), false
+	}
+	first, _, _ := strings.Cut(string(data), "\n")
+	return true, strings.TrimSpace(strings.TrimLeft(first, "#/ ")) == generatedHeader
+}
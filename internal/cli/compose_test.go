@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/store"
+)
+
+const testComposeFile = `services:
+  web:
+    image: shop
+    x-varnish: shop
+  worker:
+    image: shop
+  api:
+    image: api
+  redis:
+    image: redis:7
+`
+
+// setupCompose creates project shop (with a worker profile) for the
+// current directory, project api, and a compose file using both.
+func setupCompose(t *testing.T) (string, func()) {
+	t.Helper()
+	projectDir, cleanupProject := setupProjectForEnv(t, "shop")
+
+	cfg, err := project.LoadByName("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Profiles = map[string]*project.Profile{"worker": {Overrides: map[string]string{"db.host": "worker-db"}}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	api := project.New()
+	api.Project = "api"
+	api.Include = []string{"api.*"}
+	if err := api.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, _ := store.Load()
+	st.Set("shop.db.host", "localhost")
+	st.Set("shop.db.password", "pa$$word")
+	st.Set("api.api.url", "https://api.example.com")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if err := os.WriteFile(projectDir+"/docker-compose.yml", []byte(testComposeFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	origWd, _ := os.Getwd()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	return projectDir, func() {
+		_ = os.Chdir(origWd)
+		cleanupProject()
+	}
+}
+
+func TestRunCompose(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	_, cleanupCompose := setupCompose(t)
+	defer cleanupCompose()
+
+	var stdout, stderr bytes.Buffer
+	if err := runCompose(nil, &stdout, &stderr); err != nil {
+		t.Fatalf("runCompose error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "wrote docker-compose.override.yml") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "skipped redis") {
+		t.Errorf("expected redis to be skipped, got: %s", stderr.String())
+	}
+
+	info, err := os.Stat("docker-compose.override.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("override permissions = %v, want %v", info.Mode().Perm(), config.PermSecure)
+	}
+	content, _ := os.ReadFile("docker-compose.override.yml")
+	want := `# Generated by varnish - do not edit manually
+# Regenerate with: varnish compose
+
+services:
+  web:
+    environment:
+      DB_HOST: localhost
+      DB_PASSWORD: pa$$$$word
+  worker:
+    environment:
+      DB_HOST: worker-db
+      DB_PASSWORD: pa$$$$word
+  api:
+    environment:
+      API_URL: https://api.example.com
+`
+	if string(content) != want {
+		t.Errorf("override file:\n%s\nwant:\n%s", content, want)
+	}
+
+	// A generated file is replaced without --force
+	if err := runCompose([]string{"--map", "web=@worker"}, &stdout, &stderr); err != nil {
+		t.Fatalf("second runCompose error: %v", err)
+	}
+	content, _ = os.ReadFile("docker-compose.override.yml")
+	if !strings.Contains(string(content), "--map web=@worker") || strings.Contains(string(content), "DB_HOST: localhost") {
+		t.Errorf("--map not applied:\n%s", content)
+	}
+}
+
+func TestRunComposeDryRun(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	_, cleanupCompose := setupCompose(t)
+	defer cleanupCompose()
+
+	var stdout, stderr bytes.Buffer
+	if err := runCompose([]string{"--dry-run"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runCompose --dry-run error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "DB_PASSWORD: '********'") || strings.Contains(stdout.String(), "word") {
+		t.Errorf("secrets should be masked:\n%s", stdout.String())
+	}
+	if _, err := os.Stat("docker-compose.override.yml"); !os.IsNotExist(err) {
+		t.Error("--dry-run should not write the override file")
+	}
+}
+
+func TestRunComposeErrors(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	_, cleanupCompose := setupCompose(t)
+	defer cleanupCompose()
+
+	var stdout, stderr bytes.Buffer
+	if err := runCompose([]string{"--map", "db=shop"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "no service db") {
+		t.Errorf("expected unknown service error, got %v", err)
+	}
+	if err := runCompose([]string{"--map", "redis=missing"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "project missing not found") {
+		t.Errorf("expected unknown project error, got %v", err)
+	}
+
+	// A hand-written override is not replaced without --force
+	if err := os.WriteFile("docker-compose.override.yml", []byte("services: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCompose(nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("expected an error for a hand-written override, got %v", err)
+	}
+	if err := runCompose([]string{"--force"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runCompose --force error: %v", err)
+	}
+}
@@ -3,12 +3,28 @@
 // This file is used by:
 //   - cli/root.go: dispatches "env" command here
 //
-// Generates a .env file from the store + project config.
+// Generates a .env file from the store + project config. Values are
+// validated against the project's schema first. Other formats (JSON, YAML,
+// TOML, Java properties, dotenv variants, Kubernetes manifests) come from
+// format/format.go. Kubernetes formats go to stdout unless --output is set:
+//
+//	varnish env --format k8s-secret | kubectl apply -f -
+//
+// A project with outputs: in its config (see project/output.go) gets all of
+// them written by a plain "varnish env", each with its own subset, format
+// and profile. --output or --format writes a single file as before.
+//
 // Options:
 //
-//	--output     Output file path (default: .env)
-//	--dry-run    Print to stdout instead of writing file
-//	--force      Overwrite existing .env file
+//	--output     Output file path, or - for stdout (default: .env)
+//	--format     Output format (default: from the --output extension, else dotenv)
+//	--name       Kubernetes resource name (default: the project name)
+//	--namespace  Kubernetes namespace
+//	--label      Kubernetes label key=value (repeatable)
+//	--dry-run    Print to stdout instead of writing file (secrets masked)
+//	--reveal     Show secret values in --dry-run output
+//	--force      Overwrite existing output files
+//	--profile    Profile to apply (default: $VARNISH_PROFILE; outputs may set their own)
 package cli
 
 import (
@@ -16,43 +32,108 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/format"
 	"github.com/dk/varnish/internal/project"
 	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/secret"
 	"github.com/dk/varnish/internal/store"
 )
 
+// generatedHeader starts every file varnish generates.
+const generatedHeader = "Generated by varnish - do not edit manually"
+
 func runEnv(args []string, stdout, stderr io.Writer) error {
 	fs := flag.NewFlagSet("env", flag.ContinueOnError)
 	fs.SetOutput(stderr)
-	output := fs.String("output", ".env", "output file path")
+	output := fs.String("output", ".env", "output file path, or - for stdout")
+	formatName := fs.String("format", "", "output format: "+strings.Join(format.Names(), ", ")+" (default: from --output)")
 	dryRun := fs.Bool("dry-run", false, "print to stdout instead of writing file")
 	force := fs.Bool("force", false, "overwrite existing output file")
+	reveal := fs.Bool("reveal", false, "show secret values in --dry-run output")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
+	name := fs.String("name", "", "Kubernetes resource name (default: the project name)")
+	namespace := fs.String("namespace", "", "Kubernetes namespace")
+	var labels stringList
+	fs.Var(&labels, "label", "Kubernetes label key=value (repeatable)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	// Load project config
-	cfg, err := project.Load()
+	// An explicit --format wins; otherwise the extension of --output decides
+	f := format.ForPath(*output)
+	if *formatName != "" {
+		explicit, err := format.Lookup(*formatName)
+		if err != nil {
+			return err
+		}
+		f = explicit
+	}
+	outputSet := false
+	fs.Visit(func(fl *flag.Flag) { outputSet = outputSet || fl.Name == "output" })
+	if f.DefaultOutput != "" && !outputSet {
+		*output = f.DefaultOutput
+	}
+
+	opts := format.Options{
+		Header: []string{
+			generatedHeader,
+			"Regenerate with: " + regenerateCommand(*output, f),
+		},
+		Namespace: *namespace,
+		Labels:    make(map[string]string),
+	}
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("invalid --label %q (use key=value)", l)
+		}
+		opts.Labels[k] = v
+	}
+
+	// Load project config. The profile is applied below, per output if
+	// the project has outputs.
+	base, err := loadBaseConfig()
 	if err != nil {
-		return fmt.Errorf("load project config: %w", err)
+		return err
 	}
-	if cfg == nil {
-		return fmt.Errorf("no .varnish.yaml found (run 'varnish init' first)")
+	opts.Name = *name
+	if opts.Name == "" {
+		opts.Name = format.KubernetesName(base.Project)
 	}
 
 	// Load store
-	st, err := store.Load()
+	st, err := loadStoreFor(base)
 	if err != nil {
 		return fmt.Errorf("load store: %w", err)
 	}
 
+	// Without --output or --format, a project's outputs are all written
+	if len(base.Outputs) > 0 && !outputSet && *formatName == "" {
+		opts.Header = []string{generatedHeader, "Regenerate with: varnish env"}
+		return writeOutputs(base, st, opts, outputsRun{
+			profile: *profile,
+			dryRun:  *dryRun,
+			reveal:  *reveal,
+			force:   *force,
+		}, stdout, stderr)
+	}
+
+	cfg, err := applyProfile(base, *profile)
+	if err != nil {
+		return err
+	}
+
 	// Resolve variables
 	res := resolver.New(st, cfg)
-	vars := res.Resolve()
+	vars, err := res.Resolve()
+	if err != nil {
+		return err
+	}
 
 	// Check for missing variables
 	missing := res.MissingVars()
@@ -60,20 +141,28 @@ func runEnv(args []string, stdout, stderr io.Writer) error {
 		fmt.Fprintf(stderr, "warning: missing variables in store: %s\n", strings.Join(missing, ", "))
 	}
 
+	// Refuse to generate values that break the schema
+	if err := reportViolations(stderr, res.Validate(vars)); err != nil {
+		return err
+	}
+
 	// Build output content
+	out, masked := formatVars(vars, *dryRun && !*reveal)
 	var sb strings.Builder
-	sb.WriteString("# Generated by varnish - do not edit manually\n")
-	sb.WriteString("# Regenerate with: varnish env\n\n")
-
-	for _, v := range vars {
-		// Quote values that contain special characters
-		value := quoteEnvValue(v.Value)
-		sb.WriteString(fmt.Sprintf("%s=%s\n", v.EnvName, value))
+	if err := f.Write(&sb, out, opts); err != nil {
+		return fmt.Errorf("format %s: %w", f.Name, err)
 	}
-
 	content := sb.String()
 
 	if *dryRun {
+		fmt.Fprint(stdout, content)
+		if masked > 0 {
+			fmt.Fprintf(stderr, "note: %d secret value(s) masked (use --reveal to show)\n", masked)
+		}
+		return nil
+	}
+
+	if *output == "-" {
 		fmt.Fprint(stdout, content)
 		return nil
 	}
@@ -92,22 +181,179 @@ func runEnv(args []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
-// quoteEnvValue quotes a value if it contains spaces, quotes, or other special chars.
-func quoteEnvValue(s string) string {
-	// If empty or contains special characters, quote it
-	needsQuotes := s == "" ||
-		strings.ContainsAny(s, " \t\n\r\"'$`\\#") ||
-		strings.HasPrefix(s, "=")
+// regenerateCommand returns the varnish env command that writes output
+// in format f again.
+func regenerateCommand(output string, f *format.Formatter) string {
+	cmd := "varnish env"
+	defaultOutput := f.DefaultOutput
+	if defaultOutput == "" {
+		defaultOutput = ".env"
+	}
+	if output != defaultOutput {
+		cmd += " --output " + output
+	}
+	if format.ForPath(output) != f {
+		cmd += " --format " + f.Name
+	}
+	return cmd
+}
+
+// formatVars converts resolved variables for a formatter, replacing secret
+// values with secret.Mask if mask is set. Dry-run output is for reading,
+// so secrets are masked there unless --reveal is given.
+func formatVars(vars []resolver.ResolvedVar, mask bool) ([]format.Var, int) {
+	masked := 0
+	out := make([]format.Var, 0, len(vars))
+	for _, v := range vars {
+		value := v.Value
+		if mask && v.Sensitive {
+			value = secret.Mask
+			masked++
+		}
+		out = append(out, format.Var{Name: v.EnvName, Value: value, Sensitive: v.Sensitive})
+	}
+	return out, masked
+}
+
+// outputsRun holds the flags of a "varnish env" run writing outputs.
+type outputsRun struct {
+	profile string // Applied to outputs without a profile of their own
+	dryRun  bool
+	reveal  bool
+	force   bool
+}
+
+// writtenOutput is an output rendered in memory, not yet written.
+type writtenOutput struct {
+	output  *project.Output
+	path    string // Resolved against the project directory; - for stdout
+	profile string
+	content string
+	count   int
+	missing []string
+}
+
+// writeOutputs writes each of cfg's outputs. Everything is resolved and
+// formatted before anything is written, so a bad value or an existing
+// file leaves all outputs as they were.
+func writeOutputs(base *project.Config, st *store.Store, opts format.Options, run outputsRun, stdout, stderr io.Writer) error {
+	root, err := projectRoot()
+	if err != nil {
+		return err
+	}
+
+	masked := 0
+	var outs []writtenOutput
+	seen := make(map[string]bool)
+	for _, o := range base.Outputs {
+		if err := o.Check(); err != nil {
+			return err
+		}
+		path := o.Path
+		if path != "-" {
+			// .env and ./.env are the same file
+			path = filepath.Clean(inRoot(root, path))
+			if seen[path] {
+				return fmt.Errorf("output %s is listed twice", o.Path)
+			}
+			seen[path] = true
+		}
+		f := format.ForPath(o.Path)
+		if o.Format != "" {
+			if f, err = format.Lookup(o.Format); err != nil {
+				return fmt.Errorf("output %s: %w", o.Path, err)
+			}
+		}
+
+		profile := o.Profile
+		if profile == "" {
+			profile = activeProfile(run.profile)
+		}
+		cfg, err := base.WithProfile(profile)
+		if err != nil {
+			return fmt.Errorf("output %s: %w", o.Path, err)
+		}
+
+		res := resolver.New(st, cfg)
+		all, err := res.Resolve()
+		if err != nil {
+			return fmt.Errorf("output %s: %w", o.Path, err)
+		}
+		if err := reportViolations(stderr, res.Validate(all)); err != nil {
+			return fmt.Errorf("output %s: %w", o.Path, err)
+		}
 
-	if !needsQuotes {
-		return s
+		var vars []resolver.ResolvedVar
+		for _, v := range all {
+			if o.Matches(v.EnvName, v.Key) {
+				vars = append(vars, v)
+			}
+		}
+		var missing []string
+		for _, key := range res.MissingVars() {
+			if o.Matches(res.EnvName(key), key) {
+				missing = append(missing, key)
+			}
+		}
+
+		out, n := formatVars(vars, run.dryRun && !run.reveal)
+		masked += n
+		var sb strings.Builder
+		if err := f.Write(&sb, out, opts); err != nil {
+			return fmt.Errorf("output %s: format %s: %w", o.Path, f.Name, err)
+		}
+
+		outs = append(outs, writtenOutput{output: o, path: path, profile: profile, content: sb.String(), count: len(vars), missing: missing})
 	}
 
-	// Use double quotes and escape internal double quotes and backslashes
-	escaped := strings.ReplaceAll(s, "\\", "\\\\")
-	escaped = strings.ReplaceAll(escaped, "\"", "\\\"")
-	escaped = strings.ReplaceAll(escaped, "$", "\\$")
-	escaped = strings.ReplaceAll(escaped, "`", "\\`")
+	if run.dryRun {
+		for _, w := range outs {
+			fmt.Fprintf(stdout, "==> %s <==\n%s", w.output.Path, w.content)
+		}
+		if masked > 0 {
+			fmt.Fprintf(stderr, "note: %d secret value(s) masked (use --reveal to show)\n", masked)
+		}
+		reportOutputsMissing(outs, stderr)
+		return nil
+	}
 
-	return "\"" + escaped + "\""
+	if !run.force {
+		for _, w := range outs {
+			if w.path == "-" {
+				continue
+			}
+			if _, err := os.Stat(w.path); err == nil {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", w.output.Path)
+			}
+		}
+	}
+
+	for _, w := range outs {
+		if w.path == "-" {
+			fmt.Fprint(stdout, w.content)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(w.path), config.PermDir); err != nil {
+			return err
+		}
+		if err := config.AtomicWrite(w.path, []byte(w.content), config.PermSecure); err != nil {
+			return fmt.Errorf("write %s: %w", w.output.Path, err)
+		}
+		detail := fmt.Sprintf("%d variables", w.count)
+		if w.profile != "" {
+			detail += ", profile " + w.profile
+		}
+		fmt.Fprintf(stdout, "wrote %s (%s)\n", w.output.Path, detail)
+	}
+	reportOutputsMissing(outs, stderr)
+	return nil
+}
+
+// reportOutputsMissing warns about the missing variables of each output.
+func reportOutputsMissing(outs []writtenOutput, stderr io.Writer) {
+	for _, w := range outs {
+		if len(w.missing) > 0 {
+			fmt.Fprintf(stderr, "warning: %s: missing variables in store: %s\n", w.output.Path, strings.Join(w.missing, ", "))
+		}
+	}
 }
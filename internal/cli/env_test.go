@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/dk/varnish/internal/crypto"
 	"github.com/dk/varnish/internal/project"
 	"github.com/dk/varnish/internal/registry"
 	"github.com/dk/varnish/internal/store"
@@ -49,6 +50,61 @@ func TestRunEnvDryRun(t *testing.T) {
 	}
 }
 
+func TestRunEnvDryRunMasksSecrets(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "envmask")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("envmask.db.host", "localhost")
+	st.Set("envmask.db.password", "hunter2")
+	st.Set("envmask.api.endpoint", "internal")
+	_ = st.SetMeta("envmask.api.endpoint", store.Meta{Secret: true})
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runEnv([]string{"--dry-run"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --dry-run error: %v", err)
+	}
+
+	output := stdout.String()
+	for _, want := range []string{"DB_HOST=localhost", "DB_PASSWORD=********", "API_ENDPOINT=********"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got: %s", want, output)
+		}
+	}
+	if !strings.Contains(stderr.String(), "2 secret value(s) masked") {
+		t.Errorf("expected masking note, got: %s", stderr.String())
+	}
+
+	stdout.Reset()
+	if err := runEnv([]string{"--dry-run", "--reveal"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --dry-run --reveal error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "DB_PASSWORD=hunter2") {
+		t.Errorf("expected revealed secret, got: %s", stdout.String())
+	}
+
+	// Written files always contain the real values
+	if err := runEnv([]string{}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv error: %v", err)
+	}
+	data, _ := os.ReadFile(".env")
+	if !strings.Contains(string(data), "DB_PASSWORD=hunter2") {
+		t.Errorf("expected real value in .env, got: %s", data)
+	}
+}
+
 func TestRunEnvWriteFile(t *testing.T) {
 	cleanup := setupTestEnv(t)
 	defer cleanup()
@@ -244,32 +300,269 @@ func TestRunEnvMissingVarsWarning(t *testing.T) {
 	}
 }
 
-func TestQuoteEnvValue(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"simple", "simple"},
-		{"with space", "\"with space\""},
-		{"with'quote", "\"with'quote\""},
-		{"with\"doublequote", "\"with\\\"doublequote\""},
-		{"with$dollar", "\"with\\$dollar\""},
-		{"with`backtick", "\"with\\`backtick\""},
-		{"with\\backslash", "\"with\\\\backslash\""},
-		{"", "\"\""},
-		{"=startsWithEquals", "\"=startsWithEquals\""},
-		{"has\ttab", "\"has\ttab\""},
-		{"has\nnewline", "\"has\nnewline\""},
-		{"has#hash", "\"has#hash\""},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			result := quoteEnvValue(tt.input)
-			if result != tt.expected {
-				t.Errorf("quoteEnvValue(%q) = %q, want %q", tt.input, result, tt.expected)
-			}
-		})
+func TestRunEnvFormat(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "envfmt")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("envfmt.db.host", "localhost")
+	st.Set("envfmt.db.port", "5432")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runEnv([]string{"--dry-run", "--format", "json"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --format json error: %v", err)
+	}
+	if want := "{\n  \"DB_HOST\": \"localhost\",\n  \"DB_PORT\": \"5432\"\n}\n"; stdout.String() != want {
+		t.Errorf("json output = %q, want %q", stdout.String(), want)
+	}
+
+	// The format follows the --output extension
+	stdout.Reset()
+	if err := runEnv([]string{"--output", "config.yaml"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --output config.yaml error: %v", err)
+	}
+	content, err := os.ReadFile("config.yaml")
+	if err != nil {
+		t.Fatalf("failed to read config.yaml: %v", err)
+	}
+	for _, want := range []string{"# Regenerate with: varnish env --output config.yaml\n", "DB_PORT: \"5432\"\n"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("expected %q in config.yaml, got:\n%s", want, content)
+		}
+	}
+
+	// --format overrides the extension
+	if err := runEnv([]string{"--output", "app.conf", "--format", "properties"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --format properties error: %v", err)
+	}
+	content, _ = os.ReadFile("app.conf")
+	if !strings.Contains(string(content), "--format properties") || !strings.Contains(string(content), "DB_HOST=localhost") {
+		t.Errorf("unexpected app.conf:\n%s", content)
+	}
+
+	if err := runEnv([]string{"--dry-run", "--format", "xml"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Errorf("expected unknown format error, got %v", err)
+	}
+}
+
+func TestRunEnvKubernetes(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "Env_K8s")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("Env_K8s.db.host", "localhost")
+	st.Set("Env_K8s.db.password", "hunter2")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// Written to stdout, unmasked, for kubectl apply -f -
+	var stdout, stderr bytes.Buffer
+	args := []string{"--format", "k8s-secret", "--namespace", "dev", "--label", "team=core"}
+	if err := runEnv(args, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --format k8s-secret error: %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{"kind: Secret\n", "name: env-k8s\n", "namespace: dev\n", "team: core\n", "DB_PASSWORD: aHVudGVyMg==\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "DB_HOST") {
+		t.Errorf("non-sensitive values belong in the ConfigMap:\n%s", out)
+	}
+	if _, err := os.Stat(".env"); !os.IsNotExist(err) {
+		t.Error("k8s-secret should not write .env")
+	}
+
+	stdout.Reset()
+	if err := runEnv([]string{"--format", "k8s-configmap", "--name", "web"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --format k8s-configmap error: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "kind: ConfigMap\n") || !strings.Contains(out, "name: web\n") || !strings.Contains(out, "DB_HOST: localhost\n") {
+		t.Errorf("unexpected ConfigMap:\n%s", out)
+	}
+
+	if err := runEnv([]string{"--format", "k8s-secret", "--label", "nolabel"}, &stdout, &stderr); err == nil {
+		t.Error("expected an error for a label without =")
+	}
+}
+
+func TestRunEnvEncryptedProject(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	unsetenv(t, crypto.PasswordEnvVar)
+	unsetenv(t, crypto.ProjectPasswordCommandEnvVar)
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "envseg")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("envseg.db.host", "localhost")
+	st.Set("other.db.host", "elsewhere")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	t.Setenv("VARNISH_PASSWORD_ENVSEG", "envseg-password")
+	t.Setenv("VARNISH_PASSWORD_OTHER", "other-password")
+	for _, name := range []string{"envseg", "other"} {
+		if err := store.Update(func(s *store.Store) error { return s.EncryptProject(name) }); err != nil {
+			t.Fatalf("EncryptProject(%s) error: %v", name, err)
+		}
+	}
+
+	// Only this project's password is needed
+	unsetenv(t, "VARNISH_PASSWORD_OTHER")
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runEnv([]string{"--dry-run"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --dry-run error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "DB_HOST=localhost") {
+		t.Errorf("expected 'DB_HOST=localhost' in output, got: %s", stdout.String())
+	}
+
+	unsetenv(t, "VARNISH_PASSWORD_ENVSEG")
+	if err := runEnv([]string{"--dry-run"}, &stdout, &stderr); err == nil {
+		t.Error("expected an error without the project's password")
+	}
+}
+
+func TestRunEnvOutputs(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "Env_Outputs")
+	defer cleanupProject()
+
+	cfg, err := project.LoadByName("Env_Outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Profiles = map[string]*project.Profile{"test": {Overrides: map[string]string{"db.host": "testdb"}}}
+	cfg.Outputs = []*project.Output{
+		{Path: ".env"},
+		{Path: ".env.test", Profile: "test", Exclude: []string{"api.*"}},
+		{Path: "web/config.json", Include: []string{"API_*"}},
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, _ := store.Load()
+	st.Set("Env_Outputs.db.host", "localhost")
+	st.Set("Env_Outputs.db.password", "hunter2")
+	st.Set("Env_Outputs.api.url", "https://api.example.com")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// Dry-run previews every output without writing
+	var stdout, stderr bytes.Buffer
+	if err := runEnv([]string{"--dry-run"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --dry-run error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "==> web/config.json <==") || strings.Contains(stdout.String(), "hunter2") {
+		t.Errorf("unexpected dry-run output:\n%s", stdout.String())
+	}
+	if _, err := os.Stat(".env"); !os.IsNotExist(err) {
+		t.Error("--dry-run wrote .env")
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if err := runEnv(nil, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv error: %v", err)
+	}
+	for _, want := range []string{
+		"wrote .env (3 variables)",
+		"wrote .env.test (2 variables, profile test)",
+		"wrote web/config.json (1 variables)",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout.String())
+		}
+	}
+	if !strings.Contains(stderr.String(), "warning: .env: missing variables in store: key") {
+		t.Errorf("expected missing warning for .env, got: %s", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "web/config.json") {
+		t.Errorf("web/config.json doesn't include key, got: %s", stderr.String())
+	}
+
+	content, _ := os.ReadFile(".env.test")
+	if !strings.Contains(string(content), "DB_HOST=testdb") || strings.Contains(string(content), "API_URL") {
+		t.Errorf("unexpected .env.test:\n%s", content)
+	}
+	content, _ = os.ReadFile("web/config.json")
+	if string(content) != "{\n  \"API_URL\": \"https://api.example.com\"\n}\n" {
+		t.Errorf("unexpected web/config.json:\n%s", content)
+	}
+
+	// Existing outputs need --force, and none is written without it
+	if err := os.Remove(".env.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runEnv(nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), ".env already exists") {
+		t.Errorf("expected error for existing .env, got %v", err)
+	}
+	if _, err := os.Stat(".env.test"); !os.IsNotExist(err) {
+		t.Error(".env.test was written although .env exists")
+	}
+	if err := runEnv([]string{"--force"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --force error: %v", err)
+	}
+
+	// The same file under two spellings is a duplicate
+	cfg.Outputs = append(cfg.Outputs, &project.Output{Path: "./.env"})
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runEnv([]string{"--force"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "listed twice") {
+		t.Errorf("expected duplicate output error, got %v", err)
+	}
+
+	// --output still writes a single file
+	stdout.Reset()
+	if err := runEnv([]string{"--output", "single.env"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv --output error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "wrote single.env (3 variables)") {
+		t.Errorf("unexpected output: %s", stdout.String())
 	}
 }
 
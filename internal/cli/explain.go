@@ -0,0 +1,192 @@
+// explain.go implements the "varnish explain" command.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "explain" command here
+//
+// Shows how one variable got its value: the store key and include pattern
+// that matched, the mapping that renamed it, the template and inputs of a
+// computed value, and every value it shadowed on the way. Secrets are
+// masked unless --reveal is given.
+//
+// Usage:
+//
+//	varnish explain DATABASE_URL         # By env name
+//	varnish explain database.host        # By store key
+//	varnish explain --json DATABASE_URL  # Machine-readable (unmasked)
+//	varnish explain --reveal DATABASE_URL  # Show secret values
+//	varnish explain --profile test DATABASE_URL
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/secret"
+)
+
+func runExplain(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOutput := fs.Bool("json", false, "output as JSON")
+	reveal := fs.Bool("reveal", false, "show secret values")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: varnish explain [--json] [--reveal] [--profile <name>] <ENV_NAME>")
+		return fmt.Errorf("expected exactly one variable name")
+	}
+	name := fs.Arg(0)
+
+	// Load project config (with the active profile applied)
+	cfg, err := loadProjectConfig(*profile)
+	if err != nil {
+		return err
+	}
+
+	st, err := loadStoreFor(cfg)
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+
+	res := resolver.New(st, cfg)
+	vars, err := res.Resolve()
+	if err != nil {
+		return err
+	}
+
+	v, ok := findVar(vars, name)
+	if !ok {
+		for _, key := range res.MissingVars() {
+			if key == name || strings.EqualFold(strings.ReplaceAll(key, ".", "_"), name) {
+				return fmt.Errorf("%s is included by project %s but missing from the store", key, cfg.Project)
+			}
+		}
+		return fmt.Errorf("%s is not set by project %s", name, cfg.Project)
+	}
+
+	if *jsonOutput {
+		return json.NewEncoder(stdout).Encode(map[string]interface{}{
+			"name":       v.EnvName,
+			"value":      v.Value,
+			"source":     v.Source,
+			"key":        v.Key,
+			"origin":     v.Origin,
+			"sensitive":  v.Sensitive,
+			"profile":    cfg.Profile,
+			"provenance": v.Provenance,
+		})
+	}
+
+	prov := v.Provenance
+	if prov == nil {
+		prov = &resolver.Provenance{}
+	}
+
+	fmt.Fprintf(stdout, "%s=%s\n", v.EnvName, secret.MaskIf(v.Value, v.Sensitive, *reveal))
+	if v.Key != "" {
+		fmt.Fprintf(stdout, "  key:      %s\n", v.Key)
+	}
+	fmt.Fprintf(stdout, "  source:   %s\n", describeSource(v.Source, prov.StoreKey, prov.Pattern))
+	if v.Origin != "" {
+		origin := v.Origin
+		if v.Origin != cfg.Project {
+			origin += " (inherited through extends)"
+		}
+		fmt.Fprintf(stdout, "  project:  %s\n", origin)
+	}
+	if cfg.Profile != "" {
+		fmt.Fprintf(stdout, "  profile:  %s\n", cfg.Profile)
+	}
+	if prov.Mapping != "" {
+		fmt.Fprintf(stdout, "  mapping:  %s → %s\n", prov.Mapping, v.EnvName)
+	}
+
+	if prov.Template != "" {
+		fmt.Fprintf(stdout, "  template: %s\n", prov.Template)
+		if len(prov.Inputs) > 0 {
+			fmt.Fprintln(stdout, "  inputs:")
+			for _, in := range prov.Inputs {
+				if !in.Found {
+					fmt.Fprintf(stdout, "    %s  (not found)\n", in.Ref)
+					continue
+				}
+				value := secret.MaskIf(in.Value, in.Sensitive, *reveal)
+				fmt.Fprintf(stdout, "    %s = %s  (%s)\n", in.Ref, value, describeInput(in))
+			}
+		}
+	}
+
+	if len(prov.Shadowed) > 0 {
+		fmt.Fprintln(stdout, "  shadowed:")
+		for _, s := range prov.Shadowed {
+			desc := describeSource(s.Source, s.StoreKey, s.Pattern)
+			if s.Origin != "" {
+				desc += ", project " + s.Origin
+			}
+			name := s.Key
+			if name == "" {
+				name = v.EnvName
+			}
+			// A shadowed value of a secret is a secret too
+			sensitive := v.Sensitive || secret.IsSensitive(name, s.Value, st.IsSecret(s.StoreKey))
+			fmt.Fprintf(stdout, "    %s = %s  (%s)\n", name, secret.MaskIf(s.Value, sensitive, *reveal), desc)
+		}
+	}
+
+	return nil
+}
+
+// findVar looks a variable up by env name, or by its store key.
+func findVar(vars []resolver.ResolvedVar, name string) (resolver.ResolvedVar, bool) {
+	for _, v := range vars {
+		if v.EnvName == name {
+			return v, true
+		}
+	}
+	for _, v := range vars {
+		if v.Key != "" && v.Key == name {
+			return v, true
+		}
+	}
+	return resolver.ResolvedVar{}, false
+}
+
+// describeSource creates a human-readable description of where a value
+// came from, including the store key and include pattern for store values.
+func describeSource(source, storeKey, pattern string) string {
+	switch source {
+	case "store":
+		desc := "store " + storeKey
+		if pattern != "" {
+			desc += " (include " + pattern + ")"
+		}
+		return desc
+	case "override":
+		return "override in .varnish.yaml"
+	default:
+		return source
+	}
+}
+
+// describeInput describes where a template input's value came from.
+func describeInput(in resolver.Input) string {
+	switch in.Source {
+	case "computed":
+		return "computed " + in.Name
+	case "store":
+		return "store " + in.Name
+	default:
+		return in.Source
+	}
+}
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/store"
+)
+
+// setupProjectForExplain creates a project with store values, an override,
+// a mapping and a computed value, and chdirs into it.
+func setupProjectForExplain(t *testing.T) func() {
+	t.Helper()
+	cleanup := setupTestEnv(t)
+
+	projectDir, cleanupProject := setupProjectForList(t, "explainapp")
+
+	cfg, _ := project.LoadByName("explainapp")
+	cfg.Include = []string{"db.*", "db.host"}
+	cfg.Overrides = map[string]string{"db.name": "app_dev"}
+	cfg.Mappings = map[string]string{"db.host": "DB_SERVER"}
+	cfg.Computed = map[string]string{"DATABASE_URL": "postgres://${db.host}/${db.name}?x=${db.opts:-none}"}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	st, _ := store.Load()
+	st.Set("explainapp.db.host", "localhost")
+	st.Set("explainapp.db.name", "app")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	return func() {
+		_ = os.Chdir(origWd)
+		cleanupProject()
+		cleanup()
+	}
+}
+
+func TestRunExplainStoreValue(t *testing.T) {
+	defer setupProjectForExplain(t)()
+
+	var stdout, stderr bytes.Buffer
+	if err := runExplain([]string{"DB_SERVER"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExplain error: %v", err)
+	}
+
+	output := stdout.String()
+	for _, want := range []string{
+		"DB_SERVER=localhost",
+		"key:      db.host",
+		"source:   store explainapp.db.host (include db.*)",
+		"mapping:  db.host → DB_SERVER",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got: %s", want, output)
+		}
+	}
+}
+
+func TestRunExplainShadowed(t *testing.T) {
+	defer setupProjectForExplain(t)()
+
+	// Look up by key rather than env name
+	var stdout, stderr bytes.Buffer
+	if err := runExplain([]string{"db.name"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExplain error: %v", err)
+	}
+
+	output := stdout.String()
+	if !strings.Contains(output, "DB_NAME=app_dev") {
+		t.Errorf("expected override value, got: %s", output)
+	}
+	if !strings.Contains(output, "source:   override in .varnish.yaml") {
+		t.Errorf("expected override source, got: %s", output)
+	}
+	if !strings.Contains(output, "db.name = app  (store explainapp.db.name (include db.*), project explainapp)") {
+		t.Errorf("expected shadowed store value, got: %s", output)
+	}
+}
+
+func TestRunExplainComputed(t *testing.T) {
+	defer setupProjectForExplain(t)()
+
+	var stdout, stderr bytes.Buffer
+	if err := runExplain([]string{"DATABASE_URL"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExplain error: %v", err)
+	}
+
+	output := stdout.String()
+	for _, want := range []string{
+		"DATABASE_URL=postgres://localhost/app_dev?x=none",
+		"template: postgres://${db.host}/${db.name}?x=${db.opts:-none}",
+		"db.host = localhost  (store explainapp.db.host)",
+		"db.name = app_dev  (override)",
+		"db.opts  (not found)",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got: %s", want, output)
+		}
+	}
+}
+
+func TestRunExplainJSON(t *testing.T) {
+	defer setupProjectForExplain(t)()
+
+	var stdout, stderr bytes.Buffer
+	if err := runExplain([]string{"--json", "DATABASE_URL"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExplain error: %v", err)
+	}
+
+	var out struct {
+		Name       string               `json:"name"`
+		Source     string               `json:"source"`
+		Provenance *resolver.Provenance `json:"provenance"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout.String())
+	}
+	if out.Name != "DATABASE_URL" || out.Source != "computed" {
+		t.Errorf("unexpected output: %+v", out)
+	}
+	if out.Provenance == nil || len(out.Provenance.Inputs) != 3 {
+		t.Fatalf("expected 3 inputs, got: %s", stdout.String())
+	}
+	if in := out.Provenance.Inputs[1]; in.Ref != "db.name" || in.Source != "override" {
+		t.Errorf("unexpected input: %+v", in)
+	}
+}
+
+func TestRunExplainUnknown(t *testing.T) {
+	defer setupProjectForExplain(t)()
+
+	var stdout, stderr bytes.Buffer
+	err := runExplain([]string{"NOPE"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "NOPE is not set by project explainapp") {
+		t.Errorf("expected not-set error, got: %v", err)
+	}
+
+	if err := runExplain([]string{}, &stdout, &stderr); err == nil {
+		t.Error("expected error without a name")
+	}
+}
+
+func TestRunExplainMasksSecrets(t *testing.T) {
+	defer setupProjectForExplain(t)()
+
+	cfg, _ := project.LoadByName("explainapp")
+	cfg.Computed["DSN"] = "${db.host}:${db.password}"
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	st, _ := store.Load()
+	st.Set("explainapp.db.password", "hunter2")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runExplain([]string{"DSN"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExplain error: %v", err)
+	}
+	output := stdout.String()
+	if strings.Contains(output, "hunter2") {
+		t.Errorf("secret leaked: %s", output)
+	}
+	if !strings.Contains(output, "DSN=********") || !strings.Contains(output, "db.host = localhost") {
+		t.Errorf("expected masked value and plain inputs, got: %s", output)
+	}
+
+	stdout.Reset()
+	if err := runExplain([]string{"--reveal", "DSN"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExplain error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "DSN=localhost:hunter2") {
+		t.Errorf("expected revealed value, got: %s", stdout.String())
+	}
+}
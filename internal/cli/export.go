@@ -0,0 +1,231 @@
+// export.go implements the "varnish export" command.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "export" command here
+//
+// Prints the project's resolved variables as statements for a specific
+// shell, so they can be loaded with eval instead of sourcing a .env file:
+//
+//	eval "$(varnish export --shell zsh)"
+//	varnish export --shell fish | source
+//	varnish export --shell powershell | Invoke-Expression
+//
+// The names exported are recorded in VARNISH_EXPORTED. On the next export,
+// any name listed there that is no longer resolved gets an unset statement,
+// so stale variables don't linger in the shell.
+//
+// Options:
+//
+//	--shell      Target shell: bash, zsh, fish, powershell, nu (default: from $SHELL)
+//	--hook       Prompt-hook mode (see hook.go): only print what changed
+//	--profile    Profile to apply (default: $VARNISH_PROFILE)
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dk/varnish/internal/resolver"
+)
+
+// ExportedVarsEnv lists the names set by the previous export, colon-separated.
+const ExportedVarsEnv = "VARNISH_EXPORTED"
+
+// shellSyntax renders set/unset statements for one shell.
+type shellSyntax struct {
+	set   func(name, value string) string
+	unset func(name string) string
+}
+
+var shells = map[string]shellSyntax{
+	"bash": {
+		set:   func(n, v string) string { return "export " + n + "=" + quotePOSIX(v) },
+		unset: func(n string) string { return "unset " + n },
+	},
+	"zsh": {
+		set:   func(n, v string) string { return "export " + n + "=" + quotePOSIX(v) },
+		unset: func(n string) string { return "unset " + n },
+	},
+	"fish": {
+		set:   func(n, v string) string { return "set -gx " + n + " " + quoteFish(v) },
+		unset: func(n string) string { return "set -e " + n },
+	},
+	"powershell": {
+		set:   func(n, v string) string { return "$env:" + n + " = " + quotePowerShell(v) },
+		unset: func(n string) string { return "Remove-Item Env:" + n + " -ErrorAction SilentlyContinue" },
+	},
+	"nu": {
+		set:   func(n, v string) string { return "$env." + n + " = " + quoteNu(v) },
+		unset: func(n string) string { return "hide-env -i " + n },
+	},
+}
+
+// supportedShells returns the shell names accepted by --shell, sorted.
+func supportedShells() []string {
+	names := make([]string, 0, len(shells))
+	for name := range shells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectShell guesses the shell from $SHELL, falling back to bash.
+func detectShell() string {
+	name := filepath.Base(os.Getenv("SHELL"))
+	switch name {
+	case "pwsh", "powershell":
+		return "powershell"
+	}
+	if _, ok := shells[name]; ok {
+		return name
+	}
+	return "bash"
+}
+
+func runExport(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	shellFlag := fs.String("shell", "", "target shell: "+strings.Join(supportedShells(), ", "))
+	hook := fs.Bool("hook", false, "prompt-hook mode: follow the current directory's project")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	shell := *shellFlag
+	if shell == "" {
+		shell = detectShell()
+	}
+	sh, ok := shells[shell]
+	if !ok {
+		return fmt.Errorf("unknown shell: %s (supported: %s)", shell, strings.Join(supportedShells(), ", "))
+	}
+
+	if *hook {
+		return runExportHook(sh, stdout, stderr)
+	}
+
+	// Load project config (with the active profile applied)
+	cfg, err := loadProjectConfig(*profile)
+	if err != nil {
+		return err
+	}
+
+	// Load store
+	st, err := loadStoreFor(cfg)
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+
+	res := resolver.New(st, cfg)
+	vars, err := res.Resolve()
+	if err != nil {
+		return err
+	}
+
+	missing := res.MissingVars()
+	if len(missing) > 0 {
+		fmt.Fprintf(stderr, "warning: missing variables in store: %s\n", strings.Join(missing, ", "))
+	}
+
+	writeShellExports(stdout, stderr, sh, vars, previousExports())
+	return nil
+}
+
+// previousExports returns the names recorded by the last export.
+func previousExports() []string {
+	val := os.Getenv(ExportedVarsEnv)
+	if val == "" {
+		return nil
+	}
+	return strings.Split(val, ":")
+}
+
+// validShellName matches names every supported shell accepts unquoted.
+var validShellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeShellExports writes set statements for vars, unset statements for
+// names in previous that are no longer present, and updates ExportedVarsEnv.
+func writeShellExports(w, stderr io.Writer, sh shellSyntax, vars []resolver.ResolvedVar, previous []string) {
+	current := make(map[string]bool, len(vars))
+	names := make([]string, 0, len(vars))
+
+	for _, v := range vars {
+		if !validShellName.MatchString(v.EnvName) {
+			fmt.Fprintf(stderr, "warning: skipping %q (not a valid shell variable name)\n", v.EnvName)
+			continue
+		}
+		current[v.EnvName] = true
+		names = append(names, v.EnvName)
+		fmt.Fprintln(w, sh.set(v.EnvName, v.Value))
+	}
+
+	for _, name := range previous {
+		if name == "" || current[name] || !validShellName.MatchString(name) {
+			continue
+		}
+		fmt.Fprintln(w, sh.unset(name))
+	}
+
+	if len(names) == 0 {
+		fmt.Fprintln(w, sh.unset(ExportedVarsEnv))
+		return
+	}
+	fmt.Fprintln(w, sh.set(ExportedVarsEnv, strings.Join(names, ":")))
+}
+
+// quotePOSIX wraps s in single quotes for sh/bash/zsh.
+// A literal quote closes the string, adds an escaped quote, then reopens.
+func quotePOSIX(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteFish wraps s in single quotes for fish.
+// Inside fish single quotes only \ and ' need escaping.
+func quoteFish(s string) string {
+	escaped := strings.ReplaceAll(s, `\`, `\\`)
+	escaped = strings.ReplaceAll(escaped, "'", `\'`)
+	return "'" + escaped + "'"
+}
+
+// quotePowerShell wraps s in single quotes for PowerShell.
+// A literal ' is doubled; nothing else is interpreted.
+func quotePowerShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteNu wraps s in double quotes for nushell, escaping backslashes,
+// quotes and control characters.
+func quoteNu(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
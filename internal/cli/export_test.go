@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/store"
+)
+
+func TestRunExportBash(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "exportbash")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("exportbash.db.host", "localhost")
+	st.Set("exportbash.db.pass", "it's")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	t.Setenv(ExportedVarsEnv, "DB_HOST:OLD_VAR")
+
+	var stdout, stderr bytes.Buffer
+	if err := runExport([]string{"--shell", "bash"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExport error: %v", err)
+	}
+
+	output := stdout.String()
+	for _, want := range []string{
+		"export DB_HOST='localhost'\n",
+		`export DB_PASS='it'\''s'` + "\n",
+		"unset OLD_VAR\n",
+		"export VARNISH_EXPORTED='DB_HOST:DB_PASS'\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "unset DB_HOST") {
+		t.Error("should not unset a variable that is still resolved")
+	}
+}
+
+func TestRunExportUnknownShell(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	err := runExport([]string{"--shell", "tcsh"}, &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected error for unknown shell")
+	}
+	if !strings.Contains(err.Error(), "unknown shell") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWriteShellExports(t *testing.T) {
+	vars := []resolver.ResolvedVar{{EnvName: "A", Value: `x'y"z\n`}}
+
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"zsh", []string{`export A='x'\''y"z\n'`, "unset GONE"}},
+		{"fish", []string{`set -gx A 'x\'y"z\\n'`, "set -e GONE"}},
+		{"powershell", []string{`$env:A = 'x''y"z\n'`, "Remove-Item Env:GONE"}},
+		{"nu", []string{`$env.A = "x'y\"z\\n"`, "hide-env -i GONE"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			writeShellExports(&out, &errOut, shells[tt.shell], vars, []string{"A", "GONE"})
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("expected %q in output, got:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteShellExportsSkipsInvalidNames(t *testing.T) {
+	vars := []resolver.ResolvedVar{
+		{EnvName: "GOOD", Value: "1"},
+		{EnvName: "BAD-NAME", Value: "2"},
+	}
+
+	var out, errOut bytes.Buffer
+	writeShellExports(&out, &errOut, shells["bash"], vars, nil)
+
+	if strings.Contains(out.String(), "BAD-NAME") {
+		t.Errorf("invalid name should be skipped, got:\n%s", out.String())
+	}
+	if !strings.Contains(errOut.String(), "BAD-NAME") {
+		t.Error("expected warning about invalid name")
+	}
+}
@@ -0,0 +1,234 @@
+// history.go implements "varnish store history" and "varnish store rollback".
+//
+// This file is used by:
+//   - cli/store.go: dispatches "history" and "rollback" subcommands here
+//
+// Every change to the store is recorded (see store/history.go), so a
+// mistyped "store set" can be undone:
+//
+//	varnish store history db.password          # Changes, newest first
+//	varnish store rollback db.password         # Undo the latest change
+//	varnish store rollback --to 2 db.password  # Value after change #2
+//	varnish store rollback --to 2025-01-02 db.password  # Value as of a time
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/dk/varnish/internal/secret"
+	"github.com/dk/varnish/internal/store"
+)
+
+// historyTimeFormats are accepted by rollback --to, in local time unless
+// the format carries a zone.
+var historyTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// runStoreHistory handles: varnish store history <key>
+func runStoreHistory(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store history", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	projectFlag := fs.String("project", "", "namespace under project name")
+	fs.StringVar(projectFlag, "p", "", "namespace under project name (shorthand)")
+	global := fs.Bool("global", false, "bypass project auto-detection")
+	fs.BoolVar(global, "g", false, "bypass project auto-detection (shorthand)")
+	profileFlag := fs.String("profile", "", "namespace under project@profile (or set VARNISH_PROFILE)")
+	jsonOutput := fs.Bool("json", false, "output as JSON")
+	reveal := fs.Bool("reveal", false, "show secret values")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: varnish store history <key>")
+		return fmt.Errorf("expected exactly one key")
+	}
+
+	storeKey, st, changes, err := loadKeyHistory(fs.Arg(0), *projectFlag, *global, *profileFlag)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return json.NewEncoder(stdout).Encode(map[string]interface{}{
+			"key":     storeKey,
+			"changes": changes,
+		})
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintf(stdout, "no history for %s\n", storeKey)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "history of %s (%d change(s), newest first):\n", storeKey, len(changes))
+	flagged := st.IsSecret(storeKey)
+	show := func(value string) string {
+		return secret.MaskIf(value, secret.IsSensitive(storeKey, value, flagged), *reveal)
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		before := "(unset)"
+		if c.Existed {
+			before = show(c.Previous)
+		}
+		after := "(deleted)"
+		if c.Op == store.OpSet {
+			after = show(c.Value)
+		}
+		line := fmt.Sprintf("  #%-3d %s  %-6s  %s → %s", i+1, c.Time.Local().Format("2006-01-02 15:04:05"), c.Op, before, after)
+		if c.Command != "" {
+			line += "  (" + c.Command + ")"
+		}
+		fmt.Fprintln(stdout, line)
+	}
+	return nil
+}
+
+// runStoreRollback handles: varnish store rollback <key> [--to <n|time>]
+func runStoreRollback(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store rollback", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	projectFlag := fs.String("project", "", "namespace under project name")
+	fs.StringVar(projectFlag, "p", "", "namespace under project name (shorthand)")
+	global := fs.Bool("global", false, "bypass project auto-detection")
+	fs.BoolVar(global, "g", false, "bypass project auto-detection (shorthand)")
+	profileFlag := fs.String("profile", "", "namespace under project@profile (or set VARNISH_PROFILE)")
+	to := fs.String("to", "", "change number (from 'store history') or time to roll back to")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: varnish store rollback [--to <n|time>] <key>")
+		return fmt.Errorf("expected exactly one key")
+	}
+
+	storeKey, err := historyKey(fs.Arg(0), *projectFlag, *global, *profileFlag)
+	if err != nil {
+		return err
+	}
+
+	// Read the history and roll back under the store lock, so no change
+	// can slip in between
+	var message string
+	err = store.Update(func(st *store.Store) error {
+		if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+			return err
+		}
+		h, err := st.History()
+		if err != nil {
+			return err
+		}
+		changes := h.For(storeKey)
+		if len(changes) == 0 {
+			return fmt.Errorf("no history for %s", storeKey)
+		}
+
+		// Work out the target state
+		var value, target string
+		var exists bool
+		switch {
+		case *to == "":
+			// Undo the latest change
+			last := changes[len(changes)-1]
+			value, exists = last.Previous, last.Existed
+			target = fmt.Sprintf("before change #%d", len(changes))
+		default:
+			if n, convErr := strconv.Atoi(*to); convErr == nil {
+				value, exists, err = store.ValueAt(changes, n)
+				target = fmt.Sprintf("change #%d", n)
+			} else {
+				t, parseErr := parseHistoryTime(*to)
+				if parseErr != nil {
+					return parseErr
+				}
+				value, exists, err = store.ValueAsOf(changes, t)
+				target = t.Format("2006-01-02 15:04:05")
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", storeKey, err)
+			}
+		}
+
+		current, currentExists := st.Get(storeKey)
+		switch {
+		case current == value && currentExists == exists:
+			message = fmt.Sprintf("%s is already at %s", storeKey, target)
+		case exists:
+			st.Set(storeKey, value)
+			message = fmt.Sprintf("rolled back %s to %s", storeKey, target)
+		default:
+			st.Delete(storeKey)
+			message = fmt.Sprintf("rolled back %s to %s (deleted, it did not exist then)", storeKey, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, message)
+	return nil
+}
+
+// historyKey resolves a key given on the command line to its store key.
+func historyKey(key, projectFlag string, global bool, profileFlag string) (string, error) {
+	_, namespace, err := resolveNamespace(projectFlag, global, profileFlag)
+	if err != nil {
+		return "", err
+	}
+
+	storeKey := normalizeKey(key)
+	if namespace != "" {
+		storeKey = namespace + "." + storeKey
+	}
+	return storeKey, nil
+}
+
+// loadKeyHistory resolves a key to its store key and loads the store and
+// the key's changes, oldest first.
+func loadKeyHistory(key, projectFlag string, global bool, profileFlag string) (string, *store.Store, []store.Change, error) {
+	storeKey, err := historyKey(key, projectFlag, global, profileFlag)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("load store: %w", err)
+	}
+	if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+		return "", nil, nil, err
+	}
+	h, err := st.History()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return storeKey, st, h.For(storeKey), nil
+}
+
+// parseHistoryTime parses a --to time in one of historyTimeFormats.
+func parseHistoryTime(s string) (time.Time, error) {
+	for _, layout := range historyTimeFormats {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			// A bare date means the end of that day
+			if layout == "2006-01-02" {
+				t = t.Add(24*time.Hour - time.Nanosecond)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --to %q (use a change number or a time like 2025-01-02 15:04)", s)
+}
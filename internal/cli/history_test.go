@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/store"
+)
+
+func TestRunStoreHistoryAndRollback(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	for _, v := range []string{"first", "second", "typo"} {
+		if err := run([]string{"store", "set", "-g", "db.password", v}, &stdout, &stderr); err != nil {
+			t.Fatalf("store set error: %v", err)
+		}
+	}
+
+	stdout.Reset()
+	if err := runStore([]string{"history", "-g", "db.password"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store history error: %v", err)
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "history of db.password (3 change(s), newest first)") {
+		t.Errorf("expected header, got: %s", output)
+	}
+	if strings.Contains(output, "typo") || !strings.Contains(output, "(unset) → ********") {
+		t.Errorf("expected masked values, got: %s", output)
+	}
+	if !strings.Contains(output, "(store set)") {
+		t.Errorf("expected command, got: %s", output)
+	}
+	// Newest first
+	if strings.Index(output, "#3") > strings.Index(output, "#1") {
+		t.Errorf("expected newest first, got: %s", output)
+	}
+
+	stdout.Reset()
+	if err := runStore([]string{"history", "-g", "--reveal", "db.password"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store history error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "second → typo") {
+		t.Errorf("expected revealed values, got: %s", stdout.String())
+	}
+
+	// Undo the latest change
+	if err := run([]string{"store", "rollback", "-g", "db.password"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store rollback error: %v", err)
+	}
+	st, _ := store.Load()
+	if v, _ := st.Get("db.password"); v != "second" {
+		t.Errorf("after rollback = %q, want second", v)
+	}
+
+	// Roll back to a change number; the rollback itself is change #4
+	if err := run([]string{"store", "rollback", "-g", "--to", "1", "db.password"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store rollback --to error: %v", err)
+	}
+	st, _ = store.Load()
+	if v, _ := st.Get("db.password"); v != "first" {
+		t.Errorf("after rollback --to 1 = %q, want first", v)
+	}
+
+	h, _ := store.LoadHistory()
+	changes := h.For("db.password")
+	if len(changes) != 5 || changes[4].Command != "store rollback" {
+		t.Errorf("expected rollbacks in history, got %+v", changes)
+	}
+
+	// Before the first change, the key did not exist
+	if err := runStore([]string{"rollback", "-g", "--to", "2000-01-01", "db.password"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store rollback --to time error: %v", err)
+	}
+	st, _ = store.Load()
+	if _, ok := st.Get("db.password"); ok {
+		t.Error("expected key to be deleted")
+	}
+}
+
+func TestRunStoreRollbackErrors(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	if err := runStore([]string{"rollback", "-g", "missing"}, &stdout, &stderr); err == nil {
+		t.Error("expected error without history")
+	}
+
+	if err := runStore([]string{"set", "-g", "key", "v"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if err := runStore([]string{"rollback", "-g", "--to", "5", "key"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for unknown change number")
+	}
+	if err := runStore([]string{"rollback", "-g", "--to", "yesterday", "key"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for invalid time")
+	}
+}
@@ -0,0 +1,251 @@
+// hook.go implements the "varnish hook" command.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "hook" command here
+//   - cli/export.go: "export --hook" calls runExportHook
+//
+// Prints a shell snippet that runs "varnish export --hook" before every
+// prompt. The registry decides which project the current directory belongs
+// to; when that changes, the old project's variables are unset and the new
+// project's variables are exported.
+//
+// Usage:
+//
+//	eval "$(varnish hook bash)"     # ~/.bashrc
+//	eval "$(varnish hook zsh)"      # ~/.zshrc
+//	varnish hook fish | source      # ~/.config/fish/config.fish
+//	varnish hook powershell | Out-String | Invoke-Expression  # $PROFILE
+//
+// Caching:
+//
+//	The shell keeps VARNISH_HOOK_STATE, a fingerprint of the active project
+//	and profile (VARNISH_PROFILE) plus the size and mtime of store.yaml and
+//	the project config. When the fingerprint is unchanged the hook prints
+//	nothing, so an encrypted store is only decrypted (and the key only
+//	derived) when something changed.
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/registry"
+	"github.com/dk/varnish/internal/resolver"
+)
+
+// HookStateEnv holds the fingerprint of what the hook last loaded.
+const HookStateEnv = "VARNISH_HOOK_STATE"
+
+// hookScripts are the per-shell snippets; %s is the quoted varnish binary.
+var hookScripts = map[string]string{
+	"bash": `_varnish_hook() {
+  local previous_exit_status=$?
+  eval "$(%s export --shell bash --hook)"
+  return $previous_exit_status
+}
+if [[ ";${PROMPT_COMMAND[*]:-};" != *";_varnish_hook;"* ]]; then
+  PROMPT_COMMAND="_varnish_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`,
+	"zsh": `_varnish_hook() {
+  eval "$(%s export --shell zsh --hook)"
+}
+typeset -ag precmd_functions chpwd_functions
+if (( ! ${precmd_functions[(I)_varnish_hook]} )); then
+  precmd_functions=(_varnish_hook $precmd_functions)
+fi
+if (( ! ${chpwd_functions[(I)_varnish_hook]} )); then
+  chpwd_functions=(_varnish_hook $chpwd_functions)
+fi
+`,
+	"fish": `function __varnish_hook --on-event fish_prompt --on-variable PWD
+    %s export --shell fish --hook | source
+end
+`,
+	"powershell": `$global:__varnishPrompt = $function:prompt
+function global:prompt {
+    & %s export --shell powershell --hook | Out-String | Invoke-Expression
+    & $global:__varnishPrompt
+}
+`,
+}
+
+func runHook(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		printHookUsage(stdout)
+		return nil
+	}
+
+	shell := args[0]
+	switch shell {
+	case "help", "-h", "--help":
+		printHookUsage(stdout)
+		return nil
+	}
+
+	script, ok := hookScripts[shell]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown shell: %s\n\n", shell)
+		printHookUsage(stderr)
+		return fmt.Errorf("unknown shell: %s (supported: bash, zsh, fish, powershell)", shell)
+	}
+
+	// Use the absolute path so the hook keeps working if PATH changes
+	bin := "varnish"
+	if exe, err := os.Executable(); err == nil {
+		bin = exe
+	}
+	quoted := quotePOSIX(bin)
+	if shell == "fish" {
+		quoted = quoteFish(bin)
+	} else if shell == "powershell" {
+		quoted = quotePowerShell(bin)
+	}
+
+	fmt.Fprintf(stdout, script, quoted)
+	return nil
+}
+
+func printHookUsage(w io.Writer) {
+	fmt.Fprintln(w, `Usage: varnish hook <shell>
+
+Print a shell hook that loads the current project's variables on every
+prompt and unloads them when you leave the project directory.
+
+Supported shells:
+  bash          eval "$(varnish hook bash)"          # ~/.bashrc
+  zsh           eval "$(varnish hook zsh)"           # ~/.zshrc
+  fish          varnish hook fish | source           # config.fish
+  powershell    varnish hook powershell | Out-String | Invoke-Expression
+
+The hook never asks for a password, since it runs inside the prompt. For a
+password-encrypted store, run 'varnish unlock' once per session (and
+'varnish unlock -p <project>' for a project encrypted separately), or set
+VARNISH_PASSWORD or VARNISH_PASSWORD_COMMAND in the shell. Until then the
+hook warns and loads nothing. A store encrypted to recipients opens with your
+identity file.`)
+}
+
+// runExportHook is "varnish export --hook": print only what changed since
+// the last prompt. Errors are reported to stderr rather than returned so a
+// broken store never breaks the user's prompt.
+func runExportHook(sh shellSyntax, stdout, stderr io.Writer) error {
+	// A password prompt would block the shell's prompt
+	crypto.SetPrompt(false)
+	defer crypto.SetPrompt(true)
+
+	previousState := os.Getenv(HookStateEnv)
+
+	reg, err := registry.Load()
+	if err != nil {
+		fmt.Fprintf(stderr, "varnish: load registry: %v\n", err)
+		return nil
+	}
+
+	proj := reg.LookupCurrent()
+	if proj == "" {
+		if previousState == "" {
+			return nil
+		}
+		// Left the project: unload everything the hook exported
+		writeShellExports(stdout, stderr, sh, nil, previousExports())
+		fmt.Fprintln(stdout, sh.unset(HookStateEnv))
+		fmt.Fprintf(stderr, "varnish: unloaded %s\n", hookStateProject(previousState))
+		return nil
+	}
+
+	profile := activeProfile("")
+	state := project.Namespace(proj, profile) + ":" + hookFingerprint(proj)
+	if state == previousState {
+		return nil
+	}
+
+	vars, err := resolveProject(proj, profile)
+	if err != nil {
+		// Don't leave the previous project's variables (maybe secrets)
+		// loaded here. Record the state anyway so we don't retry (and
+		// warn) every prompt.
+		fmt.Fprintf(stderr, "varnish: %s: %v\n", proj, err)
+		switch {
+		case errors.Is(err, crypto.ErrProjectPasswordRequired):
+			fmt.Fprintf(stderr, "varnish: run 'varnish unlock -p <project>' for the project named above\n")
+		case errors.Is(err, crypto.ErrPasswordRequired):
+			fmt.Fprintf(stderr, "varnish: run 'varnish unlock' to load %s\n", proj)
+		}
+		writeShellExports(stdout, stderr, sh, nil, previousExports())
+		fmt.Fprintln(stdout, sh.set(HookStateEnv, state))
+		return nil
+	}
+
+	writeShellExports(stdout, stderr, sh, vars, previousExports())
+	fmt.Fprintln(stdout, sh.set(HookStateEnv, state))
+	fmt.Fprintf(stderr, "varnish: loaded %s (%d variables)\n", project.Namespace(proj, profile), len(vars))
+	return nil
+}
+
+// resolveProject loads a project's config and the store and resolves it.
+func resolveProject(name, profile string) ([]resolver.ResolvedVar, error) {
+	cfg, err := project.LoadByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("load project config: %w", err)
+	}
+
+	cfg, err = cfg.WithProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := loadStoreFor(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("load store: %w", err)
+	}
+
+	return resolver.New(st, cfg).Resolve()
+}
+
+// hookFingerprint summarizes the files a project's resolution depends on.
+// Only metadata and the (small) project configs are read, so this is cheap
+// enough to run on every prompt.
+func hookFingerprint(proj string) string {
+	var paths []string
+	if storePath, err := config.StorePath(); err == nil {
+		paths = append(paths, storePath)
+	}
+	paths = append(paths, config.ProjectConfigPathFor(proj), config.SegmentPathFor(proj))
+
+	// Parent projects (extends) affect resolution too
+	if cfg, err := project.LoadByName(proj); err == nil {
+		for _, layer := range cfg.Ancestry() {
+			if layer.Project != proj {
+				paths = append(paths, config.ProjectConfigPathFor(layer.Project), config.SegmentPathFor(layer.Project))
+			}
+		}
+	}
+
+	h := sha256.New()
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(h, "%s:missing\n", p)
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d:%d\n", p, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// hookStateProject extracts the project name from a HookStateEnv value.
+func hookStateProject(state string) string {
+	if idx := strings.LastIndex(state, ":"); idx >= 0 {
+		return state[:idx]
+	}
+	return state
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/registry"
+	"github.com/dk/varnish/internal/store"
+)
+
+func TestRunHookScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish", "powershell"} {
+		t.Run(shell, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := runHook([]string{shell}, &stdout, &stderr); err != nil {
+				t.Fatalf("runHook(%s) error: %v", shell, err)
+			}
+			want := "export --shell " + shell + " --hook"
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("expected %q in hook script, got:\n%s", want, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunHookUnknownShell(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := runHook([]string{"csh"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for unknown shell")
+	}
+}
+
+func TestRunExportHookLifecycle(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "hookproj")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("hookproj.db.host", "localhost")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	t.Setenv(HookStateEnv, "")
+	t.Setenv(ExportedVarsEnv, "")
+
+	// First prompt in the project: variables are loaded
+	var stdout, stderr bytes.Buffer
+	if err := runExport([]string{"--shell", "bash", "--hook"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExport --hook error: %v", err)
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "export DB_HOST='localhost'") {
+		t.Fatalf("expected DB_HOST export, got:\n%s", output)
+	}
+	state := hookStateFromOutput(t, output)
+
+	// Next prompt with the same state: nothing to do
+	t.Setenv(HookStateEnv, state)
+	t.Setenv(ExportedVarsEnv, "DB_HOST")
+	stdout.Reset()
+	if err := runExport([]string{"--shell", "bash", "--hook"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExport --hook error: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output when state is unchanged, got:\n%s", stdout.String())
+	}
+
+	// Leaving the project: variables are unloaded
+	outside := t.TempDir()
+	if err := os.Chdir(outside); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	stdout.Reset()
+	if err := runExport([]string{"--shell", "bash", "--hook"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExport --hook error: %v", err)
+	}
+	output = stdout.String()
+	if !strings.Contains(output, "unset DB_HOST") {
+		t.Errorf("expected DB_HOST to be unset, got:\n%s", output)
+	}
+	if !strings.Contains(output, "unset "+HookStateEnv) {
+		t.Errorf("expected hook state to be cleared, got:\n%s", output)
+	}
+}
+
+func TestRunExportHookBrokenProject(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	// A directory registered for a project whose config is gone
+	brokenDir := t.TempDir()
+	reg, _ := registry.Load()
+	reg.Register(brokenDir, "hookbroken")
+	if err := reg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(brokenDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// Coming from a project that exported a secret
+	t.Setenv(HookStateEnv, "hookgood:0123456789abcdef")
+	t.Setenv(ExportedVarsEnv, "DB_HOST:DB_PASSWORD")
+
+	var stdout, stderr bytes.Buffer
+	if err := runExport([]string{"--shell", "bash", "--hook"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExport --hook error: %v", err)
+	}
+	output := stdout.String()
+	for _, want := range []string{"unset DB_HOST", "unset DB_PASSWORD", "unset " + ExportedVarsEnv} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q, got:\n%s", want, output)
+		}
+	}
+	if !strings.Contains(output, "export "+HookStateEnv+"='hookbroken:") {
+		t.Errorf("expected the new state to be recorded, got:\n%s", output)
+	}
+	if !strings.Contains(stderr.String(), "varnish: hookbroken:") {
+		t.Errorf("expected the error on stderr, got: %s", stderr.String())
+	}
+}
+
+func TestHookFingerprintChangesWithStore(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	st := store.New()
+	st.Set("fp.key", "one")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	before := hookFingerprint("fp")
+
+	st.Set("fp.key", "a longer value")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if after := hookFingerprint("fp"); after == before {
+		t.Error("fingerprint should change when the store changes")
+	}
+}
+
+// hookStateFromOutput extracts the VARNISH_HOOK_STATE value from bash output.
+func hookStateFromOutput(t *testing.T, output string) string {
+	t.Helper()
+	prefix := "export " + HookStateEnv + "='"
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSuffix(strings.TrimPrefix(line, prefix), "'")
+		}
+	}
+	t.Fatalf("no %s in output:\n%s", HookStateEnv, output)
+	return ""
+}
+
+func TestRunExportHookLockedStore(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "hooklocked")
+	defer cleanupProject()
+	setupEncryptedStoreCLI(t, "password")
+	unsetenv(t, crypto.PasswordEnvVar)
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	t.Setenv(HookStateEnv, "")
+	t.Setenv(ExportedVarsEnv, "")
+
+	var stdout, stderr bytes.Buffer
+	if err := runExport([]string{"--shell", "bash", "--hook"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runExport --hook error: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "run 'varnish unlock' to load hooklocked") {
+		t.Errorf("expected an unlock hint, got: %s", stderr.String())
+	}
+}
@@ -14,7 +14,9 @@
 //	--no-import      Don't import default values into the store
 //	--sync           Sync store with .env file (removes empty/missing vars)
 //	--force          Overwrite existing project config
-//	--encrypt        Enable encryption for the store (requires VARNISH_PASSWORD)
+//	--encrypt        Enable encryption for the store (asks for a password, see crypto/password.go)
+//
+// With --sync or --force, a snapshot is taken first (see cli/snapshot.go).
 package cli
 
 import (
@@ -44,7 +46,7 @@ func runInit(args []string, stdout, stderr io.Writer) error {
 	fs.BoolVar(sync, "s", false, "sync store (shorthand)")
 	force := fs.Bool("force", false, "overwrite existing project config")
 	encrypt := fs.Bool("encrypt", false, "enable encryption for the store")
-	password := fs.String("password", "", "encryption password (or set VARNISH_PASSWORD)")
+	password := fs.String("password", "", "deprecated: encryption password, visible in shell history and ps (use the prompt or --password-file)")
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
@@ -53,15 +55,15 @@ func runInit(args []string, stdout, stderr io.Writer) error {
 		return err
 	}
 
-	// If --password provided, set the env var for this session
 	if *password != "" {
-		os.Setenv(crypto.PasswordEnvVar, *password)
+		warnPasswordFlag(stderr)
+		crypto.SetPassword(*password)
 	}
 
 	// Validate encryption requirements
 	if *encrypt {
-		if _, err := crypto.GetPassword(); err != nil {
-			return fmt.Errorf("--encrypt requires --password or VARNISH_PASSWORD env var")
+		if _, err := crypto.NewPassword(); err != nil {
+			return fmt.Errorf("--encrypt: %w", err)
 		}
 	}
 
@@ -132,14 +134,24 @@ func runInit(args []string, stdout, stderr io.Writer) error {
 	// Set project name
 	cfg.Project = projectName
 
+	// --force can replace an existing config and --sync deletes variables
+	if *force || *sync {
+		if err := snapshotBefore("init", stderr); err != nil {
+			return err
+		}
+	}
+
 	// Save the project config to ~/.varnish/projects/<project>.yaml
 	if err := cfg.Save(); err != nil {
 		return fmt.Errorf("save config: %w", err)
 	}
 
 	// Register this directory with the project
-	reg.Register(cwd, projectName)
-	if err := reg.Save(); err != nil {
+	err = registry.Update(func(reg *registry.Registry) error {
+		reg.Register(cwd, projectName)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("save registry: %w", err)
 	}
 
@@ -151,11 +163,6 @@ func runInit(args []string, stdout, stderr io.Writer) error {
 	// or if encryption is being enabled
 	needsStore := (!*noImport && len(vars) > 0) || *encrypt
 	if needsStore {
-		st, err := store.Load()
-		if err != nil {
-			return fmt.Errorf("load store: %w", err)
-		}
-
 		// Build set of keys that should exist (from .env file)
 		shouldExist := make(map[string]bool)
 		for _, v := range vars {
@@ -166,65 +173,63 @@ func runInit(args []string, stdout, stderr io.Writer) error {
 		added := 0
 		removed := 0
 
-		// Add/update variables (if not --no-import)
-		// Variables without defaults get empty values - this shows the user what keys exist
-		if !*noImport {
-			for _, v := range vars {
-				storeKey := projectName + "." + v.Key
-				// Only update if key doesn't exist or has a value to set
-				_, exists := st.Get(storeKey)
-				if v.HasValue {
-					st.Set(storeKey, v.Default)
-					added++
-				} else if !exists {
-					// Key doesn't exist - add with empty value so user knows it's needed
-					st.Set(storeKey, "")
-					added++
+		err := store.Update(func(st *store.Store) error {
+			// Add/update variables (if not --no-import)
+			// Variables without defaults get empty values - this shows the user what keys exist
+			if !*noImport {
+				for _, v := range vars {
+					storeKey := projectName + "." + v.Key
+					// Only update if key doesn't exist or has a value to set
+					_, exists := st.Get(storeKey)
+					if v.HasValue {
+						st.Set(storeKey, v.Default)
+						added++
+					} else if !exists {
+						// Key doesn't exist - add with empty value so user knows it's needed
+						st.Set(storeKey, "")
+						added++
+					}
+					// If key exists and no new value, leave it alone
 				}
-				// If key exists and no new value, leave it alone
-			}
 
-			// --sync: also remove variables NOT in .env file at all
-			if *sync {
-				prefix := projectName + "."
-				for _, key := range st.Keys() {
-					if strings.HasPrefix(key, prefix) && !shouldExist[key] {
-						st.Delete(key)
-						removed++
-						// Show the key without project prefix
-						shortKey := strings.TrimPrefix(key, prefix)
-						fmt.Fprintf(stdout, "removed %s (not in .env)\n", shortKey)
+				// --sync: also remove variables NOT in .env file at all
+				if *sync {
+					prefix := projectName + "."
+					for _, key := range st.Keys() {
+						if strings.HasPrefix(key, prefix) && !shouldExist[key] {
+							st.Delete(key)
+							removed++
+							// Show the key without project prefix
+							shortKey := strings.TrimPrefix(key, prefix)
+							fmt.Fprintf(stdout, "removed %s (not in .env)\n", shortKey)
+						}
 					}
 				}
 			}
-		}
 
-		// Enable encryption if requested
-		encryptionEnabled := false
-		if *encrypt {
-			if st.IsEncrypted() {
-				fmt.Fprintln(stdout, "store is already encrypted")
-			} else {
-				if err := st.EnableEncryption(); err != nil {
-					return fmt.Errorf("enable encryption: %w", err)
+			// Enable encryption if requested
+			if *encrypt {
+				if st.IsEncrypted() {
+					fmt.Fprintln(stdout, "store is already encrypted")
+				} else {
+					if err := st.EnableEncryption(); err != nil {
+						return fmt.Errorf("enable encryption: %w", err)
+					}
+					fmt.Fprintln(stdout, "encryption enabled for store")
 				}
-				encryptionEnabled = true
-				fmt.Fprintln(stdout, "encryption enabled for store")
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		if added > 0 || removed > 0 || encryptionEnabled {
-			if err := st.Save(); err != nil {
-				return fmt.Errorf("save store: %w", err)
-			}
-			if added > 0 {
-				fmt.Fprintf(stdout, "imported %d variables into store\n", added)
-			}
-			if removed > 0 {
-				fmt.Fprintf(stdout, "removed %d stale variables\n", removed)
-			}
+		if added > 0 {
+			fmt.Fprintf(stdout, "imported %d variables into store\n", added)
+		}
+		if removed > 0 {
+			fmt.Fprintf(stdout, "removed %d stale variables\n", removed)
 		}
-
 	}
 
 	return nil
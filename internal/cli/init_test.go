@@ -443,11 +443,20 @@ func TestRunInitEncryptWithPasswordFlag(t *testing.T) {
 		t.Fatalf("chdir: %v", err)
 	}
 
+	unsetenv(t, crypto.PasswordEnvVar)
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
 	var stdout, stderr bytes.Buffer
 	err = runInit([]string{"--project", "pwdtest", "--encrypt", "--password", "mypassword"}, &stdout, &stderr)
 	if err != nil {
 		t.Fatalf("runInit error: %v\nstderr: %s", err, stderr.String())
 	}
+	if !strings.Contains(stderr.String(), "--password is deprecated") {
+		t.Errorf("expected a deprecation warning, got: %s", stderr.String())
+	}
+	if os.Getenv(crypto.PasswordEnvVar) != "" {
+		t.Errorf("--password was copied to %s", crypto.PasswordEnvVar)
+	}
 
 	output := stdout.String()
 	if !strings.Contains(output, "encryption enabled") {
@@ -0,0 +1,112 @@
+// key.go implements "varnish key", which manages the user's X25519
+// identity for stores encrypted to recipients.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "key" command here
+//
+// The identity is the private half; the public key printed alongside it is
+// what teammates add with "varnish store recipients add":
+//
+//	varnish key generate                    # Write ~/.varnish/identity.txt
+//	varnish key generate --output team.txt  # Write elsewhere
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+func runKey(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		printKeyUsage(stderr)
+		return fmt.Errorf("missing subcommand")
+	}
+
+	subcmd := args[0]
+	subArgs := args[1:]
+
+	switch subcmd {
+	case "generate":
+		return runKeyGenerate(subArgs, stdout, stderr)
+	case "help", "-h", "--help":
+		printKeyUsage(stdout)
+		return nil
+	default:
+		fmt.Fprintf(stderr, "unknown key subcommand: %s\n\n", subcmd)
+		printKeyUsage(stderr)
+		return fmt.Errorf("unknown key subcommand: %s", subcmd)
+	}
+}
+
+func printKeyUsage(w io.Writer) {
+	fmt.Fprintln(w, `Usage: varnish key <subcommand> [flags]
+
+Subcommands:
+  generate            Create an identity and print its public key
+
+Flags:
+  --output    Where to write the identity (default: VARNISH_IDENTITY, else
+              ~/.varnish/identity.txt)
+  --force     Replace an existing identity file
+
+Give the public key to whoever manages the store, who adds it with
+'varnish store recipients add <public key>'. Keep the identity file secret:
+it decrypts every store encrypted to its public key.
+
+Examples:
+  varnish key generate
+  varnish store recipients add varnish1...`)
+}
+
+func runKeyGenerate(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("key generate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "", "where to write the identity (default: VARNISH_IDENTITY, else ~/.varnish/identity.txt)")
+	force := fs.Bool("force", false, "replace an existing identity file")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	path := *output
+	if path == "" {
+		p, err := crypto.IdentityPath()
+		if err != nil {
+			return err
+		}
+		path = p
+	}
+
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists (use --force to replace it; stores encrypted to it can no longer be decrypted)", path)
+	}
+
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+	recipient := id.Recipient().String()
+
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), recipient, id)
+	if err := os.MkdirAll(filepath.Dir(path), config.PermDir); err != nil {
+		return fmt.Errorf("create identity directory: %w", err)
+	}
+	if err := config.AtomicWrite(path, []byte(content), config.PermSecure); err != nil {
+		return fmt.Errorf("write identity: %w", err)
+	}
+
+	fmt.Fprintf(stderr, "identity written to %s\n", path)
+	fmt.Fprintf(stdout, "public key: %s\n", recipient)
+	return nil
+}
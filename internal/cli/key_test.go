@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+func TestRunKeyGenerate(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	unsetenv(t, crypto.IdentityEnvVar)
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"key", "generate"}, &stdout, &stderr); err != nil {
+		t.Fatalf("key generate error: %v", err)
+	}
+
+	pub := strings.TrimPrefix(strings.TrimSpace(stdout.String()), "public key: ")
+	if _, err := crypto.ParseRecipient(pub); err != nil {
+		t.Fatalf("printed public key %q: %v", pub, err)
+	}
+
+	path, _ := config.IdentityPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("identity file not written: %v", err)
+	}
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("identity permissions = %o, want %o", info.Mode().Perm(), config.PermSecure)
+	}
+	ids, err := crypto.LoadIdentities()
+	if err != nil {
+		t.Fatalf("LoadIdentities() error: %v", err)
+	}
+	if ids[0].Recipient().String() != pub {
+		t.Error("identity file doesn't match the printed public key")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# public key: "+pub) {
+		t.Errorf("identity file should note the public key:\n%s", data)
+	}
+
+	// An existing identity is not replaced without --force
+	if err := run([]string{"key", "generate"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected already-exists error, got %v", err)
+	}
+	stdout.Reset()
+	if err := run([]string{"key", "generate", "--force"}, &stdout, &stderr); err != nil {
+		t.Fatalf("key generate --force error: %v", err)
+	}
+	if strings.Contains(stdout.String(), pub) {
+		t.Error("--force should generate a new key")
+	}
+}
+
+func TestRunKeyGenerateOutput(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	path := filepath.Join(t.TempDir(), "keys", "team.txt")
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"key", "generate", "--output", path}, &stdout, &stderr); err != nil {
+		t.Fatalf("key generate --output error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("identity not written to --output: %v", err)
+	}
+	if !strings.Contains(stderr.String(), path) {
+		t.Errorf("stderr should name the file: %s", stderr.String())
+	}
+}
+
+func TestRunKeyUnknown(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"key"}, &stdout, &stderr); err == nil {
+		t.Error("key without a subcommand should fail")
+	}
+	if err := run([]string{"key", "bogus"}, &stdout, &stderr); err == nil {
+		t.Error("key with an unknown subcommand should fail")
+	}
+}
@@ -3,12 +3,16 @@
 // This file is used by:
 //   - cli/root.go: dispatches "list" command here
 //
-// Shows the project's resolved variables.
+// Shows the project's resolved variables. Values inherited from a parent
+// project (extends) are marked with the ancestor they came from, and
+// secrets are masked unless --reveal is given.
 // Options:
 //
 //	--resolved   Show final resolved values (default behavior)
 //	--missing    Only show variables that are missing from the store
-//	--json       Output as JSON
+//	--json       Output as JSON (values unmasked, with a sensitive flag)
+//	--reveal     Show secret values instead of masking them
+//	--profile    Profile to apply (default: $VARNISH_PROFILE)
 package cli
 
 import (
@@ -17,9 +21,8 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/dk/varnish/internal/project"
 	"github.com/dk/varnish/internal/resolver"
-	"github.com/dk/varnish/internal/store"
+	"github.com/dk/varnish/internal/secret"
 )
 
 func runList(args []string, stdout, stderr io.Writer) error {
@@ -28,22 +31,21 @@ func runList(args []string, stdout, stderr io.Writer) error {
 	resolved := fs.Bool("resolved", false, "show resolved values (default)")
 	missing := fs.Bool("missing", false, "only show missing variables")
 	jsonOutput := fs.Bool("json", false, "output as JSON")
+	reveal := fs.Bool("reveal", false, "show secret values")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	// Load project config
-	cfg, err := project.Load()
+	// Load project config (with the active profile applied)
+	cfg, err := loadProjectConfig(*profile)
 	if err != nil {
-		return fmt.Errorf("load project config: %w", err)
-	}
-	if cfg == nil {
-		return fmt.Errorf("no .varnish.yaml found (run 'varnish init' first)")
+		return err
 	}
 
 	// Load store
-	st, err := store.Load()
+	st, err := loadStoreFor(cfg)
 	if err != nil {
 		return fmt.Errorf("load store: %w", err)
 	}
@@ -75,21 +77,27 @@ func runList(args []string, stdout, stderr io.Writer) error {
 
 	// Default: show resolved variables
 	_ = resolved // Flag exists for explicitness, but is default behavior
-	vars := res.Resolve()
+	vars, err := res.Resolve()
+	if err != nil {
+		return err
+	}
 	missingVars := res.MissingVars()
 
 	if *jsonOutput {
 		// Build JSON-friendly structure
-		varList := make([]map[string]string, 0, len(vars))
+		varList := make([]map[string]interface{}, 0, len(vars))
 		for _, v := range vars {
-			varList = append(varList, map[string]string{
-				"name":   v.EnvName,
-				"value":  v.Value,
-				"source": v.Source,
-				"key":    v.Key,
+			varList = append(varList, map[string]interface{}{
+				"name":      v.EnvName,
+				"value":     v.Value,
+				"source":    v.Source,
+				"key":       v.Key,
+				"origin":    v.Origin,
+				"sensitive": v.Sensitive,
 			})
 		}
 		return json.NewEncoder(stdout).Encode(map[string]interface{}{
+			"profile":   cfg.Profile,
 			"variables": varList,
 			"missing":   missingVars,
 		})
@@ -101,10 +109,19 @@ func runList(args []string, stdout, stderr io.Writer) error {
 	}
 
 	// Print with source information
-	fmt.Fprintln(stdout, "resolved variables:")
+	if cfg.Profile != "" {
+		fmt.Fprintf(stdout, "resolved variables (profile: %s):\n", cfg.Profile)
+	} else {
+		fmt.Fprintln(stdout, "resolved variables:")
+	}
 	for _, v := range vars {
 		source := formatSource(v.Source, v.Key)
-		fmt.Fprintf(stdout, "  %s=%s  (%s)\n", v.EnvName, v.Value, source)
+		if v.Origin != "" && v.Origin != cfg.Project {
+			// Inherited through extends
+			source += ", from " + v.Origin
+		}
+		value := secret.MaskIf(v.Value, v.Sensitive, *reveal)
+		fmt.Fprintf(stdout, "  %s=%s  (%s)\n", v.EnvName, value, source)
 	}
 
 	// Also show any missing
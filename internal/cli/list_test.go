@@ -291,6 +291,53 @@ func TestRunListShowsSource(t *testing.T) {
 	}
 }
 
+func TestRunListShowsInheritedOrigin(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForList(t, "listchild")
+	defer cleanupProject()
+
+	base := project.New()
+	base.Project = "listbase"
+	base.Include = []string{"kafka.*"}
+	if err := base.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	child, _ := project.LoadByName("listchild")
+	child.Extends = []string{"listbase"}
+	if err := child.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	store, _ := store.Load()
+	store.Set("listbase.kafka.brokers", "kafka:9092")
+	store.Set("listchild.test.var", "mine")
+	if err := store.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runList([]string{}, &stdout, &stderr); err != nil {
+		t.Fatalf("runList error: %v", err)
+	}
+
+	output := stdout.String()
+	if !strings.Contains(output, "KAFKA_BROKERS=kafka:9092  (store: kafka.brokers, from listbase)") {
+		t.Errorf("expected inherited origin, got: %s", output)
+	}
+	if strings.Contains(output, "TEST_VAR=mine  (store: test.var, from") {
+		t.Errorf("own values should not show an origin, got: %s", output)
+	}
+}
+
 func TestFormatSource(t *testing.T) {
 	tests := []struct {
 		source   string
@@ -344,3 +391,48 @@ func setupProjectForList(t *testing.T, projectName string) (string, func()) {
 		os.RemoveAll(projectDir)
 	}
 }
+
+func TestRunListMasksSecrets(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForList(t, "listmask")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("listmask.test.token", "abc123")
+	st.Set("listmask.test.name", "visible")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runList([]string{}, &stdout, &stderr); err != nil {
+		t.Fatalf("runList error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "TEST_TOKEN=********") || !strings.Contains(stdout.String(), "TEST_NAME=visible") {
+		t.Errorf("expected masked token only, got: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runList([]string{"--reveal"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runList --reveal error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "TEST_TOKEN=abc123") {
+		t.Errorf("expected revealed token, got: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runList([]string{"--json"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runList --json error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), `"name":"TEST_TOKEN","origin":"listmask","sensitive":true,"source":"store","value":"abc123"`) {
+		t.Errorf("expected sensitive flag in JSON, got: %s", stdout.String())
+	}
+}
@@ -0,0 +1,52 @@
+// profile.go holds the --profile handling shared by several commands.
+//
+// This file is used by:
+//   - cli/env.go, list.go, check.go, run.go, export.go: layer the profile onto the config
+//   - cli/store.go: namespace keys under project@profile
+//   - cli/hook.go: follow VARNISH_PROFILE in the prompt hook
+//
+// The active profile comes from --profile, falling back to VARNISH_PROFILE.
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dk/varnish/internal/project"
+)
+
+// activeProfile returns the profile from the flag, or VARNISH_PROFILE if unset.
+func activeProfile(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(project.ProfileEnvVar)
+}
+
+// applyProfile layers the active profile onto cfg.
+func applyProfile(cfg *project.Config, flagValue string) (*project.Config, error) {
+	return cfg.WithProfile(activeProfile(flagValue))
+}
+
+// loadProjectConfig loads the current directory's project config with the
+// active profile applied.
+func loadProjectConfig(profileFlag string) (*project.Config, error) {
+	cfg, err := loadBaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	return applyProfile(cfg, profileFlag)
+}
+
+// loadBaseConfig loads the current directory's project config without
+// applying a profile.
+func loadBaseConfig() (*project.Config, error) {
+	cfg, err := project.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load project config: %w", err)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("no .varnish.yaml found (run 'varnish init' first)")
+	}
+	return cfg, nil
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/store"
+)
+
+// setupProfileProject registers a project with a "test" profile.
+func setupProfileProject(t *testing.T, name string) string {
+	t.Helper()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, name)
+	t.Cleanup(cleanupProject)
+
+	cfg, err := project.LoadByName(name)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	cfg.Profiles = map[string]*project.Profile{
+		"test": {Overrides: map[string]string{"db.name": "app_test"}},
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	return projectDir
+}
+
+func TestRunEnvProfile(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir := setupProfileProject(t, "envprof")
+
+	st, _ := store.Load()
+	st.Set("envprof.db.host", "localhost")
+	st.Set("envprof@test.db.host", "test-db")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runEnv([]string{"--dry-run", "--profile", "test"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv error: %v", err)
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "DB_HOST=test-db") {
+		t.Errorf("expected profile store value, got:\n%s", output)
+	}
+	if !strings.Contains(output, "DB_NAME=app_test") {
+		t.Errorf("expected profile override, got:\n%s", output)
+	}
+
+	// VARNISH_PROFILE is used when --profile is not given
+	t.Setenv(project.ProfileEnvVar, "test")
+	stdout.Reset()
+	if err := runEnv([]string{"--dry-run"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runEnv error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "DB_HOST=test-db") {
+		t.Errorf("expected VARNISH_PROFILE to apply, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunEnvUnknownProfile(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir := setupProfileProject(t, "envbadprof")
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := runEnv([]string{"--dry-run", "--profile", "prod"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "unknown profile") {
+		t.Errorf("expected unknown profile error, got %v", err)
+	}
+}
+
+func TestRunStoreProfile(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir := setupProfileProject(t, "storeprof")
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runStore([]string{"set", "--profile", "test", "db.host", "test-db"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store set error: %v", err)
+	}
+	if err := runStore([]string{"set", "db.port", "5432"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store set error: %v", err)
+	}
+
+	st, _ := store.Load()
+	if v, _ := st.Get("storeprof@test.db.host"); v != "test-db" {
+		t.Errorf("expected key under profile namespace, got %q", v)
+	}
+
+	// get falls back to the base namespace
+	stdout.Reset()
+	if err := runStore([]string{"get", "--profile", "test", "db.port"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store get error: %v", err)
+	}
+	if strings.TrimSpace(stdout.String()) != "5432" {
+		t.Errorf("expected fallback to base value, got %q", stdout.String())
+	}
+
+	// list only shows the profile namespace
+	stdout.Reset()
+	if err := runStore([]string{"list", "--profile", "test"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store list error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "storeprof@test.db.host=test-db") || strings.Contains(stdout.String(), "db.port") {
+		t.Errorf("unexpected list output:\n%s", stdout.String())
+	}
+
+	// unknown profiles are rejected
+	if err := runStore([]string{"set", "--profile", "nope", "a", "b"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for undeclared profile")
+	}
+}
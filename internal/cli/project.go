@@ -58,7 +58,8 @@ func printProjectUsage(w io.Writer) {
 Subcommands:
   name            Show current project name (default)
   list            List all projects in the store (with numeric IDs)
-  delete <ref>    Delete all variables for a project (by name or ID)
+  delete <ref>    Delete all variables for a project (by name or ID);
+                  a snapshot is taken first (see 'varnish snapshot')
 
 Flags:
   --path      Show path to project config (with 'name')
@@ -75,6 +76,9 @@ Examples:
   varnish project delete 2 --dry-run  # preview deletion by ID`)
 }
 
+// lockedCount stands for the unknown variable count of a locked project.
+const lockedCount = -1
+
 // getOrderedProjects returns project names sorted alphabetically with their variable counts.
 // The order is stable and used for numeric ID assignment.
 func getOrderedProjects() ([]string, map[string]int, error) {
@@ -88,10 +92,17 @@ func getOrderedProjects() ([]string, map[string]int, error) {
 	for _, key := range st.Keys() {
 		idx := strings.Index(key, ".")
 		if idx > 0 {
-			proj := key[:idx]
+			// Profile namespaces (myapp@test) count towards their project
+			proj, _ := project.SplitNamespace(key[:idx])
 			projects[proj]++
 		}
 	}
+	// Projects encrypted separately are listed while locked, uncounted
+	for _, name := range st.EncryptedProjects() {
+		if st.IsLocked(name) {
+			projects[name] = lockedCount
+		}
+	}
 
 	// Sort project names for stable ordering
 	names := make([]string, 0, len(projects))
@@ -204,13 +215,13 @@ func runProjectList(args []string, stdout, stderr io.Writer) error {
 		id := i + 1 // 1-based IDs
 		if regErr != nil {
 			// No registry, just show without directory info
-			fmt.Fprintf(stdout, "%d  %s (%d variables)\n", id, name, projects[name])
+			fmt.Fprintf(stdout, "%d  %s (%s)\n", id, name, describeCount(projects[name]))
 		} else {
 			dirs := reg.ProjectDirs(name)
 			if len(dirs) > 0 {
-				fmt.Fprintf(stdout, "%d  %s (%d variables) → %s\n", id, name, projects[name], dirs[0])
+				fmt.Fprintf(stdout, "%d  %s (%s) → %s\n", id, name, describeCount(projects[name]), dirs[0])
 			} else {
-				fmt.Fprintf(stdout, "%d  %s (%d variables)\n", id, name, projects[name])
+				fmt.Fprintf(stdout, "%d  %s (%s)\n", id, name, describeCount(projects[name]))
 			}
 		}
 	}
@@ -218,6 +229,14 @@ func runProjectList(args []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
+// describeCount describes a project's variable count from getOrderedProjects.
+func describeCount(n int) string {
+	if n == lockedCount {
+		return "encrypted, locked"
+	}
+	return fmt.Sprintf("%d variables", n)
+}
+
 // runProjectDelete deletes all variables for a project
 func runProjectDelete(args []string, stdout, stderr io.Writer) error {
 	fs := flag.NewFlagSet("project delete", flag.ContinueOnError)
@@ -242,24 +261,53 @@ func runProjectDelete(args []string, stdout, stderr io.Writer) error {
 		return err
 	}
 	prefix := projectName + "."
+	profilePrefix := projectName + project.ProfileSeparator
 
-	st, err := store.Load()
-	if err != nil {
-		return fmt.Errorf("load store: %w", err)
-	}
-
-	// Find all keys for this project
+	// Find and delete all keys for this project, including its profile
+	// namespaces, under the store lock
 	var toDelete []string
-	for _, key := range st.Keys() {
-		if strings.HasPrefix(key, prefix) {
-			toDelete = append(toDelete, key)
+	empty := false
+	locked := false
+	err = store.Update(func(st *store.Store) error {
+		// A locked project goes without its password: its segment is
+		// deleted whole
+		locked = st.IsLocked(projectName)
+		for _, key := range st.Keys() {
+			if strings.HasPrefix(key, prefix) || strings.HasPrefix(key, profilePrefix) {
+				toDelete = append(toDelete, key)
+			}
+		}
+
+		if len(toDelete) == 0 && !locked {
+			return fmt.Errorf("no variables found for project: %s", projectName)
+		}
+		if *dryRun {
+			return nil
+		}
+
+		if err := snapshotBefore("project delete "+projectName, stderr); err != nil {
+			return err
 		}
-	}
 
-	if len(toDelete) == 0 {
-		return fmt.Errorf("no variables found for project: %s", projectName)
+		for _, key := range toDelete {
+			st.Delete(key)
+		}
+		if locked {
+			if err := st.DropProject(projectName); err != nil {
+				return err
+			}
+		}
+		empty = st.Len() == 0
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	if *dryRun && locked {
+		fmt.Fprintf(stdout, "would delete encrypted project '%s' (locked, with all its variables)\n", projectName)
+		return nil
+	}
 	if *dryRun {
 		fmt.Fprintf(stdout, "would delete %d variables for project '%s':\n", len(toDelete), projectName)
 		for _, key := range toDelete {
@@ -268,37 +316,31 @@ func runProjectDelete(args []string, stdout, stderr io.Writer) error {
 		return nil
 	}
 
-	// Delete all keys
-	for _, key := range toDelete {
-		st.Delete(key)
-	}
-
 	// If store is now empty, remove the file entirely
-	if st.Len() == 0 {
+	if empty {
 		if removeErr := store.Remove(); removeErr != nil {
 			return fmt.Errorf("remove store: %w", removeErr)
 		}
-	} else {
-		if saveErr := st.Save(); saveErr != nil {
-			return fmt.Errorf("save store: %w", saveErr)
-		}
 	}
 
-	// Also remove from registry and delete config
-	reg, regErr := registry.Load()
-	if regErr == nil {
+	// Also remove from registry and delete config (best effort)
+	_ = registry.Update(func(reg *registry.Registry) error {
 		// Remove all directory registrations for this project
 		for dir, p := range reg.Projects {
 			if p == projectName {
 				delete(reg.Projects, dir)
 			}
 		}
-		_ = reg.Save() // Best effort
-	}
+		return nil
+	})
 
 	// Delete project config file (best effort)
 	_ = project.Delete(projectName)
 
-	fmt.Fprintf(stdout, "deleted %d variables for project '%s'\n", len(toDelete), projectName)
+	if locked {
+		fmt.Fprintf(stdout, "deleted encrypted project '%s' with all its variables\n", projectName)
+	} else {
+		fmt.Fprintf(stdout, "deleted %d variables for project '%s'\n", len(toDelete), projectName)
+	}
 	return nil
 }
@@ -0,0 +1,254 @@
+// recipients.go implements "varnish store recipients", which encrypts the
+// store to the public keys of the people sharing it.
+//
+// This file is used by:
+//   - cli/store.go: dispatches "store recipients" here
+//
+// Each developer creates an identity with "varnish key generate" and
+// unlocks the store with it, so no password needs to be shared:
+//
+//	varnish store recipients list                # Who can decrypt the store
+//	varnish store recipients add varnish1...     # Add a teammate
+//	varnish store recipients remove varnish1...  # Remove one, with a new data key
+//
+// The first "add" switches a plaintext or password-encrypted store over to
+// recipients, including your own public key so you can still decrypt it.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/store"
+)
+
+func runStoreRecipients(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		printRecipientsUsage(stderr)
+		return fmt.Errorf("missing subcommand")
+	}
+
+	subcmd := args[0]
+	subArgs := args[1:]
+
+	switch subcmd {
+	case "list", "ls":
+		return runRecipientsList(subArgs, stdout, stderr)
+	case "add":
+		return runRecipientsAdd(subArgs, stdout, stderr)
+	case "remove", "rm":
+		return runRecipientsRemove(subArgs, stdout, stderr)
+	case "help", "-h", "--help":
+		printRecipientsUsage(stdout)
+		return nil
+	default:
+		fmt.Fprintf(stderr, "unknown recipients subcommand: %s\n\n", subcmd)
+		printRecipientsUsage(stderr)
+		return fmt.Errorf("unknown recipients subcommand: %s", subcmd)
+	}
+}
+
+func printRecipientsUsage(w io.Writer) {
+	fmt.Fprintln(w, `Usage: varnish store recipients <subcommand> [flags]
+
+Subcommands:
+  list, ls                List the public keys the store is encrypted to
+  add <public key>...     Encrypt the store to more public keys
+  remove, rm <key>...     Stop encrypting the store to public keys
+
+Flags:
+  --force     Remove even if none of your identities would be left (with 'remove')
+
+Public keys come from 'varnish key generate'. The first 'add' switches a
+plaintext or password-encrypted store to recipients and adds your own
+public key too, so you need an identity first. Every change re-encrypts
+the store and its history with a new data key; the previous ciphertext is
+kept in *.bak files.
+
+Examples:
+  varnish key generate
+  varnish store recipients add varnish1qz...
+  varnish store recipients list`)
+}
+
+func runRecipientsList(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store recipients list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	recipients, err := store.LoadRecipients()
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		fmt.Fprintln(stdout, "store is not encrypted to recipients")
+		return nil
+	}
+
+	identities, _ := crypto.LoadIdentities()
+	for _, r := range recipients {
+		if isOwnRecipient(r, identities) {
+			fmt.Fprintf(stdout, "%s (you)\n", r)
+		} else {
+			fmt.Fprintln(stdout, r)
+		}
+	}
+	return nil
+}
+
+func runRecipientsAdd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store recipients add", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	added, err := parseRecipients(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	recipients, err := store.LoadRecipients()
+	if err != nil {
+		return err
+	}
+
+	// Switching to recipients: include our own key, or we'd be locked out
+	if len(recipients) == 0 {
+		identities, err := crypto.LoadIdentities()
+		if err != nil {
+			return fmt.Errorf("switching to recipients adds your own public key: %w", err)
+		}
+		recipients = append(recipients, identities[0].Recipient())
+	}
+
+	count := 0
+	for _, r := range added {
+		if containsRecipient(recipients, r) {
+			fmt.Fprintf(stderr, "%s is already a recipient\n", r)
+			continue
+		}
+		recipients = append(recipients, r)
+		count++
+	}
+
+	backups, err := store.SetRecipients(recipients)
+	if err != nil {
+		return fmt.Errorf("encrypt store: %w", err)
+	}
+	// Password keys don't apply any more
+	if err := store.ForgetKeys(); err != nil {
+		fmt.Fprintf(stderr, "warning: %v\n", err)
+	}
+
+	fmt.Fprintf(stdout, "added %d recipient(s); store encrypted to %d recipient(s)\n", count, len(recipients))
+	printBackups(stdout, backups)
+	return nil
+}
+
+func runRecipientsRemove(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store recipients remove", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	force := fs.Bool("force", false, "remove even if none of your identities would be left")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	removed, err := parseRecipients(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	current, err := store.LoadRecipients()
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return errors.New("store is not encrypted to recipients")
+	}
+
+	for _, r := range removed {
+		if !containsRecipient(current, r) {
+			return fmt.Errorf("%s is not a recipient", r)
+		}
+	}
+	var remaining []crypto.Recipient
+	for _, r := range current {
+		if !containsRecipient(removed, r) {
+			remaining = append(remaining, r)
+		}
+	}
+	if len(remaining) == 0 {
+		return errors.New("cannot remove every recipient (use 'varnish store decrypt' to turn encryption off)")
+	}
+
+	identities, _ := crypto.LoadIdentities()
+	mine := false
+	for _, r := range remaining {
+		mine = mine || isOwnRecipient(r, identities)
+	}
+	if !mine && !*force {
+		return errors.New("none of your identities would be a recipient, so you couldn't decrypt the store any more (use --force)")
+	}
+
+	backups, err := store.SetRecipients(remaining)
+	if err != nil {
+		return fmt.Errorf("encrypt store: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "removed %d recipient(s); store encrypted to %d recipient(s) with a new data key\n", len(removed), len(remaining))
+	fmt.Fprintln(stdout, "copies they already have can still be decrypted; rotate secrets they shouldn't keep")
+	printBackups(stdout, backups)
+	return nil
+}
+
+// parseRecipients parses public keys given as arguments.
+func parseRecipients(args []string) ([]crypto.Recipient, error) {
+	if len(args) == 0 {
+		return nil, errors.New("missing public key")
+	}
+	var recipients []crypto.Recipient
+	for _, arg := range args {
+		r, err := crypto.ParseRecipient(arg)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// containsRecipient reports whether r is in recipients.
+func containsRecipient(recipients []crypto.Recipient, r crypto.Recipient) bool {
+	for _, other := range recipients {
+		if other.Equal(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnRecipient reports whether r belongs to one of identities.
+func isOwnRecipient(r crypto.Recipient, identities []*crypto.Identity) bool {
+	for _, id := range identities {
+		if id.Recipient().Equal(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/store"
+)
+
+// newTestIdentity generates an identity and returns it with the path of
+// an identity file holding it.
+func newTestIdentity(t *testing.T) (*crypto.Identity, string) {
+	t.Helper()
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	if err := os.WriteFile(path, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return id, path
+}
+
+func TestRunStoreRecipients(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedStoreCLI(t, "password")
+	me, myPath := newTestIdentity(t)
+	teammate, teammatePath := newTestIdentity(t)
+	t.Setenv(crypto.IdentityEnvVar, myPath)
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "recipients", "list"}, &stdout, &stderr); err != nil {
+		t.Fatalf("recipients list error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "not encrypted to recipients") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	// The first add switches from the password, adding our own key
+	stdout.Reset()
+	if err := run([]string{"store", "recipients", "add", teammate.Recipient().String()}, &stdout, &stderr); err != nil {
+		t.Fatalf("recipients add error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store encrypted to 2 recipient(s)") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"store", "recipients", "ls"}, &stdout, &stderr); err != nil {
+		t.Fatalf("recipients list error: %v", err)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, me.Recipient().String()+" (you)") || !strings.Contains(out, teammate.Recipient().String()) {
+		t.Errorf("unexpected list:\n%s", out)
+	}
+
+	// The teammate reads it with their own identity, no password
+	unsetenv(t, crypto.PasswordEnvVar)
+	t.Setenv(crypto.IdentityEnvVar, teammatePath)
+	stdout.Reset()
+	if err := run([]string{"store", "get", "--global", "app.name"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store get as teammate error: %v", err)
+	}
+	if strings.TrimSpace(stdout.String()) != "demo" {
+		t.Errorf("store get = %q, want demo", stdout.String())
+	}
+
+	// Removing the teammate locks them out
+	t.Setenv(crypto.IdentityEnvVar, myPath)
+	stdout.Reset()
+	if err := run([]string{"store", "recipients", "remove", teammate.Recipient().String()}, &stdout, &stderr); err != nil {
+		t.Fatalf("recipients remove error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "new data key") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+	t.Setenv(crypto.IdentityEnvVar, teammatePath)
+	if _, err := store.Load(); err == nil {
+		t.Error("removed teammate should not decrypt the store")
+	}
+}
+
+func TestRunStoreRecipientsAddNeedsIdentity(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	teammate, _ := newTestIdentity(t)
+	t.Setenv(crypto.IdentityEnvVar, filepath.Join(t.TempDir(), "missing.txt"))
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"store", "recipients", "add", teammate.Recipient().String()}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "varnish key generate") {
+		t.Errorf("expected identity error, got %v", err)
+	}
+}
+
+func TestRunStoreRecipientsRemoveChecks(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	me, myPath := newTestIdentity(t)
+	teammate, _ := newTestIdentity(t)
+	stranger, _ := newTestIdentity(t)
+	t.Setenv(crypto.IdentityEnvVar, myPath)
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "recipients", "remove", teammate.Recipient().String()}, &stdout, &stderr); err == nil {
+		t.Error("remove on a store without recipients should fail")
+	}
+
+	if err := run([]string{"store", "recipients", "add", teammate.Recipient().String()}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"not a recipient", []string{stranger.Recipient().String()}, "not a recipient"},
+		{"everyone", []string{me.Recipient().String(), teammate.Recipient().String()}, "every recipient"},
+		{"yourself", []string{me.Recipient().String()}, "--force"},
+		{"invalid key", []string{"varnish1bogus"}, "invalid recipient"},
+		{"no key", nil, "missing public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"store", "recipients", "remove"}, tt.args...)
+			err := run(args, &stdout, &stderr)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	// With --force you can remove yourself
+	if err := run([]string{"store", "recipients", "remove", "--force", me.Recipient().String()}, &stdout, &stderr); err != nil {
+		t.Errorf("remove --force error: %v", err)
+	}
+}
+
+func TestRunStoreRecipientsPasswordCommands(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	_, myPath := newTestIdentity(t)
+	teammate, _ := newTestIdentity(t)
+	t.Setenv(crypto.IdentityEnvVar, myPath)
+	setupEncryptedStoreCLI(t, "password")
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "recipients", "add", teammate.Recipient().String()}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(crypto.NewPasswordEnvVar, "new-password")
+	if err := run([]string{"store", "rekey"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "recipients") {
+		t.Errorf("rekey error = %v, want recipients error", err)
+	}
+	if err := run([]string{"store", "encrypt"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "recipients") {
+		t.Errorf("encrypt error = %v, want recipients error", err)
+	}
+	if err := run([]string{"unlock"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "nothing to unlock") {
+		t.Errorf("unlock error = %v, want nothing to unlock", err)
+	}
+
+	// Decrypting goes back to plaintext
+	stdout.Reset()
+	if err := run([]string{"store", "decrypt", "--yes"}, &stdout, &stderr); err != nil {
+		t.Fatalf("decrypt error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store decrypted") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
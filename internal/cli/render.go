@@ -0,0 +1,259 @@
+// render.go implements "varnish render", which writes the files listed
+// under templates: in the project config.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "render" command here
+//
+// For tools that read config files rather than the environment (nginx,
+// pgbouncer, Spring), each template is rendered with the resolved
+// variables and store keys (see render/render.go):
+//
+//	templates:
+//	  deploy/nginx.conf.tmpl: deploy/nginx.conf
+//	  config/application.yaml.tmpl: config/application.yaml
+//
+// Paths are relative to the project directory. Outputs are written 0600
+// since they usually hold secrets:
+//
+//	varnish render                        # Render every template
+//	varnish render deploy/nginx.conf.tmpl # Render one template
+//	varnish render --dry-run              # Preview (secrets masked)
+//
+// An existing output is only replaced if varnish rendered it and it hasn't
+// been edited since (see render/manifest.go), or with --force.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/registry"
+	"github.com/dk/varnish/internal/render"
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/secret"
+)
+
+// renderedFile is a template rendered in memory, not yet written.
+type renderedFile struct {
+	template string
+	output   string // Absolute path
+	content  []byte
+}
+
+func runRender(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dryRun := fs.Bool("dry-run", false, "print rendered files to stdout instead of writing them")
+	reveal := fs.Bool("reveal", false, "show secret values in --dry-run output")
+	force := fs.Bool("force", false, "overwrite outputs varnish didn't render, or that were edited since")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	cfg, err := loadProjectConfig(*profile)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Templates) == 0 {
+		return fmt.Errorf("project %s has no templates (add a templates: section to its config)", cfg.Project)
+	}
+
+	templates, err := selectTemplates(cfg.Templates, fs.Args())
+	if err != nil {
+		return err
+	}
+	root, err := projectRoot()
+	if err != nil {
+		return err
+	}
+	if err := checkTemplateOutputs(root, cfg.Templates); err != nil {
+		return err
+	}
+
+	st, err := loadStoreFor(cfg)
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+	res := resolver.New(st, cfg)
+	vars, err := res.Resolve()
+	if err != nil {
+		return err
+	}
+	if missing := res.MissingVars(); len(missing) > 0 {
+		fmt.Fprintf(stderr, "warning: missing variables in store: %s\n", strings.Join(missing, ", "))
+	}
+	if err := reportViolations(stderr, res.Validate(vars)); err != nil {
+		return err
+	}
+
+	// Dry-run output is for reading, so secrets are masked there unless
+	// --reveal is given
+	mask := *dryRun && !*reveal
+	masked := 0
+	data := render.Data{Env: make(map[string]string, len(vars)), Project: cfg.Project, Profile: cfg.Profile}
+	for _, v := range vars {
+		value := v.Value
+		if mask && v.Sensitive {
+			value = secret.Mask
+			masked++
+		}
+		data.Env[v.EnvName] = value
+	}
+	key := func(k string) string {
+		storeKey, ok := res.StoreKey(k)
+		if !ok {
+			return ""
+		}
+		value, _ := st.Get(storeKey)
+		if mask && secret.IsSensitive(k, value, st.IsSecret(storeKey)) {
+			masked++
+			return secret.Mask
+		}
+		return value
+	}
+
+	// Render everything before writing anything, so a template error
+	// doesn't leave half the outputs updated
+	var files []renderedFile
+	for _, tmpl := range templates {
+		text, err := os.ReadFile(inRoot(root, tmpl))
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+		content, err := render.Execute(tmpl, string(text), data, key)
+		if err != nil {
+			return fmt.Errorf("render %w", err)
+		}
+		output, err := filepath.Abs(inRoot(root, cfg.Templates[tmpl]))
+		if err != nil {
+			return err
+		}
+		files = append(files, renderedFile{template: tmpl, output: output, content: content})
+	}
+
+	if *dryRun {
+		for _, f := range files {
+			fmt.Fprintf(stdout, "==> %s <==\n%s", cfg.Templates[f.template], f.content)
+			if len(f.content) > 0 && f.content[len(f.content)-1] != '\n' {
+				fmt.Fprintln(stdout)
+			}
+		}
+		if masked > 0 {
+			fmt.Fprintf(stderr, "note: secret values masked (use --reveal to show)\n")
+		}
+		return nil
+	}
+
+	// A failed write ends the run, but the files written before it are
+	// still recorded, so the next run doesn't take them for hand-edited
+	var writeErr error
+	err = render.UpdateManifest(func(m *render.Manifest) error {
+		if !*force {
+			for _, f := range files {
+				owner, err := m.Owner(f.output)
+				if err != nil {
+					return err
+				}
+				switch owner {
+				case render.Edited:
+					return fmt.Errorf("%s has changed since varnish rendered it (use --force to overwrite)", f.output)
+				case render.Foreign:
+					return fmt.Errorf("%s exists and was not rendered by varnish (use --force to overwrite)", f.output)
+				}
+			}
+		}
+
+		for _, f := range files {
+			if err := os.MkdirAll(filepath.Dir(f.output), config.PermDir); err != nil {
+				writeErr = err
+				return nil
+			}
+			if err := config.AtomicWrite(f.output, f.content, config.PermSecure); err != nil {
+				writeErr = fmt.Errorf("write %s: %w", f.output, err)
+				return nil
+			}
+			m.Record(f.output, f.content)
+			fmt.Fprintf(stdout, "wrote %s (from %s)\n", cfg.Templates[f.template], f.template)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// selectTemplates returns the templates named in args, or all of them,
+// sorted.
+func selectTemplates(templates map[string]string, args []string) ([]string, error) {
+	if len(args) == 0 {
+		all := make([]string, 0, len(templates))
+		for tmpl := range templates {
+			all = append(all, tmpl)
+		}
+		sort.Strings(all)
+		return all, nil
+	}
+	for _, arg := range args {
+		if _, ok := templates[arg]; !ok {
+			return nil, fmt.Errorf("%s is not a template of this project", arg)
+		}
+	}
+	return args, nil
+}
+
+// checkTemplateOutputs refuses templates that write the same file, which
+// would otherwise overwrite each other in one run.
+func checkTemplateOutputs(root string, templates map[string]string) error {
+	names := make([]string, 0, len(templates))
+	for tmpl := range templates {
+		names = append(names, tmpl)
+	}
+	sort.Strings(names)
+
+	writtenBy := make(map[string]string) // output -> template
+	for _, tmpl := range names {
+		output := filepath.Clean(inRoot(root, templates[tmpl]))
+		if other, ok := writtenBy[output]; ok {
+			return fmt.Errorf("templates %s and %s both write %s", other, tmpl, templates[tmpl])
+		}
+		writtenBy[output] = tmpl
+	}
+	return nil
+}
+
+// projectRoot returns the registered directory of the current project,
+// which template paths are relative to.
+func projectRoot() (string, error) {
+	reg, err := registry.Load()
+	if err != nil {
+		return "", fmt.Errorf("load registry: %w", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if _, root := reg.LookupRoot(cwd); root != "" {
+		return root, nil
+	}
+	return cwd, nil
+}
+
+// inRoot resolves path against root unless it is absolute.
+func inRoot(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
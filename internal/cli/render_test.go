@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/store"
+)
+
+const testNginxTemplate = `server_name {{ .Env.DB_HOST }};
+port {{ key "db.port" | default "5432" }};
+password {{ .Env.DB_PASSWORD | quote }};
+`
+
+// setupRender creates a project with a template in its directory and
+// changes into a subdirectory of it.
+func setupRender(t *testing.T) (string, func()) {
+	t.Helper()
+	projectDir, cleanupProject := setupProjectForEnv(t, "myapp")
+
+	cfg, err := project.LoadByName("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Templates = map[string]string{"nginx.conf.tmpl": "out/nginx.conf"}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, _ := store.Load()
+	st.Set("myapp.db.host", "localhost")
+	st.Set("myapp.db.password", "s3cret")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(projectDir, "nginx.conf.tmpl"), []byte(testNginxTemplate), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(projectDir, "src")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	origWd, _ := os.Getwd()
+	if err := os.Chdir(sub); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	return projectDir, func() {
+		_ = os.Chdir(origWd)
+		cleanupProject()
+	}
+}
+
+func TestRunRender(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	projectDir, cleanupRender := setupRender(t)
+	defer cleanupRender()
+
+	var stdout, stderr bytes.Buffer
+	if err := runRender(nil, &stdout, &stderr); err != nil {
+		t.Fatalf("runRender error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "wrote out/nginx.conf (from nginx.conf.tmpl)") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	output := filepath.Join(projectDir, "out", "nginx.conf")
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("output permissions = %v, want %v", info.Mode().Perm(), config.PermSecure)
+	}
+	content, _ := os.ReadFile(output)
+	want := "server_name localhost;\nport 5432;\npassword \"s3cret\";\n"
+	if string(content) != want {
+		t.Errorf("rendered:\n%s\nwant:\n%s", content, want)
+	}
+
+	// A rendered file is replaced without --force
+	if err := runRender(nil, &stdout, &stderr); err != nil {
+		t.Fatalf("second runRender error: %v", err)
+	}
+
+	// An edited one is not
+	if err := os.WriteFile(output, []byte("edited\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = runRender(nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "changed since varnish rendered it") {
+		t.Errorf("expected edited file to be kept, got %v", err)
+	}
+	if err := runRender([]string{"--force"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runRender --force error: %v", err)
+	}
+	content, _ = os.ReadFile(output)
+	if string(content) != want {
+		t.Errorf("--force did not replace the edited file:\n%s", content)
+	}
+}
+
+func TestRunRenderRefusesForeignFile(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	projectDir, cleanupRender := setupRender(t)
+	defer cleanupRender()
+
+	output := filepath.Join(projectDir, "out", "nginx.conf")
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output, []byte("hand-written\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := runRender(nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "not rendered by varnish") {
+		t.Errorf("expected hand-written file to be kept, got %v", err)
+	}
+	content, _ := os.ReadFile(output)
+	if string(content) != "hand-written\n" {
+		t.Errorf("hand-written file changed:\n%s", content)
+	}
+}
+
+func TestRunRenderDryRun(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	projectDir, cleanupRender := setupRender(t)
+	defer cleanupRender()
+
+	var stdout, stderr bytes.Buffer
+	if err := runRender([]string{"--dry-run"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runRender --dry-run error: %v", err)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "==> out/nginx.conf <==") || !strings.Contains(out, "server_name localhost;") {
+		t.Errorf("unexpected dry-run output:\n%s", out)
+	}
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("dry-run output shows a secret:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "out")); !os.IsNotExist(err) {
+		t.Error("--dry-run wrote files")
+	}
+
+	stdout.Reset()
+	if err := runRender([]string{"--dry-run", "--reveal"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runRender --reveal error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), `password "s3cret";`) {
+		t.Errorf("--reveal output:\n%s", stdout.String())
+	}
+}
+
+func TestRunRenderErrors(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	projectDir, cleanupRender := setupRender(t)
+	defer cleanupRender()
+
+	var stdout, stderr bytes.Buffer
+	if err := runRender([]string{"other.tmpl"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for a template not in the config")
+	}
+
+	bad := "{{ required \"API_KEY is not set\" .Env.API_KEY }}\n"
+	if err := os.WriteFile(filepath.Join(projectDir, "nginx.conf.tmpl"), []byte(bad), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := runRender(nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "API_KEY is not set") {
+		t.Errorf("expected required error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "out")); !os.IsNotExist(err) {
+		t.Error("failed render wrote files")
+	}
+}
+
+func TestRunRenderPartialWrite(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	projectDir, cleanupRender := setupRender(t)
+	defer cleanupRender()
+
+	// The second output's directory is a file, so its write fails after
+	// the first output is written
+	cfg, err := project.LoadByName("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Templates["other.tmpl"] = "blocked/other.conf"
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "other.tmpl"), []byte("other\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "blocked"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hand-written first output, replaced with --force
+	output := filepath.Join(projectDir, "out", "nginx.conf")
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output, []byte("hand-written\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runRender([]string{"--force"}, &stdout, &stderr); err == nil {
+		t.Fatal("expected the blocked write to fail")
+	}
+	if !strings.Contains(stdout.String(), "wrote out/nginx.conf") {
+		t.Fatalf("expected the first output to be written, got: %s", stdout.String())
+	}
+
+	// The written file was recorded, so it is replaced without --force
+	if err := runRender([]string{"nginx.conf.tmpl"}, &stdout, &stderr); err != nil {
+		t.Errorf("runRender after a partial write error: %v", err)
+	}
+}
+
+func TestRunRenderDuplicateOutputs(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	projectDir, cleanupRender := setupRender(t)
+	defer cleanupRender()
+
+	cfg, err := project.LoadByName("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Templates["copy.tmpl"] = "./out/../out/nginx.conf"
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "copy.tmpl"), []byte("copy\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = runRender([]string{"nginx.conf.tmpl"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "both write") {
+		t.Errorf("expected duplicate output error, got %v", err)
+	}
+}
@@ -11,15 +11,36 @@
 //	varnish init [flags]
 //	varnish store <subcommand> [flags]
 //	varnish env [flags]
+//	varnish compose [flags]
+//	varnish render [flags] [template...]
+//	varnish run [flags] -- <command>
+//	varnish export --shell <shell>
+//	varnish hook <shell>
 //	varnish list [flags]
+//	varnish explain <ENV_NAME>
+//	varnish snapshot <subcommand> [flags]
+//	varnish restore <snapshot>
+//	varnish unlock [--timeout <duration>] [--project <ref>]
+//	varnish lock
+//	varnish agent [status]
+//	varnish key generate [--output <path>]
 //	varnish version
 //	varnish help
+//
+// --password-file and --password-fd are global: they may appear before the
+// command or among its flags, and apply to whichever command reads the
+// store password.
 package cli
 
 import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/store"
 )
 
 // Run is the main entry point for the CLI.
@@ -30,6 +51,11 @@ func Run(args []string) error {
 
 // run is the internal implementation, accepting writers for testing.
 func run(args []string, stdout, stderr io.Writer) error {
+	args, err := passwordFlags(args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) == 0 {
 		printUsage(stdout)
 		return nil
@@ -38,6 +64,9 @@ func run(args []string, stdout, stderr io.Writer) error {
 	cmd := args[0]
 	cmdArgs := args[1:]
 
+	// Recorded in the store history with every change
+	store.Command = commandName(args)
+
 	switch cmd {
 	case "init":
 		return runInit(cmdArgs, stdout, stderr)
@@ -45,10 +74,34 @@ func run(args []string, stdout, stderr io.Writer) error {
 		return runStore(cmdArgs, stdout, stderr)
 	case "env":
 		return runEnv(cmdArgs, stdout, stderr)
+	case "compose":
+		return runCompose(cmdArgs, stdout, stderr)
+	case "render":
+		return runRender(cmdArgs, stdout, stderr)
+	case "run":
+		return runRun(cmdArgs, stdout, stderr)
+	case "export":
+		return runExport(cmdArgs, stdout, stderr)
+	case "hook":
+		return runHook(cmdArgs, stdout, stderr)
 	case "list":
 		return runList(cmdArgs, stdout, stderr)
+	case "explain":
+		return runExplain(cmdArgs, stdout, stderr)
 	case "project":
 		return runProject(cmdArgs, stdout, stderr)
+	case "snapshot":
+		return runSnapshot(cmdArgs, stdout, stderr)
+	case "restore":
+		return runRestore(cmdArgs, stdout, stderr)
+	case "unlock":
+		return runUnlock(cmdArgs, stdout, stderr)
+	case "lock":
+		return runLock(cmdArgs, stdout, stderr)
+	case "agent":
+		return runAgent(cmdArgs, stdout, stderr)
+	case "key":
+		return runKey(cmdArgs, stdout, stderr)
 	case "completion":
 		return runCompletion(cmdArgs, stdout, stderr)
 	case "check":
@@ -65,6 +118,81 @@ func run(args []string, stdout, stderr io.Writer) error {
 	}
 }
 
+// commandName returns the command and, for commands with subcommands, the
+// subcommand: "store set", "project delete", "init". Values are left out
+// since they may be secrets.
+func commandName(args []string) string {
+	name := args[0]
+	if hasSubcommands(name) && len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name += " " + args[1]
+	}
+	return name
+}
+
+// hasSubcommands reports whether command takes a subcommand name as its
+// first argument: "store set", "project delete", "snapshot create".
+func hasSubcommands(command string) bool {
+	return command == "store" || command == "project" || command == "snapshot"
+}
+
+// passwordFlags removes the global --password-file and --password-fd flags
+// from args and sets them as password sources. They are taken from before
+// the command and from the flags following it (and its subcommand). The
+// first other argument ends the scan: what follows are the command's
+// arguments, such as the program "varnish run" starts, and are left alone.
+func passwordFlags(args []string) ([]string, error) {
+	file, fd := "", -1
+	rest := make([]string, 0, len(args))
+	command, subcommand := "", ""
+scan:
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			switch {
+			case command == "":
+				command = arg
+			case hasSubcommands(command) && subcommand == "":
+				subcommand = arg
+			default:
+				rest = append(rest, args[i:]...)
+				break scan
+			}
+			rest = append(rest, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "--password-file" && name != "--password-fd" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			value = args[i]
+		}
+
+		if name == "--password-file" {
+			file = value
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid --password-fd %q (want a file descriptor number)", value)
+		}
+		fd = n
+	}
+
+	crypto.SetPasswordSources(file, fd)
+	return rest, nil
+}
+
 func printUsage(w io.Writer) {
 	fmt.Fprintln(w, `varnish - environment variable manager
 
@@ -75,16 +203,39 @@ Commands:
   init        Initialize project (.varnish.yaml)
   store       Manage central store (set/get/list/delete/import)
   env         Generate .env file from store + project config
+  compose     Write a docker-compose override with each service's environment
+  render      Render the project's templates into config files
+  run         Run a command with resolved variables in its environment
+  export      Print shell statements that export resolved variables
+  hook        Print a shell hook that loads variables per directory
   list        Show project's resolved variables
+  explain     Show where one variable's value came from
   project     Show current project name
+  snapshot    List, create and compare snapshots of the store and configs
+  restore     Restore the store and configs from a snapshot
+  unlock      Keep an encrypted store's keys in an agent for this session
+  lock        Wipe the agent's keys
+  agent       Run the unlock agent, or show its status
+  key         Generate an identity for stores shared with recipients
   check       Validate config and check for missing variables
   completion  Generate shell completion scripts
   version     Show version
   help        Show this help
 
+Global flags:
+  --password-file <path>  Read the store password from a file
+  --password-fd <n>       Read the store password from a file descriptor
+
+An encrypted store's password is taken from, in order: --password-file,
+--password-fd, VARNISH_PASSWORD, the output of VARNISH_PASSWORD_COMMAND,
+or a prompt if stdin is a terminal. A project encrypted with its own
+password ('store encrypt --project') takes it from VARNISH_PASSWORD_<PROJECT>,
+the output of VARNISH_PROJECT_PASSWORD_COMMAND, or a prompt.
+
 Examples:
   varnish store set database.host localhost --project myapp
   varnish env --force
+  varnish run -- npm start
 
 Run 'varnish <command> -h' for help on a specific command.`)
 }
@@ -0,0 +1,172 @@
+// run.go implements the "varnish run" command.
+//
+// This file is used by:
+//   - cli/root.go: dispatches "run" command here
+//
+// Executes a command with the project's resolved variables injected into
+// its environment. Nothing is written to disk, so decrypted values from an
+// encrypted store never end up in the working tree.
+//
+// Usage:
+//
+//	varnish run [flags] -- <command> [args...]
+//
+// Options:
+//
+//	--clean      Start from an empty environment instead of inheriting the parent's
+//	--profile    Profile to apply (default: $VARNISH_PROFILE)
+//
+// Signals received by varnish are forwarded to the child, and the child's
+// exit code becomes varnish's exit code.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dk/varnish/internal/resolver"
+)
+
+// ExitError carries a child process exit code back to main so it can be
+// used as varnish's own exit code without printing an extra error line.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func runRun(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	clean := fs.Bool("clean", false, "don't inherit the parent environment")
+	profile := fs.String("profile", "", "profile to apply (or set VARNISH_PROFILE)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	// flag stops at "--" and drops it, so everything left is the command
+	cmdArgs := fs.Args()
+	if len(cmdArgs) == 0 {
+		fmt.Fprintln(stderr, "usage: varnish run [--clean] -- <command> [args...]")
+		return fmt.Errorf("missing command")
+	}
+
+	// Load project config (with the active profile applied)
+	cfg, err := loadProjectConfig(*profile)
+	if err != nil {
+		return err
+	}
+
+	// Load store
+	st, err := loadStoreFor(cfg)
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+
+	// Resolve variables
+	res := resolver.New(st, cfg)
+	vars, err := res.Resolve()
+	if err != nil {
+		return err
+	}
+
+	missing := res.MissingVars()
+	if len(missing) > 0 {
+		fmt.Fprintf(stderr, "warning: missing variables in store: %s\n", strings.Join(missing, ", "))
+	}
+
+	var base []string
+	if !*clean {
+		base = os.Environ()
+	}
+
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = mergeEnv(base, vars)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", cmdArgs[0], err)
+	}
+
+	// Forward signals to the child until it exits
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				_ = cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitCode(exitErr)}
+	}
+	return fmt.Errorf("run %s: %w", cmdArgs[0], err)
+}
+
+// exitCode returns the exit code to report for a finished child.
+// A child killed by a signal reports 128+signal, matching shell convention.
+func exitCode(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	if code := err.ExitCode(); code >= 0 {
+		return code
+	}
+	return 1
+}
+
+// mergeEnv overlays resolved variables on top of a base environment.
+// Entries in base that are overridden are dropped; the rest keep their order.
+func mergeEnv(base []string, vars []resolver.ResolvedVar) []string {
+	override := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		override[v.EnvName] = true
+	}
+
+	env := make([]string, 0, len(base)+len(vars))
+	for _, kv := range base {
+		name := kv
+		if idx := strings.Index(kv, "="); idx >= 0 {
+			name = kv[:idx]
+		}
+		if override[name] {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	for _, v := range vars {
+		env = append(env, v.EnvName+"="+v.Value)
+	}
+	return env
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/store"
+)
+
+func TestRunRunInjectsVariables(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "runinject")
+	defer cleanupProject()
+
+	st, _ := store.Load()
+	st.Set("runinject.db.host", "localhost")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := runRun([]string{"--", "sh", "-c", "echo $DB_HOST"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("runRun error: %v (stderr: %s)", err, stderr.String())
+	}
+
+	if got := strings.TrimSpace(stdout.String()); got != "localhost" {
+		t.Errorf("child saw DB_HOST=%q, want 'localhost'", got)
+	}
+
+	// Nothing should be written to disk
+	if _, err := os.Stat(".env"); !os.IsNotExist(err) {
+		t.Error("run should not create a .env file")
+	}
+}
+
+func TestRunRunExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "runexit")
+	defer cleanupProject()
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := runRun([]string{"--", "sh", "-c", "exit 3"}, &stdout, &stderr)
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected ExitError, got %v", err)
+	}
+	if exitErr.Code != 3 {
+		t.Errorf("exit code = %d, want 3", exitErr.Code)
+	}
+}
+
+func TestRunRunClean(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	projectDir, cleanupProject := setupProjectForEnv(t, "runclean")
+	defer cleanupProject()
+
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	t.Setenv("VARNISH_RUN_TEST", "leaked")
+
+	var stdout, stderr bytes.Buffer
+	err := runRun([]string{"--clean", "--", "/bin/sh", "-c", "echo \"[$VARNISH_RUN_TEST]\""}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("runRun error: %v", err)
+	}
+
+	if got := strings.TrimSpace(stdout.String()); got != "[]" {
+		t.Errorf("--clean should not inherit parent env, got %q", got)
+	}
+}
+
+func TestRunRunMissingCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	err := runRun([]string{}, &stdout, &stderr)
+	if err == nil {
+		t.Error("expected error when no command given")
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/bin", "DB_HOST=old", "HOME=/home/me"}
+	vars := []resolver.ResolvedVar{
+		{EnvName: "DB_HOST", Value: "new"},
+		{EnvName: "API_KEY", Value: "a=b"},
+	}
+
+	got := mergeEnv(base, vars)
+	want := []string{"PATH=/bin", "HOME=/home/me", "DB_HOST=new", "API_KEY=a=b"}
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("mergeEnv() = %v, want %v", got, want)
+	}
+}
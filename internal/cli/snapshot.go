@@ -0,0 +1,370 @@
+// snapshot.go implements "varnish snapshot" and "varnish restore".
+//
+// This file is used by:
+//   - cli/root.go: dispatches "snapshot" and "restore" commands here
+//   - cli/project.go, cli/init.go, cli/store.go: snapshotBefore
+//
+// Snapshots are copies of store.yaml, history.yaml, registry.yaml, the
+// project configs and the projects encrypted separately (see
+// snapshot/snapshot.go). One is taken automatically before destructive
+// commands (project delete, init --sync/--force, store import, restore):
+//
+//	varnish snapshot list                  # Snapshots, oldest first
+//	varnish snapshot create                # Take one now
+//	varnish snapshot diff <snapshot>       # Changes since a snapshot
+//	varnish snapshot diff <old> <new>      # Changes between two snapshots
+//	varnish restore <snapshot>             # Go back to a snapshot
+//
+// Snapshots are referenced by ID, a unique prefix of one, or "latest".
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/registry"
+	"github.com/dk/varnish/internal/secret"
+	"github.com/dk/varnish/internal/snapshot"
+	"github.com/dk/varnish/internal/store"
+)
+
+func runSnapshot(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		printSnapshotUsage(stderr)
+		return fmt.Errorf("missing subcommand")
+	}
+
+	subcmd := args[0]
+	subArgs := args[1:]
+
+	switch subcmd {
+	case "list", "ls":
+		return runSnapshotList(subArgs, stdout, stderr)
+	case "create":
+		return runSnapshotCreate(subArgs, stdout, stderr)
+	case "diff":
+		return runSnapshotDiff(subArgs, stdout, stderr)
+	case "help", "-h", "--help":
+		printSnapshotUsage(stdout)
+		return nil
+	default:
+		fmt.Fprintf(stderr, "unknown snapshot subcommand: %s\n\n", subcmd)
+		printSnapshotUsage(stderr)
+		return fmt.Errorf("unknown snapshot subcommand: %s", subcmd)
+	}
+}
+
+func printSnapshotUsage(w io.Writer) {
+	fmt.Fprintln(w, `Usage: varnish snapshot <subcommand> [flags]
+
+Subcommands:
+  list                List snapshots, oldest first (alias: ls)
+  create              Snapshot the store, history, registry, project configs
+                      and separately encrypted projects
+  diff <snap> [snap]  Show changes since a snapshot, or between two
+
+Flags:
+  --reason    Why the snapshot was taken (with 'create')
+  --json      Output as JSON (with 'list')
+  --reveal    Show secret values (with 'diff')
+
+A snapshot is taken automatically before project delete, init --sync or
+--force, store import and restore. Restore one with 'varnish restore <snap>'.
+Snapshots are referenced by ID, a unique ID prefix, or "latest".
+
+Examples:
+  varnish snapshot create --reason "before migration"
+  varnish snapshot diff latest
+  varnish restore 20250102-150405`)
+}
+
+func runSnapshotList(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("snapshot list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOutput := fs.Bool("json", false, "output as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	snaps, err := snapshot.List()
+	if err != nil {
+		return fmt.Errorf("list snapshots: %w", err)
+	}
+
+	if *jsonOutput {
+		out := make([]map[string]interface{}, 0, len(snaps))
+		for _, s := range snaps {
+			out = append(out, map[string]interface{}{
+				"id":      s.ID,
+				"created": s.Created,
+				"reason":  s.Reason,
+				"files":   s.Files,
+			})
+		}
+		return json.NewEncoder(stdout).Encode(map[string]interface{}{
+			"snapshots": out,
+		})
+	}
+
+	if len(snaps) == 0 {
+		fmt.Fprintln(stdout, "no snapshots")
+		return nil
+	}
+
+	fmt.Fprintln(stdout, "snapshots:")
+	for _, s := range snaps {
+		fmt.Fprintf(stdout, "  %-18s  %s  %d file(s)  %s\n",
+			s.ID, s.Created.Local().Format("2006-01-02 15:04:05"), len(s.Files), s.Reason)
+	}
+	return nil
+}
+
+func runSnapshotCreate(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("snapshot create", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	reason := fs.String("reason", "manual", "why the snapshot was taken")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	snap, err := snapshot.Create(*reason)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "created snapshot %s (%d file(s))\n", snap.ID, len(snap.Files))
+	return nil
+}
+
+func runSnapshotDiff(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("snapshot diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	reveal := fs.Bool("reveal", false, "show secret values")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(stderr, "usage: varnish snapshot diff <snapshot> [snapshot]")
+		return fmt.Errorf("expected one or two snapshots")
+	}
+
+	from, err := snapshot.Find(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fromState, err := loadDataState(from.Dir())
+	if err != nil {
+		return fmt.Errorf("load snapshot %s: %w", from.ID, err)
+	}
+
+	toName := "current state"
+	toDir, err := config.VarnishDir()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 2 {
+		to, err := snapshot.Find(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		toName = "snapshot " + to.ID
+		toDir = to.Dir()
+	}
+	toState, err := loadDataState(toDir)
+	if err != nil {
+		return fmt.Errorf("load %s: %w", toName, err)
+	}
+
+	lines := diffDataStates(fromState, toState, *reveal)
+	if len(lines) == 0 {
+		fmt.Fprintf(stdout, "no changes between snapshot %s and %s\n", from.ID, toName)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "changes from snapshot %s to %s:\n", from.ID, toName)
+	for _, line := range lines {
+		fmt.Fprintln(stdout, line)
+	}
+	return nil
+}
+
+func runRestore(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: varnish restore <snapshot>")
+		return fmt.Errorf("expected snapshot ID")
+	}
+
+	snap, err := snapshot.Find(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	backup, err := snap.Restore()
+	if err != nil && backup != nil {
+		// Neither the snapshot nor the previous files are fully in place
+		return fmt.Errorf("%w (previous state saved as snapshot %s, undo with: varnish restore %s)", err, backup.ID, backup.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "restored snapshot %s (%d file(s))\n", snap.ID, len(snap.Files))
+	if backup != nil {
+		fmt.Fprintf(stdout, "previous state saved as snapshot %s\n", backup.ID)
+	}
+	return nil
+}
+
+// snapshotBefore snapshots the data files before a destructive command and
+// tells the user how to undo it.
+func snapshotBefore(reason string, stderr io.Writer) error {
+	snap, err := snapshot.Auto(reason)
+	if err != nil {
+		return fmt.Errorf("snapshot before %s: %w", reason, err)
+	}
+	if snap != nil {
+		fmt.Fprintf(stderr, "saved snapshot %s (undo with: varnish restore %s)\n", snap.ID, snap.ID)
+	}
+	return nil
+}
+
+// dataState is the content of the data files in ~/.varnish or a snapshot.
+type dataState struct {
+	store    *store.Store
+	registry *registry.Registry
+	projects map[string][]byte // file name -> content
+}
+
+// loadDataState reads the data files under dir. Missing files are empty.
+func loadDataState(dir string) (*dataState, error) {
+	st, err := store.LoadFrom(filepath.Join(dir, config.StoreFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		st, err = store.New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := registry.LoadFrom(filepath.Join(dir, config.RegistryFileName))
+	if err != nil {
+		return nil, fmt.Errorf("load registry: %w", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, config.ProjectsDirName, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	projects := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		projects[filepath.Base(path)] = data
+	}
+
+	return &dataState{store: st, registry: reg, projects: projects}, nil
+}
+
+// diffDataStates describes the changes from one state to another, one
+// line per change, grouped by file. Secret values are masked unless reveal.
+func diffDataStates(from, to *dataState, reveal bool) []string {
+	var lines []string
+
+	// Store variables
+	mask := func(st *store.Store, key, value string) string {
+		return secret.MaskIf(value, secret.IsSensitive(key, value, st.IsSecret(key)), reveal)
+	}
+	var storeLines []string
+	for _, key := range unionKeys(from.store.Variables, to.store.Variables) {
+		old, hadOld := from.store.Get(key)
+		cur, hasCur := to.store.Get(key)
+		switch {
+		case !hadOld:
+			storeLines = append(storeLines, fmt.Sprintf("  + %s = %s", key, mask(to.store, key, cur)))
+		case !hasCur:
+			storeLines = append(storeLines, fmt.Sprintf("  - %s = %s", key, mask(from.store, key, old)))
+		case old != cur:
+			storeLines = append(storeLines, fmt.Sprintf("  ~ %s: %s → %s", key, mask(from.store, key, old), mask(to.store, key, cur)))
+		}
+	}
+	if len(storeLines) > 0 {
+		lines = append(lines, config.StoreFileName+":")
+		lines = append(lines, storeLines...)
+	}
+
+	// Directory registrations
+	var regLines []string
+	for _, dir := range unionKeys(from.registry.Projects, to.registry.Projects) {
+		old, hadOld := from.registry.Projects[dir]
+		cur, hasCur := to.registry.Projects[dir]
+		switch {
+		case !hadOld:
+			regLines = append(regLines, fmt.Sprintf("  + %s → %s", dir, cur))
+		case !hasCur:
+			regLines = append(regLines, fmt.Sprintf("  - %s → %s", dir, old))
+		case old != cur:
+			regLines = append(regLines, fmt.Sprintf("  ~ %s: %s → %s", dir, old, cur))
+		}
+	}
+	if len(regLines) > 0 {
+		lines = append(lines, config.RegistryFileName+":")
+		lines = append(lines, regLines...)
+	}
+
+	// Project configs, compared as whole files
+	var projLines []string
+	for _, name := range unionKeys(from.projects, to.projects) {
+		old, hadOld := from.projects[name]
+		cur, hasCur := to.projects[name]
+		switch {
+		case !hadOld:
+			projLines = append(projLines, "  + "+name)
+		case !hasCur:
+			projLines = append(projLines, "  - "+name)
+		case string(old) != string(cur):
+			projLines = append(projLines, "  ~ "+name)
+		}
+	}
+	if len(projLines) > 0 {
+		lines = append(lines, config.ProjectsDirName+"/:")
+		lines = append(lines, projLines...)
+	}
+
+	return lines
+}
+
+// unionKeys returns the keys of both maps, sorted.
+func unionKeys[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		seen[k] = true
+	}
+	for k := range b {
+		seen[k] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
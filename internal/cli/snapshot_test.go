@@ -0,0 +1,220 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/registry"
+	"github.com/dk/varnish/internal/snapshot"
+	"github.com/dk/varnish/internal/store"
+)
+
+func TestProjectDeleteSnapshotAndRestore(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	st := store.New()
+	st.Set("myapp.db.host", "localhost")
+	st.Set("myapp.db.password", "hunter2")
+	st.Set("other.key", "value")
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := project.New()
+	cfg.Project = "myapp"
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reg := registry.New()
+	reg.Register("/work/myapp", "myapp")
+	if err := reg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"project", "delete", "myapp"}, &stdout, &stderr); err != nil {
+		t.Fatalf("project delete error: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "saved snapshot") {
+		t.Errorf("expected snapshot note, got: %s", stderr.String())
+	}
+
+	snaps, _ := snapshot.List()
+	if len(snaps) != 1 || snaps[0].Reason != "project delete myapp" {
+		t.Fatalf("expected one snapshot, got %+v", snaps)
+	}
+	id := snaps[0].ID
+
+	// diff shows what the delete removed, with secrets masked
+	stdout.Reset()
+	if err := run([]string{"snapshot", "diff", "latest"}, &stdout, &stderr); err != nil {
+		t.Fatalf("snapshot diff error: %v", err)
+	}
+	output := stdout.String()
+	for _, want := range []string{
+		"- myapp.db.host = localhost",
+		"- myapp.db.password = ********",
+		"- /work/myapp → myapp",
+		"- myapp.yaml",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("diff missing %q, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "hunter2") || strings.Contains(output, "other.key") {
+		t.Errorf("unexpected diff content:\n%s", output)
+	}
+
+	stdout.Reset()
+	if err := run([]string{"snapshot", "diff", "--reveal", id}, &stdout, &stderr); err != nil {
+		t.Fatalf("snapshot diff --reveal error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "- myapp.db.password = hunter2") {
+		t.Errorf("expected revealed value, got:\n%s", stdout.String())
+	}
+
+	// restore brings everything back
+	stdout.Reset()
+	if err := run([]string{"restore", id}, &stdout, &stderr); err != nil {
+		t.Fatalf("restore error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "restored snapshot "+id) ||
+		!strings.Contains(stdout.String(), "previous state saved as snapshot") {
+		t.Errorf("unexpected restore output: %s", stdout.String())
+	}
+	st, _ = store.Load()
+	if v, _ := st.Get("myapp.db.password"); v != "hunter2" {
+		t.Errorf("myapp.db.password = %q after restore", v)
+	}
+	if !project.Exists("myapp") {
+		t.Error("project config should be restored")
+	}
+	reg, _ = registry.Load()
+	if reg.Projects["/work/myapp"] != "myapp" {
+		t.Error("registry entry should be restored")
+	}
+
+	stdout.Reset()
+	if err := run([]string{"snapshot", "diff", id}, &stdout, &stderr); err != nil {
+		t.Fatalf("snapshot diff error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "no changes") {
+		t.Errorf("expected no changes after restore, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunSnapshotListAndCreate(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	if err := runSnapshot([]string{"list"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "no snapshots") {
+		t.Errorf("expected 'no snapshots', got: %s", stdout.String())
+	}
+
+	if err := runSnapshot([]string{"create"}, &stdout, &stderr); err == nil {
+		t.Error("expected error with nothing to snapshot")
+	}
+
+	if err := runStore([]string{"set", "-g", "app.name", "value"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := runSnapshot([]string{"create", "--reason", "before migration"}, &stdout, &stderr); err != nil {
+		t.Fatalf("snapshot create error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "created snapshot") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runSnapshot([]string{"list"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "before migration") {
+		t.Errorf("expected reason in list, got: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runSnapshot([]string{"list", "--json"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Snapshots []struct {
+			ID     string   `json:"id"`
+			Reason string   `json:"reason"`
+			Files  []string `json:"files"`
+		} `json:"snapshots"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(out.Snapshots) != 1 || !slices.Contains(out.Snapshots[0].Files, "store.yaml") {
+		t.Errorf("unexpected JSON: %s", stdout.String())
+	}
+
+	// Changes since the snapshot
+	if err := runStore([]string{"set", "-g", "app.name", "changed"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := runSnapshot([]string{"diff", "latest"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "~ app.name: value → changed") {
+		t.Errorf("expected change in diff, got: %s", stdout.String())
+	}
+}
+
+func TestInitSyncTakesSnapshot(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	origWd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWd) }()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(".env", []byte("A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if err := runInit([]string{"-p", "snapapp"}, &stdout, &stderr); err != nil {
+		t.Fatalf("init error: %v", err)
+	}
+	if snaps, _ := snapshot.List(); len(snaps) != 0 {
+		t.Errorf("plain init should not snapshot, got %d", len(snaps))
+	}
+
+	if err := runInit([]string{"-p", "snapapp", "--force", "--sync"}, &stdout, &stderr); err != nil {
+		t.Fatalf("init --sync error: %v", err)
+	}
+	if snaps, _ := snapshot.List(); len(snaps) != 1 {
+		t.Errorf("expected a snapshot before init --sync, got %d", len(snaps))
+	}
+}
+
+func TestRunRestoreErrors(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	if err := runRestore(nil, &stdout, &stderr); err == nil {
+		t.Error("expected error without snapshot")
+	}
+	if err := runRestore([]string{"latest"}, &stdout, &stderr); err == nil {
+		t.Error("expected error with no snapshots")
+	}
+	if err := runSnapshot([]string{"bogus"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for unknown subcommand")
+	}
+}
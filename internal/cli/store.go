@@ -7,30 +7,47 @@
 //
 //	varnish store set <key> <value>   Add/update a variable
 //	varnish store set <key> --stdin   Read value from stdin (for secrets)
+//	varnish store set <key> <value> --desc "..." --tag db --secret
+//	varnish store set <key> --desc "..."  Update metadata only
 //	varnish store get <key>           Retrieve a variable
 //	varnish store list [--pattern]    List variables (optional glob filter)
+//	varnish store list --tag db       Only variables with a tag
+//	varnish store list --reveal       Show secrets (masked by default)
 //	varnish store delete <key>        Remove a variable
-//	varnish store import <file>       Import from .env file
+//	varnish store import <file>       Import from .env file (snapshots first)
+//	varnish store history <key>       Show previous values (see history.go)
+//	varnish store rollback <key>      Restore a previous value
+//	varnish store encrypt             Encrypt the store
+//	varnish store rekey               Change the store password
+//	varnish store decrypt             Turn encryption off (asks first)
+//	varnish store encrypt -p myapp    Encrypt one project with its own password
+//	varnish store rekey -p myapp      Change one project's password
+//	varnish store decrypt -p myapp    Move it back into the store
 //
 // Project auto-detection:
 //
 //	When in a directory with .varnish.yaml, store commands automatically
 //	use that project's namespace. Use --global to bypass this.
+//	With --profile (or VARNISH_PROFILE) keys go under project@profile.
 package cli
 
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
+	"github.com/dk/varnish/internal/config"
 	"github.com/dk/varnish/internal/crypto"
 	"github.com/dk/varnish/internal/project"
 	"github.com/dk/varnish/internal/registry"
+	"github.com/dk/varnish/internal/secret"
 	"github.com/dk/varnish/internal/store"
+	"golang.org/x/term"
 )
 
 // detectProject returns the project name for the current directory.
@@ -44,6 +61,28 @@ func detectProject() string {
 	return reg.LookupCurrent()
 }
 
+// loadStoreFor loads the store with the projects cfg resolves from, itself
+// and those it extends, unlocked if they are encrypted separately.
+func loadStoreFor(cfg *project.Config) (*store.Store, error) {
+	st, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := unlockFor(st, cfg); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// unlockFor unlocks the projects cfg resolves from in a loaded store.
+func unlockFor(st *store.Store, cfg *project.Config) error {
+	var projects []string
+	for _, layer := range cfg.Ancestry() {
+		projects = append(projects, layer.Project)
+	}
+	return st.Unlock(projects...)
+}
+
 // normalizeKey converts shell-style variable names to dot notation.
 // DATABASE_HOST → database.host
 // API_KEY → api.key
@@ -98,6 +137,16 @@ func runStore(args []string, stdout, stderr io.Writer) error {
 		return runStoreImport(subArgs, stdout, stderr)
 	case "encrypt":
 		return runStoreEncrypt(subArgs, stdout, stderr)
+	case "rekey":
+		return runStoreRekey(subArgs, stdout, stderr)
+	case "decrypt":
+		return runStoreDecrypt(subArgs, stdout, stderr)
+	case "recipients":
+		return runStoreRecipients(subArgs, stdout, stderr)
+	case "history":
+		return runStoreHistory(subArgs, stdout, stderr)
+	case "rollback":
+		return runStoreRollback(subArgs, stdout, stderr)
 	case "help", "-h", "--help":
 		printStoreUsage(stdout)
 		return nil
@@ -119,7 +168,15 @@ Subcommands:
   list, ls            List all variables (optional glob filter)
   delete, rm <key>    Remove a variable from the store
   import <file>       Import variables from a .env file
-  encrypt             Enable encryption on the store
+  encrypt             Enable encryption on the store (--kdf-memory, --kdf-time)
+  encrypt -p <ref>    Encrypt one project with its own password
+  rekey               Change the store password (--new-password-file <path>)
+  rekey -p <ref>      Change the password of an encrypted project
+  decrypt             Turn encryption off (asks first, or --yes)
+  decrypt -p <ref>    Move an encrypted project back into the store
+  recipients          Encrypt to teammates' public keys (list, add, remove)
+  history <key>       Show a variable's previous values
+  rollback <key>      Undo the latest change (--to <n|time> for an older one)
 
 Keys can use either dot notation (db.host) or shell-style (DATABASE_HOST).
 Shell-style keys are automatically converted: DATABASE_HOST → database.host
@@ -127,6 +184,12 @@ Shell-style keys are automatically converted: DATABASE_HOST → database.host
 Flags:
   -p, --project <ref>   Namespace under project (name or ID from 'varnish project list')
   -g, --global          Bypass project auto-detection, use global namespace
+  --profile <name>      Use the project@profile namespace (or set VARNISH_PROFILE)
+
+Metadata (set):
+  --desc <text>         Describe the variable
+  --tag <tag>           Tag the variable (repeatable, replaces existing tags)
+  --secret              Mark the variable as a secret
 
 When in a directory with .varnish.yaml, the project is auto-detected.
 Use --global to set/get variables without a project prefix.
@@ -136,7 +199,12 @@ Examples:
   varnish store set DATABASE_HOST localhost # shell-style (same as above)
   varnish store set -p 1 db.host localhost # by project ID
   varnish store list -p 2                  # list project #2's vars
-  varnish store list --global              # shows all vars`)
+  varnish store list --global              # shows all vars
+  varnish store set --profile test db.name myapp_test  # myapp@test.db.name
+  varnish store set --secret --tag db db.password s3cret
+  varnish store set --desc "Postgres host" db.host   # metadata only
+  varnish store list --tag db              # only vars tagged db
+  varnish store list --reveal              # show secrets (masked by default)`)
 }
 
 // resolveProjectFlag resolves the project flag value.
@@ -154,6 +222,41 @@ func resolveProjectFlag(projectFlag string, global bool) (string, error) {
 	return resolveProjectRef(projectFlag)
 }
 
+// resolveNamespace resolves the project like resolveProjectFlag, then
+// applies the active profile (--profile or VARNISH_PROFILE).
+// Returns the project name and the store namespace ("myapp" or "myapp@test").
+func resolveNamespace(projectFlag string, global bool, profileFlag string) (string, string, error) {
+	proj, err := resolveProjectFlag(projectFlag, global)
+	if err != nil {
+		return "", "", err
+	}
+
+	profile := activeProfile(profileFlag)
+	if profile == "" {
+		return proj, proj, nil
+	}
+	if proj == "" {
+		if profileFlag != "" {
+			return "", "", fmt.Errorf("--profile requires a project")
+		}
+		// VARNISH_PROFILE doesn't apply to the global namespace
+		return "", "", nil
+	}
+
+	// Catch typos when the project declares its profiles
+	if project.Exists(proj) {
+		cfg, err := project.LoadByName(proj)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := cfg.WithProfile(profile); err != nil {
+			return "", "", err
+		}
+	}
+
+	return proj, project.Namespace(proj, profile), nil
+}
+
 // runStoreSet handles: varnish store set <key> <value> [--stdin] [--project]
 // Also supports: varnish store set <key>=<value>
 func runStoreSet(args []string, stdout, stderr io.Writer) error {
@@ -164,6 +267,11 @@ func runStoreSet(args []string, stdout, stderr io.Writer) error {
 	fs.StringVar(projectFlag, "p", "", "namespace under project name (shorthand)")
 	global := fs.Bool("global", false, "bypass project auto-detection")
 	fs.BoolVar(global, "g", false, "bypass project auto-detection (shorthand)")
+	profileFlag := fs.String("profile", "", "namespace under project@profile (or set VARNISH_PROFILE)")
+	desc := fs.String("desc", "", "description of the variable")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag the variable (repeatable, replaces existing tags)")
+	markSecret := fs.Bool("secret", false, "mark the variable as a secret (--secret=false to unmark)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -171,6 +279,15 @@ func runStoreSet(args []string, stdout, stderr io.Writer) error {
 
 	remaining := fs.Args()
 
+	// Which metadata flags were given; others keep their current values
+	metaFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "desc", "tag", "secret":
+			metaFlags[f.Name] = true
+		}
+	})
+
 	// Need at least key
 	if len(remaining) < 1 {
 		fmt.Fprintln(stderr, "usage: varnish store set <key> <value>")
@@ -179,8 +296,8 @@ func runStoreSet(args []string, stdout, stderr io.Writer) error {
 		return fmt.Errorf("missing key")
 	}
 
-	// Resolve project (auto-detect or resolve ID/name)
-	resolvedProject, err := resolveProjectFlag(*projectFlag, *global)
+	// Resolve project (auto-detect or resolve ID/name) and profile
+	resolvedProject, namespace, err := resolveNamespace(*projectFlag, *global, *profileFlag)
 	if err != nil {
 		return err
 	}
@@ -205,6 +322,10 @@ func runStoreSet(args []string, stdout, stderr io.Writer) error {
 			value = strings.TrimSuffix(value, "\r") // Handle Windows line endings
 		} else {
 			// Value from argument
+			if len(remaining) < 2 && len(metaFlags) > 0 {
+				// Metadata-only update of an existing key
+				return updateStoreMeta(key, *projectFlag, *global, *profileFlag, metaFlags, *desc, tags, *markSecret, stdout)
+			}
 			if len(remaining) < 2 {
 				fmt.Fprintln(stderr, "usage: varnish store set <key> <value>")
 				fmt.Fprintln(stderr, "       varnish store set <key>=<value>")
@@ -214,22 +335,31 @@ func runStoreSet(args []string, stdout, stderr io.Writer) error {
 		}
 	}
 
-	// Apply project prefix
+	// Apply project (and profile) prefix
 	storeKey := key
-	if resolvedProject != "" {
-		storeKey = resolvedProject + "." + key
+	if namespace != "" {
+		storeKey = namespace + "." + key
 	}
 
-	// Load, modify, save
-	st, err := store.Load()
-	if err != nil {
-		return fmt.Errorf("load store: %w", err)
-	}
+	// Load, modify, save under the store lock
+	err = store.Update(func(st *store.Store) error {
+		if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+			return err
+		}
 
-	st.Set(storeKey, value)
+		// Validate against the project's schema before storing
+		if err := reportViolations(stderr, validateStoreValue(st, resolvedProject, namespace, key, value)); err != nil {
+			return fmt.Errorf("not set %s: %w", storeKey, err)
+		}
 
-	if err := st.Save(); err != nil {
-		return fmt.Errorf("save store: %w", err)
+		st.Set(storeKey, value)
+		if len(metaFlags) > 0 {
+			return st.SetMeta(storeKey, applyMetaFlags(st.Meta(storeKey), metaFlags, *desc, tags, *markSecret))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	fmt.Fprintf(stdout, "set %s\n", storeKey)
@@ -245,6 +375,62 @@ func runStoreSet(args []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
+// updateStoreMeta handles "store set <key> --desc/--tag/--secret" without a
+// value: only the metadata of an existing key changes.
+func updateStoreMeta(key, projectFlag string, global bool, profileFlag string, metaFlags map[string]bool, desc string, tags []string, markSecret bool, stdout io.Writer) error {
+	_, namespace, err := resolveNamespace(projectFlag, global, profileFlag)
+	if err != nil {
+		return err
+	}
+
+	storeKey := key
+	if namespace != "" {
+		storeKey = namespace + "." + key
+	}
+
+	err = store.Update(func(st *store.Store) error {
+		if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+			return err
+		}
+		if _, ok := st.Get(storeKey); !ok {
+			return fmt.Errorf("key not found: %s (give a value to create it)", storeKey)
+		}
+		return st.SetMeta(storeKey, applyMetaFlags(st.Meta(storeKey), metaFlags, desc, tags, markSecret))
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "updated %s\n", storeKey)
+	return nil
+}
+
+// applyMetaFlags returns m with the metadata flags that were given applied.
+func applyMetaFlags(m store.Meta, given map[string]bool, desc string, tags []string, markSecret bool) store.Meta {
+	if given["desc"] {
+		m.Description = desc
+	}
+	if given["tag"] {
+		m.Tags = tags
+	}
+	if given["secret"] {
+		m.Secret = markSecret
+	}
+	return m
+}
+
+// stringList is a repeatable string flag: --tag a --tag b
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 // runStoreGet handles: varnish store get <key> [--project]
 func runStoreGet(args []string, stdout, stderr io.Writer) error {
 	fs := flag.NewFlagSet("store get", flag.ContinueOnError)
@@ -253,6 +439,7 @@ func runStoreGet(args []string, stdout, stderr io.Writer) error {
 	fs.StringVar(projectFlag, "p", "", "namespace under project name (shorthand)")
 	global := fs.Bool("global", false, "bypass project auto-detection")
 	fs.BoolVar(global, "g", false, "bypass project auto-detection (shorthand)")
+	profileFlag := fs.String("profile", "", "namespace under project@profile (or set VARNISH_PROFILE)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -265,24 +452,31 @@ func runStoreGet(args []string, stdout, stderr io.Writer) error {
 
 	key := normalizeKey(fs.Arg(0))
 
-	// Resolve project (auto-detect or resolve ID/name)
-	resolvedProject, err := resolveProjectFlag(*projectFlag, *global)
+	// Resolve project (auto-detect or resolve ID/name) and profile
+	resolvedProject, namespace, err := resolveNamespace(*projectFlag, *global, *profileFlag)
 	if err != nil {
 		return err
 	}
 
-	// Apply project prefix
+	// Apply project (and profile) prefix
 	storeKey := key
-	if resolvedProject != "" {
-		storeKey = resolvedProject + "." + key
+	if namespace != "" {
+		storeKey = namespace + "." + key
 	}
 
 	st, err := store.Load()
 	if err != nil {
 		return fmt.Errorf("load store: %w", err)
 	}
+	if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+		return err
+	}
 
 	value, ok := st.Get(storeKey)
+	if !ok && namespace != resolvedProject {
+		// Profiles fall back to the project's base namespace
+		value, ok = st.Get(resolvedProject + "." + key)
+	}
 	if !ok {
 		return fmt.Errorf("key not found: %s", storeKey)
 	}
@@ -300,14 +494,17 @@ func runStoreList(args []string, stdout, stderr io.Writer) error {
 	fs.StringVar(projectFlag, "p", "", "filter to project namespace (shorthand)")
 	global := fs.Bool("global", false, "show all variables (bypass project auto-detection)")
 	fs.BoolVar(global, "g", false, "show all variables (shorthand)")
+	profileFlag := fs.String("profile", "", "filter to project@profile namespace (or set VARNISH_PROFILE)")
 	jsonOutput := fs.Bool("json", false, "output as JSON")
+	tag := fs.String("tag", "", "only show variables with this tag")
+	reveal := fs.Bool("reveal", false, "show secret values")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	// Resolve project (auto-detect or resolve ID/name)
-	resolvedProject, err := resolveProjectFlag(*projectFlag, *global)
+	// Resolve project (auto-detect or resolve ID/name) and profile
+	resolvedProject, namespace, err := resolveNamespace(*projectFlag, *global, *profileFlag)
 	if err != nil {
 		return err
 	}
@@ -316,9 +513,29 @@ func runStoreList(args []string, stdout, stderr io.Writer) error {
 	if err != nil {
 		return fmt.Errorf("load store: %w", err)
 	}
+	// Without a project, encrypted projects stay locked and are listed as such
+	if err := st.Unlock(resolvedProject); err != nil {
+		return err
+	}
 
 	keys := st.Keys()
-	if len(keys) == 0 {
+
+	// Build effective pattern
+	effectivePattern := *pattern
+	if namespace != "" && effectivePattern == "" {
+		effectivePattern = namespace + ".*"
+	} else if namespace != "" {
+		effectivePattern = namespace + "." + effectivePattern
+	}
+
+	locked := []string{}
+	for _, name := range st.EncryptedProjects() {
+		if st.IsLocked(name) && *tag == "" && (effectivePattern == "" || matchGlob(effectivePattern, name+".*")) {
+			locked = append(locked, name)
+		}
+	}
+
+	if len(keys) == 0 && len(locked) == 0 {
 		if *jsonOutput {
 			return json.NewEncoder(stdout).Encode(map[string]interface{}{
 				"variables": []interface{}{},
@@ -328,14 +545,6 @@ func runStoreList(args []string, stdout, stderr io.Writer) error {
 		return nil
 	}
 
-	// Build effective pattern
-	effectivePattern := *pattern
-	if resolvedProject != "" && effectivePattern == "" {
-		effectivePattern = resolvedProject + ".*"
-	} else if resolvedProject != "" {
-		effectivePattern = resolvedProject + "." + effectivePattern
-	}
-
 	// Collect matching variables
 	variables := make(map[string]string)
 	for _, key := range keys {
@@ -343,25 +552,70 @@ func runStoreList(args []string, stdout, stderr io.Writer) error {
 		if effectivePattern != "" && !matchGlob(effectivePattern, key) {
 			continue
 		}
+		if *tag != "" && !hasTag(st.Meta(key).Tags, *tag) {
+			continue
+		}
 		value, _ := st.Get(key)
 		variables[key] = value
 	}
 
 	if *jsonOutput {
+		metadata := make(map[string]store.Meta, len(variables))
+		sensitive := make(map[string]bool, len(variables))
+		for key, value := range variables {
+			metadata[key] = st.Meta(key)
+			sensitive[key] = secret.IsSensitive(key, value, st.IsSecret(key))
+		}
 		return json.NewEncoder(stdout).Encode(map[string]interface{}{
 			"variables": variables,
+			"metadata":  metadata,
+			"sensitive": sensitive,
+			"locked":    locked,
 		})
 	}
 
 	for _, key := range keys {
 		if value, ok := variables[key]; ok {
-			fmt.Fprintf(stdout, "%s=%s\n", key, value)
+			value = secret.MaskIf(value, secret.IsSensitive(key, value, st.IsSecret(key)), *reveal)
+			fmt.Fprintf(stdout, "%s=%s%s\n", key, value, formatMeta(st.Meta(key)))
 		}
 	}
+	for _, name := range locked {
+		fmt.Fprintf(stdout, "%s.* (locked)\n", name)
+	}
 
 	return nil
 }
 
+// hasTag reports whether tags contains tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// formatMeta renders metadata as a trailing comment for "store list":
+// "  # Postgres password [db, prod] (secret)". Empty metadata renders as "".
+func formatMeta(m store.Meta) string {
+	var parts []string
+	if m.Description != "" {
+		parts = append(parts, m.Description)
+	}
+	if len(m.Tags) > 0 {
+		parts = append(parts, "["+strings.Join(m.Tags, ", ")+"]")
+	}
+	if m.Secret {
+		parts = append(parts, "(secret)")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "  # " + strings.Join(parts, " ")
+}
+
 // runStoreDelete handles: varnish store delete <key> [--project]
 func runStoreDelete(args []string, stdout, stderr io.Writer) error {
 	fs := flag.NewFlagSet("store delete", flag.ContinueOnError)
@@ -370,6 +624,7 @@ func runStoreDelete(args []string, stdout, stderr io.Writer) error {
 	fs.StringVar(projectFlag, "p", "", "namespace under project name (shorthand)")
 	global := fs.Bool("global", false, "bypass project auto-detection")
 	fs.BoolVar(global, "g", false, "bypass project auto-detection (shorthand)")
+	profileFlag := fs.String("profile", "", "namespace under project@profile (or set VARNISH_PROFILE)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -382,29 +637,29 @@ func runStoreDelete(args []string, stdout, stderr io.Writer) error {
 
 	key := normalizeKey(fs.Arg(0))
 
-	// Resolve project (auto-detect or resolve ID/name)
-	resolvedProject, err := resolveProjectFlag(*projectFlag, *global)
+	// Resolve project (auto-detect or resolve ID/name) and profile
+	_, namespace, err := resolveNamespace(*projectFlag, *global, *profileFlag)
 	if err != nil {
 		return err
 	}
 
-	// Apply project prefix
+	// Apply project (and profile) prefix
 	storeKey := key
-	if resolvedProject != "" {
-		storeKey = resolvedProject + "." + key
+	if namespace != "" {
+		storeKey = namespace + "." + key
 	}
 
-	st, err := store.Load()
+	err = store.Update(func(st *store.Store) error {
+		if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+			return err
+		}
+		if !st.Delete(storeKey) {
+			return fmt.Errorf("key not found: %s", storeKey)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("load store: %w", err)
-	}
-
-	if !st.Delete(storeKey) {
-		return fmt.Errorf("key not found: %s", storeKey)
-	}
-
-	if err := st.Save(); err != nil {
-		return fmt.Errorf("save store: %w", err)
+		return err
 	}
 
 	fmt.Fprintf(stdout, "deleted %s\n", storeKey)
@@ -419,13 +674,14 @@ func runStoreImport(args []string, stdout, stderr io.Writer) error {
 	fs.StringVar(projectFlag, "p", "", "namespace under project name (shorthand)")
 	global := fs.Bool("global", false, "bypass project auto-detection")
 	fs.BoolVar(global, "g", false, "bypass project auto-detection (shorthand)")
+	profileFlag := fs.String("profile", "", "namespace under project@profile (or set VARNISH_PROFILE)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	// Resolve project (auto-detect or resolve ID/name)
-	resolvedProject, err := resolveProjectFlag(*projectFlag, *global)
+	// Resolve project (auto-detect or resolve ID/name) and profile
+	_, namespace, err := resolveNamespace(*projectFlag, *global, *profileFlag)
 	if err != nil {
 		return err
 	}
@@ -448,25 +704,33 @@ func runStoreImport(args []string, stdout, stderr io.Writer) error {
 		return nil
 	}
 
-	// Load store
-	st, err := store.Load()
-	if err != nil {
-		return fmt.Errorf("load store: %w", err)
-	}
-
 	// Import each variable
 	count := 0
-	for _, v := range vars {
-		if v.HasValue {
-			// Apply project prefix
-			storeKey := v.Key
-			if resolvedProject != "" {
-				storeKey = resolvedProject + "." + v.Key
+	err = store.Update(func(st *store.Store) error {
+		for _, v := range vars {
+			if v.HasValue {
+				// Apply project prefix
+				storeKey := v.Key
+				if namespace != "" {
+					storeKey = namespace + "." + v.Key
+				}
+				if err := st.Unlock(store.ProjectOf(storeKey)); err != nil {
+					return err
+				}
+				st.Set(storeKey, v.Default)
+				count++
+				fmt.Fprintf(stdout, "imported %s → %s\n", v.EnvName, storeKey)
 			}
-			st.Set(storeKey, v.Default)
-			count++
-			fmt.Fprintf(stdout, "imported %s → %s\n", v.EnvName, storeKey)
 		}
+		if count == 0 {
+			return nil
+		}
+
+		// An import can overwrite many values at once
+		return snapshotBefore("store import", stderr)
+	})
+	if err != nil {
+		return err
 	}
 
 	if count == 0 {
@@ -474,10 +738,6 @@ func runStoreImport(args []string, stdout, stderr io.Writer) error {
 		return nil
 	}
 
-	if err := st.Save(); err != nil {
-		return fmt.Errorf("save store: %w", err)
-	}
-
 	fmt.Fprintf(stdout, "imported %d variables\n", count)
 	return nil
 }
@@ -485,7 +745,11 @@ func runStoreImport(args []string, stdout, stderr io.Writer) error {
 func runStoreEncrypt(args []string, stdout, stderr io.Writer) error {
 	fs := flag.NewFlagSet("store encrypt", flag.ContinueOnError)
 	fs.SetOutput(stderr)
-	password := fs.String("password", "", "encryption password (or set VARNISH_PASSWORD)")
+	password := fs.String("password", "", "deprecated: encryption password, visible in shell history and ps (use the prompt or --password-file)")
+	kdfMemory := fs.Int("kdf-memory", 0, "Argon2id memory in MiB (default: config.yaml, else 64)")
+	kdfTime := fs.Int("kdf-time", 0, "Argon2id passes (default: config.yaml, else 1)")
+	projectFlag := fs.String("project", "", "encrypt only this project, with its own password")
+	fs.StringVar(projectFlag, "p", "", "encrypt only this project, with its own password (shorthand)")
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
@@ -494,42 +758,383 @@ func runStoreEncrypt(args []string, stdout, stderr io.Writer) error {
 		return err
 	}
 
-	// If --password provided, set the env var for this session
+	if *kdfMemory < 0 || *kdfMemory > crypto.MaxKDFMemory/1024 {
+		return fmt.Errorf("--kdf-memory must be between 1 and %d (MiB)", crypto.MaxKDFMemory/1024)
+	}
+	if *kdfTime < 0 || *kdfTime > crypto.MaxKDFTime {
+		return fmt.Errorf("--kdf-time must be between 1 and %d", crypto.MaxKDFTime)
+	}
+	tune := *kdfMemory > 0 || *kdfTime > 0
 	if *password != "" {
-		os.Setenv(crypto.PasswordEnvVar, *password)
+		warnPasswordFlag(stderr)
 	}
 
-	// Validate password is available
-	if _, err := crypto.GetPassword(); err != nil {
-		return fmt.Errorf("encryption requires --password or VARNISH_PASSWORD env var")
+	if *projectFlag != "" {
+		if tune {
+			return fmt.Errorf("--kdf-memory and --kdf-time apply to the store; projects use encryption.kdf in config.yaml")
+		}
+		return encryptProject(*projectFlag, *password, stdout)
 	}
 
-	// Load store
+	if *password != "" {
+		crypto.SetPassword(*password)
+	}
+
+	// An encrypted store needs its current password; a plaintext one a new
+	// password (asked twice at a prompt)
 	st, err := store.Load()
 	if err != nil {
 		return fmt.Errorf("load store: %w", err)
 	}
-
-	// Check if already encrypted
-	if st.IsEncrypted() {
+	if len(st.Recipients()) > 0 {
+		return fmt.Errorf("store is encrypted to recipients; to use a password instead, run 'varnish store decrypt' first")
+	}
+	if st.IsEncrypted() && !tune {
 		fmt.Fprintln(stdout, "store is already encrypted")
 		return nil
 	}
+	if !st.IsEncrypted() {
+		if _, err := crypto.NewPassword(); err != nil {
+			return fmt.Errorf("encryption password: %w", err)
+		}
+	}
+
+	// Load, encrypt, save
+	reencrypted := false
+	count := 0
+	var params crypto.KDFParams
+	err = store.Update(func(st *store.Store) error {
+		reencrypted = st.IsEncrypted()
+		count = st.Len()
+		if tune {
+			p, err := st.KDFParams()
+			if err != nil {
+				return err
+			}
+			if *kdfMemory > 0 {
+				p.Memory = uint32(*kdfMemory) * 1024
+			}
+			if *kdfTime > 0 {
+				p.Time = uint32(*kdfTime)
+			}
+			if err := st.SetKDFParams(p); err != nil {
+				return fmt.Errorf("key derivation parameters: %w", err)
+			}
+		}
+		if err := st.EnableEncryption(); err != nil {
+			return fmt.Errorf("enable encryption: %w", err)
+		}
+		p, err := st.KDFParams()
+		params = p
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if reencrypted {
+		fmt.Fprintf(stdout, "store re-encrypted (%d variables)\n", count)
+	} else {
+		fmt.Fprintf(stdout, "store encrypted (%d variables)\n", count)
+	}
+	fmt.Fprintf(stdout, "key derivation: %s\n", params)
+	return nil
+}
+
+// warnPasswordFlag warns that --password was used.
+func warnPasswordFlag(stderr io.Writer) {
+	fmt.Fprintln(stderr, "warning: --password is deprecated: it is visible in shell history and ps (use the prompt, --password-file or --password-fd)")
+}
+
+// encryptProject handles "store encrypt --project": the project's
+// variables and history move into a segment with its own password.
+func encryptProject(ref, password string, stdout io.Writer) error {
+	name, err := resolveProjectRef(ref)
+	if err != nil {
+		return err
+	}
+	if password != "" {
+		crypto.SetProjectPassword(name, password)
+	}
+
+	if _, err := os.Stat(config.SegmentPathFor(name)); err == nil {
+		fmt.Fprintf(stdout, "project %s is already encrypted separately\n", name)
+		return nil
+	}
+	if err := checkProjectKeys(name); err != nil {
+		return err
+	}
+	// Asked twice at a prompt, then remembered for EncryptProject
+	if _, err := crypto.NewProjectPassword(name); err != nil {
+		return fmt.Errorf("password for project %s: %w", name, err)
+	}
 
-	// Enable encryption
-	if err := st.EnableEncryption(); err != nil {
-		return fmt.Errorf("enable encryption: %w", err)
+	count := 0
+	err = store.Update(func(st *store.Store) error {
+		if err := st.EncryptProject(name); err != nil {
+			return err
+		}
+		for _, key := range st.Keys() {
+			if store.ProjectOf(key) == name {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	// Save encrypted store
-	if err := st.Save(); err != nil {
-		return fmt.Errorf("save store: %w", err)
+	fmt.Fprintf(stdout, "project %s encrypted with its own password (%d variables)\n", name, count)
+	fmt.Fprintf(stdout, "other projects no longer need it; set %s or enter it when asked\n", crypto.ProjectPasswordEnvVar(name))
+	return nil
+}
+
+// checkProjectKeys refuses to encrypt a project whose namespace holds keys
+// it doesn't use. Store keys carry no marker of being global, so a global
+// aws.region is indistinguishable from project aws's "region"; encrypting
+// aws would lock it away from everything else.
+func checkProjectKeys(name string) error {
+	if !project.Exists(name) {
+		return fmt.Errorf("project %s not found (only a project's own keys can be encrypted with it)", name)
+	}
+	cfg, err := project.LoadByName(name)
+	if err != nil {
+		return fmt.Errorf("load project config: %w", err)
+	}
+	st, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
 	}
 
-	fmt.Fprintf(stdout, "store encrypted (%d variables)\n", st.Len())
+	var foreign []string
+	prefix := name + "."
+	for _, key := range st.Keys() {
+		// Profile namespaces (name@profile.) are the project's own
+		if strings.HasPrefix(key, prefix) && !cfg.UsesKey(strings.TrimPrefix(key, prefix)) {
+			foreign = append(foreign, key)
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("project %s doesn't include %s, which may be global keys that would be encrypted with it (rename them, or add them to the project's include)", name, strings.Join(foreign, ", "))
+	}
 	return nil
 }
 
+func runStoreRekey(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store rekey", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	newPasswordFile := fs.String("new-password-file", "", "read the new password from a file (or set VARNISH_NEW_PASSWORD)")
+	projectFlag := fs.String("project", "", "change the password of this encrypted project only")
+	fs.StringVar(projectFlag, "p", "", "change the password of this encrypted project only (shorthand)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	if *projectFlag != "" {
+		return rekeyProject(*projectFlag, *newPasswordFile, stdout)
+	}
+
+	// Check the current password before asking for a new one
+	st, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+	if !st.IsEncrypted() {
+		return fmt.Errorf("store is not encrypted (use 'varnish store encrypt')")
+	}
+	if len(st.Recipients()) > 0 {
+		return store.ErrRecipients
+	}
+	current, err := crypto.GetPassword()
+	if err != nil {
+		return err
+	}
+
+	newPassword, err := crypto.ReplacementPassword(*newPasswordFile)
+	if err != nil {
+		return fmt.Errorf("new password: %w", err)
+	}
+	if newPassword == current {
+		return fmt.Errorf("new password is the same as the current one")
+	}
+
+	backups, err := store.Rekey(newPassword)
+	if err != nil {
+		return fmt.Errorf("rekey store: %w", err)
+	}
+	// Keys from the old password are of no use any more
+	if err := store.ForgetKeys(); err != nil {
+		fmt.Fprintf(stderr, "warning: %v\n", err)
+	}
+
+	fmt.Fprintf(stdout, "store password changed (%d variables)\n", st.Len())
+	printBackups(stdout, backups)
+	return nil
+}
+
+// rekeyProject handles "store rekey --project". Neither the store nor other
+// projects are read or written.
+func rekeyProject(ref, newPasswordFile string, stdout io.Writer) error {
+	name, err := resolveProjectRef(ref)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(config.SegmentPathFor(name)); os.IsNotExist(err) {
+		return fmt.Errorf("project %s is not encrypted separately (use 'varnish store encrypt --project %s')", name, name)
+	}
+	current, err := crypto.ProjectPassword(name)
+	if err != nil {
+		return err
+	}
+
+	newPassword, err := crypto.ReplacementProjectPassword(name, newPasswordFile)
+	if err != nil {
+		return fmt.Errorf("new password: %w", err)
+	}
+	if newPassword == current {
+		return fmt.Errorf("new password is the same as the current one")
+	}
+
+	backups, err := store.RekeyProject(name, newPassword)
+	if err != nil {
+		return fmt.Errorf("rekey project: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "password of project %s changed\n", name)
+	printBackups(stdout, backups)
+	return nil
+}
+
+func runStoreDecrypt(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("store decrypt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	fs.BoolVar(yes, "y", false, "don't ask for confirmation (shorthand)")
+	projectFlag := fs.String("project", "", "move this encrypted project back into the store")
+	fs.StringVar(projectFlag, "p", "", "move this encrypted project back into the store (shorthand)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	if *projectFlag != "" {
+		return decryptProject(*projectFlag, *yes, stdout, stderr)
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+	if !st.IsEncrypted() {
+		fmt.Fprintln(stdout, "store is not encrypted")
+		return nil
+	}
+
+	if !*yes {
+		if !stdinIsTerminal() {
+			return fmt.Errorf("store decrypt writes secrets to disk in plaintext; confirm with --yes")
+		}
+		if !confirm("Write the store and its history to disk in plaintext?", stderr) {
+			fmt.Fprintln(stdout, "aborted")
+			return nil
+		}
+	}
+
+	backups, err := store.Decrypt()
+	if err != nil {
+		return fmt.Errorf("decrypt store: %w", err)
+	}
+	if err := store.ForgetKeys(); err != nil {
+		fmt.Fprintf(stderr, "warning: %v\n", err)
+	}
+
+	fmt.Fprintf(stdout, "store decrypted (%d variables)\n", st.Len())
+	printBackups(stdout, backups)
+	return nil
+}
+
+// decryptProject handles "store decrypt --project": the project's variables
+// and history move back into the store, which may be plaintext.
+func decryptProject(ref string, yes bool, stdout, stderr io.Writer) error {
+	name, err := resolveProjectRef(ref)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+	if !st.IsLocked(name) {
+		fmt.Fprintf(stdout, "project %s is not encrypted separately\n", name)
+		return nil
+	}
+
+	if !yes {
+		question := fmt.Sprintf("Move project %s into the store, readable with the store password?", name)
+		if !st.IsEncrypted() {
+			question = fmt.Sprintf("Write project %s to disk in plaintext, in the store?", name)
+		}
+		if !stdinIsTerminal() {
+			return fmt.Errorf("store decrypt --project removes the project's own password; confirm with --yes")
+		}
+		if !confirm(question, stderr) {
+			fmt.Fprintln(stdout, "aborted")
+			return nil
+		}
+	}
+
+	count := 0
+	err = store.Update(func(st *store.Store) error {
+		if err := st.DecryptProject(name); err != nil {
+			return err
+		}
+		for _, key := range st.Keys() {
+			if store.ProjectOf(key) == name {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "project %s moved back into the store (%d variables)\n", name, count)
+	return nil
+}
+
+// printBackups lists the backups of the previous ciphertext.
+func printBackups(w io.Writer, backups []string) {
+	for _, path := range backups {
+		fmt.Fprintf(w, "previous ciphertext kept in %s\n", path)
+	}
+}
+
+// stdinIsTerminal reports whether stdin is a terminal. Replaced in tests.
+var stdinIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// confirm asks a yes/no question on the terminal; the default is no.
+func confirm(question string, stderr io.Writer) bool {
+	fmt.Fprintf(stderr, "%s [y/N] ", question)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
 // matchGlob is a simple glob matcher for store list --pattern.
 // Supports * as wildcard.
 func matchGlob(pattern, s string) bool {
@@ -555,42 +1160,47 @@ func matchGlob(pattern, s string) bool {
 	return pattern == s
 }
 
+// errNoChange aborts a project.Update that has nothing to change.
+var errNoChange = errors.New("no change")
+
 // ensureIncludePattern adds a pattern to the project config if the key isn't already covered.
 // For example, if key is "db.user", it will add "db.*" if not already included.
 func ensureIncludePattern(projectName, key string, stdout io.Writer) error {
-	cfg, err := project.LoadByName(projectName)
-	if err != nil {
-		return err
-	}
-
-	// Check if key is already matched by existing includes
-	for _, pat := range cfg.Include {
-		if matchGlob(pat, key) {
-			return nil // Already covered
+	added := ""
+	err := project.Update(projectName, func(cfg *project.Config) error {
+		// Check if key is already matched by existing includes
+		for _, pat := range cfg.Include {
+			if matchGlob(pat, key) {
+				return errNoChange // Already covered
+			}
 		}
-	}
 
-	// Generate pattern for this key
-	// For "db.user" -> "db.*", for "simple" -> "simple"
-	pat := key
-	if idx := strings.Index(key, "."); idx > 0 {
-		pat = key[:idx] + ".*"
-	}
-
-	// Check if this pattern already exists
-	for _, p := range cfg.Include {
-		if p == pat {
-			return nil // Pattern already exists
+		// Generate pattern for this key
+		// For "db.user" -> "db.*", for "simple" -> "simple"
+		pat := key
+		if idx := strings.Index(key, "."); idx > 0 {
+			pat = key[:idx] + ".*"
 		}
-	}
 
-	// Add the new pattern
-	cfg.Include = append(cfg.Include, pat)
+		// Check if this pattern already exists
+		for _, p := range cfg.Include {
+			if p == pat {
+				return errNoChange // Pattern already exists
+			}
+		}
 
-	if err := cfg.Save(); err != nil {
+		// Add the new pattern
+		cfg.Include = append(cfg.Include, pat)
+		added = pat
+		return nil
+	})
+	if err == errNoChange {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(stdout, "added '%s' to project includes\n", pat)
+	fmt.Fprintf(stdout, "added '%s' to project includes\n", added)
 	return nil
 }
@@ -2,12 +2,14 @@ package cli
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
 	"github.com/dk/varnish/internal/project"
 	"github.com/dk/varnish/internal/store"
 )
@@ -617,9 +619,12 @@ func TestRunStoreEncrypt(t *testing.T) {
 		t.Fatalf("failed to save store: %v", err)
 	}
 
+	unsetenv(t, crypto.PasswordEnvVar)
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
 	var stdout, stderr bytes.Buffer
 
-	// Encrypt with --password flag
+	// Encrypt with the deprecated --password flag
 	err := runStore([]string{"encrypt", "--password", "testpassword"}, &stdout, &stderr)
 	if err != nil {
 		t.Fatalf("runStore encrypt error: %v", err)
@@ -628,6 +633,13 @@ func TestRunStoreEncrypt(t *testing.T) {
 	if !strings.Contains(stdout.String(), "encrypted") {
 		t.Errorf("expected 'encrypted' in output, got: %s", stdout.String())
 	}
+	if !strings.Contains(stderr.String(), "--password is deprecated") {
+		t.Errorf("expected a deprecation warning, got: %s", stderr.String())
+	}
+	// Kept out of the environment, so commands varnish runs don't see it
+	if os.Getenv(crypto.PasswordEnvVar) != "" {
+		t.Errorf("--password was copied to %s", crypto.PasswordEnvVar)
+	}
 
 	// Verify store is encrypted
 	t.Setenv("VARNISH_PASSWORD", "testpassword")
@@ -661,11 +673,249 @@ func TestRunStoreEncryptNoPassword(t *testing.T) {
 	}
 }
 
+func TestRunStoreEncryptKDFFlags(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	t.Setenv(crypto.PasswordEnvVar, "testpassword")
+
+	st := store.New()
+	st.Set("app.name", "demo")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runStore([]string{"encrypt", "--kdf-memory", "8", "--kdf-time", "2"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store encrypt error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "key derivation: argon2id time=2 memory=8MiB threads=4") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	// On an encrypted store the flags re-encrypt with new parameters
+	stdout.Reset()
+	if err := runStore([]string{"encrypt", "--kdf-time", "3"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store encrypt error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store re-encrypted") || !strings.Contains(stdout.String(), "time=3 memory=8MiB") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	path, _ := config.StorePath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := crypto.ParseHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KDF.Time != 3 || h.KDF.Memory != 8*1024 {
+		t.Errorf("KDF = %v, want time 3, memory 8MiB", h.KDF)
+	}
+
+	if err := runStore([]string{"encrypt", "--kdf-time", "1000"}, &stdout, &stderr); err == nil {
+		t.Error("expected error for out of range --kdf-time")
+	}
+}
+
+func TestRunStoreEncryptPasswordFile(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	unsetenv(t, crypto.PasswordEnvVar)
+	unsetenv(t, crypto.PasswordCommandEnvVar)
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
+	st := store.New()
+	st.Set("test.key", "secret-value")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte("filepassword\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The global flag works after the command too
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "encrypt", "--password-file", pwFile}, &stdout, &stderr); err != nil {
+		t.Fatalf("store encrypt error: %v", err)
+	}
+
+	stdout.Reset()
+	if err := run([]string{"--password-file=" + pwFile, "store", "get", "-g", "test.key"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store get error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "secret-value") {
+		t.Errorf("expected decrypted value, got: %s", stdout.String())
+	}
+
+	// Without a source, reading fails
+	if err := run([]string{"store", "get", "-g", "test.key"}, &stdout, &stderr); err == nil {
+		t.Error("expected error without a password source")
+	}
+}
+
+// setupEncryptedStoreCLI saves an encrypted store holding app.name under
+// password.
+func setupEncryptedStoreCLI(t *testing.T, password string) {
+	t.Helper()
+	unsetenv(t, crypto.PasswordCommandEnvVar)
+	unsetenv(t, crypto.NewPasswordEnvVar)
+	t.Setenv(crypto.PasswordEnvVar, password)
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
+	st := store.New()
+	st.Set("app.name", "demo")
+	if err := st.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+}
+
+func TestRunStoreRekey(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedStoreCLI(t, "old-password")
+
+	var stdout, stderr bytes.Buffer
+
+	// The same password again is refused
+	t.Setenv(crypto.NewPasswordEnvVar, "old-password")
+	if err := run([]string{"store", "rekey"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "same") {
+		t.Errorf("expected same-password error, got %v", err)
+	}
+
+	t.Setenv(crypto.NewPasswordEnvVar, "new-password")
+	if err := run([]string{"store", "rekey"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store rekey error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store password changed (1 variables)") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "store.yaml"+store.BackupSuffix) {
+		t.Errorf("expected backup path in output, got: %s", stdout.String())
+	}
+
+	// Now the new password is needed, and --new-password-file changes it again
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte("third-password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"store", "rekey", "--new-password-file", pwFile}, &stdout, &stderr); err == nil {
+		t.Error("rekey with the old password should fail")
+	}
+	t.Setenv(crypto.PasswordEnvVar, "new-password")
+	if err := run([]string{"store", "rekey", "--new-password-file", pwFile}, &stdout, &stderr); err != nil {
+		t.Fatalf("store rekey --new-password-file error: %v", err)
+	}
+
+	t.Setenv(crypto.PasswordEnvVar, "third-password")
+	st, err := store.Load()
+	if err != nil {
+		t.Fatalf("load with new password: %v", err)
+	}
+	if v, _ := st.Get("app.name"); v != "demo" {
+		t.Errorf("app.name = %q, want demo", v)
+	}
+}
+
+func TestRunStoreRekeyNotEncrypted(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"store", "rekey"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Errorf("expected not encrypted error, got %v", err)
+	}
+}
+
+func TestRunStoreDecrypt(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedStoreCLI(t, "old-password")
+
+	orig := stdinIsTerminal
+	stdinIsTerminal = func() bool { return false }
+	defer func() { stdinIsTerminal = orig }()
+
+	// Without a terminal to ask, --yes is required
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "decrypt"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "--yes") {
+		t.Errorf("expected confirmation error, got %v", err)
+	}
+
+	if err := run([]string{"store", "decrypt", "--yes"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store decrypt error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store decrypted (1 variables)") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	unsetenv(t, crypto.PasswordEnvVar)
+	st, err := store.Load()
+	if err != nil {
+		t.Fatalf("load without password: %v", err)
+	}
+	if st.IsEncrypted() {
+		t.Error("store should not be encrypted")
+	}
+
+	stdout.Reset()
+	if err := run([]string{"store", "decrypt", "-y"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store decrypt error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "store is not encrypted") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestPasswordFlags(t *testing.T) {
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{"none", []string{"env", "--force"}, []string{"env", "--force"}, false},
+		{"file", []string{"--password-file", "pw", "env"}, []string{"env"}, false},
+		{"file equals", []string{"env", "--password-file=pw"}, []string{"env"}, false},
+		{"fd", []string{"list", "--password-fd", "3"}, []string{"list"}, false},
+		{"after separator", []string{"run", "--", "tool", "--password-file", "x"}, []string{"run", "--", "tool", "--password-file", "x"}, false},
+		{"run command args", []string{"run", "npm", "test", "--password-file", "x"}, []string{"run", "npm", "test", "--password-file", "x"}, false},
+		{"run flags", []string{"run", "--clean", "--password-file", "pw", "npm"}, []string{"run", "--clean", "npm"}, false},
+		{"subcommand", []string{"store", "encrypt", "--password-file", "pw"}, []string{"store", "encrypt"}, false},
+		{"after positional", []string{"store", "set", "key", "--password-file", "x"}, []string{"store", "set", "key", "--password-file", "x"}, false},
+		{"missing value", []string{"env", "--password-file"}, nil, true},
+		{"bad fd", []string{"env", "--password-fd", "three"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := passwordFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("passwordFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("passwordFlags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRunStoreEncryptAlreadyEncrypted(t *testing.T) {
 	cleanup := setupTestEnv(t)
 	defer cleanup()
 
 	t.Setenv("VARNISH_PASSWORD", "testpassword")
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
 
 	// Create and encrypt a store
 	st := store.New()
@@ -689,3 +939,277 @@ func TestRunStoreEncryptAlreadyEncrypted(t *testing.T) {
 		t.Errorf("expected 'already encrypted' in output, got: %s", stdout.String())
 	}
 }
+
+func TestRunStoreSetMetadata(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	err := runStore([]string{"set", "-g", "--desc", "Postgres password", "--tag", "db", "--tag", "prod", "--secret", "db.password", "s3cret"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("runStore set error: %v", err)
+	}
+
+	st, _ := store.Load()
+	m := st.Meta("db.password")
+	if m.Description != "Postgres password" || !m.Secret || strings.Join(m.Tags, ",") != "db,prod" {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+
+	// A new value keeps the metadata
+	if err := runStore([]string{"set", "-g", "db.password", "n3w"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runStore set error: %v", err)
+	}
+	// Metadata-only update
+	if err := runStore([]string{"set", "-g", "--secret=false", "db.password"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runStore set metadata error: %v", err)
+	}
+
+	st, _ = store.Load()
+	if v, _ := st.Get("db.password"); v != "n3w" {
+		t.Errorf("value = %q", v)
+	}
+	if m := st.Meta("db.password"); m.Secret || m.Description != "Postgres password" {
+		t.Errorf("unexpected metadata after update: %+v", m)
+	}
+
+	if err := runStore([]string{"set", "-g", "--desc", "x", "missing.key"}, &stdout, &stderr); err == nil {
+		t.Error("metadata-only update of a missing key should fail")
+	}
+}
+
+func TestRunStoreListMetadata(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	st := store.New()
+	st.Set("db.host", "localhost")
+	st.Set("db.password", "s3cret")
+	_ = st.SetMeta("db.password", store.Meta{Description: "Postgres password", Tags: []string{"db"}, Secret: true})
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runStore([]string{"list", "-g"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runStore list error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "db.password=********  # Postgres password [db] (secret)") {
+		t.Errorf("expected metadata in output, got: %s", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "db.host=localhost\n") {
+		t.Errorf("expected plain line without metadata, got: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runStore([]string{"list", "-g", "--reveal"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runStore list --reveal error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "db.password=s3cret  #") {
+		t.Errorf("expected revealed secret, got: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runStore([]string{"list", "-g", "--tag", "db"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runStore list --tag error: %v", err)
+	}
+	if strings.Contains(stdout.String(), "db.host") || !strings.Contains(stdout.String(), "db.password") {
+		t.Errorf("expected only tagged variables, got: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := runStore([]string{"list", "-g", "--json"}, &stdout, &stderr); err != nil {
+		t.Fatalf("runStore list --json error: %v", err)
+	}
+	var out struct {
+		Variables map[string]string     `json:"variables"`
+		Metadata  map[string]store.Meta `json:"metadata"`
+		Sensitive map[string]bool       `json:"sensitive"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if m := out.Metadata["db.password"]; !m.Secret || m.Created.IsZero() {
+		t.Errorf("unexpected JSON metadata: %+v", m)
+	}
+	// JSON is for machines: values are not masked, sensitivity is flagged
+	if out.Variables["db.password"] != "s3cret" || !out.Sensitive["db.password"] || out.Sensitive["db.host"] {
+		t.Errorf("unexpected JSON values: %+v %+v", out.Variables, out.Sensitive)
+	}
+}
+
+// setupEncryptedProjectCLI saves app.name and other.name in a plaintext
+// store and encrypts project app with password.
+func setupEncryptedProjectCLI(t *testing.T, password string) {
+	t.Helper()
+	unsetenv(t, crypto.PasswordEnvVar)
+	unsetenv(t, crypto.ProjectPasswordCommandEnvVar)
+	unsetenv(t, crypto.NewPasswordEnvVar)
+	t.Setenv("VARNISH_PASSWORD_APP", password)
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
+	cfg := project.New()
+	cfg.Project = "app"
+	cfg.Include = []string{"*"}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save project: %v", err)
+	}
+
+	st := store.New()
+	st.Set("app.name", "demo")
+	st.Set("other.name", "plain")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "encrypt", "--project", "app"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store encrypt --project error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "project app encrypted with its own password (1 variables)") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestRunStoreEncryptProjectGlobalKeys(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	unsetenv(t, crypto.PasswordEnvVar)
+	t.Setenv("VARNISH_PASSWORD_AWS", "aws-password")
+	t.Cleanup(func() { crypto.SetPasswordSources("", -1) })
+
+	// Project aws uses aws.db.*; aws.region is a global key
+	cfg := project.New()
+	cfg.Project = "aws"
+	cfg.Include = []string{"db.*"}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save project: %v", err)
+	}
+	st := store.New()
+	st.Set("aws.db.host", "localhost")
+	st.Set("aws.region", "eu-west-1")
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"store", "encrypt", "--project", "aws"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "aws.region") {
+		t.Fatalf("expected the global key to block encryption, got %v", err)
+	}
+	if _, err := os.Stat(config.SegmentPathFor("aws")); !os.IsNotExist(err) {
+		t.Error("segment written despite the collision")
+	}
+
+	// A prefix that is no project is refused too
+	err = run([]string{"store", "encrypt", "--project", "nosuch"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "project nosuch not found") {
+		t.Errorf("expected unknown project error, got %v", err)
+	}
+
+	// Once the project includes it, the key is the project's
+	cfg.Include = append(cfg.Include, "region")
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"store", "encrypt", "--project", "aws"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store encrypt --project error: %v", err)
+	}
+}
+
+func TestRunStoreEncryptProject(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedProjectCLI(t, "app-password")
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "encrypt", "-p", "app"}, &stdout, &stderr); err != nil {
+		t.Fatalf("second store encrypt --project error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "already encrypted") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	// Without the project's password it is listed as locked
+	unsetenv(t, "VARNISH_PASSWORD_APP")
+	stdout.Reset()
+	if err := run([]string{"store", "list", "--global"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store list --global error: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "app.* (locked)") || !strings.Contains(out, "other.name=plain") || strings.Contains(out, "demo") {
+		t.Errorf("unexpected list output: %s", out)
+	}
+	if err := run([]string{"store", "get", "-g", "app.name"}, &stdout, &stderr); err == nil {
+		t.Error("store get of a locked project should fail without its password")
+	}
+
+	t.Setenv("VARNISH_PASSWORD_APP", "app-password")
+	stdout.Reset()
+	if err := run([]string{"store", "get", "-g", "app.name"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store get error: %v", err)
+	}
+	if strings.TrimSpace(stdout.String()) != "demo" {
+		t.Errorf("app.name = %q, want demo", stdout.String())
+	}
+	if err := run([]string{"store", "set", "-p", "app", "port", "8080"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store set error: %v", err)
+	}
+	if _, err := os.Stat(config.SegmentPathFor("app")); err != nil {
+		t.Errorf("segment missing: %v", err)
+	}
+}
+
+func TestRunStoreRekeyProject(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedProjectCLI(t, "old-password")
+
+	var stdout, stderr bytes.Buffer
+	t.Setenv(crypto.NewPasswordEnvVar, "new-password")
+	if err := run([]string{"store", "rekey", "--project", "app"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store rekey --project error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "password of project app changed") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	t.Setenv("VARNISH_PASSWORD_APP", "new-password")
+	stdout.Reset()
+	if err := run([]string{"store", "get", "-g", "app.name"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store get with the new password error: %v", err)
+	}
+
+	if err := run([]string{"store", "rekey", "-p", "other"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "not encrypted separately") {
+		t.Errorf("expected not-encrypted error, got %v", err)
+	}
+}
+
+func TestRunStoreDecryptProject(t *testing.T) {
+	cleanup := setupTestEnv(t)
+	defer cleanup()
+	setupEncryptedProjectCLI(t, "app-password")
+
+	orig := stdinIsTerminal
+	stdinIsTerminal = func() bool { return false }
+	defer func() { stdinIsTerminal = orig }()
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"store", "decrypt", "-p", "app"}, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "--yes") {
+		t.Errorf("expected confirmation error, got %v", err)
+	}
+	if err := run([]string{"store", "decrypt", "-p", "app", "--yes"}, &stdout, &stderr); err != nil {
+		t.Fatalf("store decrypt --project error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "project app moved back into the store (1 variables)") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	unsetenv(t, "VARNISH_PASSWORD_APP")
+	st, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := st.Get("app.name"); v != "demo" || len(st.EncryptedProjects()) != 0 {
+		t.Errorf("app.name = %q, encrypted projects = %v", v, st.EncryptedProjects())
+	}
+}
@@ -0,0 +1,48 @@
+// validate.go holds the schema validation reporting shared by commands.
+//
+// This file is used by:
+//   - cli/env.go: refuses to write a .env that breaks the schema
+//   - cli/store.go: validates "store set" values
+//   - cli/check.go: reports violations alongside other errors
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/resolver"
+	"github.com/dk/varnish/internal/store"
+)
+
+// reportViolations prints every schema violation and returns an error if
+// there were any.
+func reportViolations(w io.Writer, violations []project.Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "schema violations:")
+	for _, v := range violations {
+		fmt.Fprintf(w, "  ✗ %s\n", v)
+	}
+	return fmt.Errorf("%d schema violation(s)", len(violations))
+}
+
+// validateStoreValue checks a value about to be stored for a project's key
+// against the project's schema. namespace selects the profile, if any.
+// Keys outside a project, or projects without a config, are not validated.
+func validateStoreValue(st *store.Store, proj, namespace, key, value string) []project.Violation {
+	if proj == "" || !project.Exists(proj) {
+		return nil
+	}
+	cfg, err := project.LoadByName(proj)
+	if err != nil {
+		return nil
+	}
+	if _, profile := project.SplitNamespace(namespace); profile != "" {
+		if cfg, err = cfg.WithProfile(profile); err != nil {
+			return nil
+		}
+	}
+	return resolver.New(st, cfg).ValidateValue(key, value)
+}
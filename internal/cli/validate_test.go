@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/store"
+)
+
+// setupProjectWithSchema creates a project whose schema requires a valid
+// port and a known log level, stores values, and chdirs into it.
+func setupProjectWithSchema(t *testing.T, port, level string) func() {
+	t.Helper()
+	cleanup := setupTestEnv(t)
+
+	projectDir, cleanupProject := setupProjectForList(t, "schemaapp")
+
+	cfg, _ := project.LoadByName("schemaapp")
+	cfg.Include = []string{"database.*", "log.*"}
+	cfg.Schema = map[string]*project.Rule{
+		"DATABASE_PORT": {Type: "port", Required: true},
+		"log.level":     {Enum: []string{"debug", "info"}},
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	st, _ := store.Load()
+	st.Set("schemaapp.database.port", port)
+	st.Set("schemaapp.log.level", level)
+	if err := st.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	origWd, _ := os.Getwd()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	return func() {
+		_ = os.Chdir(origWd)
+		cleanupProject()
+		cleanup()
+	}
+}
+
+func TestRunCheckSchema(t *testing.T) {
+	defer setupProjectWithSchema(t, "abc", "trace")()
+
+	var stdout, stderr bytes.Buffer
+	if err := runCheck([]string{}, &stdout, &stderr); err == nil {
+		t.Fatal("expected check to fail on schema violations")
+	}
+
+	// Every violation is reported, not just the first
+	for _, want := range []string{
+		"schema: DATABASE_PORT: must be a port number (1-65535)",
+		"schema: log.level: must be one of: debug, info",
+	} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected %q in errors, got: %s", want, stderr.String())
+		}
+	}
+}
+
+func TestRunCheckSchemaValid(t *testing.T) {
+	defer setupProjectWithSchema(t, "5432", "info")()
+
+	var stdout, stderr bytes.Buffer
+	if err := runCheck([]string{}, &stdout, &stderr); err != nil {
+		t.Fatalf("runCheck error: %v\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "✓ 2 schema rule(s) satisfied") {
+		t.Errorf("expected schema summary, got: %s", stdout.String())
+	}
+}
+
+func TestRunEnvSchemaViolation(t *testing.T) {
+	defer setupProjectWithSchema(t, "abc", "info")()
+
+	var stdout, stderr bytes.Buffer
+	err := runEnv([]string{"--dry-run"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "1 schema violation(s)") {
+		t.Fatalf("expected schema error, got: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "✗ DATABASE_PORT: must be a port number") {
+		t.Errorf("expected violation on stderr, got: %s", stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output, got: %s", stdout.String())
+	}
+}
+
+func TestRunStoreSetSchema(t *testing.T) {
+	defer setupProjectWithSchema(t, "5432", "info")()
+
+	var stdout, stderr bytes.Buffer
+	err := runStore([]string{"set", "database.port", "abc"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "not set schemaapp.database.port") {
+		t.Fatalf("expected schema error, got: %v", err)
+	}
+
+	st, _ := store.Load()
+	if v, _ := st.Get("schemaapp.database.port"); v != "5432" {
+		t.Errorf("value should be unchanged, got %q", v)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if err := runStore([]string{"set", "database.port", "6543"}, &stdout, &stderr); err != nil {
+		t.Fatalf("valid value rejected: %v\n%s", err, stderr.String())
+	}
+
+	// Keys outside the project are not validated
+	if err := runStore([]string{"set", "--global", "database.port", "abc"}, &stdout, &stderr); err != nil {
+		t.Errorf("global key should not be validated: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package compose reads the services of a Docker Compose file and writes
+// an override file giving each of them its environment.
+//
+// This package is used by:
+//   - cli/compose.go: "varnish compose"
+//
+// Compose merges docker-compose.override.yml (or compose.override.yaml)
+// into the file next to it, so the generated override only needs each
+// service's environment block:
+//
+//	services:
+//	  web:
+//	    environment:
+//	      DB_HOST: localhost
+//
+// A service can name its varnish project in the compose file with the
+// x-varnish extension field, which Compose itself ignores:
+//
+//	services:
+//	  web:
+//	    image: myapp
+//	    x-varnish: myapp@dev
+package compose
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dk/varnish/internal/format"
+	"gopkg.in/yaml.v3"
+)
+
+// ExtensionField names a service's varnish project (and profile).
+const ExtensionField = "x-varnish"
+
+// FileNames are the compose files looked for, in the order Compose
+// prefers them.
+var FileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Service is a service declared in a compose file.
+type Service struct {
+	Name    string
+	Varnish string // Value of x-varnish, if set
+}
+
+// ServiceEnv is the environment written for one service.
+type ServiceEnv struct {
+	Service string
+	Vars    []format.Var
+}
+
+// Find returns the compose file in dir.
+func Find(dir string) (string, error) {
+	for _, name := range FileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no compose file found (looked for %s; use --file)", strings.Join(FileNames, ", "))
+}
+
+// OverridePath returns the override file Compose loads along with path:
+// docker-compose.yml → docker-compose.override.yml.
+func OverridePath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".override" + ext
+}
+
+// Load returns the services of a compose file, in file order.
+func Load(path string) ([]Service, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read compose file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s is empty", filepath.Base(path))
+	}
+
+	services := mappingValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s has no services", filepath.Base(path))
+	}
+
+	var result []Service
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		s := Service{Name: services.Content[i].Value}
+		if v := mappingValue(services.Content[i+1], ExtensionField); v != nil {
+			if v.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("service %s: %s must be a project name", s.Name, ExtensionField)
+			}
+			s.Varnish = v.Value
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// WriteOverride writes an override file setting each service's
+// environment, with header as its leading comment. Compose interpolates
+// $ in compose files, so it is written as $$.
+func WriteOverride(w io.Writer, services []ServiceEnv, header []string) error {
+	servicesNode := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range services {
+		env := &yaml.Node{Kind: yaml.MappingNode}
+		for _, v := range s.Vars {
+			env.Content = append(env.Content, str(v.Name), str(strings.ReplaceAll(v.Value, "$", "$$")))
+		}
+		if len(env.Content) == 0 {
+			env.Style = yaml.FlowStyle
+		}
+		service := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{str("environment"), env}}
+		servicesNode.Content = append(servicesNode.Content, str(s.Service), service)
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{str("services"), servicesNode}}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	if len(header) > 0 {
+		doc.HeadComment = strings.Join(header, "\n")
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// str returns a scalar node that always reads back as the string s.
+func str(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
@@ -0,0 +1,112 @@
+package compose
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dk/varnish/internal/format"
+	"gopkg.in/yaml.v3"
+)
+
+const composeFile = `services:
+  web:
+    image: myapp
+    x-varnish: myapp@docker
+  worker:
+    image: myapp
+  redis:
+    image: redis:7
+`
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Find(dir); err == nil {
+		t.Error("expected an error without a compose file")
+	}
+
+	for _, name := range []string{"docker-compose.yml", "compose.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(composeFile), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, err := Find(dir)
+	if err != nil || filepath.Base(path) != "compose.yaml" {
+		t.Errorf("Find() = %q, %v, want compose.yaml", path, err)
+	}
+}
+
+func TestOverridePath(t *testing.T) {
+	tests := map[string]string{
+		"docker-compose.yml": "docker-compose.override.yml",
+		"dev/compose.yaml":   "dev/compose.override.yaml",
+	}
+	for in, want := range tests {
+		if got := OverridePath(in); got != want {
+			t.Errorf("OverridePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	if err := os.WriteFile(path, []byte(composeFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	want := []Service{{Name: "web", Varnish: "myapp@docker"}, {Name: "worker"}, {Name: "redis"}}
+	if len(services) != len(want) {
+		t.Fatalf("Load() = %v, want %v", services, want)
+	}
+	for i := range want {
+		if services[i] != want[i] {
+			t.Errorf("service %d = %+v, want %+v", i, services[i], want[i])
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("version: '3'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for a file without services")
+	}
+}
+
+func TestWriteOverride(t *testing.T) {
+	envs := []ServiceEnv{
+		{Service: "web", Vars: []format.Var{
+			{Name: "DB_PORT", Value: "5432"},
+			{Name: "PRICE", Value: "$5"},
+			{Name: "MULTI", Value: "a\nb"},
+		}},
+		{Service: "worker"},
+	}
+	var buf bytes.Buffer
+	if err := WriteOverride(&buf, envs, []string{"Generated by varnish"}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("# Generated by varnish\n")) {
+		t.Errorf("missing header:\n%s", buf.String())
+	}
+
+	var got struct {
+		Services map[string]struct {
+			Environment map[string]interface{} `yaml:"environment"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not YAML: %v\n%s", err, buf.String())
+	}
+	web := got.Services["web"].Environment
+	if web["DB_PORT"] != "5432" || web["PRICE"] != "$$5" || web["MULTI"] != "a\nb" {
+		t.Errorf("web environment = %#v", web)
+	}
+	if _, ok := got.Services["worker"]; !ok {
+		t.Error("worker missing")
+	}
+}
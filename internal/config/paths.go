@@ -8,6 +8,19 @@
 //
 // Varnish stores all data in ~/.varnish/:
 //   - store.yaml: all variables (0600 permissions - contains secrets)
+//   - history.yaml: previous values of changed variables (0600)
+//   - config.yaml: global settings (see settings.go)
+//   - snapshots/: copies of the files above, taken before destructive commands
+//   - *.lock: advisory locks held while a file is changed (see lock/lock.go)
+//   - identity.txt: the user's X25519 identity for stores encrypted to
+//     recipients (0600, see crypto/recipient.go)
+//   - agent.sock: socket of the unlock agent, while it runs (see agent/agent.go)
+//   - segments/: projects encrypted with their own password, one
+//     <project>.yaml each (0600, see store/segment.go)
+//   - *.bak: previous ciphertext of store.yaml, history.yaml and segments,
+//     kept by "store rekey" and "store decrypt" (see store/rekey.go)
+//   - rendered.yaml: files written by "varnish render" and a hash of their
+//     content, so hand-written files aren't overwritten (see render/manifest.go)
 //   - registry.yaml: maps directories to project names (0644)
 //   - projects/: directory containing per-project configs
 //   - <project>.yaml: project-specific config (0644)
@@ -25,6 +38,9 @@ const (
 	// StoreFileName is the central variable store.
 	StoreFileName = "store.yaml"
 
+	// HistoryFileName holds the change history of the store.
+	HistoryFileName = "history.yaml"
+
 	// ConfigFileName is the global config file.
 	ConfigFileName = "config.yaml"
 
@@ -34,6 +50,21 @@ const (
 	// ProjectsDirName is the subdirectory for project configs.
 	ProjectsDirName = "projects"
 
+	// SegmentsDirName is the subdirectory for separately encrypted projects.
+	SegmentsDirName = "segments"
+
+	// SnapshotsDirName is the subdirectory for snapshots.
+	SnapshotsDirName = "snapshots"
+
+	// AgentSocketName is the unlock agent's socket.
+	AgentSocketName = "agent.sock"
+
+	// IdentityFileName holds the user's X25519 identity.
+	IdentityFileName = "identity.txt"
+
+	// RenderedFileName records the files written by "varnish render".
+	RenderedFileName = "rendered.yaml"
+
 	// ProjectConfigName is the legacy per-project config file name.
 	// Kept for migration purposes.
 	ProjectConfigName = ".varnish.yaml"
@@ -67,6 +98,24 @@ func StorePath() (string, error) {
 	return filepath.Join(dir, StoreFileName), nil
 }
 
+// HistoryPath returns the path to ~/.varnish/history.yaml.
+func HistoryPath() (string, error) {
+	dir, err := VarnishDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, HistoryFileName), nil
+}
+
+// SnapshotsDir returns the path to ~/.varnish/snapshots/.
+func SnapshotsDir() (string, error) {
+	dir, err := VarnishDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, SnapshotsDirName), nil
+}
+
 // ConfigPath returns the path to ~/.varnish/config.yaml.
 func ConfigPath() (string, error) {
 	dir, err := VarnishDir()
@@ -76,6 +125,33 @@ func ConfigPath() (string, error) {
 	return filepath.Join(dir, ConfigFileName), nil
 }
 
+// AgentSocketPath returns the path to ~/.varnish/agent.sock.
+func AgentSocketPath() (string, error) {
+	dir, err := VarnishDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, AgentSocketName), nil
+}
+
+// IdentityPath returns the path to ~/.varnish/identity.txt.
+func IdentityPath() (string, error) {
+	dir, err := VarnishDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, IdentityFileName), nil
+}
+
+// RenderedPath returns the path to ~/.varnish/rendered.yaml.
+func RenderedPath() (string, error) {
+	dir, err := VarnishDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, RenderedFileName), nil
+}
+
 // EnsureVarnishDir creates ~/.varnish if it doesn't exist.
 // Sets permissions to 0700 (owner only) since it will contain secrets.
 func EnsureVarnishDir() error {
@@ -118,54 +194,82 @@ func ProjectConfigPathFor(project string) string {
 	return filepath.Join(ProjectsDir(), project+".yaml")
 }
 
+// SegmentsDir returns the path to ~/.varnish/segments/.
+func SegmentsDir() string {
+	dir, err := VarnishDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, SegmentsDirName)
+}
+
+// EnsureSegmentsDir creates ~/.varnish/segments/ if it doesn't exist.
+func EnsureSegmentsDir() error {
+	if err := EnsureVarnishDir(); err != nil {
+		return err
+	}
+	return os.MkdirAll(SegmentsDir(), PermDir)
+}
+
+// SegmentPathFor returns the path of a project's segment.
+// e.g., ~/.varnish/segments/myapp.yaml
+func SegmentPathFor(project string) string {
+	return filepath.Join(SegmentsDir(), project+".yaml")
+}
+
 // AtomicWrite writes data to a file atomically by writing to a temp file
 // first, syncing, then renaming. This prevents partial writes.
 func AtomicWrite(path string, data []byte, perm os.FileMode) error {
-	dir := filepath.Dir(path)
+	tmpName, err := WriteTemp(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	// Atomic rename
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
 
+// WriteTemp writes data to a synced temp file next to path, ready to be
+// renamed over it, and returns the temp file's name. Callers that write
+// several files use it to write them all before replacing any.
+func WriteTemp(path string, data []byte, perm os.FileMode) (string, error) {
 	// Create temp file in same directory (for atomic rename)
-	tmp, err := os.CreateTemp(dir, ".tmp-")
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
 	if err != nil {
-		return err
+		return "", err
 	}
 	tmpName := tmp.Name()
 
-	// Clean up on any error
-	defer func() {
-		if tmpName != "" {
-			os.Remove(tmpName)
-		}
-	}()
-
 	// Write data
 	if _, err := tmp.Write(data); err != nil {
 		tmp.Close()
-		return err
+		os.Remove(tmpName)
+		return "", err
 	}
 
 	// Sync to disk
 	if err := tmp.Sync(); err != nil {
 		tmp.Close()
-		return err
+		os.Remove(tmpName)
+		return "", err
 	}
 
 	// Close before rename
 	if err := tmp.Close(); err != nil {
-		return err
+		os.Remove(tmpName)
+		return "", err
 	}
 
 	// Set permissions
 	if err := os.Chmod(tmpName, perm); err != nil {
-		return err
-	}
-
-	// Atomic rename
-	if err := os.Rename(tmpName, path); err != nil {
-		return err
+		os.Remove(tmpName)
+		return "", err
 	}
-
-	tmpName = "" // Prevent cleanup since rename succeeded
-	return nil
+	return tmpName, nil
 }
 
 // FindProjectConfig searches for .varnish.yaml starting from the current
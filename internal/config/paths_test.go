@@ -48,6 +48,28 @@ func TestConfigPath(t *testing.T) {
 	}
 }
 
+func TestHistoryPath(t *testing.T) {
+	path, err := HistoryPath()
+	if err != nil {
+		t.Fatalf("HistoryPath() error: %v", err)
+	}
+
+	if !strings.HasSuffix(path, "history.yaml") {
+		t.Errorf("HistoryPath() = %q, expected to end with 'history.yaml'", path)
+	}
+}
+
+func TestSnapshotsDir(t *testing.T) {
+	dir, err := SnapshotsDir()
+	if err != nil {
+		t.Fatalf("SnapshotsDir() error: %v", err)
+	}
+
+	if filepath.Base(dir) != "snapshots" || !strings.Contains(dir, ".varnish") {
+		t.Errorf("SnapshotsDir() = %q, expected ~/.varnish/snapshots", dir)
+	}
+}
+
 func TestRegistryPath(t *testing.T) {
 	path := RegistryPath()
 
@@ -92,6 +114,26 @@ func TestProjectConfigPathFor(t *testing.T) {
 	}
 }
 
+func TestSegmentPathFor(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	path := SegmentPathFor("myapp")
+	if !strings.HasSuffix(path, filepath.Join(DirName, "segments", "myapp.yaml")) {
+		t.Errorf("SegmentPathFor(myapp) = %q", path)
+	}
+
+	if err := EnsureSegmentsDir(); err != nil {
+		t.Fatalf("EnsureSegmentsDir() error: %v", err)
+	}
+	info, err := os.Stat(SegmentsDir())
+	if err != nil {
+		t.Fatalf("failed to stat segments dir: %v", err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != PermDir {
+		t.Errorf("segments dir mode = %v, want a directory with %o", info.Mode(), PermDir)
+	}
+}
+
 func TestEnsureVarnishDir(t *testing.T) {
 	// This test uses the real home directory
 	// In a production test suite, you might want to mock this
@@ -0,0 +1,135 @@
+// settings.go loads the global settings file, ~/.varnish/config.yaml.
+//
+// This file is used by:
+//   - store/history.go: to read how many changes to keep per key
+//   - snapshot/snapshot.go: to read how many snapshots to keep
+//   - store/store.go: to read the key derivation parameters for encryption
+//   - cli/agent.go: to read the unlock agent's idle timeout
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultHistoryLimit is how many changes are kept per key by default.
+const DefaultHistoryLimit = 20
+
+// DefaultSnapshotKeep is how many snapshots are kept by default.
+const DefaultSnapshotKeep = 10
+
+// DefaultAgentTimeout is how long the unlock agent keeps unused keys.
+const DefaultAgentTimeout = 15 * time.Minute
+
+// Settings holds the global settings in ~/.varnish/config.yaml:
+//
+//	history:
+//	  limit: 50   # changes kept per key; -1 disables history
+//	snapshots:
+//	  keep: 5     # snapshots kept; -1 disables automatic snapshots
+//	encryption:
+//	  kdf:
+//	    memory: 256  # Argon2id memory in MiB
+//	    time: 3      # Argon2id passes
+//	    threads: 4   # Argon2id parallelism
+//	agent:
+//	  timeout: 1h    # forget keys unused for this long
+//
+// A missing file or setting means the default.
+type Settings struct {
+	History    HistorySettings    `yaml:"history,omitempty"`
+	Snapshots  SnapshotSettings   `yaml:"snapshots,omitempty"`
+	Encryption EncryptionSettings `yaml:"encryption,omitempty"`
+	Agent      AgentSettings      `yaml:"agent,omitempty"`
+}
+
+// HistorySettings configures the store's change history.
+type HistorySettings struct {
+	Limit int `yaml:"limit,omitempty"`
+}
+
+// SnapshotSettings configures automatic snapshots.
+type SnapshotSettings struct {
+	Keep int `yaml:"keep,omitempty"`
+}
+
+// EncryptionSettings configures store encryption.
+type EncryptionSettings struct {
+	KDF KDFSettings `yaml:"kdf,omitempty"`
+}
+
+// KDFSettings are the key derivation parameters used when the store is
+// written. Zero fields keep the store's current parameters.
+type KDFSettings struct {
+	Memory  int `yaml:"memory,omitempty"` // MiB
+	Time    int `yaml:"time,omitempty"`
+	Threads int `yaml:"threads,omitempty"`
+}
+
+// AgentSettings configures the unlock agent.
+type AgentSettings struct {
+	Timeout string `yaml:"timeout,omitempty"` // duration, e.g. 30m
+}
+
+// LoadSettings reads ~/.varnish/config.yaml. A missing file is not an error.
+func LoadSettings() (*Settings, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var s Settings
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// HistoryLimit returns how many changes to keep per key: the configured
+// limit, DefaultHistoryLimit if unset, or 0 if history is disabled.
+func (s *Settings) HistoryLimit() int {
+	switch {
+	case s.History.Limit < 0:
+		return 0
+	case s.History.Limit == 0:
+		return DefaultHistoryLimit
+	default:
+		return s.History.Limit
+	}
+}
+
+// SnapshotKeep returns how many snapshots to keep: the configured number,
+// DefaultSnapshotKeep if unset, or 0 if automatic snapshots are disabled.
+func (s *Settings) SnapshotKeep() int {
+	switch {
+	case s.Snapshots.Keep < 0:
+		return 0
+	case s.Snapshots.Keep == 0:
+		return DefaultSnapshotKeep
+	default:
+		return s.Snapshots.Keep
+	}
+}
+
+// AgentTimeout returns the unlock agent's idle timeout: the configured
+// duration, or DefaultAgentTimeout if unset.
+func (s *Settings) AgentTimeout() (time.Duration, error) {
+	if s.Agent.Timeout == "" {
+		return DefaultAgentTimeout, nil
+	}
+	d, err := time.ParseDuration(s.Agent.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid agent.timeout %q in config.yaml (want a duration like 30m)", s.Agent.Timeout)
+	}
+	return d, nil
+}
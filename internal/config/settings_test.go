@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// Missing file means defaults
+	s, err := LoadSettings()
+	if err != nil {
+		t.Fatalf("LoadSettings() error: %v", err)
+	}
+	if got := s.HistoryLimit(); got != DefaultHistoryLimit {
+		t.Errorf("HistoryLimit() = %d, want %d", got, DefaultHistoryLimit)
+	}
+
+	dir := filepath.Join(home, DirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ConfigFileName)
+
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"history:\n  limit: 5\n", 5},
+		{"history:\n  limit: -1\n", 0},
+		{"history: {}\n", DefaultHistoryLimit},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		s, err := LoadSettings()
+		if err != nil {
+			t.Fatalf("LoadSettings() error: %v", err)
+		}
+		if got := s.HistoryLimit(); got != tt.want {
+			t.Errorf("HistoryLimit() for %q = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("history: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSettings(); err == nil {
+		t.Error("expected error for invalid config.yaml")
+	}
+}
+
+func TestSnapshotKeep(t *testing.T) {
+	tests := []struct {
+		keep int
+		want int
+	}{
+		{0, DefaultSnapshotKeep},
+		{3, 3},
+		{-1, 0},
+	}
+	for _, tt := range tests {
+		s := &Settings{Snapshots: SnapshotSettings{Keep: tt.keep}}
+		if got := s.SnapshotKeep(); got != tt.want {
+			t.Errorf("SnapshotKeep() with keep %d = %d, want %d", tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestAgentTimeout(t *testing.T) {
+	tests := []struct {
+		timeout string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultAgentTimeout, false},
+		{"1h", time.Hour, false},
+		{"0s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		s := &Settings{Agent: AgentSettings{Timeout: tt.timeout}}
+		got, err := s.AgentTimeout()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("AgentTimeout() for %q error = %v, wantErr %v", tt.timeout, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("AgentTimeout() for %q = %v, want %v", tt.timeout, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,28 @@
 // Package crypto provides encryption/decryption for the varnish store.
 // Uses AES-256-GCM for authenticated encryption and Argon2id for key derivation.
+// The password comes from one of several sources, see password.go.
+//
+// Encrypted files start with a header recording the format version and,
+// since version 2, the key derivation parameters:
+//
+//	v1: Magic (8B) | Version (1B) | Salt (16B) | Nonce (12B) | Ciphertext+Tag
+//	v2: Magic (8B) | Version (1B) | KDF (1B) | Time (4B) | Memory KiB (4B) |
+//	    Threads (1B) | Salt (16B) | Nonce (12B) | Ciphertext+Tag
+//
+// In v2 the whole header is authenticated as GCM additional data, so the
+// parameters can't be altered without failing decryption. Version 1 files,
+// which always used DefaultKDFParams, can still be decrypted; Encrypt only
+// writes version 2. Version 3 files are encrypted to public keys instead of
+// a password, see recipient.go.
 package crypto
 
 import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -18,24 +32,83 @@ const (
 	PasswordEnvVar = "VARNISH_PASSWORD"
 
 	// Version is the current encryption format version.
-	Version = 1
+	Version = 2
 
-	// Key derivation parameters (Argon2id)
-	argonTime    = 1
-	argonMemory  = 64 * 1024 // 64 MB
-	argonThreads = 4
-	argonKeyLen  = 32 // AES-256
+	// VersionLegacy is the first format, without KDF parameters.
+	VersionLegacy = 1
+
+	// KDFArgon2id identifies Argon2id in the v2 header.
+	KDFArgon2id = 1
+
+	argonKeyLen = 32 // AES-256
 
 	// Sizes
-	saltSize  = 16
-	nonceSize = 12 // GCM standard nonce size
+	saltSize     = 16
+	nonceSize    = 12 // GCM standard nonce size
+	kdfFieldSize = 1 + 4 + 4 + 1
+)
+
+// Limits on KDF parameters. A header asking for more is rejected rather
+// than letting a damaged or hostile file exhaust memory or time.
+const (
+	MaxKDFTime    = 100
+	MaxKDFMemory  = 4 * 1024 * 1024 // KiB, 4 GiB
+	MaxKDFThreads = 255
 )
 
 // MagicBytes identifies encrypted varnish store files.
 var MagicBytes = []byte("VARNISH\x00")
 
-// ErrPasswordRequired is returned when VARNISH_PASSWORD is not set.
-var ErrPasswordRequired = errors.New("VARNISH_PASSWORD environment variable not set")
+// ErrPasswordRequired is returned when no password source is available
+// (see password.go).
+var ErrPasswordRequired = errors.New("no password source (use --password-file, --password-fd, VARNISH_PASSWORD or VARNISH_PASSWORD_COMMAND, or run in a terminal)")
+
+// KDFParams are the Argon2id parameters used to derive the key.
+type KDFParams struct {
+	Time    uint32 // passes over memory
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// DefaultKDFParams are used unless configured otherwise, and by every
+// version 1 file.
+var DefaultKDFParams = KDFParams{Time: 1, Memory: 64 * 1024, Threads: 4}
+
+// Validate checks that p is usable and within the limits.
+func (p KDFParams) Validate() error {
+	switch {
+	case p.Time < 1 || p.Time > MaxKDFTime:
+		return fmt.Errorf("KDF time %d out of range (1-%d)", p.Time, MaxKDFTime)
+	case p.Threads < 1:
+		return fmt.Errorf("KDF threads must be at least 1")
+	case p.Memory < 8*uint32(p.Threads) || p.Memory > MaxKDFMemory:
+		return fmt.Errorf("KDF memory %d KiB out of range (%d-%d KiB)", p.Memory, 8*uint32(p.Threads), MaxKDFMemory)
+	}
+	return nil
+}
+
+// String describes p, e.g. "argon2id time=1 memory=64MiB threads=4".
+func (p KDFParams) String() string {
+	mem := fmt.Sprintf("%dKiB", p.Memory)
+	if p.Memory%1024 == 0 {
+		mem = fmt.Sprintf("%dMiB", p.Memory/1024)
+	}
+	return fmt.Sprintf("argon2id time=%d memory=%s threads=%d", p.Time, mem, p.Threads)
+}
+
+// Header is the parsed header of an encrypted file.
+type Header struct {
+	Version int
+	KDF     KDFParams
+	Salt    []byte
+
+	// Recipients of a version 3 file
+	Recipients []Recipient
+
+	stanzas []stanza
+	nonce   []byte
+	size    int // header length in bytes
+}
 
 // IsEncrypted returns true if data starts with the varnish magic bytes.
 func IsEncrypted(data []byte) bool {
@@ -50,129 +123,162 @@ func IsEncrypted(data []byte) bool {
 	return true
 }
 
-// GetPassword reads the encryption password from VARNISH_PASSWORD env var.
-// Returns ErrPasswordRequired if the variable is not set or empty.
-func GetPassword() (string, error) {
-	password := os.Getenv(PasswordEnvVar)
-	if password == "" {
-		return "", ErrPasswordRequired
+// ParseHeader reads the header of encrypted data. The parameters it
+// returns are not authenticated until the data is decrypted.
+func ParseHeader(data []byte) (*Header, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("invalid encrypted data: missing magic bytes")
+	}
+	if len(data) < len(MagicBytes)+1 {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	offset := len(MagicBytes)
+	h := &Header{Version: int(data[offset])}
+	offset++
+
+	switch h.Version {
+	case VersionLegacy:
+		h.KDF = DefaultKDFParams
+	case Version:
+		if len(data) < offset+kdfFieldSize {
+			return nil, errors.New("encrypted data too short")
+		}
+		if data[offset] != KDFArgon2id {
+			return nil, fmt.Errorf("unsupported key derivation function: %d", data[offset])
+		}
+		h.KDF = KDFParams{
+			Time:    binary.BigEndian.Uint32(data[offset+1:]),
+			Memory:  binary.BigEndian.Uint32(data[offset+5:]),
+			Threads: data[offset+9],
+		}
+		offset += kdfFieldSize
+		if err := h.KDF.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid encryption header: %w", err)
+		}
+	case VersionRecipients:
+		return parseRecipientsHeader(data, h)
+	default:
+		return nil, fmt.Errorf("unsupported encryption version: %d", h.Version)
+	}
+
+	// Salt, nonce and at least a GCM tag must follow
+	if len(data) < offset+saltSize+nonceSize+16 {
+		return nil, errors.New("encrypted data too short")
 	}
-	return password, nil
+	h.Salt = data[offset : offset+saltSize]
+	offset += saltSize
+	h.nonce = data[offset : offset+nonceSize]
+	offset += nonceSize
+	h.size = offset
+	return h, nil
 }
 
-// DeriveKey derives a 256-bit key from password and salt using Argon2id.
+// DeriveKey derives a 256-bit key from password and salt using Argon2id
+// with DefaultKDFParams.
 func DeriveKey(password string, salt []byte) []byte {
-	return argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return deriveKey(password, salt, DefaultKDFParams)
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM with a key derived from password.
-// Returns encrypted data in format: Magic (8B) | Version (1B) | Salt (16B) | Nonce (12B) | Ciphertext+Tag
-func Encrypt(plaintext []byte, password string) ([]byte, error) {
-	// Generate random salt
-	salt := make([]byte, saltSize)
-	if _, err := rand.Read(salt); err != nil {
-		return nil, fmt.Errorf("generate salt: %w", err)
-	}
-
-	// Derive key
-	key := DeriveKey(password, salt)
+func deriveKey(password string, salt []byte, p KDFParams) []byte {
+	return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argonKeyLen)
+}
 
-	// Create cipher
+// newGCM creates an AES-256-GCM cipher for key.
+func newGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("create cipher: %w", err)
 	}
-
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return nil, fmt.Errorf("create GCM: %w", err)
 	}
+	return gcm, nil
+}
 
-	// Generate random nonce
-	nonce := make([]byte, nonceSize)
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, fmt.Errorf("generate nonce: %w", err)
-	}
-
-	// Encrypt
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
-
-	// Build output: Magic | Version | Salt | Nonce | Ciphertext
-	headerSize := len(MagicBytes) + 1 + saltSize + nonceSize
-	result := make([]byte, headerSize+len(ciphertext))
+// Encrypt encrypts plaintext using AES-256-GCM with a key derived from
+// password with DefaultKDFParams.
+func Encrypt(plaintext []byte, password string) ([]byte, error) {
+	return EncryptWith(plaintext, password, DefaultKDFParams)
+}
 
-	offset := 0
-	copy(result[offset:], MagicBytes)
-	offset += len(MagicBytes)
+// EncryptWith encrypts plaintext like Encrypt, deriving the key with
+// params. Returns data in the version 2 format.
+func EncryptWith(plaintext []byte, password string, params KDFParams) ([]byte, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
 
-	result[offset] = Version
-	offset++
+	// Generate random salt
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
 
-	copy(result[offset:], salt)
-	offset += saltSize
+	return seal(plaintext, salt, params, deriveKey(password, salt, params))
+}
 
-	copy(result[offset:], nonce)
-	offset += nonceSize
+// seal encrypts plaintext with key, which was derived with salt and
+// params, in the version 2 format.
+func seal(plaintext, salt []byte, params KDFParams, key []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
 
-	copy(result[offset:], ciphertext)
+	// Build header: Magic | Version | KDF | Time | Memory | Threads | Salt | Nonce
+	header := make([]byte, 0, len(MagicBytes)+1+kdfFieldSize+saltSize+nonceSize)
+	header = append(header, MagicBytes...)
+	header = append(header, Version, KDFArgon2id)
+	header = binary.BigEndian.AppendUint32(header, params.Time)
+	header = binary.BigEndian.AppendUint32(header, params.Memory)
+	header = append(header, params.Threads)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
 
-	return result, nil
+	// The header is authenticated along with the ciphertext
+	return gcm.Seal(header, nonce, plaintext, header), nil
 }
 
-// Decrypt decrypts data that was encrypted with Encrypt.
-// Returns ErrPasswordRequired if password is empty.
+// Decrypt decrypts data that was encrypted with Encrypt, in either format
+// version. Returns ErrPasswordRequired if password is empty, and
+// ErrRecipientsEncrypted for data encrypted to recipients.
 func Decrypt(data []byte, password string) ([]byte, error) {
 	if password == "" {
 		return nil, ErrPasswordRequired
 	}
 
-	// Minimum size: Magic + Version + Salt + Nonce + at least 16 bytes (GCM tag)
-	minSize := len(MagicBytes) + 1 + saltSize + nonceSize + 16
-	if len(data) < minSize {
-		return nil, errors.New("encrypted data too short")
-	}
-
-	// Verify magic bytes
-	if !IsEncrypted(data) {
-		return nil, errors.New("invalid encrypted data: missing magic bytes")
+	h, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
 	}
-
-	offset := len(MagicBytes)
-
-	// Check version
-	version := data[offset]
-	if version != Version {
-		return nil, fmt.Errorf("unsupported encryption version: %d", version)
+	if h.Version == VersionRecipients {
+		return nil, ErrRecipientsEncrypted
 	}
-	offset++
 
-	// Extract salt
-	salt := data[offset : offset+saltSize]
-	offset += saltSize
-
-	// Extract nonce
-	nonce := data[offset : offset+nonceSize]
-	offset += nonceSize
-
-	// Extract ciphertext
-	ciphertext := data[offset:]
-
-	// Derive key
-	key := DeriveKey(password, salt)
+	return open(data, h, deriveKey(password, h.Salt, h.KDF))
+}
 
-	// Create cipher
-	block, err := aes.NewCipher(key)
+// open decrypts data, whose header is h, with key.
+func open(data []byte, h *Header, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
-		return nil, fmt.Errorf("create cipher: %w", err)
+		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("create GCM: %w", err)
+	// Version 1 didn't authenticate its header
+	var aad []byte
+	if h.Version >= Version {
+		aad = data[:h.size]
 	}
 
-	// Decrypt
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, h.nonce, data[h.size:], aad)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt: %w", err)
 	}
@@ -331,3 +331,115 @@ func TestDecryptTamperedCiphertext(t *testing.T) {
 		t.Error("Decrypt() should fail on tampered ciphertext (GCM auth should catch this)")
 	}
 }
+
+// encryptV1 builds a version 1 file, as Encrypt wrote before version 2.
+func encryptV1(t *testing.T, plaintext []byte, password string) []byte {
+	t.Helper()
+	salt := bytes.Repeat([]byte{1}, saltSize)
+	nonce := bytes.Repeat([]byte{2}, nonceSize)
+	gcm, err := newGCM(DeriveKey(password, salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte{}, MagicBytes...)
+	out = append(out, VersionLegacy)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil)
+}
+
+func TestDecryptVersion1(t *testing.T) {
+	data := encryptV1(t, []byte("legacy"), "testpassword")
+
+	h, err := ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader() error = %v", err)
+	}
+	if h.Version != VersionLegacy || h.KDF != DefaultKDFParams {
+		t.Errorf("ParseHeader() = version %d, %v; want 1, defaults", h.Version, h.KDF)
+	}
+
+	got, err := Decrypt(data, "testpassword")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(got) != "legacy" {
+		t.Errorf("Decrypt() = %q, want %q", got, "legacy")
+	}
+}
+
+func TestEncryptWithParams(t *testing.T) {
+	params := KDFParams{Time: 2, Memory: 8 * 1024, Threads: 2}
+	data, err := EncryptWith([]byte("tuned"), "testpassword", params)
+	if err != nil {
+		t.Fatalf("EncryptWith() error = %v", err)
+	}
+
+	h, err := ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader() error = %v", err)
+	}
+	if h.Version != Version || h.KDF != params {
+		t.Errorf("ParseHeader() = version %d, %v; want %d, %v", h.Version, h.KDF, Version, params)
+	}
+
+	got, err := Decrypt(data, "testpassword")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(got) != "tuned" {
+		t.Errorf("Decrypt() = %q, want %q", got, "tuned")
+	}
+
+	if _, err := EncryptWith([]byte("x"), "testpassword", KDFParams{}); err == nil {
+		t.Error("EncryptWith() should reject zero parameters")
+	}
+}
+
+func TestDecryptTamperedHeader(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	data, err := EncryptWith([]byte("important data"), "testpassword", params)
+	if err != nil {
+		t.Fatalf("EncryptWith() error = %v", err)
+	}
+
+	// Raise the time parameter from 1 to 2: still valid, but authenticated
+	tampered := append([]byte{}, data...)
+	tampered[len(MagicBytes)+5] = 2
+	if _, err := Decrypt(tampered, "testpassword"); err == nil {
+		t.Error("Decrypt() should fail on a tampered header")
+	}
+
+	// A header asking for absurd memory is rejected before deriving a key
+	tampered = append([]byte{}, data...)
+	tampered[len(MagicBytes)+6] = 0xFF
+	if _, err := Decrypt(tampered, "testpassword"); err == nil {
+		t.Error("Decrypt() should reject out-of-range parameters")
+	}
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  KDFParams
+		wantErr bool
+	}{
+		{"defaults", DefaultKDFParams, false},
+		{"no time", KDFParams{Time: 0, Memory: 64 * 1024, Threads: 4}, true},
+		{"too much time", KDFParams{Time: MaxKDFTime + 1, Memory: 64 * 1024, Threads: 4}, true},
+		{"no threads", KDFParams{Time: 1, Memory: 64 * 1024, Threads: 0}, true},
+		{"too little memory", KDFParams{Time: 1, Memory: 16, Threads: 4}, true},
+		{"too much memory", KDFParams{Time: 1, Memory: MaxKDFMemory + 1, Threads: 4}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.params.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if got := DefaultKDFParams.String(); got != "argon2id time=1 memory=64MiB threads=4" {
+		t.Errorf("String() = %q", got)
+	}
+}
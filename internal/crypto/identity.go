@@ -0,0 +1,54 @@
+// identity.go finds the user's identities for stores encrypted to
+// recipients (see recipient.go).
+//
+// This file is used by:
+//   - store/store.go: to decrypt a store encrypted to recipients
+//   - cli/key.go: where "varnish key generate" writes by default
+//   - cli/store.go: to tell which recipient is you
+//
+// The identity file is VARNISH_IDENTITY if set, else ~/.varnish/identity.txt.
+// It holds one identity per line; blank lines and # comments are ignored.
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dk/varnish/internal/config"
+)
+
+// IdentityEnvVar names an identity file to use instead of the default.
+const IdentityEnvVar = "VARNISH_IDENTITY"
+
+// ErrIdentityRequired is returned when there is no identity file.
+var ErrIdentityRequired = errors.New("no identity file (run 'varnish key generate', or set VARNISH_IDENTITY)")
+
+// IdentityPath returns the identity file to use.
+func IdentityPath() (string, error) {
+	if path := os.Getenv(IdentityEnvVar); path != "" {
+		return path, nil
+	}
+	return config.IdentityPath()
+}
+
+// LoadIdentities reads the identity file. Returns ErrIdentityRequired if
+// it doesn't exist.
+func LoadIdentities() ([]*Identity, error) {
+	path, err := IdentityPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrIdentityRequired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read identity file: %w", err)
+	}
+	ids, err := ParseIdentities(data)
+	if err != nil {
+		return nil, fmt.Errorf("identity file %s: %w", path, err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,75 @@
+// key.go encrypts and decrypts with a derived key instead of a password,
+// for keys held by the unlock agent (see store/agent.go), so an unlocked
+// store is read and written without deriving a key each time.
+//
+// This file is used by:
+//   - store/agent.go: DecryptWithKey and EncryptWithKey with the agent's keys
+//   - cli/agent.go: UnlockKey for "varnish unlock"
+//
+// A key is identified by the salt and KDF parameters it was derived with.
+// Writing with a derived key keeps the file's salt, so the key stays valid
+// for the rewritten file; a new nonce is still used for every write.
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// KeyID names the key derived with salt and params.
+func KeyID(salt []byte, params KDFParams) string {
+	return fmt.Sprintf("%s-%d-%d-%d", hex.EncodeToString(salt), params.Time, params.Memory, params.Threads)
+}
+
+// DataKeyID returns the ID of the key that decrypts data, or false if data
+// isn't encrypted with a password.
+func DataKeyID(data []byte) (string, bool) {
+	h, err := ParseHeader(data)
+	if err != nil || h.Version == VersionRecipients {
+		return "", false
+	}
+	return KeyID(h.Salt, h.KDF), true
+}
+
+// DecryptWithKey decrypts data with key, derived as named by DataKeyID.
+// Returns ErrRecipientsEncrypted for data encrypted to recipients.
+func DecryptWithKey(data, key []byte) ([]byte, error) {
+	h, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Version == VersionRecipients {
+		return nil, ErrRecipientsEncrypted
+	}
+	return open(data, h, key)
+}
+
+// EncryptWithKey encrypts plaintext with key, which was derived with salt
+// and params, the header of the file being rewritten.
+func EncryptWithKey(plaintext []byte, params KDFParams, salt, key []byte) ([]byte, error) {
+	if len(salt) != saltSize {
+		return nil, fmt.Errorf("invalid salt length %d", len(salt))
+	}
+	return seal(plaintext, salt, params, key)
+}
+
+// UnlockKey derives the key for encrypted data from password and checks
+// that it decrypts the data. Returns the key and the ID to give the agent.
+// Data encrypted to recipients has no password key, see recipient.go.
+func UnlockKey(data []byte, password string) (string, []byte, error) {
+	if password == "" {
+		return "", nil, ErrPasswordRequired
+	}
+	h, err := ParseHeader(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if h.Version == VersionRecipients {
+		return "", nil, ErrRecipientsEncrypted
+	}
+	key := deriveKey(password, h.Salt, h.KDF)
+	if _, err := open(data, h, key); err != nil {
+		return "", nil, err
+	}
+	return KeyID(h.Salt, h.KDF), key, nil
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestUnlockKey(t *testing.T) {
+	data, err := Encrypt([]byte("secret"), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := UnlockKey(data, "wrong"); err == nil {
+		t.Error("UnlockKey() with the wrong password should fail")
+	}
+	if _, _, err := UnlockKey(data, ""); err != ErrPasswordRequired {
+		t.Errorf("UnlockKey() with no password error = %v, want ErrPasswordRequired", err)
+	}
+
+	id, key, err := UnlockKey(data, "password")
+	if err != nil {
+		t.Fatalf("UnlockKey() error: %v", err)
+	}
+	if want, ok := DataKeyID(data); !ok || id != want {
+		t.Errorf("id = %q, want the file's salt and params %q", id, want)
+	}
+	if len(key) != argonKeyLen {
+		t.Errorf("key is %d bytes, want %d", len(key), argonKeyLen)
+	}
+}
+
+func TestWithKey(t *testing.T) {
+	data, err := Encrypt([]byte("secret"), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := ParseHeader(data)
+	_, key, err := UnlockKey(data, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := DecryptWithKey(data, key)
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("DecryptWithKey() = %q, %v", plain, err)
+	}
+
+	// Rewriting keeps the salt, so the password and the key both work
+	out, err := EncryptWithKey([]byte("new"), h.KDF, h.Salt, key)
+	if err != nil {
+		t.Fatalf("EncryptWithKey() error: %v", err)
+	}
+	if plain, err := Decrypt(out, "password"); err != nil || string(plain) != "new" {
+		t.Errorf("Decrypt() of EncryptWithKey output = %q, %v", plain, err)
+	}
+	if id, _ := DataKeyID(out); id != KeyID(h.Salt, h.KDF) {
+		t.Errorf("rewritten file has key ID %q, want %q", id, KeyID(h.Salt, h.KDF))
+	}
+
+	// Another file has another salt, so the key doesn't apply
+	other, _ := Encrypt([]byte("other"), "password")
+	if _, err := DecryptWithKey(other, key); err == nil {
+		t.Error("DecryptWithKey() of a file with another salt should fail")
+	}
+	if _, ok := DataKeyID([]byte("plain")); ok {
+		t.Error("DataKeyID() of plaintext should report false")
+	}
+}
@@ -0,0 +1,373 @@
+// password.go finds the store password.
+//
+// This file is used by:
+//   - store/store.go: to encrypt and decrypt the store and its history
+//   - cli/root.go: sets the --password-file and --password-fd sources
+//   - cli/init.go, cli/store.go: SetPassword for the deprecated --password
+//   - cli/init.go, cli/store.go: NewPassword when enabling encryption
+//   - cli/store.go: ReplacementPassword for "store rekey"
+//   - cli/agent.go: ProjectPassword for "unlock --project"
+//   - cli/hook.go: SetPrompt so the shell hook never blocks the prompt
+//   - store/segment.go: ProjectPassword for encrypted projects
+//
+// Sources, in order of precedence:
+//
+//  1. --password <password>    deprecated: visible in shell history and ps
+//  2. --password-file <path>   first line of the file
+//  3. --password-fd <n>        first line read from an open file descriptor
+//  4. VARNISH_PASSWORD         the password itself
+//  5. VARNISH_PASSWORD_COMMAND a command whose first line of output is the
+//     password, e.g. "pass show varnish"
+//  6. an interactive prompt, if stdin is a terminal (input is not echoed)
+//     and prompts aren't turned off with SetPrompt
+//
+// Passwords from a descriptor, a command or a prompt are remembered for the
+// rest of the process, so a command that reads and writes the store asks
+// only once.
+//
+// A project encrypted with its own password (see store/segment.go) has its
+// own sources, so one password never opens another project:
+//
+//  1. --password with --project         deprecated, as above
+//  2. VARNISH_PASSWORD_<PROJECT>         e.g. VARNISH_PASSWORD_MYAPP
+//  3. VARNISH_PROJECT_PASSWORD_COMMAND   run with VARNISH_PROJECT=<project>
+//  4. an interactive prompt naming the project, likewise
+//
+// A --password value is kept in memory rather than in the environment, so
+// password commands and other programs varnish runs don't inherit it.
+package crypto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// PasswordCommandEnvVar names a command that prints the password.
+const PasswordCommandEnvVar = "VARNISH_PASSWORD_COMMAND"
+
+// ProjectPasswordCommandEnvVar names a command that prints the password of
+// an encrypted project, given in ProjectEnvVar.
+const ProjectPasswordCommandEnvVar = "VARNISH_PROJECT_PASSWORD_COMMAND"
+
+// ProjectEnvVar tells the project password command which project it is for.
+const ProjectEnvVar = "VARNISH_PROJECT"
+
+// NewPasswordEnvVar holds the new password for "store rekey".
+const NewPasswordEnvVar = "VARNISH_NEW_PASSWORD"
+
+// ErrProjectPasswordRequired is returned when no source has the password of
+// an encrypted project.
+var ErrProjectPasswordRequired = errors.New("no project password (set VARNISH_PASSWORD_<PROJECT> or VARNISH_PROJECT_PASSWORD_COMMAND, or run in a terminal)")
+
+// ErrNewPasswordRequired is returned when changing the password and no
+// source for the new one is available.
+var ErrNewPasswordRequired = errors.New("no new password (use --new-password-file or VARNISH_NEW_PASSWORD, or run in a terminal)")
+
+var (
+	mu           sync.Mutex
+	passwordFile string            // --password-file
+	passwordFD   = -1              // --password-fd
+	cached       map[string]string // source -> password, for one-shot sources
+	given        map[string]string // --password, by project ("" for the store)
+	noPrompt     bool              // SetPrompt(false)
+
+	// Replaced in tests
+	isTerminal             = func() bool { return term.IsTerminal(int(os.Stdin.Fd())) }
+	readPassword           = func() ([]byte, error) { return term.ReadPassword(int(os.Stdin.Fd())) }
+	promptOutput io.Writer = os.Stderr
+)
+
+// SetPasswordSources sets the password file and descriptor given on the
+// command line (empty and -1 for none) and forgets remembered passwords.
+func SetPasswordSources(file string, fd int) {
+	mu.Lock()
+	defer mu.Unlock()
+	passwordFile = file
+	passwordFD = fd
+	cached = nil
+	given = nil
+}
+
+// SetPrompt turns interactive password prompts on or off. With prompts
+// off, a password no other source has is ErrPasswordRequired (or
+// ErrProjectPasswordRequired), even in a terminal.
+func SetPrompt(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	noPrompt = !enabled
+}
+
+// canPrompt reports whether a password may be asked for. The caller holds
+// mu.
+func canPrompt() bool {
+	return !noPrompt && isTerminal()
+}
+
+// SetPassword sets the store password given with the deprecated --password
+// flag. It wins over every other source.
+func SetPassword(password string) {
+	SetProjectPassword("", password)
+}
+
+// SetProjectPassword is SetPassword for an encrypted project.
+func SetProjectPassword(project, password string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if given == nil {
+		given = make(map[string]string)
+	}
+	given[project] = password
+}
+
+// GetPassword returns the store password from the first source that has
+// one (see the list above). Returns ErrPasswordRequired if none does.
+func GetPassword() (string, error) {
+	return getPassword(false)
+}
+
+// NewPassword is GetPassword for setting a password: an interactive prompt
+// asks twice to catch typos.
+func NewPassword() (string, error) {
+	return getPassword(true)
+}
+
+func getPassword(confirm bool) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch {
+	case given[""] != "":
+		return given[""], nil
+	case passwordFile != "":
+		return remember("file", func() (string, error) {
+			return readPasswordFile(passwordFile)
+		})
+	case passwordFD >= 0:
+		return remember("fd", func() (string, error) {
+			f := os.NewFile(uintptr(passwordFD), fmt.Sprintf("fd %d", passwordFD))
+			if f == nil {
+				return "", fmt.Errorf("invalid password file descriptor %d", passwordFD)
+			}
+			defer f.Close()
+			return firstLine(f, fmt.Sprintf("password fd %d", passwordFD))
+		})
+	case os.Getenv(PasswordEnvVar) != "":
+		return os.Getenv(PasswordEnvVar), nil
+	case os.Getenv(PasswordCommandEnvVar) != "":
+		return remember("command", func() (string, error) {
+			return runPasswordCommand(PasswordCommandEnvVar)
+		})
+	case canPrompt():
+		return remember("prompt", func() (string, error) {
+			return prompt(confirm)
+		})
+	}
+	return "", ErrPasswordRequired
+}
+
+// ReplacementPassword returns the new password when changing it: the first
+// line of file if given, else VARNISH_NEW_PASSWORD, else a prompt that asks
+// twice. The sources of the current password are not consulted.
+func ReplacementPassword(file string) (string, error) {
+	return replacementPassword(file, func() (string, error) {
+		return prompt(true)
+	})
+}
+
+// ReplacementProjectPassword is ReplacementPassword for an encrypted
+// project: the prompt names the project.
+func ReplacementProjectPassword(project, file string) (string, error) {
+	return replacementPassword(file, func() (string, error) {
+		return promptProject(project, true)
+	})
+}
+
+func replacementPassword(file string, ask func() (string, error)) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch {
+	case file != "":
+		return readPasswordFile(file)
+	case os.Getenv(NewPasswordEnvVar) != "":
+		return os.Getenv(NewPasswordEnvVar), nil
+	case canPrompt():
+		return ask()
+	}
+	return "", ErrNewPasswordRequired
+}
+
+// ProjectPasswordEnvVar returns the environment variable holding the
+// password of an encrypted project: VARNISH_PASSWORD_ and the project name
+// in upper case, with characters other than letters and digits as _.
+func ProjectPasswordEnvVar(project string) string {
+	var b strings.Builder
+	b.WriteString(PasswordEnvVar + "_")
+	for _, r := range strings.ToUpper(project) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// ProjectPassword returns the password of an encrypted project from the
+// first of its sources that has one (see the list above). The store
+// password's sources are not consulted. Returns ErrPasswordRequired if
+// none has one.
+func ProjectPassword(project string) (string, error) {
+	return projectPassword(project, false)
+}
+
+// NewProjectPassword is ProjectPassword for encrypting a project: an
+// interactive prompt asks twice to catch typos.
+func NewProjectPassword(project string) (string, error) {
+	return projectPassword(project, true)
+}
+
+func projectPassword(project string, confirm bool) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	envVar := ProjectPasswordEnvVar(project)
+	switch {
+	case project != "" && given[project] != "":
+		return given[project], nil
+	// A project called "command" doesn't get VARNISH_PASSWORD_COMMAND
+	case envVar != PasswordCommandEnvVar && os.Getenv(envVar) != "":
+		return os.Getenv(envVar), nil
+	case os.Getenv(ProjectPasswordCommandEnvVar) != "":
+		return remember("command:"+project, func() (string, error) {
+			return runPasswordCommand(ProjectPasswordCommandEnvVar, ProjectEnvVar+"="+project)
+		})
+	case canPrompt():
+		return remember("prompt:"+project, func() (string, error) {
+			return promptProject(project, confirm)
+		})
+	}
+	return "", fmt.Errorf("project %s: %w", project, ErrProjectPasswordRequired)
+}
+
+// remember returns the password cached for source, or gets and caches it.
+// The caller holds mu.
+func remember(source string, get func() (string, error)) (string, error) {
+	if p, ok := cached[source]; ok {
+		return p, nil
+	}
+	p, err := get()
+	if err != nil {
+		return "", err
+	}
+	if cached == nil {
+		cached = make(map[string]string)
+	}
+	cached[source] = p
+	return p, nil
+}
+
+// readPasswordFile returns the first line of the file at path.
+func readPasswordFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read password file: %w", err)
+	}
+	defer f.Close()
+	return firstLine(f, "password file "+path)
+}
+
+// firstLine reads the first line of r as a password.
+func firstLine(r io.Reader, what string) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("read %s: %w", what, err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("%s is empty", what)
+	}
+	return line, nil
+}
+
+// runPasswordCommand runs the command in the environment variable name
+// through the shell, with env added to its environment. Its stdin and
+// stderr are the user's, so it can prompt (e.g. for a GPG passphrase).
+func runPasswordCommand(name string, env ...string) (string, error) {
+	command := os.Getenv(name)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", name, err)
+	}
+	return firstLine(strings.NewReader(string(out)), "output of "+name)
+}
+
+// prompt asks for the password on the terminal without echoing it.
+func prompt(confirm bool) (string, error) {
+	label := "Store password: "
+	if confirm {
+		label = "New store password: "
+	}
+	return promptLabel(label, confirm)
+}
+
+// promptProject asks for the password of an encrypted project.
+func promptProject(project string, confirm bool) (string, error) {
+	label := fmt.Sprintf("Password for project %s: ", project)
+	if confirm {
+		label = fmt.Sprintf("New password for project %s: ", project)
+	}
+	return promptLabel(label, confirm)
+}
+
+// promptLabel asks with label, and a second time if confirm is set.
+func promptLabel(label string, confirm bool) (string, error) {
+	p, err := promptOnce(label)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := promptOnce("Confirm password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return p, nil
+}
+
+func promptOnce(label string) (string, error) {
+	fmt.Fprint(promptOutput, label)
+	b, err := readPassword()
+	fmt.Fprintln(promptOutput)
+	if err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	if len(b) == 0 {
+		return "", errors.New("empty password")
+	}
+	return string(b), nil
+}
@@ -0,0 +1,325 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// resetSources clears the password sources and environment, and fakes a
+// terminal that answers with replies (or no terminal if replies is nil).
+func resetSources(t *testing.T, replies []string) *bytes.Buffer {
+	t.Helper()
+	unsetenv(t, PasswordEnvVar)
+	unsetenv(t, PasswordCommandEnvVar)
+	unsetenv(t, ProjectPasswordCommandEnvVar)
+	SetPasswordSources("", -1)
+
+	origTerminal, origRead, origOut := isTerminal, readPassword, promptOutput
+	var out bytes.Buffer
+	isTerminal = func() bool { return replies != nil }
+	readPassword = func() ([]byte, error) {
+		if len(replies) == 0 {
+			return nil, errors.New("no more input")
+		}
+		r := replies[0]
+		replies = replies[1:]
+		return []byte(r), nil
+	}
+	promptOutput = &out
+
+	t.Cleanup(func() {
+		isTerminal, readPassword, promptOutput = origTerminal, origRead, origOut
+		SetPasswordSources("", -1)
+	})
+	return &out
+}
+
+func TestGetPasswordNoSource(t *testing.T) {
+	resetSources(t, nil)
+
+	if _, err := GetPassword(); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("GetPassword() error = %v, want ErrPasswordRequired", err)
+	}
+}
+
+func TestGetPasswordFile(t *testing.T) {
+	resetSources(t, nil)
+	t.Setenv(PasswordEnvVar, "from-env")
+
+	path := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(path, []byte("from-file\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	SetPasswordSources(path, -1)
+
+	got, err := GetPassword()
+	if err != nil {
+		t.Fatalf("GetPassword() error = %v", err)
+	}
+	if got != "from-file" {
+		t.Errorf("GetPassword() = %q, want %q (file beats env)", got, "from-file")
+	}
+}
+
+func TestGetPasswordFileErrors(t *testing.T) {
+	resetSources(t, nil)
+	dir := t.TempDir()
+
+	SetPasswordSources(filepath.Join(dir, "missing"), -1)
+	if _, err := GetPassword(); err == nil {
+		t.Error("expected error for missing password file")
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	SetPasswordSources(empty, -1)
+	if _, err := GetPassword(); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("expected empty password error, got %v", err)
+	}
+}
+
+func TestGetPasswordFD(t *testing.T) {
+	resetSources(t, nil)
+	t.Setenv(PasswordEnvVar, "from-env")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("from-fd\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	SetPasswordSources("", int(r.Fd()))
+
+	// The descriptor can be read only once; the password is remembered
+	for i := 0; i < 2; i++ {
+		got, err := GetPassword()
+		if err != nil {
+			t.Fatalf("GetPassword() error = %v", err)
+		}
+		if got != "from-fd" {
+			t.Errorf("GetPassword() = %q, want %q", got, "from-fd")
+		}
+	}
+}
+
+func TestGetPasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	resetSources(t, []string{"from-prompt"})
+
+	t.Setenv(PasswordCommandEnvVar, "printf 'from-command\\nsecond line\\n'")
+	got, err := GetPassword()
+	if err != nil {
+		t.Fatalf("GetPassword() error = %v", err)
+	}
+	if got != "from-command" {
+		t.Errorf("GetPassword() = %q, want %q (command beats prompt)", got, "from-command")
+	}
+
+	// The environment variable beats the command
+	t.Setenv(PasswordEnvVar, "from-env")
+	if got, _ := GetPassword(); got != "from-env" {
+		t.Errorf("GetPassword() = %q, want %q", got, "from-env")
+	}
+}
+
+func TestGetPasswordCommandFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	resetSources(t, nil)
+
+	t.Setenv(PasswordCommandEnvVar, "exit 3")
+	_, err := GetPassword()
+	if err == nil || !strings.Contains(err.Error(), PasswordCommandEnvVar) {
+		t.Errorf("expected command failure, got %v", err)
+	}
+
+	t.Setenv(PasswordCommandEnvVar, "true")
+	if _, err := GetPassword(); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("expected empty output error, got %v", err)
+	}
+}
+
+func TestGetPasswordPrompt(t *testing.T) {
+	out := resetSources(t, []string{"typed"})
+
+	// Asked once, then remembered
+	for i := 0; i < 2; i++ {
+		got, err := GetPassword()
+		if err != nil {
+			t.Fatalf("GetPassword() error = %v", err)
+		}
+		if got != "typed" {
+			t.Errorf("GetPassword() = %q, want %q", got, "typed")
+		}
+	}
+	if n := strings.Count(out.String(), "Store password: "); n != 1 {
+		t.Errorf("prompted %d times, want 1: %q", n, out.String())
+	}
+}
+
+func TestNewPasswordConfirms(t *testing.T) {
+	out := resetSources(t, []string{"first", "first"})
+	got, err := NewPassword()
+	if err != nil {
+		t.Fatalf("NewPassword() error = %v", err)
+	}
+	if got != "first" {
+		t.Errorf("NewPassword() = %q, want %q", got, "first")
+	}
+	if !strings.Contains(out.String(), "Confirm password: ") {
+		t.Errorf("expected confirmation prompt, got %q", out.String())
+	}
+
+	resetSources(t, []string{"first", "typo"})
+	if _, err := NewPassword(); err == nil || !strings.Contains(err.Error(), "do not match") {
+		t.Errorf("expected mismatch error, got %v", err)
+	}
+
+	resetSources(t, []string{""})
+	if _, err := NewPassword(); err == nil {
+		t.Error("expected error for empty password")
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	resetSources(t, nil)
+	unsetenv(t, "VARNISH_PASSWORD_MYAPP")
+	t.Setenv(PasswordEnvVar, "from-env")
+
+	SetPassword("from-flag")
+	if got, _ := GetPassword(); got != "from-flag" {
+		t.Errorf("GetPassword() = %q, want %q", got, "from-flag")
+	}
+	if got := os.Getenv(PasswordEnvVar); got != "from-env" {
+		t.Errorf("SetPassword changed %s to %q", PasswordEnvVar, got)
+	}
+	if _, err := ProjectPassword("myapp"); !errors.Is(err, ErrProjectPasswordRequired) {
+		t.Errorf("the store password should not open a project, got %v", err)
+	}
+
+	SetProjectPassword("myapp", "myapp-flag")
+	if got, _ := ProjectPassword("myapp"); got != "myapp-flag" {
+		t.Errorf("ProjectPassword() = %q, want %q", got, "myapp-flag")
+	}
+	if os.Getenv("VARNISH_PASSWORD_MYAPP") != "" {
+		t.Error("SetProjectPassword set VARNISH_PASSWORD_MYAPP")
+	}
+
+	// New sources forget it
+	SetPasswordSources("", -1)
+	if got, _ := GetPassword(); got != "from-env" {
+		t.Errorf("GetPassword() after SetPasswordSources = %q, want %q", got, "from-env")
+	}
+}
+
+func TestProjectPasswordEnvVar(t *testing.T) {
+	tests := map[string]string{
+		"myapp":      "VARNISH_PASSWORD_MYAPP",
+		"my-app.web": "VARNISH_PASSWORD_MY_APP_WEB",
+		"api2":       "VARNISH_PASSWORD_API2",
+	}
+	for project, want := range tests {
+		if got := ProjectPasswordEnvVar(project); got != want {
+			t.Errorf("ProjectPasswordEnvVar(%q) = %q, want %q", project, got, want)
+		}
+	}
+}
+
+func TestProjectPasswordSources(t *testing.T) {
+	resetSources(t, nil)
+	unsetenv(t, "VARNISH_PASSWORD_MYAPP")
+	t.Setenv(PasswordEnvVar, "store-password")
+
+	// The store password is not a project password
+	if _, err := ProjectPassword("myapp"); !errors.Is(err, ErrProjectPasswordRequired) {
+		t.Errorf("ProjectPassword() error = %v, want ErrProjectPasswordRequired", err)
+	}
+
+	t.Setenv("VARNISH_PASSWORD_MYAPP", "myapp-password")
+	if got, _ := ProjectPassword("myapp"); got != "myapp-password" {
+		t.Errorf("ProjectPassword() = %q, want %q", got, "myapp-password")
+	}
+	if _, err := ProjectPassword("other"); err == nil {
+		t.Error("another project's password should not be used")
+	}
+}
+
+func TestProjectPasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	resetSources(t, nil)
+	t.Setenv(PasswordCommandEnvVar, "echo store-password")
+	t.Setenv(ProjectPasswordCommandEnvVar, "echo pw-$"+ProjectEnvVar)
+
+	for _, project := range []string{"myapp", "api"} {
+		got, err := ProjectPassword(project)
+		if err != nil {
+			t.Fatalf("ProjectPassword(%q) error = %v", project, err)
+		}
+		if want := "pw-" + project; got != want {
+			t.Errorf("ProjectPassword(%q) = %q, want %q", project, got, want)
+		}
+	}
+
+	// A project called "command" doesn't read the store's password command
+	if got, _ := ProjectPassword("command"); got != "pw-command" {
+		t.Errorf("ProjectPassword(command) = %q, want %q", got, "pw-command")
+	}
+}
+
+func TestProjectPasswordPrompt(t *testing.T) {
+	out := resetSources(t, []string{"new", "new"})
+
+	got, err := NewProjectPassword("myapp")
+	if err != nil {
+		t.Fatalf("NewProjectPassword() error = %v", err)
+	}
+	if got != "new" {
+		t.Errorf("NewProjectPassword() = %q, want %q", got, "new")
+	}
+	if !strings.Contains(out.String(), "New password for project myapp: ") {
+		t.Errorf("prompt should name the project, got %q", out.String())
+	}
+
+	// Remembered for the project, not for others
+	if got, _ := ProjectPassword("myapp"); got != "new" {
+		t.Errorf("ProjectPassword() = %q, want the remembered %q", got, "new")
+	}
+	if _, err := ProjectPassword("api"); err == nil {
+		t.Error("expected a prompt (and no more input) for another project")
+	}
+}
+
+func TestSetPrompt(t *testing.T) {
+	out := resetSources(t, []string{"from-prompt"})
+	SetPrompt(false)
+	t.Cleanup(func() { SetPrompt(true) })
+
+	if _, err := GetPassword(); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("GetPassword() error = %v, want ErrPasswordRequired", err)
+	}
+	if _, err := ProjectPassword("myapp"); !errors.Is(err, ErrProjectPasswordRequired) {
+		t.Errorf("ProjectPassword() error = %v, want ErrProjectPasswordRequired", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("prompted with prompts off: %q", out.String())
+	}
+
+	SetPrompt(true)
+	if got, err := GetPassword(); err != nil || got != "from-prompt" {
+		t.Errorf("GetPassword() = %q, %v; want the prompt's answer", got, err)
+	}
+}
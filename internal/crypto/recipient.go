@@ -0,0 +1,315 @@
+// recipient.go encrypts files to X25519 public keys instead of a password,
+// so a store can be shared without sharing a secret.
+//
+// This file is used by:
+//   - store/store.go: reads and writes stores encrypted to recipients
+//   - store/recipients.go: changes the recipients of the store
+//   - cli/key.go: "varnish key generate"
+//
+// Each file gets a new random data key, which encrypts the content with
+// AES-256-GCM. The data key is wrapped once per recipient, in the style of
+// age: an ephemeral X25519 key agreement with the recipient's public key,
+// HKDF-SHA256 to a wrapping key, and AES-256-GCM. Anyone holding the
+// identity (private key) of one recipient can unwrap the data key.
+//
+//	v3: Magic (8B) | Version (1B) | Count (1B) |
+//	    Count x [ Recipient (32B) | Ephemeral (32B) | Wrapped key (48B) ] |
+//	    Nonce (12B) | Ciphertext+Tag
+//
+// As in version 2 the whole header is authenticated as additional data.
+// The recipients' public keys are in the clear, so the list can be shown
+// without an identity.
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// VersionRecipients is the format of files encrypted to recipients.
+	VersionRecipients = 3
+
+	// RecipientPrefix starts an encoded public key.
+	RecipientPrefix = "varnish1"
+
+	// IdentityPrefix starts an encoded identity.
+	IdentityPrefix = "VARNISH-SECRET-KEY-1"
+
+	// MaxRecipients is the most recipients a file can have.
+	MaxRecipients = 255
+
+	x25519KeySize = 32
+	wrappedSize   = argonKeyLen + 16 // data key + GCM tag
+	stanzaSize    = 2*x25519KeySize + wrappedSize
+	wrapInfo      = "varnish-x25519-v1"
+)
+
+// ErrNoIdentityMatch is returned when none of the identities is a
+// recipient of the data.
+var ErrNoIdentityMatch = errors.New("none of your identities is a recipient of this file")
+
+// ErrRecipientsEncrypted is returned when a password is used on data that
+// is encrypted to recipients.
+var ErrRecipientsEncrypted = errors.New("encrypted to recipients, not a password (needs an identity)")
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Recipient is an X25519 public key data can be encrypted to.
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// ParseRecipient parses a public key as printed by String.
+func ParseRecipient(s string) (Recipient, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, RecipientPrefix) {
+		return Recipient{}, fmt.Errorf("invalid recipient %q: must start with %s", s, RecipientPrefix)
+	}
+	raw, err := keyEncoding.DecodeString(strings.ToUpper(s[len(RecipientPrefix):]))
+	if err != nil || len(raw) != x25519KeySize {
+		return Recipient{}, fmt.Errorf("invalid recipient %q", s)
+	}
+	return recipientFromBytes(raw)
+}
+
+func recipientFromBytes(raw []byte) (Recipient, error) {
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return Recipient{}, fmt.Errorf("invalid recipient key: %w", err)
+	}
+	return Recipient{key: key}, nil
+}
+
+// String encodes the public key, e.g. "varnish1" followed by 52 characters.
+func (r Recipient) String() string {
+	return RecipientPrefix + strings.ToLower(keyEncoding.EncodeToString(r.key.Bytes()))
+}
+
+// Equal reports whether r and other are the same key.
+func (r Recipient) Equal(other Recipient) bool {
+	return r.key != nil && other.key != nil && r.key.Equal(other.key)
+}
+
+// Identity is an X25519 private key that decrypts data for its Recipient.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity creates a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseIdentity parses an identity as printed by String.
+func ParseIdentity(s string) (*Identity, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, IdentityPrefix) {
+		return nil, fmt.Errorf("invalid identity: must start with %s", IdentityPrefix)
+	}
+	raw, err := keyEncoding.DecodeString(s[len(IdentityPrefix):])
+	if err != nil || len(raw) != x25519KeySize {
+		return nil, errors.New("invalid identity")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// String encodes the private key. Keep it secret.
+func (id *Identity) String() string {
+	return IdentityPrefix + keyEncoding.EncodeToString(id.key.Bytes())
+}
+
+// Recipient returns the public key for id.
+func (id *Identity) Recipient() Recipient {
+	return Recipient{key: id.key.PublicKey()}
+}
+
+// stanza is the data key wrapped for one recipient.
+type stanza struct {
+	recipient Recipient
+	ephemeral []byte
+	wrapped   []byte
+}
+
+// wrapKey derives the key that wraps the data key for one recipient.
+func wrapKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte(nil), ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, wrapInfo, argonKeyLen)
+}
+
+// EncryptToRecipients encrypts plaintext so that any of recipients can
+// decrypt it. Returns data in the version 3 format.
+func EncryptToRecipients(plaintext []byte, recipients []Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	if len(recipients) > MaxRecipients {
+		return nil, fmt.Errorf("too many recipients (max %d)", MaxRecipients)
+	}
+
+	dataKey := make([]byte, argonKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	defer clear(dataKey)
+
+	header := make([]byte, 0, len(MagicBytes)+2+len(recipients)*stanzaSize+nonceSize)
+	header = append(header, MagicBytes...)
+	header = append(header, VersionRecipients, byte(len(recipients)))
+	for _, r := range recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate ephemeral key: %w", err)
+		}
+		shared, err := ephemeral.ECDH(r.key)
+		if err != nil {
+			return nil, fmt.Errorf("key agreement: %w", err)
+		}
+		key, err := wrapKey(shared, ephemeral.PublicKey().Bytes(), r.key.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		// Each wrapping key is used once, so a zero nonce is safe
+		header = append(header, r.key.Bytes()...)
+		header = append(header, ephemeral.PublicKey().Bytes()...)
+		header = gcm.Seal(header, make([]byte, nonceSize), dataKey, nil)
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(header, nonce, plaintext, header), nil
+}
+
+// parseRecipientsHeader reads the rest of a version 3 header into h.
+func parseRecipientsHeader(data []byte, h *Header) (*Header, error) {
+	offset := len(MagicBytes) + 1
+	if len(data) < offset+1 {
+		return nil, errors.New("encrypted data too short")
+	}
+	count := int(data[offset])
+	offset++
+	if count == 0 {
+		return nil, errors.New("invalid encryption header: no recipients")
+	}
+	if len(data) < offset+count*stanzaSize+nonceSize+16 {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	for i := 0; i < count; i++ {
+		r, err := recipientFromBytes(data[offset : offset+x25519KeySize])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption header: %w", err)
+		}
+		h.stanzas = append(h.stanzas, stanza{
+			recipient: r,
+			ephemeral: data[offset+x25519KeySize : offset+2*x25519KeySize],
+			wrapped:   data[offset+2*x25519KeySize : offset+stanzaSize],
+		})
+		h.Recipients = append(h.Recipients, r)
+		offset += stanzaSize
+	}
+	h.nonce = data[offset : offset+nonceSize]
+	h.size = offset + nonceSize
+	return h, nil
+}
+
+// DecryptWithIdentities decrypts data encrypted with EncryptToRecipients,
+// using whichever of identities is a recipient.
+func DecryptWithIdentities(data []byte, identities []*Identity) ([]byte, error) {
+	h, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Version != VersionRecipients {
+		return nil, errors.New("not encrypted to recipients")
+	}
+
+	for _, id := range identities {
+		for _, st := range h.stanzas {
+			if !st.recipient.Equal(id.Recipient()) {
+				continue
+			}
+			dataKey, err := unwrap(id, st)
+			if err != nil {
+				return nil, err
+			}
+			plaintext, err := open(data, h, dataKey)
+			clear(dataKey)
+			return plaintext, err
+		}
+	}
+	return nil, ErrNoIdentityMatch
+}
+
+// unwrap recovers the data key from the stanza for id.
+func unwrap(id *Identity, st stanza) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(st.ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption header: %w", err)
+	}
+	shared, err := id.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement: %w", err)
+	}
+	key, err := wrapKey(shared, st.ephemeral, st.recipient.key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := gcm.Open(nil, make([]byte, nonceSize), st.wrapped, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// ParseIdentities reads identities from the content of an identity file:
+// one per line, ignoring blank lines and lines starting with #.
+func ParseIdentities(data []byte) ([]*Identity, error) {
+	var ids []*Identity
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		id, err := ParseIdentity(string(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no identities found")
+	}
+	return ids, nil
+}
@@ -0,0 +1,212 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newIdentity generates an identity, failing the test if it can't.
+func newIdentity(t *testing.T) *Identity {
+	t.Helper()
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestIdentityRoundTrip(t *testing.T) {
+	id := newIdentity(t)
+
+	encoded := id.String()
+	if !strings.HasPrefix(encoded, IdentityPrefix) {
+		t.Errorf("identity %q should start with %s", encoded, IdentityPrefix)
+	}
+	parsed, err := ParseIdentity(encoded)
+	if err != nil {
+		t.Fatalf("ParseIdentity() error: %v", err)
+	}
+	if !parsed.Recipient().Equal(id.Recipient()) {
+		t.Error("parsed identity has a different public key")
+	}
+
+	pub := id.Recipient().String()
+	if !strings.HasPrefix(pub, RecipientPrefix) || pub != strings.ToLower(pub) {
+		t.Errorf("public key %q should be lowercase and start with %s", pub, RecipientPrefix)
+	}
+	r, err := ParseRecipient(pub)
+	if err != nil {
+		t.Fatalf("ParseRecipient() error: %v", err)
+	}
+	if !r.Equal(id.Recipient()) {
+		t.Error("parsed recipient differs")
+	}
+	if r.Equal(newIdentity(t).Recipient()) {
+		t.Error("different keys should not be equal")
+	}
+}
+
+func TestParseRecipientInvalid(t *testing.T) {
+	pub := newIdentity(t).Recipient().String()
+
+	for _, s := range []string{
+		"",
+		"age1qqqq",
+		RecipientPrefix,
+		pub[:len(pub)-1],
+		pub + "a",
+		RecipientPrefix + "!!!!",
+	} {
+		if _, err := ParseRecipient(s); err == nil {
+			t.Errorf("ParseRecipient(%q) should fail", s)
+		}
+	}
+	if _, err := ParseIdentity(pub); err == nil {
+		t.Error("ParseIdentity() of a public key should fail")
+	}
+}
+
+func TestEncryptToRecipients(t *testing.T) {
+	alice, bob, eve := newIdentity(t), newIdentity(t), newIdentity(t)
+	plaintext := []byte("database.password: secret123\n")
+
+	data, err := EncryptToRecipients(plaintext, []Recipient{alice.Recipient(), bob.Recipient()})
+	if err != nil {
+		t.Fatalf("EncryptToRecipients() error: %v", err)
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, plaintext) {
+		t.Fatal("output should be encrypted")
+	}
+
+	for name, id := range map[string]*Identity{"alice": alice, "bob": bob} {
+		got, err := DecryptWithIdentities(data, []*Identity{id})
+		if err != nil {
+			t.Errorf("%s: DecryptWithIdentities() error: %v", name, err)
+		} else if !bytes.Equal(got, plaintext) {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+
+	// Any matching identity in the list will do
+	if _, err := DecryptWithIdentities(data, []*Identity{eve, bob}); err != nil {
+		t.Errorf("DecryptWithIdentities() with a matching second identity error: %v", err)
+	}
+	if _, err := DecryptWithIdentities(data, []*Identity{eve}); !errors.Is(err, ErrNoIdentityMatch) {
+		t.Errorf("DecryptWithIdentities() as a non-recipient error = %v, want ErrNoIdentityMatch", err)
+	}
+	if _, err := Decrypt(data, "password"); !errors.Is(err, ErrRecipientsEncrypted) {
+		t.Errorf("Decrypt() with a password error = %v, want ErrRecipientsEncrypted", err)
+	}
+
+	h, err := ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader() error: %v", err)
+	}
+	if h.Version != VersionRecipients || len(h.Recipients) != 2 ||
+		!h.Recipients[0].Equal(alice.Recipient()) || !h.Recipients[1].Equal(bob.Recipient()) {
+		t.Errorf("header = version %d, %d recipients", h.Version, len(h.Recipients))
+	}
+}
+
+func TestEncryptToRecipientsErrors(t *testing.T) {
+	if _, err := EncryptToRecipients([]byte("x"), nil); err == nil {
+		t.Error("EncryptToRecipients() without recipients should fail")
+	}
+	many := make([]Recipient, MaxRecipients+1)
+	for i := range many {
+		many[i] = newIdentity(t).Recipient()
+	}
+	if _, err := EncryptToRecipients([]byte("x"), many); err == nil {
+		t.Error("EncryptToRecipients() with too many recipients should fail")
+	}
+}
+
+func TestEncryptToRecipientsNewDataKey(t *testing.T) {
+	id := newIdentity(t)
+	recipients := []Recipient{id.Recipient()}
+
+	a, _ := EncryptToRecipients([]byte("same"), recipients)
+	b, _ := EncryptToRecipients([]byte("same"), recipients)
+	if bytes.Equal(a, b) {
+		t.Error("encrypting twice should produce different output")
+	}
+}
+
+func TestDecryptWithIdentitiesTampered(t *testing.T) {
+	alice, bob := newIdentity(t), newIdentity(t)
+	data, err := EncryptToRecipients([]byte("secret"), []Recipient{alice.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swapping in another recipient's public key is caught: bob's identity
+	// finds its stanza but can't unwrap the key
+	swapped := append([]byte(nil), data...)
+	copy(swapped[len(MagicBytes)+2:], bob.Recipient().key.Bytes())
+	if _, err := DecryptWithIdentities(swapped, []*Identity{bob}); err == nil {
+		t.Error("decrypting with a swapped recipient should fail")
+	}
+
+	// Any changed byte fails authentication
+	for _, i := range []int{len(MagicBytes) + 2 + 40, len(data) - 1} {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 0x01
+		if _, err := DecryptWithIdentities(tampered, []*Identity{alice}); err == nil {
+			t.Errorf("decrypting with byte %d changed should fail", i)
+		}
+	}
+
+	// Truncated
+	if _, err := DecryptWithIdentities(data[:len(MagicBytes)+10], []*Identity{alice}); err == nil {
+		t.Error("decrypting truncated data should fail")
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	a, b := newIdentity(t), newIdentity(t)
+	data := "# created: today\n# public key: " + a.Recipient().String() + "\n" +
+		a.String() + "\n\n  " + b.String() + "  \n"
+
+	ids, err := ParseIdentities([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseIdentities() error: %v", err)
+	}
+	if len(ids) != 2 || !ids[1].Recipient().Equal(b.Recipient()) {
+		t.Errorf("got %d identities", len(ids))
+	}
+
+	if _, err := ParseIdentities([]byte("# only a comment\n")); err == nil {
+		t.Error("ParseIdentities() without identities should fail")
+	}
+	if _, err := ParseIdentities([]byte(a.String() + "\nnot a key\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ParseIdentities() with a bad line error = %v, want line 2", err)
+	}
+}
+
+func TestLoadIdentities(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	unsetenv(t, IdentityEnvVar)
+
+	if _, err := LoadIdentities(); !errors.Is(err, ErrIdentityRequired) {
+		t.Errorf("LoadIdentities() without a file error = %v, want ErrIdentityRequired", err)
+	}
+
+	id := newIdentity(t)
+	path := filepath.Join(t.TempDir(), "team.txt")
+	if err := os.WriteFile(path, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(IdentityEnvVar, path)
+
+	ids, err := LoadIdentities()
+	if err != nil {
+		t.Fatalf("LoadIdentities() error: %v", err)
+	}
+	if len(ids) != 1 || !ids[0].Recipient().Equal(id.Recipient()) {
+		t.Error("LoadIdentities() should read the VARNISH_IDENTITY file")
+	}
+}
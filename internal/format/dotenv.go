@@ -0,0 +1,129 @@
+// dotenv.go writes the dotenv variants: KEY=value lines read by shells,
+// dotenv libraries and Docker, which differ in their quoting.
+//
+// This file is used by:
+//   - format/format.go: registers dotenv, dotenv-posix, dotenv-compose and
+//     docker
+//
+// Values that need quoting are written as:
+//
+//	dotenv          "double quotes", \ " $ ` escaped, newlines kept as is
+//	dotenv-posix    'single quotes', a quote written as '\''
+//	dotenv-compose  "double quotes", \ " $ escaped, newlines as \n
+//	docker          as they are: docker run --env-file takes everything
+//	                after the first = literally, quotes included
+package format
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// posixName matches names a POSIX shell accepts in an assignment.
+var posixName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// plainValue matches values every dotenv reader takes literally unquoted.
+var plainValue = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+func writeDotenv(w io.Writer, vars []Var, opts Options) error {
+	return writeAssignments(w, vars, opts, nil, quoteDotenv)
+}
+
+func writeDotenvPOSIX(w io.Writer, vars []Var, opts Options) error {
+	return writeAssignments(w, vars, opts, posixName, quotePOSIX)
+}
+
+func writeDotenvCompose(w io.Writer, vars []Var, opts Options) error {
+	return writeAssignments(w, vars, opts, posixName, quoteCompose)
+}
+
+func writeDocker(w io.Writer, vars []Var, opts Options) error {
+	for _, v := range vars {
+		if v.Name == "" || strings.ContainsAny(v.Name, " \t=") {
+			return fmt.Errorf("%q is not a valid variable name for docker --env-file", v.Name)
+		}
+		if strings.ContainsAny(v.Value, "\n\r") {
+			return fmt.Errorf("%s: docker --env-file cannot hold multi-line values (use dotenv-compose with env_file, or varnish compose)", v.Name)
+		}
+	}
+	return writeAssignments(w, vars, opts, nil, func(s string) string { return s })
+}
+
+// writeAssignments writes NAME=value lines with values quoted by quote.
+// Names not matching valid, when given, are an error.
+func writeAssignments(w io.Writer, vars []Var, opts Options, valid *regexp.Regexp, quote func(string) string) error {
+	if err := writeHeader(w, "#", opts); err != nil {
+		return err
+	}
+	for _, v := range vars {
+		if valid != nil && !valid.MatchString(v.Name) {
+			return fmt.Errorf("%q is not a valid variable name for this format", v.Name)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", v.Name, quote(v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteDotenv quotes a value if it contains spaces, quotes, or other
+// special characters, escaping what a shell would expand.
+func quoteDotenv(s string) string {
+	// If empty or contains special characters, quote it
+	needsQuotes := s == "" ||
+		strings.ContainsAny(s, " \t\n\r\"'$`\\#") ||
+		strings.HasPrefix(s, "=")
+
+	if !needsQuotes {
+		return s
+	}
+
+	// Use double quotes and escape internal double quotes and backslashes
+	escaped := strings.ReplaceAll(s, "\\", "\\\\")
+	escaped = strings.ReplaceAll(escaped, "\"", "\\\"")
+	escaped = strings.ReplaceAll(escaped, "$", "\\$")
+	escaped = strings.ReplaceAll(escaped, "`", "\\`")
+
+	return "\"" + escaped + "\""
+}
+
+// quotePOSIX wraps a value in single quotes unless it is plain. Inside
+// single quotes a shell expands nothing; a literal quote closes the
+// string, adds an escaped quote, then reopens.
+func quotePOSIX(s string) string {
+	if plainValue.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteCompose wraps a value in double quotes unless it is plain, the way
+// Docker Compose reads .env and env_file files: \ and " are escaped, $ is
+// escaped so it isn't interpolated, and line breaks become \n and \r.
+func quoteCompose(s string) string {
+	if plainValue.MatchString(s) {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '$':
+			sb.WriteString(`\$`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
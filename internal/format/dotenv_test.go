@@ -0,0 +1,123 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQuoteDotenv(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"simple", "simple"},
+		{"with space", "\"with space\""},
+		{"with'quote", "\"with'quote\""},
+		{"with\"doublequote", "\"with\\\"doublequote\""},
+		{"with$dollar", "\"with\\$dollar\""},
+		{"with`backtick", "\"with\\`backtick\""},
+		{"with\\backslash", "\"with\\\\backslash\""},
+		{"", "\"\""},
+		{"=startsWithEquals", "\"=startsWithEquals\""},
+		{"has\ttab", "\"has\ttab\""},
+		{"has\nnewline", "\"has\nnewline\""},
+		{"has#hash", "\"has#hash\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := quoteDotenv(tt.input)
+			if result != tt.expected {
+				t.Errorf("quoteDotenv(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestQuotePOSIX(t *testing.T) {
+	tests := map[string]string{
+		"simple":         "simple",
+		"a/b:c=d,e@f.g":  "a/b:c=d,e@f.g",
+		"":               "''",
+		"with space":     "'with space'",
+		"it's":           `'it'\''s'`,
+		"$HOME `id` \\n": "'$HOME `id` \\n'",
+		"two\nlines":     "'two\nlines'",
+	}
+	for in, want := range tests {
+		if got := quotePOSIX(in); got != want {
+			t.Errorf("quotePOSIX(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestQuoteCompose(t *testing.T) {
+	tests := map[string]string{
+		"simple":         "simple",
+		"":               `""`,
+		"with space":     `"with space"`,
+		`say "hi"`:       `"say \"hi\""`,
+		"$HOME":          `"\$HOME"`,
+		`back\slash`:     `"back\\slash"`,
+		"two\nlines\r":   `"two\nlines\r"`,
+		"it's # comment": `"it's # comment"`,
+	}
+	for in, want := range tests {
+		if got := quoteCompose(in); got != want {
+			t.Errorf("quoteCompose(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteDotenvInvalidName(t *testing.T) {
+	vars := []Var{{Name: "MY-VAR", Value: "x"}}
+	for _, name := range []string{"dotenv-posix", "dotenv-compose"} {
+		f, _ := Lookup(name)
+		if err := f.Write(&bytes.Buffer{}, vars, Options{}); err == nil {
+			t.Errorf("%s: expected an error for an invalid name", name)
+		}
+	}
+}
+
+func TestWriteDotenvPOSIX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeDotenvPOSIX(&buf, tricky, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `DB_HOST=localhost
+DB_PORT=5432
+EMPTY=''
+ENABLED=true
+MULTI='line one
+line two'
+QUOTES='it'\''s "quoted"'
+SHELL='$HOME ` + "`id`" + ` \n'
+UNICODE='café ☕'
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteDocker(t *testing.T) {
+	vars := []Var{
+		{Name: "GREETING", Value: `say "hi" # not a comment`},
+		{Name: "PRICE", Value: "$5 'each'"},
+		{Name: "EMPTY", Value: ""},
+	}
+	var buf bytes.Buffer
+	if err := writeDocker(&buf, vars, Options{Header: []string{"Generated by varnish"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Generated by varnish\n\nGREETING=say \"hi\" # not a comment\nPRICE=$5 'each'\nEMPTY=\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	if err := writeDocker(&bytes.Buffer{}, []Var{{Name: "MULTI", Value: "a\nb"}}, Options{}); err == nil {
+		t.Error("expected an error for a multi-line value")
+	}
+	if err := writeDocker(&bytes.Buffer{}, []Var{{Name: "MY VAR", Value: "x"}}, Options{}); err == nil {
+		t.Error("expected an error for a name with a space")
+	}
+}
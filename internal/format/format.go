@@ -0,0 +1,187 @@
+// Package format writes resolved variables in the file formats tools read
+// their configuration from.
+//
+// This file is used by:
+//   - cli/env.go: looks up the formatter for --format or --output
+//
+// Each format is a Formatter in the formatters registry below, with its
+// writer in its own file:
+//
+//	dotenv.go       dotenv, dotenv-posix, dotenv-compose, docker
+//	json.go         json
+//	yaml.go         yaml
+//	toml.go         toml
+//	properties.go   properties (Java)
+//	k8s.go          k8s-secret, k8s-configmap, kustomize
+//
+// Writers escape every value so that reading the file back yields it
+// byte for byte, including newlines and quotes. A format that cannot
+// represent a name or value returns an error instead of writing a file
+// that reads back differently.
+package format
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Default is the format used when neither --format nor a known --output
+// extension picks one.
+const Default = "dotenv"
+
+// Var is one variable to write.
+type Var struct {
+	Name      string
+	Value     string
+	Sensitive bool // Goes in a Secret rather than a ConfigMap
+}
+
+// Options control what a formatter writes besides the variables.
+type Options struct {
+	// Header lines are written as comments at the top of the file, in
+	// formats that have comments.
+	Header []string
+
+	// Name, Namespace and Labels of Kubernetes resources (see k8s.go).
+	Name      string
+	Namespace string
+	Labels    map[string]string
+}
+
+// Formatter writes variables in one format.
+type Formatter struct {
+	Name          string
+	Description   string
+	Extensions    []string // Inferred from --output, including the dot
+	Files         []string // Inferred from --output file names
+	DefaultOutput string   // Where to write without --output ("-" for stdout; "" for .env)
+	Write         func(w io.Writer, vars []Var, opts Options) error
+}
+
+var formatters = map[string]*Formatter{
+	"dotenv": {
+		Name:        "dotenv",
+		Description: "KEY=value, double-quoted when needed (default)",
+		Extensions:  []string{".env"},
+		Write:       writeDotenv,
+	},
+	"dotenv-posix": {
+		Name:        "dotenv-posix",
+		Description: "KEY='value', safe to source from any POSIX shell",
+		Extensions:  []string{".sh"},
+		Write:       writeDotenvPOSIX,
+	},
+	"dotenv-compose": {
+		Name:        "dotenv-compose",
+		Description: "KEY=\"value\" as read by Docker Compose .env and env_file",
+		Write:       writeDotenvCompose,
+	},
+	"docker": {
+		Name:        "docker",
+		Description: "KEY=value unquoted, for docker run --env-file",
+		Write:       writeDocker,
+	},
+	"json": {
+		Name:        "json",
+		Description: "a JSON object of strings",
+		Extensions:  []string{".json"},
+		Write:       writeJSON,
+	},
+	"yaml": {
+		Name:        "yaml",
+		Description: "a YAML mapping of strings",
+		Extensions:  []string{".yaml", ".yml"},
+		Write:       writeYAML,
+	},
+	"toml": {
+		Name:        "toml",
+		Description: "TOML key/value pairs",
+		Extensions:  []string{".toml"},
+		Write:       writeTOML,
+	},
+	"properties": {
+		Name:        "properties",
+		Description: "Java .properties",
+		Extensions:  []string{".properties"},
+		Write:       writeProperties,
+	},
+	"k8s-secret": {
+		Name:          "k8s-secret",
+		Description:   "a Kubernetes Secret of the sensitive values",
+		DefaultOutput: "-",
+		Write:         writeK8sSecret,
+	},
+	"k8s-configmap": {
+		Name:          "k8s-configmap",
+		Description:   "a Kubernetes ConfigMap of the other values",
+		DefaultOutput: "-",
+		Write:         writeK8sConfigMap,
+	},
+	"kustomize": {
+		Name:          "kustomize",
+		Description:   "a kustomization generating both",
+		Files:         []string{"kustomization.yaml", "kustomization.yml"},
+		DefaultOutput: "-",
+		Write:         writeKustomize,
+	},
+}
+
+// Lookup returns the formatter named name.
+func Lookup(name string) (*Formatter, error) {
+	f, ok := formatters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return f, nil
+}
+
+// ForPath returns the formatter for a file's name or extension. Names
+// like .env, .env.local and app.env are dotenv, kustomization.yaml is
+// kustomize; unknown extensions fall back to Default.
+func ForPath(path string) *Formatter {
+	base := strings.ToLower(filepath.Base(path))
+	ext := filepath.Ext(base)
+	for _, f := range formatters {
+		for _, name := range f.Files {
+			if name == base {
+				return f
+			}
+		}
+	}
+	for _, f := range formatters {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f
+			}
+		}
+	}
+	return formatters[Default]
+}
+
+// Names returns the names of all formats, sorted.
+func Names() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeHeader writes opts.Header as comments starting with prefix,
+// followed by a blank line.
+func writeHeader(w io.Writer, prefix string, opts Options) error {
+	if len(opts.Header) == 0 {
+		return nil
+	}
+	for _, line := range opts.Header {
+		if _, err := fmt.Fprintf(w, "%s %s\n", prefix, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
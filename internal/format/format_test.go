@@ -0,0 +1,68 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// tricky are values each format has to escape to read back unchanged.
+var tricky = []Var{
+	{Name: "DB_HOST", Value: "localhost"},
+	{Name: "DB_PORT", Value: "5432"},
+	{Name: "EMPTY", Value: ""},
+	{Name: "ENABLED", Value: "true"},
+	{Name: "MULTI", Value: "line one\nline two"},
+	{Name: "QUOTES", Value: `it's "quoted"`},
+	{Name: "SHELL", Value: "$HOME `id` \\n"},
+	{Name: "UNICODE", Value: "café ☕"},
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range Names() {
+		f, err := Lookup(name)
+		if err != nil || f.Name != name {
+			t.Errorf("Lookup(%q) = %v, %v", name, f, err)
+		}
+	}
+	_, err := Lookup("xml")
+	if err == nil || !strings.Contains(err.Error(), "dotenv") {
+		t.Errorf("Lookup(xml) error = %v, want one listing the formats", err)
+	}
+}
+
+func TestForPath(t *testing.T) {
+	tests := map[string]string{
+		".env":                   "dotenv",
+		".env.local":             "dotenv",
+		"config/app.env":         "dotenv",
+		"settings.json":          "json",
+		"config.yml":             "yaml",
+		"config.YAML":            "yaml",
+		"pyproject.toml":         "toml",
+		"application.properties": "properties",
+		"env.sh":                 "dotenv-posix",
+		"k8s/kustomization.yaml": "kustomize",
+		"unknown.txt":            Default,
+	}
+	for path, want := range tests {
+		if got := ForPath(path).Name; got != want {
+			t.Errorf("ForPath(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	opts := Options{Header: []string{"Generated by varnish"}, Name: "myapp"}
+	for _, name := range Names() {
+		f, _ := Lookup(name)
+		var buf bytes.Buffer
+		if err := f.Write(&buf, tricky[:1], opts); err != nil {
+			t.Fatalf("%s: Write() error: %v", name, err)
+		}
+		hasHeader := strings.HasPrefix(buf.String(), "# Generated by varnish\n")
+		if hasHeader != (name != "json") {
+			t.Errorf("%s: header written = %v:\n%s", name, hasHeader, buf.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// json.go writes variables as one JSON object of strings, in the order
+// given, for tools and frontend builds that read JSON config.
+//
+// This file is used by:
+//   - format/format.go: registers json
+//
+// JSON has no comments, so Options.Header is not written.
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+func writeJSON(w io.Writer, vars []Var, opts Options) error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, v := range vars {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  ")
+		if err := writeJSONString(&buf, v.Name); err != nil {
+			return err
+		}
+		buf.WriteString(": ")
+		if err := writeJSONString(&buf, v.Value); err != nil {
+			return err
+		}
+	}
+	if len(vars) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeJSONString writes s as a JSON string. HTML characters are kept as
+// they are, since the output is a file and not part of a page.
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Encode ends with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
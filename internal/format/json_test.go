@@ -0,0 +1,37 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, tricky, Options{Header: []string{"ignored"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	for _, v := range tricky {
+		if got[v.Name] != v.Value {
+			t.Errorf("%s = %q, want %q", v.Name, got[v.Name], v.Value)
+		}
+	}
+
+	// Order is kept, HTML is not escaped
+	buf.Reset()
+	_ = writeJSON(&buf, []Var{{Name: "B", Value: "<b>"}, {Name: "A", Value: "&"}}, Options{})
+	if want := "{\n  \"B\": \"<b>\",\n  \"A\": \"&\"\n}\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	_ = writeJSON(&buf, nil, Options{})
+	if buf.String() != "{}\n" {
+		t.Errorf("empty output = %q", buf.String())
+	}
+}
@@ -0,0 +1,252 @@
+// k8s.go writes Kubernetes manifests: a Secret for the sensitive values, a
+// ConfigMap for the rest, or a kustomization generating both.
+//
+// This file is used by:
+//   - format/format.go: registers k8s-secret, k8s-configmap and kustomize
+//
+// The variables are split on Var.Sensitive, so each value lands in exactly
+// one resource and a pod can load both with envFrom:
+//
+//	k8s-secret      Secret, values base64-encoded under data
+//	k8s-configmap   ConfigMap, values under data
+//	kustomize       Kustomization with a secretGenerator and a configMapGenerator
+//
+// Resources are named Options.Name, put in Options.Namespace when set, and
+// labelled with Options.Labels plus app.kubernetes.io/managed-by: varnish.
+package format
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// managedByLabel marks resources generated by varnish.
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// k8sKey matches the keys Secrets and ConfigMaps accept.
+var k8sKey = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// k8sName matches a DNS subdomain, the form resource names and namespaces
+// take.
+var k8sName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// k8sLabel matches a label value, and the name part of a label key.
+var k8sLabel = regexp.MustCompile(`^[a-zA-Z0-9]([-._a-zA-Z0-9]*[a-zA-Z0-9])?$`)
+
+// KubernetesName turns s, typically a project name, into a valid resource
+// name: lower case, with other characters replaced by "-".
+func KubernetesName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '-'
+		}
+	}, s)
+	name = strings.Trim(name, "-.")
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-.")
+	}
+	return name
+}
+
+func writeK8sSecret(w io.Writer, vars []Var, opts Options) error {
+	data, err := k8sData(vars, true, opts)
+	if err != nil {
+		return err
+	}
+	for i, n := range data.Content {
+		if i%2 == 1 {
+			n.Value = base64.StdEncoding.EncodeToString([]byte(n.Value))
+			n.Style = 0
+		}
+	}
+	doc := k8sResource("Secret", opts)
+	doc.Content = append(doc.Content, yamlString("type"), yamlString("Opaque"), yamlString("data"), data)
+	return writeYAMLDocument(w, doc, opts)
+}
+
+func writeK8sConfigMap(w io.Writer, vars []Var, opts Options) error {
+	data, err := k8sData(vars, false, opts)
+	if err != nil {
+		return err
+	}
+	doc := k8sResource("ConfigMap", opts)
+	doc.Content = append(doc.Content, yamlString("data"), data)
+	return writeYAMLDocument(w, doc, opts)
+}
+
+func writeKustomize(w io.Writer, vars []Var, opts Options) error {
+	if err := checkK8sOptions(opts); err != nil {
+		return err
+	}
+	var secrets, configs []string
+	for _, v := range vars {
+		if !k8sKey.MatchString(v.Name) {
+			return fmt.Errorf("%q is not a valid Kubernetes data key", v.Name)
+		}
+		// Kustomize strips quotes around a literal's value
+		if len(v.Value) >= 2 && (v.Value[0] == '"' || v.Value[0] == '\'') && v.Value[len(v.Value)-1] == v.Value[0] {
+			return fmt.Errorf("%s: kustomize literals cannot hold a value wrapped in quotes", v.Name)
+		}
+		if v.Sensitive {
+			secrets = append(secrets, v.Name+"="+v.Value)
+		} else {
+			configs = append(configs, v.Name+"="+v.Value)
+		}
+	}
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	doc.Content = append(doc.Content,
+		yamlString("apiVersion"), yamlString("kustomize.config.k8s.io/v1beta1"),
+		yamlString("kind"), yamlString("Kustomization"))
+	if len(configs) > 0 {
+		doc.Content = append(doc.Content, yamlString("configMapGenerator"), kustomizeGenerator(configs, "", opts))
+	}
+	if len(secrets) > 0 {
+		doc.Content = append(doc.Content, yamlString("secretGenerator"), kustomizeGenerator(secrets, "Opaque", opts))
+	}
+	return writeYAMLDocument(w, doc, opts)
+}
+
+// kustomizeGenerator returns a one-entry generator list for literals.
+func kustomizeGenerator(literals []string, secretType string, opts Options) *yaml.Node {
+	entry := &yaml.Node{Kind: yaml.MappingNode}
+	entry.Content = append(entry.Content, yamlString("name"), yamlString(opts.Name))
+	if opts.Namespace != "" {
+		entry.Content = append(entry.Content, yamlString("namespace"), yamlString(opts.Namespace))
+	}
+	if secretType != "" {
+		entry.Content = append(entry.Content, yamlString("type"), yamlString(secretType))
+	}
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, l := range literals {
+		list.Content = append(list.Content, yamlString(l))
+	}
+	options := &yaml.Node{Kind: yaml.MappingNode}
+	options.Content = append(options.Content, yamlString("labels"), k8sLabels(opts))
+	entry.Content = append(entry.Content, yamlString("literals"), list, yamlString("options"), options)
+	return &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{entry}}
+}
+
+// k8sResource returns the apiVersion, kind and metadata of a resource.
+func k8sResource(kind string, opts Options) *yaml.Node {
+	metadata := &yaml.Node{Kind: yaml.MappingNode}
+	metadata.Content = append(metadata.Content, yamlString("name"), yamlString(opts.Name))
+	if opts.Namespace != "" {
+		metadata.Content = append(metadata.Content, yamlString("namespace"), yamlString(opts.Namespace))
+	}
+	metadata.Content = append(metadata.Content, yamlString("labels"), k8sLabels(opts))
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	doc.Content = append(doc.Content,
+		yamlString("apiVersion"), yamlString("v1"),
+		yamlString("kind"), yamlString(kind),
+		yamlString("metadata"), metadata)
+	return doc
+}
+
+// k8sData returns the data mapping of the variables whose Sensitive is
+// sensitive, after checking the options and names.
+func k8sData(vars []Var, sensitive bool, opts Options) (*yaml.Node, error) {
+	if err := checkK8sOptions(opts); err != nil {
+		return nil, err
+	}
+	data := &yaml.Node{Kind: yaml.MappingNode}
+	for _, v := range vars {
+		if v.Sensitive != sensitive {
+			continue
+		}
+		if !k8sKey.MatchString(v.Name) {
+			return nil, fmt.Errorf("%q is not a valid Kubernetes data key", v.Name)
+		}
+		data.Content = append(data.Content, yamlString(v.Name), yamlString(v.Value))
+	}
+	if len(data.Content) == 0 {
+		data.Style = yaml.FlowStyle
+	}
+	return data, nil
+}
+
+// k8sLabels returns opts.Labels plus the managed-by label, sorted by key.
+func k8sLabels(opts Options) *yaml.Node {
+	labels := map[string]string{managedByLabel: "varnish"}
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, k := range keys {
+		node.Content = append(node.Content, yamlString(k), yamlString(labels[k]))
+	}
+	return node
+}
+
+// checkK8sOptions checks the resource name, namespace and labels.
+func checkK8sOptions(opts Options) error {
+	if opts.Name == "" {
+		return fmt.Errorf("a resource name is required (use --name)")
+	}
+	if len(opts.Name) > 253 || !k8sName.MatchString(opts.Name) {
+		return fmt.Errorf("invalid resource name %q: use lower case letters, digits, '-' and '.'", opts.Name)
+	}
+	if opts.Namespace != "" && (len(opts.Namespace) > 63 || !k8sName.MatchString(opts.Namespace) || strings.Contains(opts.Namespace, ".")) {
+		return fmt.Errorf("invalid namespace %q: use lower case letters, digits and '-'", opts.Namespace)
+	}
+
+	keys := make([]string, 0, len(opts.Labels))
+	for k := range opts.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !validLabelKey(k) {
+			return fmt.Errorf("invalid label key %q: use an optional DNS subdomain prefix and '/', then up to 63 letters, digits, '-', '_' and '.'", k)
+		}
+		if v := opts.Labels[k]; v != "" && (len(v) > 63 || !k8sLabel.MatchString(v)) {
+			return fmt.Errorf("invalid value %q for label %s: use up to 63 letters, digits, '-', '_' and '.'", v, k)
+		}
+	}
+	return nil
+}
+
+// validLabelKey reports whether k is a valid label key, such as app or
+// app.kubernetes.io/name.
+func validLabelKey(k string) bool {
+	name := k
+	if prefix, rest, ok := strings.Cut(k, "/"); ok {
+		if len(prefix) > 253 || !k8sName.MatchString(prefix) {
+			return false
+		}
+		name = rest
+	}
+	return len(name) <= 63 && k8sLabel.MatchString(name)
+}
+
+// writeYAMLDocument writes doc with opts.Header as its head comment.
+func writeYAMLDocument(w io.Writer, doc *yaml.Node, opts Options) error {
+	root := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{doc}}
+	if len(opts.Header) > 0 {
+		root.HeadComment = strings.Join(opts.Header, "\n")
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,181 @@
+package format
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifest is the part of a Secret or ConfigMap the tests check.
+type manifest struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Type       string `yaml:"type"`
+	Metadata   struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace"`
+		Labels    map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+	Data map[string]string `yaml:"data"`
+}
+
+var k8sVars = []Var{
+	{Name: "DB_HOST", Value: "localhost"},
+	{Name: "DB_PASSWORD", Value: "s3cr3t\nline", Sensitive: true},
+	{Name: "DB_PORT", Value: "5432"},
+}
+
+var k8sOpts = Options{
+	Header:    []string{"Generated by varnish"},
+	Name:      "myapp",
+	Namespace: "dev",
+	Labels:    map[string]string{"app": "myapp"},
+}
+
+func TestKubernetesName(t *testing.T) {
+	tests := map[string]string{
+		"myapp":        "myapp",
+		"My_App":       "my-app",
+		"-api.v2-":     "api.v2",
+		"team/billing": "team-billing",
+	}
+	for in, want := range tests {
+		if got := KubernetesName(in); got != want {
+			t.Errorf("KubernetesName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteK8sSecret(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeK8sSecret(&buf, k8sVars, k8sOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "# Generated by varnish\n") {
+		t.Errorf("missing header:\n%s", buf.String())
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("output is not YAML: %v", err)
+	}
+	if m.APIVersion != "v1" || m.Kind != "Secret" || m.Type != "Opaque" {
+		t.Errorf("apiVersion/kind/type = %s/%s/%s", m.APIVersion, m.Kind, m.Type)
+	}
+	if m.Metadata.Name != "myapp" || m.Metadata.Namespace != "dev" {
+		t.Errorf("metadata = %+v", m.Metadata)
+	}
+	if m.Metadata.Labels["app"] != "myapp" || m.Metadata.Labels[managedByLabel] != "varnish" {
+		t.Errorf("labels = %v", m.Metadata.Labels)
+	}
+	if len(m.Data) != 1 {
+		t.Fatalf("data = %v, want only the sensitive value", m.Data)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(m.Data["DB_PASSWORD"])
+	if err != nil || string(decoded) != "s3cr3t\nline" {
+		t.Errorf("DB_PASSWORD decodes to %q, %v", decoded, err)
+	}
+}
+
+func TestWriteK8sConfigMap(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeK8sConfigMap(&buf, k8sVars, k8sOpts); err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	if err := yaml.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("output is not YAML: %v", err)
+	}
+	if m.Kind != "ConfigMap" || m.Metadata.Name != "myapp" {
+		t.Errorf("kind/name = %s/%s", m.Kind, m.Metadata.Name)
+	}
+	if len(m.Data) != 2 || m.Data["DB_HOST"] != "localhost" || m.Data["DB_PORT"] != "5432" {
+		t.Errorf("data = %v", m.Data)
+	}
+
+	// Nothing left: an empty data mapping, not null
+	buf.Reset()
+	if err := writeK8sConfigMap(&buf, k8sVars[1:2], k8sOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "data: {}\n") {
+		t.Errorf("expected empty data:\n%s", buf.String())
+	}
+}
+
+func TestWriteKustomize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeKustomize(&buf, k8sVars, k8sOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	type generator struct {
+		Name      string   `yaml:"name"`
+		Namespace string   `yaml:"namespace"`
+		Literals  []string `yaml:"literals"`
+		Options   struct {
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:"options"`
+	}
+	var k struct {
+		Kind    string      `yaml:"kind"`
+		Configs []generator `yaml:"configMapGenerator"`
+		Secrets []generator `yaml:"secretGenerator"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &k); err != nil {
+		t.Fatalf("output is not YAML: %v\n%s", err, buf.String())
+	}
+	if k.Kind != "Kustomization" {
+		t.Errorf("kind = %s", k.Kind)
+	}
+	configs, secrets := k.Configs, k.Secrets
+	if len(configs) != 1 || strings.Join(configs[0].Literals, ",") != "DB_HOST=localhost,DB_PORT=5432" {
+		t.Errorf("configMapGenerator = %+v", configs)
+	}
+	if len(secrets) != 1 || len(secrets[0].Literals) != 1 || secrets[0].Literals[0] != "DB_PASSWORD=s3cr3t\nline" {
+		t.Errorf("secretGenerator = %+v", secrets)
+	}
+	if secrets[0].Namespace != "dev" || secrets[0].Options.Labels["app"] != "myapp" {
+		t.Errorf("secretGenerator = %+v", secrets[0])
+	}
+
+	quoted := []Var{{Name: "QUOTED", Value: `"x"`}}
+	if err := writeKustomize(&bytes.Buffer{}, quoted, k8sOpts); err == nil {
+		t.Error("expected an error for a value wrapped in quotes")
+	}
+}
+
+func TestK8sOptionErrors(t *testing.T) {
+	tests := []Options{
+		{},
+		{Name: "My App"},
+		{Name: "myapp", Namespace: "dev.team"},
+		{Name: "myapp", Labels: map[string]string{"my app": "x"}},
+		{Name: "myapp", Labels: map[string]string{"-app": "x"}},
+		{Name: "myapp", Labels: map[string]string{strings.Repeat("a", 64): "x"}},
+		{Name: "myapp", Labels: map[string]string{"Example.com/app": "x"}},
+		{Name: "myapp", Labels: map[string]string{"example.com/": "x"}},
+		{Name: "myapp", Labels: map[string]string{"a/b/c": "x"}},
+		{Name: "myapp", Labels: map[string]string{"app": "my app"}},
+		{Name: "myapp", Labels: map[string]string{"app": "v1-"}},
+		{Name: "myapp", Labels: map[string]string{"app": strings.Repeat("a", 64)}},
+	}
+	for _, opts := range tests {
+		if err := writeK8sSecret(&bytes.Buffer{}, k8sVars, opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+	valid := Options{Name: "myapp", Labels: map[string]string{"app.kubernetes.io/name": "My_App-1.0", "tier": ""}}
+	if err := writeK8sSecret(&bytes.Buffer{}, k8sVars, valid); err != nil {
+		t.Errorf("valid labels rejected: %v", err)
+	}
+	if err := writeKustomize(&bytes.Buffer{}, k8sVars, Options{Name: "myapp", Labels: map[string]string{"app": "my app"}}); err == nil {
+		t.Error("expected kustomize to check labels")
+	}
+	if err := writeK8sConfigMap(&bytes.Buffer{}, []Var{{Name: "bad name", Value: "x"}}, k8sOpts); err == nil {
+		t.Error("expected an error for an invalid data key")
+	}
+}
@@ -0,0 +1,64 @@
+// properties.go writes variables as a Java .properties file.
+//
+// This file is used by:
+//   - format/format.go: registers properties
+//
+// Properties.load reads ISO-8859-1, so characters outside it are written
+// as \uXXXX escapes (surrogate pairs above U+FFFF) and the file is plain
+// ASCII. Line breaks in values are escaped; leading spaces in a value and
+// separators in a key are escaped so they aren't trimmed or split on.
+package format
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+func writeProperties(w io.Writer, vars []Var, opts Options) error {
+	if err := writeHeader(w, "#", opts); err != nil {
+		return err
+	}
+	for _, v := range vars {
+		line := escapeProperty(v.Name, true) + "=" + escapeProperty(v.Value, false)
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeProperty escapes a key or value for a .properties file.
+func escapeProperty(s string, key bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case r == ' ' && (key || i == 0):
+			sb.WriteString(`\ `)
+		case (r == '=' || r == ':') && key:
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case (r == '#' || r == '!') && key && i == 0:
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&sb, `\u%04X`, u)
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,33 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteProperties(t *testing.T) {
+	vars := []Var{
+		{Name: "DB_HOST", Value: "localhost"},
+		{Name: "GREETING", Value: "  hello = world: # !"},
+		{Name: "MULTI", Value: "line one\nline two"},
+		{Name: "PATH", Value: `C:\tools`},
+		{Name: "UNICODE", Value: "café 😀"},
+		{Name: "odd key=x", Value: "v"},
+		{Name: "#hash", Value: "v"},
+	}
+	var buf bytes.Buffer
+	if err := writeProperties(&buf, vars, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `DB_HOST=localhost
+GREETING=\  hello = world: # !
+MULTI=line one\nline two
+PATH=C:\\tools
+UNICODE=caf\u00E9 \uD83D\uDE00
+odd\ key\=x=v
+\#hash=v
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,66 @@
+// toml.go writes variables as TOML key/value pairs.
+//
+// This file is used by:
+//   - format/format.go: registers toml
+//
+// Values are basic strings on one line, with control characters such as
+// newlines escaped, so every value reads back as a string.
+package format
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// tomlBareKey matches keys TOML accepts without quotes.
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func writeTOML(w io.Writer, vars []Var, opts Options) error {
+	if err := writeHeader(w, "#", opts); err != nil {
+		return err
+	}
+	for _, v := range vars {
+		key := v.Name
+		if !tomlBareKey.MatchString(key) {
+			key = quoteTOML(key)
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s\n", key, quoteTOML(v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteTOML returns s as a TOML basic string.
+func quoteTOML(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,31 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTOML(t *testing.T) {
+	vars := []Var{
+		{Name: "DB_PORT", Value: "5432"},
+		{Name: "MULTI", Value: "line one\nline two"},
+		{Name: "QUOTES", Value: `say "hi" \o/`},
+		{Name: "CONTROL", Value: "bell\a\ttab"},
+		{Name: "my.key", Value: "dotted"},
+	}
+	var buf bytes.Buffer
+	if err := writeTOML(&buf, vars, Options{Header: []string{"Generated by varnish"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# Generated by varnish
+
+DB_PORT = "5432"
+MULTI = "line one\nline two"
+QUOTES = "say \"hi\" \\o/"
+CONTROL = "bell\u0007\ttab"
+"my.key" = "dotted"
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,37 @@
+// yaml.go writes variables as a YAML mapping of strings.
+//
+// This file is used by:
+//   - format/format.go: registers yaml
+//
+// Every value is tagged as a string, so values like "5432", "true" or
+// "null" are quoted and read back as strings rather than other types.
+// Multi-line values are written as literal blocks.
+package format
+
+import (
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func writeYAML(w io.Writer, vars []Var, opts Options) error {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for _, v := range vars {
+		mapping.Content = append(mapping.Content, yamlString(v.Name), yamlString(v.Value))
+	}
+	if len(vars) == 0 {
+		// An empty block mapping has no representation; write {}
+		mapping.Style = yaml.FlowStyle
+	}
+	return writeYAMLDocument(w, mapping, opts)
+}
+
+// yamlString returns a scalar node that always reads back as the string s.
+func yamlString(s string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+	if strings.Contains(s, "\n") && !strings.ContainsAny(s, "\r\t") {
+		n.Style = yaml.LiteralStyle
+	}
+	return n
+}
@@ -0,0 +1,39 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteYAML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeYAML(&buf, tricky, Options{Header: []string{"Generated by varnish"}}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	var got map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not YAML: %v\n%s", err, out)
+	}
+	for _, v := range tricky {
+		// Every value must come back as a string, not a number or bool
+		if s, ok := got[v.Name].(string); !ok || s != v.Value {
+			t.Errorf("%s = %#v, want %q", v.Name, got[v.Name], v.Value)
+		}
+	}
+	if !strings.Contains(out, "MULTI: |-\n  line one\n  line two\n") {
+		t.Errorf("multi-line value should be a literal block:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := writeYAML(&buf, nil, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{}\n" {
+		t.Errorf("empty output = %q", buf.String())
+	}
+}
@@ -0,0 +1,298 @@
+// Package lock provides advisory cross-process locks on varnish data files.
+//
+// This package is used by:
+//   - store/store.go: around Load, Save and Update
+//   - registry/registry.go: around Load, Save and Update
+//   - project/project.go: around config writes
+//   - snapshot/snapshot.go: while copying and restoring files
+//
+// A lock on ~/.varnish/store.yaml is the file ~/.varnish/store.yaml.lock,
+// created exclusively and holding the owner's PID, host name and start
+// time. Other processes wait for it to go away, up to a timeout
+// (VARNISH_LOCK_TIMEOUT, default 10s). A lock is stale, and is taken over,
+// if its owner is on this host and no longer running, or if it is older
+// than StaleAfter and the owner can't be checked (another host, or an
+// unreadable lock file). A stale lock is renamed aside before it is
+// removed, so two processes breaking it at once can't remove a lock just
+// taken by a third.
+//
+// Locks are reentrant within a process, so code holding the store lock can
+// call functions that lock it again.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Suffix is appended to a file's path to name its lock file.
+const Suffix = ".lock"
+
+// TimeoutEnvVar overrides DefaultTimeout, e.g. VARNISH_LOCK_TIMEOUT=30s.
+const TimeoutEnvVar = "VARNISH_LOCK_TIMEOUT"
+
+// DefaultTimeout is how long to wait for a lock held by another process.
+const DefaultTimeout = 10 * time.Second
+
+// StaleAfter is the age after which a lock whose owner can't be checked is
+// considered abandoned.
+var StaleAfter = 5 * time.Minute
+
+// link is replaced in tests.
+var link = os.Link
+
+// ErrTimeout is returned when a lock isn't released in time.
+var ErrTimeout = errors.New("timed out waiting for lock")
+
+// Lock is a held lock. Release it when done.
+type Lock struct {
+	path string // lock file path
+}
+
+var (
+	mu       sync.Mutex
+	held     = make(map[string]int)  // lock file path -> acquisitions by this process
+	leftover = make(map[string]bool) // lock files Release couldn't remove
+)
+
+// Acquire locks target (a file path), waiting up to the timeout from
+// VARNISH_LOCK_TIMEOUT or DefaultTimeout.
+func Acquire(target string) (*Lock, error) {
+	timeout, err := Timeout()
+	if err != nil {
+		return nil, err
+	}
+	return AcquireTimeout(target, timeout)
+}
+
+// Timeout returns the lock timeout from VARNISH_LOCK_TIMEOUT, or
+// DefaultTimeout if it isn't set.
+func Timeout() (time.Duration, error) {
+	v := os.Getenv(TimeoutEnvVar)
+	if v == "" {
+		return DefaultTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q (want a duration like 30s)", TimeoutEnvVar, v)
+	}
+	return d, nil
+}
+
+// AcquireTimeout locks target, waiting up to timeout for another process
+// to release it.
+func AcquireTimeout(target string, timeout time.Duration) (*Lock, error) {
+	path := target + Suffix
+	if reacquire(path) {
+		return &Lock{path: path}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("lock %s: %w", filepath.Base(target), err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	delay := 10 * time.Millisecond
+	for {
+		err := create(path)
+		if err == nil {
+			mu.Lock()
+			held[path] = 1
+			mu.Unlock()
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("lock %s: %w", filepath.Base(target), err)
+		}
+
+		removed, err := removeStale(path)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", filepath.Base(target), err)
+		}
+		if removed {
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w on %s (%s; remove %s if no varnish command is running)",
+				ErrTimeout, filepath.Base(target), describe(path), path)
+		}
+		time.Sleep(delay)
+		if delay < 200*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+// reacquire counts another acquisition if this process holds path, or
+// takes back a lock file of this process that Release couldn't remove.
+func reacquire(path string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if held[path] > 0 {
+		held[path]++
+		return true
+	}
+	if leftover[path] {
+		delete(leftover, path)
+		if o, err := readOwner(path); err == nil && o.isSelf() {
+			held[path] = 1
+			return true
+		}
+	}
+	return false
+}
+
+// Release gives up the lock. The lock file is removed once every
+// acquisition in this process has been released. If it can't be removed,
+// the next Acquire in this process takes it back, and other processes take
+// it over as stale once this one has exited.
+func (l *Lock) Release() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	held[l.path]--
+	if held[l.path] > 0 {
+		return
+	}
+	delete(held, l.path)
+
+	// Don't remove a lock another process took over as stale
+	if o, err := readOwner(l.path); err == nil && !o.isSelf() {
+		return
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		leftover[l.path] = true
+	}
+}
+
+// owner identifies the process holding a lock.
+type owner struct {
+	pid     int
+	host    string
+	created time.Time
+}
+
+// isSelf reports whether o is this process.
+func (o owner) isSelf() bool {
+	return o.pid == os.Getpid() && o.host == hostname()
+}
+
+// create makes the lock file, failing if it exists.
+func create(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %s %s\n", os.Getpid(), hostname(), time.Now().UTC().Format(time.RFC3339))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// readOwner parses a lock file.
+func readOwner(path string) (owner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return owner{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return owner{}, fmt.Errorf("malformed lock file %s", path)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return owner{}, fmt.Errorf("malformed lock file %s", path)
+	}
+	created, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return owner{}, fmt.Errorf("malformed lock file %s", path)
+	}
+	return owner{pid: pid, host: fields[1], created: created}, nil
+}
+
+// removeStale removes the lock file at path if it is stale, and reports
+// whether it did.
+func removeStale(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Released in the meantime
+		return os.IsNotExist(err), nil
+	}
+
+	o, err := readOwner(path)
+	switch {
+	case err == nil && o.host == hostname():
+		if processAlive(o.pid) {
+			return false, nil
+		}
+	case err == nil:
+		if time.Since(o.created) < StaleAfter {
+			return false, nil
+		}
+	default:
+		// Unreadable, or still being written: judge by the file's age
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < StaleAfter {
+			return false, nil
+		}
+	}
+
+	return breakLock(path, data)
+}
+
+// breakLock removes the lock file at path if it still holds data, the
+// content judged stale, and reports whether the lock is gone. Comparing
+// and then removing in place would race with a process that takes over
+// the same lock in between, so the lock is first moved aside, which only
+// one process can do, and its content is checked there. A lock taken
+// since is moved back; if that fails, the error says where it was left.
+func breakLock(path string, data []byte) (bool, error) {
+	aside := fmt.Sprintf("%s.stale.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		// Released, or broken by another process, in the meantime
+		return os.IsNotExist(err), nil
+	}
+
+	current, err := os.ReadFile(aside)
+	if err == nil && string(current) == string(data) {
+		os.Remove(aside)
+		return true, nil
+	}
+
+	// Not the lock we judged stale. Link it back unless yet another lock
+	// has been created since, then drop the moved name.
+	if err := link(aside, path); err != nil {
+		return false, fmt.Errorf("put back lock taken over while breaking a stale one (left at %s): %w", aside, err)
+	}
+	os.Remove(aside)
+	return false, nil
+}
+
+// describe says who holds the lock at path, for error messages.
+func describe(path string) string {
+	o, err := readOwner(path)
+	if err != nil {
+		return "held by an unknown process"
+	}
+	return fmt.Sprintf("held by pid %d on %s since %s", o.pid, o.host, o.created.Local().Format("15:04:05"))
+}
+
+// hostname returns the host name, without spaces so it fits the lock file.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "unknown"
+	}
+	return strings.ReplaceAll(h, " ", "_")
+}
@@ -0,0 +1,250 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeLockFile creates a lock file as if another process held target.
+func writeLockFile(t *testing.T, target string, pid int, host string, created time.Time) {
+	t.Helper()
+	content := fmt.Sprintf("%d %s %s\n", pid, host, created.UTC().Format(time.RFC3339))
+	if err := os.WriteFile(target+Suffix, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("run helper process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquireRelease(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+
+	lk, err := AcquireTimeout(target, time.Second)
+	if err != nil {
+		t.Fatalf("AcquireTimeout() error: %v", err)
+	}
+	o, err := readOwner(target + Suffix)
+	if err != nil || !o.isSelf() {
+		t.Errorf("lock file owner = %+v, %v", o, err)
+	}
+
+	lk.Release()
+	if _, err := os.Stat(target + Suffix); !os.IsNotExist(err) {
+		t.Error("lock file should be removed on release")
+	}
+}
+
+func TestAcquireTakesBackLeftover(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+
+	// A lock file of this process that Release couldn't remove
+	writeLockFile(t, target, os.Getpid(), hostname(), time.Now())
+	mu.Lock()
+	leftover[target+Suffix] = true
+	mu.Unlock()
+
+	lk, err := AcquireTimeout(target, 0)
+	if err != nil {
+		t.Fatalf("leftover lock of this process should be taken back, got %v", err)
+	}
+	lk.Release()
+	if _, err := os.Stat(target + Suffix); !os.IsNotExist(err) {
+		t.Error("lock file should be removed on release")
+	}
+}
+
+func TestAcquireReentrant(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+
+	outer, err := AcquireTimeout(target, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := AcquireTimeout(target, 0)
+	if err != nil {
+		t.Fatalf("reacquiring in the same process failed: %v", err)
+	}
+
+	inner.Release()
+	if _, err := os.Stat(target + Suffix); err != nil {
+		t.Error("lock file should remain until the outer lock is released")
+	}
+	outer.Release()
+	if _, err := os.Stat(target + Suffix); !os.IsNotExist(err) {
+		t.Error("lock file should be removed after the outer release")
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+
+	// Held by a live process on this host (our parent)
+	writeLockFile(t, target, os.Getppid(), hostname(), time.Now())
+
+	start := time.Now()
+	_, err := AcquireTimeout(target, 100*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("should wait for the timeout before failing")
+	}
+}
+
+func TestAcquireWaitsForRelease(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+	writeLockFile(t, target, os.Getppid(), hostname(), time.Now())
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Remove(target + Suffix)
+	}()
+
+	lk, err := AcquireTimeout(target, 2*time.Second)
+	if err != nil {
+		t.Fatalf("expected lock after release, got %v", err)
+	}
+	lk.Release()
+}
+
+func TestAcquireStale(t *testing.T) {
+	tests := []struct {
+		name  string
+		pid   func(t *testing.T) int
+		host  string
+		age   time.Duration
+		stale bool
+	}{
+		{"dead process on this host", deadPID, hostname(), 0, true},
+		{"other host, fresh", func(*testing.T) int { return 1 }, "elsewhere", 0, false},
+		{"other host, old", func(*testing.T) int { return 1 }, "elsewhere", 2 * StaleAfter, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "store.yaml")
+			writeLockFile(t, target, tt.pid(t), tt.host, time.Now().Add(-tt.age))
+
+			lk, err := AcquireTimeout(target, 50*time.Millisecond)
+			if tt.stale {
+				if err != nil {
+					t.Fatalf("stale lock should be taken over, got %v", err)
+				}
+				lk.Release()
+				return
+			}
+			if !errors.Is(err, ErrTimeout) {
+				t.Fatalf("expected ErrTimeout, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAcquireMalformedLock(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+	if err := os.WriteFile(target+Suffix, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recent: might still be being written
+	if _, err := AcquireTimeout(target, 50*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+
+	old := time.Now().Add(-2 * StaleAfter)
+	if err := os.Chtimes(target+Suffix, old, old); err != nil {
+		t.Fatal(err)
+	}
+	lk, err := AcquireTimeout(target, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("old malformed lock should be taken over, got %v", err)
+	}
+	lk.Release()
+}
+
+func TestReleaseKeepsTakenOverLock(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "store.yaml")
+	lk, err := AcquireTimeout(target, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process judged us stale and took over
+	writeLockFile(t, target, os.Getppid(), hostname(), time.Now())
+
+	lk.Release()
+	if _, err := os.Stat(target + Suffix); err != nil {
+		t.Error("release must not remove another process's lock")
+	}
+}
+
+func TestTimeoutEnv(t *testing.T) {
+	t.Setenv(TimeoutEnvVar, "")
+	if d, err := Timeout(); err != nil || d != DefaultTimeout {
+		t.Errorf("Timeout() = %v, %v; want default", d, err)
+	}
+
+	t.Setenv(TimeoutEnvVar, "250ms")
+	if d, err := Timeout(); err != nil || d != 250*time.Millisecond {
+		t.Errorf("Timeout() = %v, %v; want 250ms", d, err)
+	}
+
+	t.Setenv(TimeoutEnvVar, "soon")
+	if _, err := Timeout(); err == nil {
+		t.Error("expected error for invalid timeout")
+	}
+}
+
+func TestBreakLock(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "store.yaml")
+	writeLockFile(t, target, 1, "elsewhere", time.Now().Add(-2*StaleAfter))
+	stale, err := os.ReadFile(target + Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Taken over by another process after it was judged stale
+	writeLockFile(t, target, 2, "elsewhere", time.Now())
+	fresh, _ := os.ReadFile(target + Suffix)
+	if removed, err := breakLock(target+Suffix, stale); removed || err != nil {
+		t.Fatalf("breakLock() = %v, %v; want a lock taken since kept", removed, err)
+	}
+	if current, err := os.ReadFile(target + Suffix); err != nil || string(current) != string(fresh) {
+		t.Errorf("lock taken since = %q, %v; want it kept as %q", current, err, fresh)
+	}
+
+	if removed, err := breakLock(target+Suffix, fresh); !removed || err != nil {
+		t.Fatalf("breakLock() = %v, %v; want the stale lock removed", removed, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("breakLock left files behind: %v", entries)
+	}
+
+	// Already gone
+	if removed, err := breakLock(target+Suffix, fresh); !removed || err != nil {
+		t.Errorf("breakLock() of a missing lock = %v, %v; want it reported gone", removed, err)
+	}
+
+	// Putting back a lock taken since fails
+	writeLockFile(t, target, 3, "elsewhere", time.Now())
+	orig := link
+	t.Cleanup(func() { link = orig })
+	link = func(string, string) error { return os.ErrExist }
+	if _, err := breakLock(target+Suffix, stale); err == nil {
+		t.Error("breakLock() should report a lock it couldn't put back")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package lock
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given PID is running.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// Signal 0 checks for existence without sending anything. EPERM means
+	// the process exists but belongs to another user.
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
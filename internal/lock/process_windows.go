@@ -0,0 +1,31 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"syscall"
+)
+
+// stillActive is the exit code GetExitCodeProcess reports for a running
+// process.
+const stillActive = 259
+
+// processAlive reports whether a process with the given PID is running.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Access denied means the process exists but belongs to another user
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
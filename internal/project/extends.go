@@ -0,0 +1,95 @@
+// extends.go implements project inheritance.
+//
+// A project can extend one or more base projects:
+//
+//	project: billing
+//	extends:
+//	  - platform-base
+//
+// Each parent keeps its own store namespace: platform-base's include
+// patterns match "platform-base.*" keys. Resolution applies layers in
+// order, most distant ancestor first and the project itself last, so:
+//
+//   - a child's store values and overrides win over any ancestor's
+//   - with extends: [a, b], b wins over a
+//   - mappings and computed values merge the same way
+//
+// An ancestor reached through two paths (a diamond) is applied once, at
+// its first position. Cycles are reported when the config is loaded.
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dk/varnish/internal/config"
+)
+
+// loadByName loads a config and its parents. stack holds the projects
+// currently being loaded, to detect extends cycles.
+func loadByName(name string, stack []string) (*Config, error) {
+	cfg, err := LoadFrom(config.ProjectConfigPathFor(name))
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.loadParents(append(stack, name)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadParents loads the configs named in Extends (recursively) into Parents.
+// LoadByName and Load do this already; use it for configs read with LoadFrom.
+func (c *Config) LoadParents() error {
+	return c.loadParents([]string{c.Project})
+}
+
+func (c *Config) loadParents(stack []string) error {
+	c.Parents = nil
+	for _, parent := range c.Extends {
+		for i, name := range stack {
+			if name == parent {
+				cycle := append(append([]string{}, stack[i:]...), parent)
+				return fmt.Errorf("extends cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		p, err := loadByName(parent, stack)
+		if err != nil {
+			return fmt.Errorf("load parent project %s: %w", parent, err)
+		}
+		c.Parents = append(c.Parents, p)
+	}
+	return nil
+}
+
+// Ancestry returns the configs to apply in order: ancestors first (each
+// once), then c itself. If a profile is active, ancestors that declare
+// the same profile have it applied too.
+func (c *Config) Ancestry() []*Config {
+	var layers []*Config
+	seen := make(map[string]bool)
+
+	var visit func(cfg *Config)
+	visit = func(cfg *Config) {
+		for _, p := range cfg.Parents {
+			if seen[p.Project] {
+				continue
+			}
+			seen[p.Project] = true
+			visit(p)
+
+			layer := p
+			if c.Profile != "" && p.HasProfile(c.Profile) {
+				if withProfile, err := p.WithProfile(c.Profile); err == nil {
+					layer = withProfile
+				}
+			}
+			layers = append(layers, layer)
+		}
+	}
+	seen[c.Project] = true
+	visit(c)
+
+	return append(layers, c)
+}
@@ -0,0 +1,97 @@
+package project
+
+import (
+	"strings"
+	"testing"
+)
+
+// saveProject saves a config named name that extends parents.
+func saveProject(t *testing.T, name string, parents ...string) {
+	t.Helper()
+	cfg := New()
+	cfg.Project = name
+	cfg.Extends = parents
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save(%s) error: %v", name, err)
+	}
+}
+
+func TestLoadByNameExtends(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	saveProject(t, "base")
+	saveProject(t, "shared", "base")
+	saveProject(t, "other", "base")
+	saveProject(t, "app", "shared", "other")
+
+	cfg, err := LoadByName("app")
+	if err != nil {
+		t.Fatalf("LoadByName() error: %v", err)
+	}
+
+	if len(cfg.Parents) != 2 {
+		t.Fatalf("expected 2 parents, got %d", len(cfg.Parents))
+	}
+
+	// base is shared by both parents (diamond) and applied once, first
+	var order []string
+	for _, layer := range cfg.Ancestry() {
+		order = append(order, layer.Project)
+	}
+	if got := strings.Join(order, ","); got != "base,shared,other,app" {
+		t.Errorf("Ancestry() = %s, want base,shared,other,app", got)
+	}
+}
+
+func TestLoadByNameExtendsCycle(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	saveProject(t, "a", "b")
+	saveProject(t, "b", "c")
+	saveProject(t, "c", "a")
+
+	_, err := LoadByName("a")
+	if err == nil {
+		t.Fatal("expected cycle error")
+	}
+	if !strings.Contains(err.Error(), "extends cycle: a -> b -> c -> a") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadByNameExtendsMissingParent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	saveProject(t, "app", "missing")
+
+	_, err := LoadByName("app")
+	if err == nil || !strings.Contains(err.Error(), "load parent project missing") {
+		t.Errorf("expected missing parent error, got %v", err)
+	}
+}
+
+func TestAncestryWithProfile(t *testing.T) {
+	parent := New()
+	parent.Project = "base"
+	parent.Profiles = map[string]*Profile{
+		"test": {Overrides: map[string]string{"kafka.brokers": "test-kafka"}},
+	}
+
+	child := New()
+	child.Project = "app"
+	child.Parents = []*Config{parent}
+	child.Profiles = map[string]*Profile{"test": {}}
+
+	merged, err := child.WithProfile("test")
+	if err != nil {
+		t.Fatalf("WithProfile() error: %v", err)
+	}
+
+	layers := merged.Ancestry()
+	if len(layers) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(layers))
+	}
+	if layers[0].Profile != "test" || layers[0].Overrides["kafka.brokers"] != "test-kafka" {
+		t.Error("ancestor declaring the profile should have it applied")
+	}
+}
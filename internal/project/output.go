@@ -0,0 +1,75 @@
+// output.go defines the files "varnish env" writes when a project lists
+// them under outputs:.
+//
+// This file is used by:
+//   - cli/env.go: writes every output when --output and --format are not given
+//
+// Each output picks a subset of the resolved variables and may apply its
+// own profile:
+//
+//	outputs:
+//	  - path: .env
+//	  - path: .env.test
+//	    profile: test
+//	    exclude: [STRIPE_*]
+//	  - path: web/config.json
+//	    format: json
+//	    include: [VITE_*, api.url]
+//
+// Include and exclude patterns are globs matched against the env name
+// (VITE_*) or the store key (api.*) of each variable. Without include,
+// every variable is written; exclude wins over include.
+package project
+
+import (
+	"fmt"
+	"path"
+)
+
+// Output is one file written by "varnish env".
+type Output struct {
+	Path    string   `yaml:"path"`              // Relative to the project directory, or - for stdout
+	Format  string   `yaml:"format,omitempty"`  // Default: from the path, as with --output
+	Include []string `yaml:"include,omitempty"` // Env names or store keys to write (default: all)
+	Exclude []string `yaml:"exclude,omitempty"` // Env names or store keys to leave out
+	Profile string   `yaml:"profile,omitempty"` // Default: the active profile
+}
+
+// Matches reports whether the variable named envName, from store key key
+// ("" for computed variables), belongs in the output.
+func (o *Output) Matches(envName, key string) bool {
+	if matchAny(o.Exclude, envName, key) {
+		return false
+	}
+	return len(o.Include) == 0 || matchAny(o.Include, envName, key)
+}
+
+// Check reports an output that cannot be written: no path, or a bad
+// pattern.
+func (o *Output) Check() error {
+	if o.Path == "" {
+		return fmt.Errorf("output has no path")
+	}
+	for _, pattern := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("output %s: invalid pattern %q", o.Path, pattern)
+		}
+	}
+	return nil
+}
+
+// matchAny reports whether any pattern matches envName or key.
+func matchAny(patterns []string, envName, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, envName); ok {
+			return true
+		}
+		if key == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestOutputMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     Output
+		envName string
+		key     string
+		want    bool
+	}{
+		{"no filter", Output{}, "DB_HOST", "db.host", true},
+		{"include env name", Output{Include: []string{"VITE_*"}}, "VITE_API", "vite.api", true},
+		{"include store key", Output{Include: []string{"db.*"}}, "DATABASE_HOST", "db.host", true},
+		{"not included", Output{Include: []string{"VITE_*"}}, "DB_HOST", "db.host", false},
+		{"exclude", Output{Exclude: []string{"STRIPE_*"}}, "STRIPE_KEY", "stripe.key", false},
+		{"exclude wins", Output{Include: []string{"*"}, Exclude: []string{"db.password"}}, "DB_PASSWORD", "db.password", false},
+		{"computed", Output{Include: []string{"db.*"}}, "DATABASE_URL", "", false},
+		{"computed by name", Output{Include: []string{"DATABASE_URL"}}, "DATABASE_URL", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.out.Matches(tt.envName, tt.key); got != tt.want {
+				t.Errorf("Matches(%q, %q) = %v, want %v", tt.envName, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputCheck(t *testing.T) {
+	if err := (&Output{Path: ".env", Include: []string{"db.*"}}).Check(); err != nil {
+		t.Errorf("Check() error: %v", err)
+	}
+	if err := (&Output{}).Check(); err == nil {
+		t.Error("expected error for output without path")
+	}
+	if err := (&Output{Path: ".env", Exclude: []string{"db.["}}).Check(); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestOutputsYAML(t *testing.T) {
+	data := []byte(`
+project: myapp
+outputs:
+  - path: .env
+  - path: web/config.json
+    format: json
+    include: [VITE_*]
+    profile: test
+`)
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Outputs) != 2 {
+		t.Fatalf("got %d outputs, want 2", len(cfg.Outputs))
+	}
+	out := cfg.Outputs[1]
+	if out.Path != "web/config.json" || out.Format != "json" || out.Profile != "test" || len(out.Include) != 1 {
+		t.Errorf("unexpected output: %+v", out)
+	}
+
+	cfg.Profiles = map[string]*Profile{"test": {}}
+	merged, err := cfg.WithProfile("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Outputs) != 2 {
+		t.Errorf("WithProfile dropped outputs")
+	}
+}
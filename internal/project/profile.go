@@ -0,0 +1,182 @@
+// profile.go implements named profiles (dev, test, staging, ...) inside a
+// project config.
+//
+// A profile is layered on top of the base config:
+//
+//	include:
+//	  - database.*
+//	overrides:
+//	  database.name: myapp_dev
+//	profiles:
+//	  test:
+//	    overrides:
+//	      database.name: myapp_test
+//
+// Each profile also gets its own store namespace. With profile "test",
+// store lookups try "myapp@test.database.host" first and fall back to
+// "myapp.database.host", so only the values that differ need to be stored.
+package project
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ProfileEnvVar selects the active profile when --profile is not given.
+const ProfileEnvVar = "VARNISH_PROFILE"
+
+// ProfileSeparator joins a project and profile in store keys: myapp@test.key
+const ProfileSeparator = "@"
+
+// Profile holds settings that are layered on top of the base config.
+type Profile struct {
+	Include   []string          `yaml:"include,omitempty"`
+	Overrides map[string]string `yaml:"overrides,omitempty"`
+	Mappings  map[string]string `yaml:"mappings,omitempty"`
+	Computed  map[string]string `yaml:"computed,omitempty"`
+}
+
+// Namespace returns the store namespace for a project and optional profile.
+// Namespace("myapp", "") → "myapp", Namespace("myapp", "test") → "myapp@test"
+func Namespace(project, profile string) string {
+	if profile == "" {
+		return project
+	}
+	return project + ProfileSeparator + profile
+}
+
+// SplitNamespace splits a store namespace into project and profile.
+// SplitNamespace("myapp@test") → "myapp", "test"
+func SplitNamespace(ns string) (project, profile string) {
+	if idx := strings.Index(ns, ProfileSeparator); idx >= 0 {
+		return ns[:idx], ns[idx+len(ProfileSeparator):]
+	}
+	return ns, ""
+}
+
+// StorePrefixes returns the store key prefixes to search, most specific
+// first: ["myapp@test.", "myapp."] with a profile, ["myapp."] without, and
+// [""] when the config has no project name.
+func (c *Config) StorePrefixes() []string {
+	if c.Project == "" {
+		return []string{""}
+	}
+	if c.Profile == "" {
+		return []string{c.Project + "."}
+	}
+	return []string{Namespace(c.Project, c.Profile) + ".", c.Project + "."}
+}
+
+// ProfileNames returns the names of all declared profiles, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasProfile reports whether the config declares the named profile.
+func (c *Config) HasProfile(name string) bool {
+	_, ok := c.Profiles[name]
+	return ok
+}
+
+// UsesKey reports whether key, a store key relative to the project's
+// namespace, is one the config pulls in: matched by an include pattern of
+// the config or one of its profiles, or renamed in mappings.
+func (c *Config) UsesKey(key string) bool {
+	patterns := append([]string{}, c.Include...)
+	for _, prof := range c.Profiles {
+		if prof != nil {
+			patterns = append(patterns, prof.Include...)
+		}
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	if _, ok := c.Mappings[key]; ok {
+		return true
+	}
+	for _, prof := range c.Profiles {
+		if prof == nil {
+			continue
+		}
+		if _, ok := prof.Mappings[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithProfile returns a copy of the config with the named profile layered
+// on top: includes are appended, map entries from the profile win.
+// An empty name returns the config unchanged.
+func (c *Config) WithProfile(name string) (*Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	if strings.ContainsAny(name, ". "+ProfileSeparator) {
+		return nil, fmt.Errorf("invalid profile name: %q", name)
+	}
+
+	prof, ok := c.Profiles[name]
+	if !ok {
+		if len(c.Profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile %q (project %s defines no profiles)", name, c.Project)
+		}
+		return nil, fmt.Errorf("unknown profile %q (defined: %s)", name, strings.Join(c.ProfileNames(), ", "))
+	}
+	if prof == nil {
+		prof = &Profile{}
+	}
+
+	merged := &Config{
+		Version:   c.Version,
+		Project:   c.Project,
+		Extends:   c.Extends,
+		Include:   appendUnique(append([]string{}, c.Include...), prof.Include...),
+		Overrides: mergeMaps(c.Overrides, prof.Overrides),
+		Mappings:  mergeMaps(c.Mappings, prof.Mappings),
+		Computed:  mergeMaps(c.Computed, prof.Computed),
+		Profiles:  c.Profiles,
+		Schema:    c.Schema,
+		Templates: c.Templates,
+		Outputs:   c.Outputs,
+		Profile:   name,
+		Parents:   c.Parents,
+	}
+	return merged, nil
+}
+
+// mergeMaps returns a new map with the entries of base, then overlay.
+func mergeMaps(base, overlay map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range overlay {
+		out[k] = v
+	}
+	return out
+}
+
+// appendUnique appends values to list, skipping ones already present.
+func appendUnique(list []string, values ...string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, v := range values {
+		if !seen[v] {
+			list = append(list, v)
+			seen[v] = true
+		}
+	}
+	return list
+}
@@ -0,0 +1,158 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	if got := Namespace("myapp", ""); got != "myapp" {
+		t.Errorf("Namespace(myapp, \"\") = %q", got)
+	}
+	if got := Namespace("myapp", "test"); got != "myapp@test" {
+		t.Errorf("Namespace(myapp, test) = %q", got)
+	}
+
+	proj, prof := SplitNamespace("myapp@test")
+	if proj != "myapp" || prof != "test" {
+		t.Errorf("SplitNamespace(myapp@test) = %q, %q", proj, prof)
+	}
+	proj, prof = SplitNamespace("myapp")
+	if proj != "myapp" || prof != "" {
+		t.Errorf("SplitNamespace(myapp) = %q, %q", proj, prof)
+	}
+}
+
+func TestWithProfile(t *testing.T) {
+	cfg := New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*"}
+	cfg.Overrides = map[string]string{"database.name": "dev", "log.level": "debug"}
+	cfg.Profiles = map[string]*Profile{
+		"test": {
+			Include:   []string{"database.*", "cache.*"},
+			Overrides: map[string]string{"database.name": "test"},
+			Computed:  map[string]string{"TEST_MODE": "true"},
+		},
+	}
+
+	merged, err := cfg.WithProfile("test")
+	if err != nil {
+		t.Fatalf("WithProfile() error: %v", err)
+	}
+
+	if merged.Profile != "test" {
+		t.Errorf("Profile = %q, want 'test'", merged.Profile)
+	}
+	if !reflect.DeepEqual(merged.Include, []string{"database.*", "cache.*"}) {
+		t.Errorf("Include = %v", merged.Include)
+	}
+	if merged.Overrides["database.name"] != "test" {
+		t.Errorf("profile override should win, got %q", merged.Overrides["database.name"])
+	}
+	if merged.Overrides["log.level"] != "debug" {
+		t.Errorf("base override should be kept, got %q", merged.Overrides["log.level"])
+	}
+	if merged.Computed["TEST_MODE"] != "true" {
+		t.Error("expected profile computed value")
+	}
+
+	// Base config must be untouched
+	if cfg.Overrides["database.name"] != "dev" || len(cfg.Include) != 1 {
+		t.Error("WithProfile modified the base config")
+	}
+}
+
+func TestWithProfileEmpty(t *testing.T) {
+	cfg := New()
+	got, err := cfg.WithProfile("")
+	if err != nil {
+		t.Fatalf("WithProfile(\"\") error: %v", err)
+	}
+	if got != cfg {
+		t.Error("empty profile should return the config unchanged")
+	}
+}
+
+func TestWithProfileUnknown(t *testing.T) {
+	cfg := New()
+	cfg.Project = "myapp"
+	cfg.Profiles = map[string]*Profile{"dev": {}, "test": {}}
+
+	_, err := cfg.WithProfile("tset")
+	if err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+	if !strings.Contains(err.Error(), "dev, test") {
+		t.Errorf("error should list defined profiles, got: %v", err)
+	}
+}
+
+func TestStorePrefixes(t *testing.T) {
+	cfg := New()
+	if got := cfg.StorePrefixes(); !reflect.DeepEqual(got, []string{""}) {
+		t.Errorf("no project: %v", got)
+	}
+
+	cfg.Project = "myapp"
+	if got := cfg.StorePrefixes(); !reflect.DeepEqual(got, []string{"myapp."}) {
+		t.Errorf("no profile: %v", got)
+	}
+
+	cfg.Profile = "test"
+	if got := cfg.StorePrefixes(); !reflect.DeepEqual(got, []string{"myapp@test.", "myapp."}) {
+		t.Errorf("with profile: %v", got)
+	}
+}
+
+func TestProfilesSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "myapp.yaml")
+
+	cfg := New()
+	cfg.Project = "myapp"
+	cfg.Profiles = map[string]*Profile{
+		"test":    {Overrides: map[string]string{"database.name": "test"}},
+		"staging": {},
+	}
+	if err := cfg.SaveTo(path); err != nil {
+		t.Fatalf("SaveTo() error: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "profile:") {
+		t.Errorf("active profile should not be serialized:\n%s", data)
+	}
+
+	loaded, err := LoadFrom(path)
+	if err != nil {
+		t.Fatalf("LoadFrom() error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.ProfileNames(), []string{"staging", "test"}) {
+		t.Errorf("ProfileNames() = %v", loaded.ProfileNames())
+	}
+	if loaded.Profiles["test"].Overrides["database.name"] != "test" {
+		t.Error("profile overrides not loaded")
+	}
+}
+
+func TestUsesKey(t *testing.T) {
+	cfg := New()
+	cfg.Include = []string{"db.*"}
+	cfg.Mappings = map[string]string{"legacy.url": "URL"}
+	cfg.Profiles = map[string]*Profile{"test": {Include: []string{"cache.host"}}, "empty": nil}
+
+	for key, want := range map[string]bool{
+		"db.host":    true,
+		"cache.host": true,
+		"legacy.url": true,
+		"region":     false,
+		"db":         false,
+	} {
+		if got := cfg.UsesKey(key); got != want {
+			t.Errorf("UsesKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -9,6 +9,11 @@
 //   - overrides: project-specific values that override the store
 //   - mappings: rename store keys to different env var names
 //   - computed: variables built from other variables (interpolation)
+//   - profiles: named variants (dev, test, ...) layered on top of the above
+//   - extends: parent projects whose config is inherited
+//   - schema: validation rules for values
+//   - templates: files rendered from the resolved variables (see render/render.go)
+//   - outputs: files "varnish env" writes, each with its own subset (see output.go)
 package project
 
 import (
@@ -16,18 +21,35 @@ import (
 	"os"
 
 	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/lock"
 	"github.com/dk/varnish/internal/registry"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the per-project configuration.
 type Config struct {
-	Version   int               `yaml:"version"`
-	Project   string            `yaml:"project,omitempty"`
-	Include   []string          `yaml:"include,omitempty"`
-	Overrides map[string]string `yaml:"overrides,omitempty"`
-	Mappings  map[string]string `yaml:"mappings,omitempty"`
-	Computed  map[string]string `yaml:"computed,omitempty"`
+	Version   int                 `yaml:"version"`
+	Project   string              `yaml:"project,omitempty"`
+	Extends   []string            `yaml:"extends,omitempty"`
+	Include   []string            `yaml:"include,omitempty"`
+	Overrides map[string]string   `yaml:"overrides,omitempty"`
+	Mappings  map[string]string   `yaml:"mappings,omitempty"`
+	Computed  map[string]string   `yaml:"computed,omitempty"`
+	Profiles  map[string]*Profile `yaml:"profiles,omitempty"`
+	Schema    map[string]*Rule    `yaml:"schema,omitempty"`
+
+	// Templates maps template files to the files "varnish render" writes
+	// from them. Relative paths are relative to the project directory.
+	Templates map[string]string `yaml:"templates,omitempty"`
+
+	// Outputs are the files plain "varnish env" writes (see output.go).
+	Outputs []*Output `yaml:"outputs,omitempty"`
+
+	// Profile is the active profile after WithProfile, not serialized.
+	Profile string `yaml:"-"`
+
+	// Parents are the loaded configs named in Extends, not serialized.
+	Parents []*Config `yaml:"-"`
 }
 
 // New creates an empty project config with version 1.
@@ -61,9 +83,9 @@ func Load() (*Config, error) {
 
 // LoadByName loads a project config by project name.
 // Looks for ~/.varnish/projects/<project>.yaml
+// Parent projects named in extends are loaded too.
 func LoadByName(name string) (*Config, error) {
-	path := config.ProjectConfigPathFor(name)
-	return LoadFrom(path)
+	return loadByName(name, nil)
 }
 
 // LoadFrom reads a project config from a specific path.
@@ -92,6 +114,9 @@ func LoadFrom(path string) (*Config, error) {
 		cfg.Computed = make(map[string]string)
 	}
 
+	// Compile schema rules now; varnish check reports the invalid ones
+	cfg.CheckSchema()
+
 	return &cfg, nil
 }
 
@@ -118,7 +143,13 @@ func (c *Config) SaveTo(path string) error {
 		return fmt.Errorf("marshal project config: %w", err)
 	}
 
-	// Use atomic write for safety
+	// Lock against concurrent writers; the write itself is atomic
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
 	if err := config.AtomicWrite(path, data, config.PermConfig); err != nil {
 		return fmt.Errorf("write project config: %w", err)
 	}
@@ -126,6 +157,28 @@ func (c *Config) SaveTo(path string) error {
 	return nil
 }
 
+// Update loads a project's config, passes it to fn, and saves it if fn
+// succeeds, holding the config's lock throughout. Parent projects are not
+// loaded.
+func Update(name string, fn func(*Config) error) error {
+	path := config.ProjectConfigPathFor(name)
+
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	cfg, err := LoadFrom(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	return cfg.SaveTo(path)
+}
+
 // Exists checks if a project config exists for the given name.
 func Exists(name string) bool {
 	path := config.ProjectConfigPathFor(name)
@@ -136,7 +189,14 @@ func Exists(name string) bool {
 // Delete removes a project's config file.
 func Delete(name string) error {
 	path := config.ProjectConfigPathFor(name)
-	err := os.Remove(path)
+
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	err = os.Remove(path)
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -0,0 +1,230 @@
+// schema.go implements value validation rules for project configs.
+//
+// The schema section declares rules per key (store key or env name):
+//
+//	schema:
+//	  database.port:
+//	    type: port
+//	    required: true
+//	    description: Postgres port
+//	  LOG_LEVEL:
+//	    enum: [debug, info, warn, error]
+//	  api.key:
+//	    regex: "sk_[a-z0-9]{16}"
+//	    min: 19
+//
+// Types: string (default), int, bool, url, port, duration, email.
+// min/max bound the number for int and port, the length in characters for
+// string, url and email, and the number of seconds for duration.
+// regex must match the whole value.
+//
+// Rules are compiled when the config is loaded; an unknown type or a bad
+// regex is reported by CheckSchema and by every Validate call, whether or
+// not the key has a value.
+//
+// Messages never include the value itself, since it may be a secret.
+package project
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SchemaTypes lists the supported value types.
+var SchemaTypes = []string{"string", "int", "bool", "url", "port", "duration", "email"}
+
+// Rule describes the values a key accepts.
+type Rule struct {
+	Type        string   `yaml:"type,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Regex       string   `yaml:"regex,omitempty"`
+	Enum        []string `yaml:"enum,omitempty"`
+	Min         *float64 `yaml:"min,omitempty"`
+	Max         *float64 `yaml:"max,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+
+	compiled bool
+	re       *regexp.Regexp // Compiled Regex, anchored to the whole value
+	err      error          // Why the rule itself is invalid
+}
+
+// Violation is a value that breaks a schema rule.
+type Violation struct {
+	Key     string // Schema key (store key or env name)
+	Message string
+}
+
+func (v Violation) Error() string {
+	return v.Key + ": " + v.Message
+}
+
+// Compile checks the rule's type and compiles its regex. The result is
+// kept, so later calls (and Validate) don't compile it again.
+func (r *Rule) Compile() error {
+	if r.compiled {
+		return r.err
+	}
+	r.compiled = true
+
+	if r.Type != "" && !slices.Contains(SchemaTypes, r.Type) {
+		r.err = fmt.Errorf("unknown schema type %q (supported: %s)", r.Type, strings.Join(SchemaTypes, ", "))
+		return r.err
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			r.err = fmt.Errorf("invalid regex %q: %w", r.Regex, err)
+			return r.err
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// Validate checks a value against the rule and returns every problem found.
+// An invalid rule is the only problem reported, even for an empty value.
+func (r *Rule) Validate(value string) []string {
+	if err := r.Compile(); err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+
+	// Optional values may be empty
+	if value == "" {
+		if r.Required {
+			return []string{"required but empty"}
+		}
+		return nil
+	}
+
+	// Type check; measure is what min/max compare against
+	var measure float64
+	hasMeasure := true
+	switch r.Type {
+	case "", "string":
+		measure = float64(utf8.RuneCountInString(value))
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			problems = append(problems, "must be an integer")
+			hasMeasure = false
+		}
+		measure = float64(n)
+	case "port":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 65535 {
+			problems = append(problems, "must be a port number (1-65535)")
+			hasMeasure = false
+		}
+		measure = float64(n)
+	case "bool":
+		if _, ok := parseBool(value); !ok {
+			problems = append(problems, "must be a boolean (true/false, yes/no, on/off, 1/0)")
+		}
+		hasMeasure = false
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, "must be a URL with a scheme and host")
+		}
+		measure = float64(utf8.RuneCountInString(value))
+	case "duration":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			problems = append(problems, "must be a duration (e.g. 30s, 5m, 1h30m)")
+			hasMeasure = false
+		}
+		measure = d.Seconds()
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			problems = append(problems, "must be an email address")
+		}
+		measure = float64(utf8.RuneCountInString(value))
+	}
+
+	if hasMeasure {
+		unit := ""
+		switch r.Type {
+		case "", "string", "url", "email":
+			unit = " characters"
+		case "duration":
+			unit = " seconds"
+		}
+		if r.Min != nil && measure < *r.Min {
+			problems = append(problems, fmt.Sprintf("must be at least %s%s", formatBound(*r.Min), unit))
+		}
+		if r.Max != nil && measure > *r.Max {
+			problems = append(problems, fmt.Sprintf("must be at most %s%s", formatBound(*r.Max), unit))
+		}
+	}
+
+	if len(r.Enum) > 0 {
+		found := false
+		for _, allowed := range r.Enum {
+			if value == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("must be one of: %s", strings.Join(r.Enum, ", ")))
+		}
+	}
+
+	if r.re != nil && !r.re.MatchString(value) {
+		problems = append(problems, fmt.Sprintf("must match %s", r.Regex))
+	}
+
+	return problems
+}
+
+// CheckSchema compiles every rule and returns the invalid ones, sorted by
+// key.
+func (c *Config) CheckSchema() []Violation {
+	var invalid []Violation
+	for _, key := range c.SchemaKeys() {
+		if rule := c.Schema[key]; rule != nil {
+			if err := rule.Compile(); err != nil {
+				invalid = append(invalid, Violation{Key: key, Message: err.Error()})
+			}
+		}
+	}
+	return invalid
+}
+
+// SchemaKeys returns the keys with rules, sorted.
+func (c *Config) SchemaKeys() []string {
+	keys := make([]string, 0, len(c.Schema))
+	for key := range c.Schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseBool accepts the usual spellings of a boolean in env files.
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "yes", "on":
+		return true, true
+	case "no", "off":
+		return false, true
+	}
+	b, err := strconv.ParseBool(s)
+	return b, err == nil
+}
+
+// formatBound prints a min/max bound without a trailing ".0".
+func formatBound(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
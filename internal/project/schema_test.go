@@ -0,0 +1,122 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func float(f float64) *float64 { return &f }
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		value string
+		want  []string // substrings of expected problems, in order
+	}{
+		{"string ok", Rule{}, "anything", nil},
+		{"optional empty", Rule{Type: "int"}, "", nil},
+		{"required empty", Rule{Required: true}, "", []string{"required"}},
+		{"int ok", Rule{Type: "int"}, "-42", nil},
+		{"int bad", Rule{Type: "int"}, "abc", []string{"integer"}},
+		{"int range", Rule{Type: "int", Min: float(1), Max: float(10)}, "11", []string{"at most 10"}},
+		{"port ok", Rule{Type: "port"}, "5432", nil},
+		{"port bad", Rule{Type: "port"}, "abc", []string{"port number"}},
+		{"port zero", Rule{Type: "port"}, "0", []string{"port number"}},
+		{"bool ok", Rule{Type: "bool"}, "yes", nil},
+		{"bool bad", Rule{Type: "bool"}, "maybe", []string{"boolean"}},
+		{"url ok", Rule{Type: "url"}, "postgres://u:p@db:5432/app", nil},
+		{"url bad", Rule{Type: "url"}, "localhost:5432", []string{"URL"}},
+		{"duration ok", Rule{Type: "duration", Max: float(60)}, "30s", nil},
+		{"duration max", Rule{Type: "duration", Max: float(60)}, "5m", []string{"at most 60 seconds"}},
+		{"duration bad", Rule{Type: "duration"}, "5 minutes", []string{"duration"}},
+		{"email ok", Rule{Type: "email"}, "ops@example.com", nil},
+		{"email bad", Rule{Type: "email"}, "Ops <ops@example.com>", []string{"email"}},
+		{"length", Rule{Min: float(8)}, "short", []string{"at least 8 characters"}},
+		{"enum ok", Rule{Enum: []string{"debug", "info"}}, "info", nil},
+		{"enum bad", Rule{Enum: []string{"debug", "info"}}, "trace", []string{"one of: debug, info"}},
+		{"regex ok", Rule{Regex: "sk_[a-z0-9]+"}, "sk_abc123", nil},
+		{"regex whole value", Rule{Regex: "sk_[a-z0-9]+"}, "xsk_abc", []string{"must match"}},
+		{"regex invalid", Rule{Regex: "("}, "x", []string{"invalid regex"}},
+		{"unknown type", Rule{Type: "uuid"}, "x", []string{"unknown schema type"}},
+		{"unknown type empty", Rule{Type: "uuid"}, "", []string{"unknown schema type"}},
+		{"several", Rule{Type: "int", Min: float(1000), Enum: []string{"8080"}}, "80", []string{"at least 1000", "one of: 8080"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rule.Validate(tt.value)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Validate(%q) = %v, want %d problem(s)", tt.value, got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("problem %d = %q, want containing %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaYAML(t *testing.T) {
+	data := []byte(`version: 1
+project: myapp
+schema:
+  database.port:
+    type: port
+    required: true
+    description: Postgres port
+  LOG_LEVEL:
+    enum: [debug, info]
+`)
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if got, want := cfg.SchemaKeys(), []string{"LOG_LEVEL", "database.port"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SchemaKeys = %v, want %v", got, want)
+	}
+	port := cfg.Schema["database.port"]
+	if port.Type != "port" || !port.Required || port.Description != "Postgres port" {
+		t.Errorf("database.port rule = %+v", port)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "myapp.yaml")
+	data := []byte(`version: 1
+project: myapp
+schema:
+  api.key:
+    regex: "sk_("
+  database.port:
+    type: port
+  LOG_LEVEL:
+    type: level
+`)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFrom(path)
+	if err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+	if !cfg.Schema["api.key"].compiled {
+		t.Error("rules should be compiled when the config loads")
+	}
+
+	var got []string
+	for _, v := range cfg.CheckSchema() {
+		got = append(got, v.Key)
+	}
+	if want := []string{"LOG_LEVEL", "api.key"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckSchema keys = %v, want %v", got, want)
+	}
+}
@@ -3,6 +3,9 @@
 // The registry lives at ~/.varnish/registry.yaml and maps absolute
 // directory paths to project names. This allows varnish to know which
 // project config to use based on the current working directory.
+//
+// Load and Save hold an advisory lock on the registry file; use Update for
+// read-modify-write sequences (see lock/lock.go).
 package registry
 
 import (
@@ -11,6 +14,7 @@ import (
 	"sort"
 
 	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/lock"
 	"gopkg.in/yaml.v3"
 )
 
@@ -33,6 +37,18 @@ func New() *Registry {
 func Load() (*Registry, error) {
 	path := config.RegistryPath()
 
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lk.Release()
+
+	return LoadFrom(path)
+}
+
+// LoadFrom reads a registry from a specific path.
+// Returns an empty registry if the file doesn't exist.
+func LoadFrom(path string) (*Registry, error) {
 	data, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
 		return New(), nil
@@ -59,6 +75,41 @@ func (r *Registry) Save() error {
 		return err
 	}
 
+	lk, err := lock.Acquire(config.RegistryPath())
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	return r.save()
+}
+
+// Update loads the registry, passes it to fn, and saves it if fn succeeds,
+// holding the registry lock throughout.
+func Update(fn func(*Registry) error) error {
+	if err := config.EnsureVarnishDir(); err != nil {
+		return err
+	}
+
+	path := config.RegistryPath()
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	r, err := LoadFrom(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(r); err != nil {
+		return err
+	}
+	return r.save()
+}
+
+// save writes the registry. The caller holds the lock.
+func (r *Registry) save() error {
 	data, err := yaml.Marshal(r)
 	if err != nil {
 		return err
@@ -89,14 +140,21 @@ func (r *Registry) Unregister(dir string) {
 // It checks the directory and all parent directories.
 // Returns empty string if not found.
 func (r *Registry) Lookup(dir string) string {
+	project, _ := r.LookupRoot(dir)
+	return project
+}
+
+// LookupRoot is like Lookup but also returns the registered directory the
+// project was found for: dir itself or the nearest parent registered.
+func (r *Registry) LookupRoot(dir string) (project, root string) {
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
-		return ""
+		return "", ""
 	}
 
 	// Check exact match first
 	if project, ok := r.Projects[absDir]; ok {
-		return project
+		return project, absDir
 	}
 
 	// Check parent directories
@@ -106,12 +164,12 @@ func (r *Registry) Lookup(dir string) string {
 			break // reached root
 		}
 		if project, ok := r.Projects[parent]; ok {
-			return project
+			return project, parent
 		}
 		absDir = parent
 	}
 
-	return ""
+	return "", ""
 }
 
 // LookupCurrent finds the project for the current working directory.
@@ -190,6 +190,20 @@ func TestLookupParentDirs(t *testing.T) {
 	}
 }
 
+func TestLookupRoot(t *testing.T) {
+	reg := New()
+	reg.Register("/home/user/projects/myapp", "myapp")
+
+	want, _ := filepath.Abs("/home/user/projects/myapp")
+	project, root := reg.LookupRoot("/home/user/projects/myapp/src/cmd")
+	if project != "myapp" || root != want {
+		t.Errorf("LookupRoot() = %q, %q", project, root)
+	}
+	if project, root := reg.LookupRoot("/home/user/other"); project != "" || root != "" {
+		t.Errorf("LookupRoot() of unregistered dir = %q, %q", project, root)
+	}
+}
+
 func TestLoadSaveWithRealPath(t *testing.T) {
 	// Create a temp HOME to test Load() and Save() with real paths
 	tmpHome, err := os.MkdirTemp("", "varnish-home-*")
@@ -0,0 +1,124 @@
+// manifest.go records the files "varnish render" wrote, so it only ever
+// overwrites its own output.
+//
+// This file is used by:
+//   - cli/render.go: checks an output before replacing it, records it after
+//
+// Rendered files can be in any format, JSON included, so they carry no
+// "generated" comment. Instead ~/.varnish/rendered.yaml maps each output's
+// absolute path to the SHA-256 of the content written. A file is varnish's
+// to replace if its content still has that hash; a file edited since, or
+// never rendered, is left alone unless --force is given.
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/lock"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest maps rendered files to the hash of their content.
+type Manifest struct {
+	Version int               `yaml:"version"`
+	Files   map[string]string `yaml:"files"` // absolute path -> sha256 hex
+}
+
+// Owner says whether an existing file may be replaced.
+type Owner int
+
+const (
+	// Missing means there is no file to replace.
+	Missing Owner = iota
+	// Rendered means varnish wrote the file and it is unchanged.
+	Rendered
+	// Edited means varnish wrote the file but it has changed since.
+	Edited
+	// Foreign means varnish never wrote the file.
+	Foreign
+)
+
+// UpdateManifest loads the manifest, passes it to fn, and saves it if fn
+// succeeds, holding the manifest lock throughout.
+func UpdateManifest(fn func(*Manifest) error) error {
+	if err := config.EnsureVarnishDir(); err != nil {
+		return err
+	}
+	path, err := config.RenderedPath()
+	if err != nil {
+		return err
+	}
+
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	m, err := loadManifest(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(m); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return config.AtomicWrite(path, data, config.PermConfig)
+}
+
+// loadManifest reads the manifest, or returns an empty one.
+func loadManifest(path string) (*Manifest, error) {
+	m := &Manifest{Version: 1, Files: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]string)
+	}
+	return m, nil
+}
+
+// Owner reports who the file at path, an absolute path, belongs to.
+func (m *Manifest) Owner(path string) (Owner, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Missing, nil
+	}
+	if err != nil {
+		return Foreign, err
+	}
+	sum, ok := m.Files[path]
+	switch {
+	case !ok:
+		return Foreign, nil
+	case sum != hash(data):
+		return Edited, nil
+	default:
+		return Rendered, nil
+	}
+}
+
+// Record notes that content was written to path, an absolute path.
+func (m *Manifest) Record(path string, content []byte) {
+	m.Files[path] = hash(content)
+}
+
+// hash returns the hex SHA-256 of data.
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
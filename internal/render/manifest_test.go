@@ -0,0 +1,52 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestOwner(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	path := filepath.Join(dir, "app.conf")
+
+	err := UpdateManifest(func(m *Manifest) error {
+		if owner, err := m.Owner(path); err != nil || owner != Missing {
+			t.Errorf("Owner() of missing file = %v, %v", owner, err)
+		}
+
+		if err := os.WriteFile(path, []byte("hand-written\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := m.Owner(path); owner != Foreign {
+			t.Errorf("Owner() of unrecorded file = %v, want Foreign", owner)
+		}
+
+		if err := os.WriteFile(path, []byte("rendered\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		m.Record(path, []byte("rendered\n"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateManifest() error: %v", err)
+	}
+
+	// The record survives a reload
+	err = UpdateManifest(func(m *Manifest) error {
+		if owner, _ := m.Owner(path); owner != Rendered {
+			t.Errorf("Owner() of rendered file = %v, want Rendered", owner)
+		}
+		if err := os.WriteFile(path, []byte("edited\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := m.Owner(path); owner != Edited {
+			t.Errorf("Owner() of edited file = %v, want Edited", owner)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateManifest() error: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package render renders config files from templates with the resolved
+// variables of a project.
+//
+// This package is used by:
+//   - cli/render.go: "varnish render"
+//
+// Templates are Go text/template files listed under templates: in the
+// project config. They see the resolved variables by env name, and can
+// look up any key of the project's store namespace:
+//
+//	server {
+//	    listen {{ .Env.PORT | default "8080" }};
+//	    server_name {{ required "HOST is not set" .Env.HOST }};
+//	    set $api_key {{ key "api.key" | quote }};
+//	}
+//
+// Functions, in addition to the text/template builtins:
+//
+//	key "db.host"        a store key, profile first, then project and ancestors
+//	default "x" VALUE    VALUE, or "x" if it is empty
+//	required "msg" VALUE VALUE, or fail the render with msg if it is empty
+//	quote VALUE          VALUE in double quotes, escaped
+//	b64enc VALUE         VALUE base64-encoded
+//	toJson VALUE         VALUE as JSON: {{ toJson .Env }} writes an object
+//
+// A variable that isn't set renders as an empty string, so default and
+// required can handle it.
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"text/template"
+)
+
+// Data is what templates are executed with.
+type Data struct {
+	Env     map[string]string // Resolved variables by env name: {{ .Env.DB_HOST }}
+	Project string
+	Profile string
+}
+
+// Execute renders the template text, named name in errors. key looks up
+// store keys for the key function.
+func Execute(name, text string, data Data, key func(string) string) ([]byte, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=zero").
+		Funcs(Funcs(key)).
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Funcs returns the template functions, with key looking up store keys.
+func Funcs(key func(string) string) template.FuncMap {
+	return template.FuncMap{
+		"key": key,
+		"default": func(def, value string) string {
+			if value == "" {
+				return def
+			}
+			return value
+		},
+		"required": func(msg, value string) (string, error) {
+			if value == "" {
+				return "", errors.New(msg)
+			}
+			return value, nil
+		},
+		"quote": strconv.Quote,
+		"b64enc": func(value string) string {
+			return base64.StdEncoding.EncodeToString([]byte(value))
+		},
+		"toJson": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("toJson: %w", err)
+			}
+			return string(data), nil
+		},
+	}
+}
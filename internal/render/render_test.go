@@ -0,0 +1,62 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	data := Data{
+		Env:     map[string]string{"HOST": "example.com", "PASSWORD": `pa"ss`, "EMPTY": ""},
+		Project: "myapp",
+		Profile: "dev",
+	}
+	store := map[string]string{"db.port": "5432"}
+	key := func(k string) string { return store[k] }
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"env", "host={{ .Env.HOST }}", "host=example.com"},
+		{"missing env", "x={{ .Env.NOPE }}", "x="},
+		{"project and profile", "{{ .Project }}/{{ .Profile }}", "myapp/dev"},
+		{"key", `port={{ key "db.port" }}`, "port=5432"},
+		{"missing key", `{{ key "db.nope" }}`, ""},
+		{"default used", `{{ .Env.NOPE | default "8080" }}`, "8080"},
+		{"default empty", `{{ .Env.EMPTY | default "x" }}`, "x"},
+		{"default unused", `{{ .Env.HOST | default "x" }}`, "example.com"},
+		{"required", `{{ required "need host" .Env.HOST }}`, "example.com"},
+		{"quote", `{{ .Env.PASSWORD | quote }}`, `"pa\"ss"`},
+		{"b64enc", `{{ .Env.HOST | b64enc }}`, "ZXhhbXBsZS5jb20="},
+		{"toJson string", `{{ toJson .Env.PASSWORD }}`, `"pa\"ss"`},
+		{"toJson map", `{{ toJson .Env }}`, `{"EMPTY":"","HOST":"example.com","PASSWORD":"pa\"ss"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Execute("test.tmpl", tt.text, data, key)
+			if err != nil {
+				t.Fatalf("Execute() error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	key := func(string) string { return "" }
+	data := Data{Env: map[string]string{}}
+
+	_, err := Execute("app.tmpl", `{{ required "HOST is not set" .Env.HOST }}`, data, key)
+	if err == nil || !strings.Contains(err.Error(), "HOST is not set") {
+		t.Errorf("required error = %v", err)
+	}
+
+	_, err = Execute("app.tmpl", "{{ .Env.HOST ", data, key)
+	if err == nil || !strings.Contains(err.Error(), "app.tmpl") {
+		t.Errorf("parse error = %v, want it to name the template", err)
+	}
+}
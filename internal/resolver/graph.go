@@ -0,0 +1,120 @@
+// graph.go orders computed values by their dependencies.
+//
+// A computed value can reference another computed value, either by its
+// env name or by the equivalent key:
+//
+//	computed:
+//	  DATABASE_URL: "postgres://${database.host}/${database.name}"
+//	  MIGRATE_URL: "${DATABASE_URL}?sslmode=disable"   # by env name
+//	  REPORT_URL: "${database.url}/reports"            # by key (DATABASE_URL)
+//
+// Values are evaluated in topological order so each reference sees the
+// final value of what it depends on, regardless of map iteration order.
+// Cycles are reported as a CycleError.
+package resolver
+
+import (
+	"sort"
+	"strings"
+)
+
+// CycleError reports a dependency cycle between computed values.
+type CycleError struct {
+	Cycle []string // env names, first and last are the same
+}
+
+func (e *CycleError) Error() string {
+	return "circular dependency in computed values: " + strings.Join(e.Cycle, " -> ")
+}
+
+// computedVar is a computed template and the project that declared it.
+type computedVar struct {
+	template string
+	origin   string
+}
+
+// computedRef returns the computed env name a reference from the computed
+// value self points to, if any. A reference to a logical key in values or
+// in the store is never a computed reference, so store and override values
+// keep precedence when names collide. The key form never points back at
+// self: "DATABASE_URL: ${database.url}?sslmode=disable" reads database.url,
+// it doesn't reference itself.
+func (r *Resolver) computedRef(ref, self string, computed map[string]computedVar, values map[string]entry) (string, bool) {
+	if _, ok := values[ref]; ok {
+		return "", false
+	}
+	if _, ok := r.lookupStoreKey(ref); ok {
+		return "", false
+	}
+	if _, ok := r.store.Variables[ref]; ok {
+		return "", false
+	}
+	if _, ok := computed[ref]; ok {
+		return ref, true
+	}
+	if envName := r.keyToEnvName(ref); envName != ref && envName != self {
+		if _, ok := computed[envName]; ok {
+			return envName, true
+		}
+	}
+	return "", false
+}
+
+// computedOrder returns computed env names in evaluation order: every value
+// comes after the computed values it references. Ties are broken by name,
+// so the order is deterministic.
+func (r *Resolver) computedOrder(computed map[string]computedVar, values map[string]entry) ([]string, error) {
+	names := make([]string, 0, len(computed))
+	for name := range computed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			// Extract the cycle from the current path
+			for i, n := range path {
+				if n == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					return &CycleError{Cycle: cycle}
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, ref := range templateRefs(computed[name].template) {
+			if dep, ok := r.computedRef(ref, name, computed, values); ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,109 @@
+package resolver
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/store"
+)
+
+func TestComputedReferencesComputed(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.host", "db")
+	s.Set("myapp.database.name", "app")
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*"}
+	cfg.Computed = map[string]string{
+		// Names chosen so alphabetical order is the wrong evaluation order
+		"A_REPORT_URL": "${database.url}/reports",
+		"B_MIGRATE":    "${DATABASE_URL}?sslmode=disable",
+		"DATABASE_URL": "postgres://${database.host}/${database.name}",
+	}
+
+	// Run several times: the result must not depend on map iteration order
+	for i := 0; i < 20; i++ {
+		varMap := make(map[string]string)
+		for _, v := range mustResolve(t, New(s, cfg)) {
+			varMap[v.EnvName] = v.Value
+		}
+
+		if varMap["A_REPORT_URL"] != "postgres://db/app/reports" {
+			t.Fatalf("A_REPORT_URL = %q", varMap["A_REPORT_URL"])
+		}
+		if varMap["B_MIGRATE"] != "postgres://db/app?sslmode=disable" {
+			t.Fatalf("B_MIGRATE = %q", varMap["B_MIGRATE"])
+		}
+	}
+}
+
+func TestComputedCycle(t *testing.T) {
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Computed = map[string]string{
+		"A": "${B}",
+		"B": "${c}", // key form of C
+		"C": "${A}",
+		"D": "standalone",
+	}
+
+	_, err := New(store.New(), cfg).Resolve()
+	var cycleErr *CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected CycleError, got %v", err)
+	}
+	if want := []string{"A", "B", "C", "A"}; !reflect.DeepEqual(cycleErr.Cycle, want) {
+		t.Errorf("Cycle = %v, want %v", cycleErr.Cycle, want)
+	}
+}
+
+func TestComputedSelfReference(t *testing.T) {
+	cfg := project.New()
+	cfg.Computed = map[string]string{"PATH_EXT": "${PATH_EXT}:/opt/bin"}
+
+	_, err := New(store.New(), cfg).Resolve()
+	var cycleErr *CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected CycleError, got %v", err)
+	}
+}
+
+func TestComputedStoreKeyWinsOverComputedKeyForm(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.url", "from-store")
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*"}
+	cfg.Computed = map[string]string{
+		"DATABASE_URL": "computed",
+		"USES_KEY":     "${database.url}",
+	}
+
+	for _, v := range mustResolve(t, New(s, cfg)) {
+		if v.EnvName == "USES_KEY" && v.Value != "from-store" {
+			t.Errorf("USES_KEY = %q, want the store value", v.Value)
+		}
+	}
+}
+
+func TestComputedKeyFormReadsStoreNotItself(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.url", "postgres://db/app")
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"api.*"} // database.url is only in the store
+	cfg.Computed = map[string]string{
+		"DATABASE_URL": "${database.url}?sslmode=disable",
+	}
+
+	for _, v := range mustResolve(t, New(s, cfg)) {
+		if v.EnvName == "DATABASE_URL" && v.Value != "postgres://db/app?sslmode=disable" {
+			t.Errorf("DATABASE_URL = %q, want the store value with sslmode", v.Value)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// provenance.go records where each resolved value came from.
+//
+// Every ResolvedVar carries a Provenance: the store key and include pattern
+// that produced it, the mapping that renamed it, the template and inputs of
+// a computed value, and the values it replaced along the way. This is what
+// "varnish explain" prints.
+package resolver
+
+// Provenance describes how a variable got its value.
+type Provenance struct {
+	StoreKey   string   `json:"store_key,omitempty"`  // Full store key (e.g., myapp@test.database.host)
+	Pattern    string   `json:"pattern,omitempty"`    // Include pattern that matched StoreKey
+	Mapping    string   `json:"mapping,omitempty"`    // Key whose mapping produced the env name
+	Template   string   `json:"template,omitempty"`   // Template of a computed value
+	Inputs     []Input  `json:"inputs,omitempty"`     // References the template looked up, in order
+	Unresolved []string `json:"unresolved,omitempty"` // Plain ${key} references nothing matched
+	Shadowed   []Step   `json:"shadowed,omitempty"`   // Values this one replaced, most recent first
+}
+
+// Step is a value a variable had before something with higher precedence
+// replaced it.
+type Step struct {
+	Value    string `json:"value"`
+	Source   string `json:"source"` // "store", "override", or "computed"
+	Key      string `json:"key,omitempty"`
+	Origin   string `json:"origin,omitempty"`
+	StoreKey string `json:"store_key,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+}
+
+// Input is a reference looked up while rendering a computed template.
+type Input struct {
+	Ref    string `json:"ref"`              // As written in the template
+	Value  string `json:"value"`            // Value it resolved to
+	Found  bool   `json:"found"`            // False if nothing matched (default used or left as-is)
+	Source string `json:"source,omitempty"` // "store", "override", or "computed"
+	Name   string `json:"name,omitempty"`   // Store key or computed env name it came from
+
+	Sensitive bool `json:"sensitive"` // Value is a secret (see package secret)
+}
+
+// entry is a value during resolution, before it becomes a ResolvedVar.
+type entry struct {
+	value    string
+	source   string
+	key      string
+	origin   string
+	storeKey string
+	pattern  string
+	shadowed []Step
+}
+
+func (e entry) step() Step {
+	return Step{
+		Value:    e.value,
+		Source:   e.source,
+		Key:      e.key,
+		Origin:   e.origin,
+		StoreKey: e.storeKey,
+		Pattern:  e.pattern,
+	}
+}
+
+// replace returns next with prev recorded as shadowed. The same store key
+// matched twice (by two include patterns) is not a replacement, so prev is
+// kept and reports the first pattern that matched.
+func replace(prev, next entry) entry {
+	if prev.source == next.source && prev.storeKey == next.storeKey && prev.origin == next.origin && prev.key == next.key {
+		return prev
+	}
+	next.shadowed = append([]Step{prev.step()}, prev.shadowed...)
+	return next
+}
@@ -1,26 +1,35 @@
 // Package resolver combines a Store and project Config to produce environment variables.
 //
 // Resolution order (later wins):
-//  1. Store variables matching Include patterns
+//  1. Store variables matching Include patterns (profile namespace wins
+//     over the project namespace when a profile is active)
 //  2. Overrides from project config
 //  3. Computed values (with interpolation)
 //
+// With extends, steps 1 and 2 run for each ancestor project (in its own
+// store namespace) before the project itself, so the project's own values
+// win over inherited ones.
+//
 // Key transformation:
 //   - Store keys like "database.host" become "DATABASE_HOST"
 //   - Mappings can override this: mappings: { database.url: DB_URL }
 //
 // Interpolation in computed values:
 //   - ${database.host} is replaced with the value of database.host
-//   - Supports nested references to other computed values
+//   - ${DATABASE_URL} or ${database.url} refers to another computed value;
+//     computed values are evaluated in dependency order (see graph.go)
+//   - ${key:-default}, ${key:?message}, ${key:+alt}, $${ escapes and
+//     filters like ${key | urlencode} (see template.go)
 package resolver
 
 import (
+	"fmt"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/dk/varnish/internal/project"
+	"github.com/dk/varnish/internal/secret"
 	"github.com/dk/varnish/internal/store"
 )
 
@@ -30,102 +39,219 @@ type ResolvedVar struct {
 	Value   string // The resolved value
 	Source  string // Where it came from: "store", "override", or "computed"
 	Key     string // Original store key (e.g., database.host)
+	Origin  string // Project whose config supplied it (differs from the project for inherited values)
+
+	// Sensitive marks secrets: flagged in the store, named like a
+	// credential, or computed from a secret. Mask it in human output.
+	Sensitive bool
+
+	Provenance *Provenance // How the value was chosen (see provenance.go)
 }
 
 // Resolver combines store and project config to produce env vars.
 type Resolver struct {
-	store   *store.Store
-	project *project.Config
+	store    *store.Store
+	project  *project.Config
+	layers   []*project.Config // ancestors first, project last
+	mappings map[string]string // merged across layers, later wins
 }
 
 // New creates a resolver with the given store and project config.
+// Parent projects (extends) must already be loaded into p.Parents.
 func New(s *store.Store, p *project.Config) *Resolver {
+	layers := p.Ancestry()
+
+	mappings := make(map[string]string)
+	for _, layer := range layers {
+		for key, envName := range layer.Mappings {
+			mappings[key] = envName
+		}
+	}
+
 	return &Resolver{
-		store:   s,
-		project: p,
+		store:    s,
+		project:  p,
+		layers:   layers,
+		mappings: mappings,
+	}
+}
+
+// Computed returns the computed templates merged across the project and the
+// projects it extends, keyed by env name. Later layers win.
+func (r *Resolver) Computed() map[string]string {
+	computed := make(map[string]string)
+	for _, layer := range r.layers {
+		for envName, template := range layer.Computed {
+			computed[envName] = template
+		}
 	}
+	return computed
 }
 
 // Resolve produces the final set of environment variables.
 // Returns them sorted by EnvName for consistent output.
-func (r *Resolver) Resolve() []ResolvedVar {
-	// Internal map: logical key (without project prefix) → value and source
-	type intermediate struct {
-		value  string
-		source string
-	}
-	resolved := make(map[string]intermediate)
-
-	// Step 1: Match store variables against Include patterns
-	// If project is set, we look for "project.pattern" in store
-	prefix := ""
-	if r.project.Project != "" {
-		prefix = r.project.Project + "."
-	}
-
-	for _, pattern := range r.project.Include {
-		// The actual pattern to match in store
-		storePattern := prefix + pattern
-
-		for storeKey, value := range r.store.Variables {
-			if matchPattern(storePattern, storeKey) {
-				// Strip prefix from key for the logical name
-				logicalKey := storeKey
-				if prefix != "" && strings.HasPrefix(storeKey, prefix) {
-					logicalKey = strings.TrimPrefix(storeKey, prefix)
+// Returns a *CycleError if computed values depend on each other in a cycle,
+// or an error if a template is invalid or a ${key:?message} is not set.
+func (r *Resolver) Resolve() ([]ResolvedVar, error) {
+	// Internal map: logical key (without project prefix) → value and provenance
+	resolved := make(map[string]entry)
+	set := func(key string, e entry) {
+		if prev, ok := resolved[key]; ok {
+			e = replace(prev, e)
+		}
+		resolved[key] = e
+	}
+
+	// Steps 1 and 2 run once per layer: inherited projects first, then
+	// this project, so the project's own values win.
+	storeKeys := r.store.Keys()
+	for _, layer := range r.layers {
+		// Step 1: Match store variables against Include patterns
+		// If project is set, we look for "project.pattern" in store. With a
+		// profile, "project@profile.pattern" is matched afterwards so it wins.
+		prefixes := layer.StorePrefixes()
+		for i := len(prefixes) - 1; i >= 0; i-- {
+			prefix := prefixes[i]
+
+			for _, pattern := range layer.Include {
+				// The actual pattern to match in store
+				storePattern := prefix + pattern
+
+				for _, storeKey := range storeKeys {
+					if matchPattern(storePattern, storeKey) {
+						// Strip prefix from key for the logical name
+						logicalKey := strings.TrimPrefix(storeKey, prefix)
+						set(logicalKey, entry{
+							value:    r.store.Variables[storeKey],
+							source:   "store",
+							key:      logicalKey,
+							origin:   layer.Project,
+							storeKey: storeKey,
+							pattern:  pattern,
+						})
+					}
 				}
-				resolved[logicalKey] = intermediate{value: value, source: "store"}
 			}
 		}
-	}
 
-	// Step 2: Apply overrides (these win over store values)
-	for key, value := range r.project.Overrides {
-		resolved[key] = intermediate{value: value, source: "override"}
+		// Step 2: Apply overrides (these win over store values)
+		for _, key := range sortedKeys(layer.Overrides) {
+			set(key, entry{value: layer.Overrides[key], source: "override", key: key, origin: layer.Project})
+		}
 	}
 
 	// Step 3: Build the final env var list
-	// First, convert store keys to env vars
-	vars := make(map[string]ResolvedVar)
-
-	for key, inter := range resolved {
+	// First, convert store keys to env vars. Keys are visited in order so
+	// two keys mapped to the same env name resolve deterministically.
+	vars := make(map[string]entry)
+	for _, key := range sortedKeys(resolved) {
 		envName := r.keyToEnvName(key)
-		vars[envName] = ResolvedVar{
-			EnvName: envName,
-			Value:   inter.value,
-			Source:  inter.source,
-			Key:     key,
+		e := resolved[key]
+		if prev, ok := vars[envName]; ok {
+			e = replace(prev, e)
 		}
+		vars[envName] = e
 	}
 
 	// Step 4: Process computed values (with interpolation)
-	// Computed values can reference store keys or other computed values
-	// Build a simple key→value map for interpolation
-	valueMap := make(map[string]string)
-	for key, inter := range resolved {
-		valueMap[key] = inter.value
-	}
-
-	for envName, template := range r.project.Computed {
-		value := r.interpolate(template, valueMap)
-		vars[envName] = ResolvedVar{
-			EnvName: envName,
-			Value:   value,
-			Source:  "computed",
-			Key:     "", // Computed values don't have a store key
+	// Computed values can reference store keys or other computed values,
+	// so they are evaluated in dependency order
+	computed := make(map[string]computedVar)
+	for _, layer := range r.layers {
+		for envName, template := range layer.Computed {
+			computed[envName] = computedVar{template: template, origin: layer.Project}
+		}
+	}
+
+	order, err := r.computedOrder(computed, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	computedValues := make(map[string]string, len(computed))
+	computedSensitive := make(map[string]bool, len(computed))
+	inputs := make(map[string][]Input, len(computed))
+	unresolved := make(map[string][]string, len(computed))
+	for _, envName := range order {
+		c := computed[envName]
+		value, in, missing, err := r.interpolate(envName, c.template, resolved, computed, computedValues)
+		if err != nil {
+			return nil, fmt.Errorf("computed %s: %w", envName, err)
+		}
+
+		// A computed value that embeds a secret is a secret
+		sensitive := secret.IsSensitive(envName, value, false)
+		for i := range in {
+			if !in[i].Found {
+				continue
+			}
+			if in[i].Source == "computed" {
+				in[i].Sensitive = computedSensitive[in[i].Name]
+			} else {
+				in[i].Sensitive = secret.IsSensitive(in[i].Ref, in[i].Value, r.store.IsSecret(in[i].Name))
+			}
+			sensitive = sensitive || in[i].Sensitive
+		}
+
+		computedValues[envName] = value
+		computedSensitive[envName] = sensitive
+		inputs[envName] = in
+		unresolved[envName] = missing
+
+		e := entry{value: value, source: "computed", origin: c.origin}
+		if prev, ok := vars[envName]; ok {
+			e = replace(prev, e)
 		}
+		vars[envName] = e
 	}
 
 	// Convert to sorted slice
 	result := make([]ResolvedVar, 0, len(vars))
-	for _, v := range vars {
-		result = append(result, v)
+	for envName, e := range vars {
+		prov := &Provenance{
+			StoreKey: e.storeKey,
+			Pattern:  e.pattern,
+			Shadowed: e.shadowed,
+		}
+		var sensitive bool
+		if e.source == "computed" {
+			prov.Template = computed[envName].template
+			prov.Inputs = inputs[envName]
+			prov.Unresolved = unresolved[envName]
+			sensitive = computedSensitive[envName]
+		} else {
+			if _, ok := r.mappings[e.key]; ok {
+				prov.Mapping = e.key
+			}
+			sensitive = secret.IsSensitive(e.key, e.value, r.store.IsSecret(e.storeKey)) ||
+				secret.SensitiveKey(envName)
+		}
+
+		result = append(result, ResolvedVar{
+			EnvName:    envName,
+			Value:      e.value,
+			Source:     e.source,
+			Key:        e.key, // Computed values don't have a store key
+			Origin:     e.origin,
+			Sensitive:  sensitive,
+			Provenance: prov,
+		})
 	}
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].EnvName < result[j].EnvName
 	})
 
-	return result
+	return result, nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // MissingVars returns store keys referenced in Include patterns that don't exist.
@@ -134,24 +260,21 @@ func (r *Resolver) MissingVars() []string {
 	var missing []string
 	seen := make(map[string]bool)
 
-	prefix := ""
-	if r.project.Project != "" {
-		prefix = r.project.Project + "."
-	}
-
-	for _, pattern := range r.project.Include {
-		// Check if pattern contains wildcards
-		if strings.ContainsAny(pattern, "*?[") {
-			// For glob patterns, we can't know what's "missing"
-			continue
-		}
+	for _, layer := range r.layers {
+		for _, pattern := range layer.Include {
+			// Check if pattern contains wildcards
+			if strings.ContainsAny(pattern, "*?[") {
+				// For glob patterns, we can't know what's "missing"
+				continue
+			}
 
-		// Literal key - check if it exists (with prefix in store)
-		storeKey := prefix + pattern
-		if _, ok := r.store.Variables[storeKey]; !ok {
-			if !seen[pattern] {
-				missing = append(missing, pattern)
-				seen[pattern] = true
+			// Literal key - check if it exists (with prefix in store),
+			// in this layer or any other
+			if _, ok := r.lookupStore(pattern); !ok {
+				if !seen[pattern] {
+					missing = append(missing, pattern)
+					seen[pattern] = true
+				}
 			}
 		}
 	}
@@ -160,12 +283,18 @@ func (r *Resolver) MissingVars() []string {
 	return missing
 }
 
+// EnvName returns the environment variable name a store key is written
+// as, after mappings.
+func (r *Resolver) EnvName(key string) string {
+	return r.keyToEnvName(key)
+}
+
 // keyToEnvName converts a store key to an environment variable name.
 // "database.host" → "DATABASE_HOST"
 // Can be overridden by Mappings in project config.
 func (r *Resolver) keyToEnvName(key string) string {
 	// Check if there's an explicit mapping
-	if envName, ok := r.project.Mappings[key]; ok {
+	if envName, ok := r.mappings[key]; ok {
 		return envName
 	}
 
@@ -174,39 +303,72 @@ func (r *Resolver) keyToEnvName(key string) string {
 	return strings.ToUpper(name)
 }
 
-// interpolate replaces ${key} references in a template with values.
-// Looks up keys in the values map first, then falls back to the store.
-func (r *Resolver) interpolate(template string, values map[string]string) string {
-	// Match ${...} patterns
-	re := regexp.MustCompile(`\$\{([^}]+)\}`)
+// interpolate renders the template of the computed value self (see
+// template.go) and returns the inputs it looked up and the references it
+// left unresolved. Looks up keys in the resolved values first, then the
+// store, then computed values already evaluated.
+func (r *Resolver) interpolate(self, template string, values map[string]entry, computed map[string]computedVar, computedValues map[string]string) (string, []Input, []string, error) {
+	var inputs []Input
+	value, unresolved, err := evalTemplate(template, func(key string) (string, bool) {
+		in := r.lookupRef(key, self, values, computed, computedValues)
+		inputs = append(inputs, in)
+		return in.Value, in.Found
+	})
+	return value, inputs, unresolved, err
+}
+
+// lookupRef finds the value of a template reference and where it came from.
+func (r *Resolver) lookupRef(key, self string, values map[string]entry, computed map[string]computedVar, computedValues map[string]string) Input {
+	// Computed values, by env name or equivalent key, unless a value or
+	// store key of that name exists
+	if envName, ok := r.computedRef(key, self, computed, values); ok {
+		if value, ok := computedValues[envName]; ok {
+			return Input{Ref: key, Value: value, Found: true, Source: "computed", Name: envName}
+		}
+	}
 
-	prefix := ""
-	if r.project.Project != "" {
-		prefix = r.project.Project + "."
+	// Look up in resolved values (these are already logical keys)
+	if e, ok := values[key]; ok {
+		return Input{Ref: key, Value: e.value, Found: true, Source: e.source, Name: e.storeKey}
 	}
 
-	return re.ReplaceAllStringFunc(template, func(match string) string {
-		// Extract key name from ${key}
-		key := match[2 : len(match)-1]
+	// Fall back to store (for keys not in Include)
+	// Try with profile/project prefixes first, then without
+	if storeKey, ok := r.lookupStoreKey(key); ok {
+		return Input{Ref: key, Value: r.store.Variables[storeKey], Found: true, Source: "store", Name: storeKey}
+	}
+	if value, ok := r.store.Variables[key]; ok {
+		return Input{Ref: key, Value: value, Found: true, Source: "store", Name: key}
+	}
+	return Input{Ref: key}
+}
 
-		// Look up in resolved values first (these are already logical keys)
-		if value, ok := values[key]; ok {
-			return value
-		}
+// lookupStore looks key up under each layer's prefixes, the project's own
+// first and then its ancestors', returning the first value found.
+func (r *Resolver) lookupStore(key string) (string, bool) {
+	if storeKey, ok := r.lookupStoreKey(key); ok {
+		return r.store.Variables[storeKey], true
+	}
+	return "", false
+}
 
-		// Fall back to store (for keys not in Include)
-		// Try with project prefix first, then without
-		storeKey := prefix + key
-		if value, ok := r.store.Variables[storeKey]; ok {
-			return value
-		}
-		if value, ok := r.store.Variables[key]; ok {
-			return value
-		}
+// StoreKey returns the store key a project-relative key refers to, the
+// way ${key} references find it: the profile's namespace first, then the
+// project's, then its ancestors'.
+func (r *Resolver) StoreKey(key string) (string, bool) {
+	return r.lookupStoreKey(key)
+}
 
-		// Not found - leave as-is so user can see what's missing
-		return match
-	})
+// lookupStoreKey is like lookupStore but returns the full store key.
+func (r *Resolver) lookupStoreKey(key string) (string, bool) {
+	for i := len(r.layers) - 1; i >= 0; i-- {
+		for _, prefix := range r.layers[i].StorePrefixes() {
+			if _, ok := r.store.Variables[prefix+key]; ok {
+				return prefix + key, true
+			}
+		}
+	}
+	return "", false
 }
 
 // matchPattern checks if a key matches a glob-like pattern.
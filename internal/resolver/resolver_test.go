@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/dk/varnish/internal/project"
@@ -29,7 +30,7 @@ func TestResolveBasic(t *testing.T) {
 	cfg.Include = []string{"database.*"}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	if len(vars) != 2 {
 		t.Fatalf("expected 2 vars, got %d", len(vars))
@@ -74,7 +75,7 @@ func TestResolveWithOverrides(t *testing.T) {
 	}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	varMap := make(map[string]ResolvedVar)
 	for _, v := range vars {
@@ -105,7 +106,7 @@ func TestResolveWithMappings(t *testing.T) {
 	}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	if len(vars) != 1 {
 		t.Fatalf("expected 1 var, got %d", len(vars))
@@ -130,7 +131,7 @@ func TestResolveWithComputed(t *testing.T) {
 	}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	varMap := make(map[string]ResolvedVar)
 	for _, v := range vars {
@@ -185,7 +186,7 @@ func TestEmptyProject(t *testing.T) {
 	cfg.Include = []string{"database.*"}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	// Should resolve without project prefix
 	if len(vars) != 1 {
@@ -204,7 +205,7 @@ func TestMultipleIncludePatterns(t *testing.T) {
 	cfg.Include = []string{"database.*", "cache.*", "api.*"}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	if len(vars) != 3 {
 		t.Errorf("expected 3 vars, got %d", len(vars))
@@ -233,7 +234,7 @@ func TestKeyToEnvName(t *testing.T) {
 			// We test indirectly through Resolve since keyToEnvName is unexported
 			s.Set(tt.key, "testvalue")
 			cfg.Include = []string{tt.key}
-			vars := r.Resolve()
+			vars := mustResolve(t, r)
 
 			found := false
 			for _, v := range vars {
@@ -261,7 +262,7 @@ func TestGlobMatching(t *testing.T) {
 	cfg.Include = []string{"database.*"}
 
 	r := New(s, cfg)
-	vars := r.Resolve()
+	vars := mustResolve(t, r)
 
 	// Should have 3 database vars, not cache
 	if len(vars) != 3 {
@@ -274,3 +275,254 @@ func TestGlobMatching(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveWithProfile(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.host", "localhost")
+	s.Set("myapp.database.port", "5432")
+	s.Set("myapp@test.database.host", "test-db")
+	s.Set("myapp@test.database.name", "only_in_test")
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*", "database.user"}
+	cfg.Profiles = map[string]*project.Profile{"test": {}}
+
+	merged, err := cfg.WithProfile("test")
+	if err != nil {
+		t.Fatalf("WithProfile() error: %v", err)
+	}
+
+	r := New(s, merged)
+	varMap := make(map[string]string)
+	for _, v := range mustResolve(t, r) {
+		varMap[v.EnvName] = v.Value
+	}
+
+	if varMap["DATABASE_HOST"] != "test-db" {
+		t.Errorf("DATABASE_HOST = %q, want profile value 'test-db'", varMap["DATABASE_HOST"])
+	}
+	if varMap["DATABASE_PORT"] != "5432" {
+		t.Errorf("DATABASE_PORT = %q, want base value '5432'", varMap["DATABASE_PORT"])
+	}
+	if varMap["DATABASE_NAME"] != "only_in_test" {
+		t.Errorf("DATABASE_NAME = %q, want 'only_in_test'", varMap["DATABASE_NAME"])
+	}
+
+	missing := r.MissingVars()
+	if len(missing) != 1 || missing[0] != "database.user" {
+		t.Errorf("MissingVars() = %v, want [database.user]", missing)
+	}
+
+	// Without the profile, the profile namespace is ignored
+	base := mustResolve(t, New(s, cfg))
+	for _, v := range base {
+		if v.EnvName == "DATABASE_HOST" && v.Value != "localhost" {
+			t.Errorf("base DATABASE_HOST = %q, want 'localhost'", v.Value)
+		}
+		if v.EnvName == "DATABASE_NAME" {
+			t.Error("profile-only key should not resolve without the profile")
+		}
+	}
+}
+
+func TestResolveWithExtends(t *testing.T) {
+	s := store.New()
+	s.Set("base.kafka.brokers", "kafka:9092")
+	s.Set("base.database.host", "shared-db")
+	s.Set("app.database.host", "app-db")
+
+	parent := project.New()
+	parent.Project = "base"
+	parent.Include = []string{"kafka.*", "database.*"}
+	parent.Overrides = map[string]string{"log.level": "info"}
+	parent.Mappings = map[string]string{"kafka.brokers": "KAFKA_URL"}
+	parent.Computed = map[string]string{"DSN": "db://${database.host}"}
+
+	child := project.New()
+	child.Project = "app"
+	child.Include = []string{"database.*"}
+	child.Parents = []*project.Config{parent}
+
+	vars := mustResolve(t, New(s, child))
+	varMap := make(map[string]ResolvedVar)
+	for _, v := range vars {
+		varMap[v.EnvName] = v
+	}
+
+	if v := varMap["KAFKA_URL"]; v.Value != "kafka:9092" || v.Origin != "base" {
+		t.Errorf("KAFKA_URL = %+v, want inherited kafka:9092 from base with parent mapping", v)
+	}
+	if v := varMap["DATABASE_HOST"]; v.Value != "app-db" || v.Origin != "app" {
+		t.Errorf("DATABASE_HOST = %+v, want child value app-db", v)
+	}
+	if v := varMap["LOG_LEVEL"]; v.Value != "info" || v.Source != "override" || v.Origin != "base" {
+		t.Errorf("LOG_LEVEL = %+v, want inherited override", v)
+	}
+	if v := varMap["DSN"]; v.Value != "db://app-db" || v.Origin != "base" {
+		t.Errorf("DSN = %+v, want inherited computed using child value", v)
+	}
+}
+
+// mustResolve calls Resolve and fails the test on error.
+func mustResolve(t *testing.T, r *Resolver) []ResolvedVar {
+	t.Helper()
+	vars, err := r.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve() error: %v", err)
+	}
+	return vars
+}
+
+func TestResolveProvenance(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.host", "base-host")
+	s.Set("myapp@test.database.host", "test-host")
+	s.Set("myapp.database.name", "app")
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*"}
+	cfg.Overrides = map[string]string{"database.name": "override"}
+	cfg.Profiles = map[string]*project.Profile{"test": {}}
+	cfg, err := cfg.WithProfile("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := make(map[string]ResolvedVar)
+	for _, v := range mustResolve(t, New(s, cfg)) {
+		vars[v.EnvName] = v
+	}
+
+	host := vars["DATABASE_HOST"].Provenance
+	if host.StoreKey != "myapp@test.database.host" || host.Pattern != "database.*" {
+		t.Errorf("DATABASE_HOST provenance = %+v", host)
+	}
+	if len(host.Shadowed) != 1 || host.Shadowed[0].StoreKey != "myapp.database.host" || host.Shadowed[0].Value != "base-host" {
+		t.Errorf("DATABASE_HOST shadowed = %+v", host.Shadowed)
+	}
+
+	name := vars["DATABASE_NAME"].Provenance
+	if len(name.Shadowed) != 1 || name.Shadowed[0].Source != "store" || name.Shadowed[0].Value != "app" {
+		t.Errorf("DATABASE_NAME shadowed = %+v", name.Shadowed)
+	}
+}
+
+func TestResolveUnresolvedProvenance(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.host", "db")
+
+	parent := project.New()
+	parent.Project = "base"
+	parent.Computed = map[string]string{"GREETING": "hello"}
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*"}
+	cfg.Parents = []*project.Config{parent}
+	cfg.Computed = map[string]string{
+		"DATABASE_URL": "postgres://${database.user}@${database.host}/${database.name:-app}",
+		"HOST_URL":     "http://${database.host}",
+	}
+
+	r := New(s, cfg)
+	if got := len(r.Computed()); got != 3 {
+		t.Errorf("Computed() has %d values, want 3", got)
+	}
+
+	vars := make(map[string]ResolvedVar)
+	for _, v := range mustResolve(t, r) {
+		vars[v.EnvName] = v
+	}
+	if got := vars["DATABASE_URL"].Provenance.Unresolved; strings.Join(got, ",") != "database.user" {
+		t.Errorf("DATABASE_URL unresolved = %v, want [database.user]", got)
+	}
+	if got := vars["HOST_URL"].Provenance.Unresolved; len(got) != 0 {
+		t.Errorf("HOST_URL unresolved = %v, want none", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.port", "abc")
+	s.Set("myapp.log.level", "info")
+
+	parent := project.New()
+	parent.Project = "base"
+	parent.Schema = map[string]*project.Rule{
+		"api.key":   {Required: true},
+		"api.token": {Type: "uuid"},
+	}
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*", "log.*"}
+	cfg.Parents = []*project.Config{parent}
+	cfg.Schema = map[string]*project.Rule{
+		"DATABASE_PORT": {Type: "port"},
+		"log.level":     {Enum: []string{"info", "warn"}},
+	}
+
+	r := New(s, cfg)
+	violations := r.Validate(mustResolve(t, r))
+
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Error())
+	}
+	want := []string{
+		"DATABASE_PORT: must be a port number (1-65535)",
+		"api.key: required but not set",
+		`api.token: unknown schema type "uuid" (supported: string, int, bool, url, port, duration, email)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate = %v, want %v", got, want)
+	}
+
+	if v := r.ValidateValue("database.port", "5432"); len(v) != 0 {
+		t.Errorf("ValidateValue(5432) = %v", v)
+	}
+	if v := r.ValidateValue("database.port", "99999"); len(v) != 1 || v[0].Key != "DATABASE_PORT" {
+		t.Errorf("ValidateValue(99999) = %v", v)
+	}
+	if v := r.ValidateValue("no.rule", "x"); v != nil {
+		t.Errorf("ValidateValue(no.rule) = %v", v)
+	}
+}
+
+func TestResolveSensitive(t *testing.T) {
+	s := store.New()
+	s.Set("myapp.database.host", "db")
+	s.Set("myapp.database.password", "hunter2")
+	s.Set("myapp.database.name", "app")
+	_ = s.SetMeta("myapp.database.name", store.Meta{Secret: true})
+
+	cfg := project.New()
+	cfg.Project = "myapp"
+	cfg.Include = []string{"database.*"}
+	cfg.Computed = map[string]string{
+		"DATABASE_URL": "postgres://${database.host}:${database.password}@x",
+		"HOST_URL":     "http://${database.host}",
+		"WRAPPED":      "${DATABASE_URL}",
+	}
+
+	sensitive := make(map[string]bool)
+	for _, v := range mustResolve(t, New(s, cfg)) {
+		sensitive[v.EnvName] = v.Sensitive
+	}
+
+	want := map[string]bool{
+		"DATABASE_HOST":     false,
+		"DATABASE_PASSWORD": true, // by key name
+		"DATABASE_NAME":     true, // flagged in the store
+		"DATABASE_URL":      true, // embeds a secret
+		"HOST_URL":          false,
+		"WRAPPED":           true, // embeds a sensitive computed value
+	}
+	for name, w := range want {
+		if sensitive[name] != w {
+			t.Errorf("%s sensitive = %v, want %v", name, sensitive[name], w)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// template.go parses and evaluates computed value templates.
+//
+// Syntax (shell-compatible where it overlaps):
+//
+//	${key}              value of key; left as-is if not found
+//	${key:-default}     default if key is unset or empty
+//	${key:?message}     fail resolution with message if key is unset or empty
+//	${key:+alt}         alt if key is set and non-empty, otherwise empty
+//	$${                 a literal "${"
+//	${key | filter}     apply filters, left to right: ${db.password | urlencode}
+//
+// Defaults and alternatives are templates themselves, so
+// ${primary.host:-${fallback.host}} works. Filters: urlencode, base64,
+// upper, lower, trim. After an operator, a pipe starts the filters only if
+// what follows it is a filter name: ${key:-a|b} defaults to "a|b", while
+// ${key:-a | upper} applies upper. \| is always a literal pipe.
+package resolver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expr is a parsed ${...} reference.
+type expr struct {
+	raw     string   // original text, including ${ and }
+	key     string   // referenced key or env name
+	op      string   // "", ":-", ":?" or ":+"
+	arg     string   // default, message or alternative (a template)
+	filters []string // filter names, applied in order
+}
+
+// segment is a piece of a template: literal text or an expression.
+type segment struct {
+	literal string
+	expr    *expr
+}
+
+// filters maps filter names to their implementation.
+var filters = map[string]func(string) string{
+	"urlencode": urlEncode,
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// filterNames returns the supported filter names, sorted.
+func filterNames() []string {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTemplate splits a template into literal text and expressions.
+// An unterminated "${" is kept as literal text.
+func parseTemplate(template string) ([]segment, error) {
+	var segments []segment
+	var lit strings.Builder
+
+	for i := 0; i < len(template); {
+		// $${ is an escaped ${
+		if strings.HasPrefix(template[i:], "$${") {
+			lit.WriteString("${")
+			i += 3
+			continue
+		}
+
+		if !strings.HasPrefix(template[i:], "${") {
+			lit.WriteByte(template[i])
+			i++
+			continue
+		}
+
+		end := matchingBrace(template, i+2)
+		if end < 0 {
+			lit.WriteString(template[i:])
+			break
+		}
+
+		e, err := parseExpr(template[i : end+1])
+		if err != nil {
+			return nil, err
+		}
+		if lit.Len() > 0 {
+			segments = append(segments, segment{literal: lit.String()})
+			lit.Reset()
+		}
+		segments = append(segments, segment{expr: e})
+		i = end + 1
+	}
+
+	if lit.Len() > 0 {
+		segments = append(segments, segment{literal: lit.String()})
+	}
+	return segments, nil
+}
+
+// matchingBrace returns the index of the } closing a ${ whose body starts
+// at start, allowing nested ${...}. Returns -1 if there is none.
+func matchingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseExpr parses "${key[:op arg][ | filter...]}".
+func parseExpr(raw string) (*expr, error) {
+	body := raw[2 : len(raw)-1]
+	e := &expr{raw: raw}
+
+	// The key runs up to the operator or the first pipe
+	end := len(body)
+	for i := 0; i < len(body); i++ {
+		if body[i] == '|' || (body[i] == ':' && i+1 < len(body) && strings.IndexByte("-?+", body[i+1]) >= 0) {
+			end = i
+			break
+		}
+	}
+	e.key = strings.TrimSpace(body[:end])
+	if e.key == "" {
+		return nil, fmt.Errorf("empty reference in %s", raw)
+	}
+
+	var names []string
+	rest := body[end:]
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		e.op = rest[:2]
+		e.arg, names = splitArgFilters(rest[2:])
+	case rest != "":
+		// Without an operator, everything after the pipe is filters
+		names = splitTopLevel(rest[1:], '|')
+	}
+
+	for _, f := range names {
+		name := strings.TrimSpace(f)
+		if _, ok := filters[name]; !ok {
+			return nil, fmt.Errorf("unknown filter %q in %s (available: %s)", name, raw, strings.Join(filterNames(), ", "))
+		}
+		e.filters = append(e.filters, name)
+	}
+	return e, nil
+}
+
+// splitArgFilters splits an operator's argument from the filters after it.
+// The filters are the trailing pipe-separated parts that name a known
+// filter; any other pipe belongs to the argument, and so does \|.
+func splitArgFilters(arg string) (string, []string) {
+	parts := splitTopLevel(arg, '|')
+	n := len(parts)
+	for n > 1 {
+		if _, ok := filters[strings.TrimSpace(parts[n-1])]; !ok {
+			break
+		}
+		n--
+	}
+
+	value := strings.Join(parts[:n], "|")
+	if n < len(parts) {
+		// Spaces around the pipe are syntax, not part of the value
+		value = strings.TrimRight(value, " \t")
+	}
+	return unescapePipes(value), parts[n:]
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside nested ${...}
+// and ones escaped with a backslash.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case s[i] == '\\' && depth == 0 && i+1 < len(s) && s[i+1] == sep:
+			i++
+		case s[i] == sep && depth == 0:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// unescapePipes turns \| into | outside nested ${...}, where it is left for
+// the nested reference to unescape.
+func unescapePipes(s string) string {
+	if !strings.Contains(s, `\|`) {
+		return s
+	}
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			b.WriteString("${")
+			i++
+			continue
+		case s[i] == '}' && depth > 0:
+			depth--
+		case s[i] == '\\' && depth == 0 && i+1 < len(s) && s[i+1] == '|':
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// templateRefs returns every key a template references, including ones in
+// defaults and alternatives. Unparseable templates have no references;
+// the parse error surfaces when the template is evaluated.
+func templateRefs(template string) []string {
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return nil
+	}
+
+	var refs []string
+	for _, seg := range segments {
+		if seg.expr == nil {
+			continue
+		}
+		refs = append(refs, seg.expr.key)
+		refs = append(refs, templateRefs(seg.expr.arg)...)
+	}
+	return refs
+}
+
+// evalTemplate renders a template. lookup returns a key's value and
+// whether it was found. Also returns the plain ${key} references nothing
+// was found for, which are left as-is in the result.
+func evalTemplate(template string, lookup func(key string) (string, bool)) (string, []string, error) {
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var sb strings.Builder
+	var unresolved []string
+	// eval renders a default, message or alternative
+	eval := func(arg string) (string, error) {
+		value, missing, err := evalTemplate(arg, lookup)
+		unresolved = append(unresolved, missing...)
+		return value, err
+	}
+	for _, seg := range segments {
+		if seg.expr == nil {
+			sb.WriteString(seg.literal)
+			continue
+		}
+
+		e := seg.expr
+		value, found := lookup(e.key)
+		set := found && value != ""
+
+		switch e.op {
+		case "":
+			if !found {
+				// Not found - leave as-is so user can see what's missing
+				sb.WriteString(e.raw)
+				unresolved = append(unresolved, e.key)
+				continue
+			}
+		case ":-":
+			if !set {
+				if value, err = eval(e.arg); err != nil {
+					return "", nil, err
+				}
+			}
+		case ":?":
+			if !set {
+				msg, err := eval(e.arg)
+				if err != nil {
+					return "", nil, err
+				}
+				if msg == "" {
+					msg = "required but not set"
+				}
+				return "", nil, fmt.Errorf("%s: %s", e.key, msg)
+			}
+		case ":+":
+			value = ""
+			if set {
+				if value, err = eval(e.arg); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+
+		for _, name := range e.filters {
+			value = filters[name](value)
+		}
+		sb.WriteString(value)
+	}
+	return sb.String(), unresolved, nil
+}
+
+// urlEncode percent-encodes everything except RFC 3986 unreserved
+// characters, so the result is safe in any part of a URL, including the
+// userinfo of a connection string.
+func urlEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&0x0f])
+	}
+	return sb.String()
+}
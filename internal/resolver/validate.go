@@ -0,0 +1,114 @@
+// validate.go checks resolved values against the project schema.
+//
+// Schema rules are keyed by store key (database.port) or env name
+// (DATABASE_PORT). With extends, ancestors' rules apply too; a project's
+// own rule for a key replaces an inherited one.
+package resolver
+
+import (
+	"sort"
+
+	"github.com/dk/varnish/internal/project"
+)
+
+// Schema returns the rules merged across layers, later wins.
+func (r *Resolver) Schema() map[string]*project.Rule {
+	schema := make(map[string]*project.Rule)
+	for _, layer := range r.layers {
+		for key, rule := range layer.Schema {
+			if rule != nil {
+				schema[key] = rule
+			}
+		}
+	}
+	return schema
+}
+
+// CheckSchema returns the invalid rules of the merged schema, sorted by key.
+func (r *Resolver) CheckSchema() []project.Violation {
+	schema := r.Schema()
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var invalid []project.Violation
+	for _, key := range keys {
+		if err := schema[key].Compile(); err != nil {
+			invalid = append(invalid, project.Violation{Key: key, Message: err.Error()})
+		}
+	}
+	return invalid
+}
+
+// Validate checks resolved variables against the schema and returns every
+// violation, sorted by key. Required keys that did not resolve, and invalid
+// rules, are reported as violations too.
+func (r *Resolver) Validate(vars []ResolvedVar) []project.Violation {
+	schema := r.Schema()
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var violations []project.Violation
+	for _, key := range keys {
+		rule := schema[key]
+		if err := rule.Compile(); err != nil {
+			violations = append(violations, project.Violation{Key: key, Message: err.Error()})
+			continue
+		}
+
+		v, ok := findByKeyOrEnvName(vars, key)
+		if !ok {
+			if rule.Required {
+				violations = append(violations, project.Violation{Key: key, Message: "required but not set"})
+			}
+			continue
+		}
+
+		for _, problem := range rule.Validate(v.Value) {
+			violations = append(violations, project.Violation{Key: key, Message: problem})
+		}
+	}
+	return violations
+}
+
+// ValidateValue checks a single value for a store key (without project
+// prefix) against the schema, matching rules by key or env name.
+// Returns nil if no rule applies.
+func (r *Resolver) ValidateValue(key, value string) []project.Violation {
+	schema := r.Schema()
+
+	ruleKey := key
+	rule, ok := schema[key]
+	if !ok {
+		ruleKey = r.keyToEnvName(key)
+		if rule, ok = schema[ruleKey]; !ok {
+			return nil
+		}
+	}
+
+	var violations []project.Violation
+	for _, problem := range rule.Validate(value) {
+		violations = append(violations, project.Violation{Key: ruleKey, Message: problem})
+	}
+	return violations
+}
+
+// findByKeyOrEnvName finds a variable by store key, then by env name.
+func findByKeyOrEnvName(vars []ResolvedVar, name string) (ResolvedVar, bool) {
+	for _, v := range vars {
+		if v.Key != "" && v.Key == name {
+			return v, true
+		}
+	}
+	for _, v := range vars {
+		if v.EnvName == name {
+			return v, true
+		}
+	}
+	return ResolvedVar{}, false
+}
@@ -0,0 +1,462 @@
+// Package snapshot saves and restores copies of the varnish data files.
+//
+// This package is used by:
+//   - cli/snapshot.go: "varnish snapshot" and "varnish restore"
+//   - cli/project.go, cli/init.go, cli/store.go: to take a snapshot before
+//     destructive commands
+//
+// A snapshot is a directory in ~/.varnish/snapshots/ named after the time
+// it was taken, e.g. 20250102-150405. It holds copies of store.yaml,
+// history.yaml, registry.yaml, projects/*.yaml and segments/*.yaml in the
+// same layout as ~/.varnish/, plus a snapshot.yaml manifest. Files are copied byte for
+// byte, so an encrypted store or project stays encrypted in its snapshots.
+//
+// Only the newest snapshots are kept (10 by default, see config.Settings).
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/lock"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the file describing a snapshot.
+const ManifestName = "snapshot.yaml"
+
+// idFormat names snapshots after the time they were taken.
+const idFormat = "20060102-150405"
+
+// ErrEmpty is returned when there are no data files to snapshot.
+var ErrEmpty = errors.New("nothing to snapshot")
+
+// now and rename are replaced in tests.
+var (
+	now    = time.Now
+	rename = os.Rename
+)
+
+// Snapshot describes a saved copy of the data files.
+type Snapshot struct {
+	ID      string    `yaml:"-"`
+	Created time.Time `yaml:"created"`
+	Reason  string    `yaml:"reason,omitempty"`
+	Files   []string  `yaml:"files"` // paths relative to ~/.varnish
+
+	root string // ~/.varnish/snapshots, set when the snapshot is created or loaded
+}
+
+// Dir returns the snapshot's directory. Its layout matches ~/.varnish/.
+func (s *Snapshot) Dir() string {
+	return filepath.Join(s.root, s.ID)
+}
+
+// filesIn returns the data files under dir, relative to it and sorted.
+func filesIn(dir string) ([]string, error) {
+	var files []string
+	for _, name := range []string{config.StoreFileName, config.HistoryFileName, config.RegistryFileName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files = append(files, name)
+		}
+	}
+
+	for _, sub := range []string{config.ProjectsDirName, config.SegmentsDirName} {
+		paths, err := filepath.Glob(filepath.Join(dir, sub, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			files = append(files, filepath.Join(sub, filepath.Base(path)))
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Create snapshots the current data files. reason says why, e.g. the
+// command about to run. Older snapshots beyond the retention limit are
+// removed. Returns ErrEmpty if there is nothing to snapshot.
+func Create(reason string) (*Snapshot, error) {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+	snap, err := create(reason)
+	if err != nil {
+		return nil, err
+	}
+	if keep := settings.SnapshotKeep(); keep > 0 {
+		if err := prune(keep); err != nil {
+			return nil, fmt.Errorf("prune snapshots: %w", err)
+		}
+	}
+	return snap, nil
+}
+
+// Auto snapshots the current data files before a destructive command.
+// Returns nil, without an error, if automatic snapshots are disabled or
+// there is nothing to snapshot.
+func Auto(reason string) (*Snapshot, error) {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+	if settings.SnapshotKeep() == 0 {
+		return nil, nil
+	}
+	snap, err := Create(reason)
+	if errors.Is(err, ErrEmpty) {
+		return nil, nil
+	}
+	return snap, err
+}
+
+// create copies the data files into a new snapshot directory.
+func create(reason string) (*Snapshot, error) {
+	varnishDir, err := config.VarnishDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Hold the store and registry locks so the copies are consistent
+	release, err := lockData(varnishDir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	files, err := filesIn(varnishDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrEmpty
+	}
+
+	root, err := config.SnapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, config.PermDir); err != nil {
+		return nil, err
+	}
+
+	// Two snapshots in the same second get a numeric suffix
+	created := now()
+	base := created.Format(idFormat)
+	snap := &Snapshot{ID: base, Created: created, Reason: reason, Files: files, root: root}
+	for n := 2; ; n++ {
+		err := os.Mkdir(snap.Dir(), config.PermDir)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		snap.ID = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	if err := snap.copyFiles(varnishDir); err != nil {
+		os.RemoveAll(snap.Dir())
+		return nil, err
+	}
+	return snap, nil
+}
+
+// lockData locks the store and registry under varnishDir, always in that
+// order. The returned function releases both.
+func lockData(varnishDir string) (func(), error) {
+	st, err := lock.Acquire(filepath.Join(varnishDir, config.StoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	reg, err := lock.Acquire(filepath.Join(varnishDir, config.RegistryFileName))
+	if err != nil {
+		st.Release()
+		return nil, err
+	}
+	return func() {
+		reg.Release()
+		st.Release()
+	}, nil
+}
+
+// copyFiles copies the snapshot's files from varnishDir and writes the
+// manifest last, so a snapshot without a manifest is incomplete.
+func (s *Snapshot) copyFiles(varnishDir string) error {
+	for _, rel := range s.Files {
+		data, err := os.ReadFile(filepath.Join(varnishDir, rel))
+		if err != nil {
+			return fmt.Errorf("read %s: %w", rel, err)
+		}
+		dest := filepath.Join(s.Dir(), rel)
+		if err := os.MkdirAll(filepath.Dir(dest), config.PermDir); err != nil {
+			return err
+		}
+		// Copies may contain secrets, whatever the original's permissions
+		if err := config.AtomicWrite(dest, data, config.PermSecure); err != nil {
+			return fmt.Errorf("write %s: %w", rel, err)
+		}
+	}
+
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return config.AtomicWrite(filepath.Join(s.Dir(), ManifestName), data, config.PermSecure)
+}
+
+// List returns all complete snapshots, oldest first.
+func List() ([]*Snapshot, error) {
+	root, err := config.SnapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []*Snapshot
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		snap, err := load(root, e.Name())
+		if err != nil {
+			// Incomplete snapshot (interrupted while being taken)
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+
+	sort.Slice(snaps, func(i, j int) bool {
+		if !snaps[i].Created.Equal(snaps[j].Created) {
+			return snaps[i].Created.Before(snaps[j].Created)
+		}
+		return snaps[i].ID < snaps[j].ID
+	})
+	return snaps, nil
+}
+
+// load reads the manifest of a snapshot in root.
+func load(root, id string) (*Snapshot, error) {
+	snap := &Snapshot{ID: id, root: root}
+	data, err := os.ReadFile(filepath.Join(snap.Dir(), ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("parse snapshot %s: %w", id, err)
+	}
+	return snap, nil
+}
+
+// Find returns the snapshot with the given ID, a unique prefix of one, or
+// the newest snapshot for "latest".
+func Find(ref string) (*Snapshot, error) {
+	snaps, err := List()
+	if err != nil {
+		return nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("no snapshots (create one with: varnish snapshot create)")
+	}
+	if ref == "latest" {
+		return snaps[len(snaps)-1], nil
+	}
+
+	var matches []*Snapshot
+	for _, snap := range snaps {
+		if snap.ID == ref {
+			return snap, nil
+		}
+		if strings.HasPrefix(snap.ID, ref) {
+			matches = append(matches, snap)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("snapshot not found: %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("snapshot %q is ambiguous (%d matches)", ref, len(matches))
+	}
+}
+
+// prune removes all but the newest keep snapshots.
+func prune(keep int) error {
+	snaps, err := List()
+	if err != nil {
+		return err
+	}
+	for len(snaps) > keep {
+		if err := os.RemoveAll(snaps[0].Dir()); err != nil {
+			return err
+		}
+		snaps = snaps[1:]
+	}
+	return nil
+}
+
+// Restore replaces the current data files with the snapshot's. Data files
+// the snapshot doesn't have are removed, so the result matches the state
+// the snapshot was taken in. The current state is snapshotted first (unless
+// automatic snapshots are disabled) and returned, so a restore can be
+// undone.
+//
+// Every file is written to a temp file before any is replaced, so a failed
+// write changes nothing. If moving them into place then fails, the
+// previous files are put back before the error is returned. Only if that
+// fails too is the backup returned along with the error, to be restored by
+// hand.
+func (s *Snapshot) Restore() (*Snapshot, error) {
+	// Read everything up front so a damaged snapshot changes nothing
+	data := make(map[string][]byte, len(s.Files))
+	for _, rel := range s.Files {
+		b, err := os.ReadFile(filepath.Join(s.Dir(), rel))
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot %s: %w", s.ID, err)
+		}
+		data[rel] = b
+	}
+
+	backup, err := Auto("restore " + s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot current state: %w", err)
+	}
+
+	varnishDir, err := config.VarnishDir()
+	if err != nil {
+		return nil, err
+	}
+	release, err := lockData(varnishDir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	current, err := filesIn(varnishDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.EnsureProjectsDir(); err != nil {
+		return nil, err
+	}
+	if err := config.EnsureSegmentsDir(); err != nil {
+		return nil, err
+	}
+
+	// Lock every file involved; the store and registry are already locked
+	// by lockData, and the lock is reentrant
+	previous := make(map[string][]byte, len(current))
+	for _, rel := range current {
+		previous[rel] = nil
+	}
+	for _, rel := range s.Files {
+		previous[rel] = nil
+	}
+	for rel := range previous {
+		lk, err := lock.Acquire(filepath.Join(varnishDir, rel))
+		if err != nil {
+			return nil, err
+		}
+		defer lk.Release()
+	}
+
+	// Keep the files being replaced, to put them back if a rename fails
+	for _, rel := range current {
+		b, err := os.ReadFile(filepath.Join(varnishDir, rel))
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			b = []byte{}
+		}
+		previous[rel] = b
+	}
+
+	staged := make(map[string]string, len(s.Files)) // rel -> temp file
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, rel := range s.Files {
+		tmp, err := config.WriteTemp(filepath.Join(varnishDir, rel), data[rel], filePerm(rel))
+		if err != nil {
+			return nil, fmt.Errorf("restore %s: %w", rel, err)
+		}
+		staged[rel] = tmp
+	}
+
+	if err := replaceFiles(varnishDir, s.Files, staged, current); err != nil {
+		if putErr := putBack(varnishDir, previous); putErr != nil {
+			return backup, fmt.Errorf("%w; putting back the previous files failed too: %v", err, putErr)
+		}
+		return nil, err
+	}
+	return backup, nil
+}
+
+// replaceFiles moves the staged files into place and removes the current
+// files that aren't among them.
+func replaceFiles(varnishDir string, files []string, staged map[string]string, current []string) error {
+	for _, rel := range files {
+		if err := rename(staged[rel], filepath.Join(varnishDir, rel)); err != nil {
+			return fmt.Errorf("restore %s: %w", rel, err)
+		}
+		delete(staged, rel)
+	}
+	for _, rel := range current {
+		if slices.Contains(files, rel) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(varnishDir, rel)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// putBack writes back the files a failed restore started replacing:
+// previous content where there was a file, and no file where there was
+// none (nil).
+func putBack(varnishDir string, previous map[string][]byte) error {
+	var errs []error
+	for rel, b := range previous {
+		path := filepath.Join(varnishDir, rel)
+		if b == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := config.AtomicWrite(path, b, filePerm(rel)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// filePerm returns the permissions of a data file: files that hold values
+// are only readable by the user.
+func filePerm(rel string) os.FileMode {
+	if rel == config.StoreFileName || rel == config.HistoryFileName || filepath.Dir(rel) == config.SegmentsDirName {
+		return config.PermSecure
+	}
+	return config.PermConfig
+}
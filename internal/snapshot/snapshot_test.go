@@ -0,0 +1,378 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+)
+
+// setupHome points HOME at a temp dir with a store, registry and one
+// project config, and returns the ~/.varnish path.
+func setupHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := filepath.Join(home, config.DirName)
+	writeFile(t, filepath.Join(dir, config.StoreFileName), "store v1")
+	writeFile(t, filepath.Join(dir, config.RegistryFileName), "registry v1")
+	writeFile(t, filepath.Join(dir, config.ProjectsDirName, "myapp.yaml"), "myapp v1")
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// fakeNow makes now() return t0, then advance a minute per call.
+func fakeNow(t *testing.T, t0 time.Time) {
+	t.Helper()
+	orig := now
+	t.Cleanup(func() { now = orig })
+	next := t0
+	now = func() time.Time {
+		cur := next
+		next = next.Add(time.Minute)
+		return cur
+	}
+}
+
+func TestCreateAndList(t *testing.T) {
+	setupHome(t)
+	fakeNow(t, time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local))
+
+	snap, err := Create("project delete myapp")
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if snap.ID != "20250102-150405" {
+		t.Errorf("ID = %q", snap.ID)
+	}
+	want := []string{"projects/myapp.yaml", "registry.yaml", "store.yaml"}
+	if len(snap.Files) != len(want) {
+		t.Fatalf("Files = %v, want %v", snap.Files, want)
+	}
+	for i := range want {
+		if snap.Files[i] != want[i] {
+			t.Errorf("Files = %v, want %v", snap.Files, want)
+		}
+	}
+	if got := readFile(t, filepath.Join(snap.Dir(), "projects", "myapp.yaml")); got != "myapp v1" {
+		t.Errorf("copied project config = %q", got)
+	}
+	info, err := os.Stat(filepath.Join(snap.Dir(), config.RegistryFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("snapshot file mode = %v, want %v", info.Mode().Perm(), config.PermSecure)
+	}
+
+	if _, err := Create("manual"); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	snaps, err := List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(snaps) != 2 || snaps[0].Reason != "project delete myapp" || snaps[1].ID != "20250102-150505" {
+		t.Errorf("List() = %+v", snaps)
+	}
+}
+
+func TestCreateSameSecond(t *testing.T) {
+	setupHome(t)
+	t0 := time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local)
+	orig := now
+	defer func() { now = orig }()
+	now = func() time.Time { return t0 }
+
+	a, err := Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Create("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID == b.ID || b.ID != a.ID+"-2" {
+		t.Errorf("IDs = %q, %q", a.ID, b.ID)
+	}
+}
+
+func TestCreateEmpty(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if _, err := Create("manual"); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Create() error = %v, want ErrEmpty", err)
+	}
+	snap, err := Auto("init")
+	if err != nil || snap != nil {
+		t.Errorf("Auto() = %v, %v; want nil, nil", snap, err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := setupHome(t)
+	writeFile(t, filepath.Join(dir, config.ConfigFileName), "snapshots:\n  keep: 2\n")
+	fakeNow(t, time.Date(2025, 1, 2, 15, 0, 0, 0, time.Local))
+
+	for i := 0; i < 4; i++ {
+		if _, err := Create("manual"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snaps, _ := List()
+	if len(snaps) != 2 || snaps[0].ID != "20250102-150200" || snaps[1].ID != "20250102-150300" {
+		t.Errorf("expected the 2 newest snapshots, got %+v", snaps)
+	}
+
+	// keep: -1 disables automatic snapshots but not manual ones
+	writeFile(t, filepath.Join(dir, config.ConfigFileName), "snapshots:\n  keep: -1\n")
+	if snap, err := Auto("init"); err != nil || snap != nil {
+		t.Errorf("Auto() = %v, %v; want nil, nil", snap, err)
+	}
+	if _, err := Create("manual"); err != nil {
+		t.Fatal(err)
+	}
+	snaps, _ = List()
+	if len(snaps) != 3 {
+		t.Errorf("expected 3 snapshots, got %d", len(snaps))
+	}
+}
+
+func TestFind(t *testing.T) {
+	setupHome(t)
+	fakeNow(t, time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local))
+
+	if _, err := Find("latest"); err == nil {
+		t.Error("expected error with no snapshots")
+	}
+
+	first, _ := Create("a")
+	second, _ := Create("b")
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"latest", second.ID, false},
+		{first.ID, first.ID, false},
+		{"20250102-1505", second.ID, false},
+		{"20250102", "", true}, // ambiguous
+		{"1999", "", true},
+	}
+	for _, tt := range tests {
+		snap, err := Find(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Find(%q) expected error", tt.ref)
+			}
+			continue
+		}
+		if err != nil || snap.ID != tt.want {
+			t.Errorf("Find(%q) = %v, %v; want %s", tt.ref, snap, err, tt.want)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := setupHome(t)
+	fakeNow(t, time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local))
+
+	snap, err := Create("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change one file, delete one, add one
+	writeFile(t, filepath.Join(dir, config.StoreFileName), "store v2")
+	os.Remove(filepath.Join(dir, config.ProjectsDirName, "myapp.yaml"))
+	writeFile(t, filepath.Join(dir, config.ProjectsDirName, "other.yaml"), "other v1")
+
+	backup, err := snap.Restore()
+	if err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if backup == nil || backup.Reason != "restore "+snap.ID {
+		t.Fatalf("expected a backup snapshot, got %+v", backup)
+	}
+
+	if got := readFile(t, filepath.Join(dir, config.StoreFileName)); got != "store v1" {
+		t.Errorf("store = %q, want store v1", got)
+	}
+	info, _ := os.Stat(filepath.Join(dir, config.StoreFileName))
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("store mode = %v, want %v", info.Mode().Perm(), config.PermSecure)
+	}
+	if got := readFile(t, filepath.Join(dir, config.ProjectsDirName, "myapp.yaml")); got != "myapp v1" {
+		t.Errorf("myapp.yaml = %q, want myapp v1", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, config.ProjectsDirName, "other.yaml")); !os.IsNotExist(err) {
+		t.Error("other.yaml should be removed")
+	}
+
+	// The backup holds the state before the restore
+	if got := readFile(t, filepath.Join(backup.Dir(), config.StoreFileName)); got != "store v2" {
+		t.Errorf("backup store = %q, want store v2", got)
+	}
+}
+
+func TestRestoreSegments(t *testing.T) {
+	dir := setupHome(t)
+	fakeNow(t, time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local))
+	segment := filepath.Join(dir, config.SegmentsDirName, "myapp.yaml")
+	writeFile(t, segment, "myapp segment v1")
+
+	snap, err := Create("project delete myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(snap.Dir(), config.SegmentsDirName, "myapp.yaml")); got != "myapp segment v1" {
+		t.Errorf("copied segment = %q", got)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, config.SegmentsDirName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snap.Restore(); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if got := readFile(t, segment); got != "myapp segment v1" {
+		t.Errorf("restored segment = %q", got)
+	}
+	info, _ := os.Stat(segment)
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("segment mode = %v, want %v", info.Mode().Perm(), config.PermSecure)
+	}
+}
+
+func TestRestoreHistory(t *testing.T) {
+	dir := setupHome(t)
+	fakeNow(t, time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local))
+	history := filepath.Join(dir, config.HistoryFileName)
+	writeFile(t, history, "history v1")
+
+	snap, err := Create("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(snap.Dir(), config.HistoryFileName)); got != "history v1" {
+		t.Errorf("copied history = %q", got)
+	}
+
+	// History that predates a restore must match the restored store
+	writeFile(t, history, "history v2")
+	if _, err := snap.Restore(); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if got := readFile(t, history); got != "history v1" {
+		t.Errorf("restored history = %q, want history v1", got)
+	}
+	info, _ := os.Stat(history)
+	if info.Mode().Perm() != config.PermSecure {
+		t.Errorf("history mode = %v, want %v", info.Mode().Perm(), config.PermSecure)
+	}
+}
+
+func TestRestoreFailurePutsBackPreviousFiles(t *testing.T) {
+	dir := setupHome(t)
+	fakeNow(t, time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local))
+
+	snap, err := Create("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, config.StoreFileName), "store v2")
+	writeFile(t, filepath.Join(dir, config.RegistryFileName), "registry v2")
+	os.Remove(filepath.Join(dir, config.ProjectsDirName, "myapp.yaml"))
+	writeFile(t, filepath.Join(dir, config.ProjectsDirName, "other.yaml"), "other v1")
+
+	// The second file fails to move into place
+	orig := rename
+	t.Cleanup(func() { rename = orig })
+	renames := 0
+	rename = func(from, to string) error {
+		if renames++; renames == 2 {
+			return os.ErrPermission
+		}
+		return orig(from, to)
+	}
+
+	if _, err := snap.Restore(); err == nil {
+		t.Fatal("expected Restore() to fail")
+	}
+	for rel, want := range map[string]string{
+		config.StoreFileName:                                "store v2",
+		config.RegistryFileName:                             "registry v2",
+		filepath.Join(config.ProjectsDirName, "other.yaml"): "other v1",
+	} {
+		if got := readFile(t, filepath.Join(dir, rel)); got != want {
+			t.Errorf("%s = %q, want %q put back", rel, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, config.ProjectsDirName, "myapp.yaml")); !os.IsNotExist(err) {
+		t.Error("myapp.yaml should not be restored by a failed restore")
+	}
+	for _, sub := range []string{".", config.ProjectsDirName} {
+		tmps, _ := filepath.Glob(filepath.Join(dir, sub, ".tmp-*"))
+		if len(tmps) > 0 {
+			t.Errorf("temp files left behind: %v", tmps)
+		}
+	}
+}
+
+func TestRestoreDamagedSnapshot(t *testing.T) {
+	dir := setupHome(t)
+
+	snap, err := Create("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(snap.Dir(), config.StoreFileName))
+	writeFile(t, filepath.Join(dir, config.RegistryFileName), "registry v2")
+
+	if _, err := snap.Restore(); err == nil {
+		t.Fatal("expected error for damaged snapshot")
+	}
+	if got := readFile(t, filepath.Join(dir, config.RegistryFileName)); got != "registry v2" {
+		t.Errorf("registry = %q; a failed restore should change nothing", got)
+	}
+}
+
+func TestListSkipsIncomplete(t *testing.T) {
+	setupHome(t)
+	root, err := config.SnapshotsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "20250102-150405"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	snaps, err := List()
+	if err != nil || len(snaps) != 0 {
+		t.Errorf("List() = %v, %v; want no snapshots", snaps, err)
+	}
+}
@@ -0,0 +1,59 @@
+// agent.go uses keys held by the unlock agent (see agent/agent.go), so an
+// unlocked store is read and written without deriving a key each time.
+//
+// This file is used by:
+//   - store/store.go: tries the agent before asking for the store password
+//   - store/segment.go: tries the agent before asking for a project password
+//   - cli/store.go, cli/recipients.go: ForgetKeys once the password changes
+//
+// The agent holds keys by the ID crypto.KeyID gives them, so a key only
+// ever applies to the file it was unlocked for (see crypto/key.go).
+package store
+
+import (
+	"errors"
+
+	"github.com/dk/varnish/internal/agent"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+// decryptWithAgent decrypts data with a key from the agent. Reports false
+// if there is no agent, it doesn't hold the key, or the key doesn't work.
+func decryptWithAgent(data []byte) ([]byte, bool) {
+	id, ok := crypto.DataKeyID(data)
+	if !ok {
+		return nil, false
+	}
+	key, ok := agent.Get(id)
+	if !ok {
+		return nil, false
+	}
+	plain, err := crypto.DecryptWithKey(data, key)
+	if err != nil {
+		return nil, false
+	}
+	return plain, true
+}
+
+// encryptWithAgent encrypts plaintext with the agent's key for salt and
+// params, the header of the file being rewritten. Reports false if the
+// agent doesn't hold that key.
+func encryptWithAgent(plaintext []byte, params crypto.KDFParams, salt []byte) ([]byte, bool) {
+	key, ok := agent.Get(crypto.KeyID(salt, params))
+	if !ok {
+		return nil, false
+	}
+	data, err := crypto.EncryptWithKey(plaintext, params, salt, key)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// ForgetKeys tells the agent, if one is running, to wipe its keys.
+func ForgetKeys() error {
+	if err := agent.Lock(); err != nil && !errors.Is(err, agent.ErrNotRunning) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dk/varnish/internal/agent"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+// serveAgent runs an unlock agent in the background under a new HOME.
+func serveAgent(t *testing.T) {
+	t.Helper()
+	// Unix socket paths are short, so avoid t.TempDir's long names
+	home, err := os.MkdirTemp("", "vs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	t.Setenv("HOME", home)
+
+	ln, err := agent.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		if err := agent.Serve(ctx, ln, time.Minute, io.Discard); err != nil {
+			t.Errorf("agent.Serve() error: %v", err)
+		}
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestWithAgent(t *testing.T) {
+	serveAgent(t)
+
+	data, err := crypto.Encrypt([]byte("secret"), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := crypto.ParseHeader(data)
+
+	// Nothing unlocked yet
+	if _, ok := decryptWithAgent(data); ok {
+		t.Error("decryptWithAgent() before unlocking should report false")
+	}
+	if _, ok := encryptWithAgent([]byte("new"), h.KDF, h.Salt); ok {
+		t.Error("encryptWithAgent() before unlocking should report false")
+	}
+
+	id, key, err := crypto.UnlockKey(data, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.Add(id, key, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, ok := decryptWithAgent(data)
+	if !ok || string(plain) != "secret" {
+		t.Fatalf("decryptWithAgent() = %q, %v", plain, ok)
+	}
+
+	// Rewriting keeps the salt, so the password and the agent both work
+	out, ok := encryptWithAgent([]byte("new"), h.KDF, h.Salt)
+	if !ok {
+		t.Fatal("encryptWithAgent() should use the agent's key")
+	}
+	if plain, err := crypto.Decrypt(out, "password"); err != nil || string(plain) != "new" {
+		t.Errorf("Decrypt() of agent output = %q, %v", plain, err)
+	}
+	if plain, ok := decryptWithAgent(out); !ok || string(plain) != "new" {
+		t.Errorf("decryptWithAgent() of agent output = %q, %v", plain, ok)
+	}
+
+	// Another file has another salt, so the key doesn't apply
+	other, _ := crypto.Encrypt([]byte("other"), "password")
+	if _, ok := decryptWithAgent(other); ok {
+		t.Error("decryptWithAgent() of a file with another salt should report false")
+	}
+
+	if err := ForgetKeys(); err != nil {
+		t.Fatalf("ForgetKeys() error: %v", err)
+	}
+	if _, ok := decryptWithAgent(data); ok {
+		t.Error("decryptWithAgent() after ForgetKeys should report false")
+	}
+	// No agent any more is not an error
+	if err := ForgetKeys(); err != nil {
+		t.Errorf("ForgetKeys() without an agent error: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+// history.go keeps an append-only log of changes to the store.
+//
+// Every Set that changes a value and every Delete is recorded when the
+// store is saved, with the previous value, the new value, the time and
+// the command that made the change:
+//
+//	version: 1
+//	changes:
+//	  - key: myapp.database.password
+//	    op: set
+//	    existed: true
+//	    previous: old-secret
+//	    value: new-secret
+//	    time: 2025-01-03T09:00:00Z
+//	    command: store set
+//
+// The log lives in ~/.varnish/history.yaml and is encrypted whenever the
+// store is. Changes are only ever appended; the oldest changes of a key are
+// dropped once it has more than the retention limit (history.limit in
+// ~/.varnish/config.yaml).
+package store
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Command describes the command making changes, recorded in the history.
+// Set by the CLI, e.g. "store set".
+var Command string
+
+// Change operations.
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// Change is one recorded change to a variable.
+type Change struct {
+	Key      string    `yaml:"key" json:"key"`
+	Op       string    `yaml:"op" json:"op"`
+	Existed  bool      `yaml:"existed" json:"existed"` // false if the change created the key
+	Previous string    `yaml:"previous,omitempty" json:"previous,omitempty"`
+	Value    string    `yaml:"value,omitempty" json:"value,omitempty"` // new value (set only)
+	Time     time.Time `yaml:"time" json:"time"`
+	Command  string    `yaml:"command,omitempty" json:"command,omitempty"`
+}
+
+// History is the change log of the store.
+type History struct {
+	Version int      `yaml:"version"`
+	Changes []Change `yaml:"changes"`
+}
+
+// record queues a change to be appended to the history on Save.
+func (s *Store) record(key, op string, previous string, existed bool, value string) {
+	s.dirty = true
+	s.markSegment(key)
+	s.pending = append(s.pending, Change{
+		Key:      key,
+		Op:       op,
+		Existed:  existed,
+		Previous: previous,
+		Value:    value,
+		Time:     now().UTC(),
+		Command:  Command,
+	})
+}
+
+// LoadHistory reads the change history. A missing file is an empty history.
+// An encrypted history requires VARNISH_PASSWORD. Changes to projects
+// encrypted separately are not in it, see Store.History.
+func LoadHistory() (*History, error) {
+	h, _, err := loadHistory()
+	return h, err
+}
+
+func loadHistory() (*History, bool, error) {
+	path, err := config.HistoryPath()
+	if err != nil {
+		return nil, false, fmt.Errorf("get history path: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &History{Version: 1}, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read history: %w", err)
+	}
+
+	plain, encrypted, err := decode(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("history: %w", err)
+	}
+
+	var h History
+	if err := yaml.Unmarshal(plain, &h); err != nil {
+		return nil, false, fmt.Errorf("parse history: %w", err)
+	}
+	if h.Version == 0 {
+		h.Version = 1
+	}
+	return &h, encrypted, nil
+}
+
+// appendHistory appends the store's pending changes to the history file,
+// applying the retention limit. The file is rewritten in the store's
+// encryption mode, so enabling encryption encrypts the history too. Changes
+// to projects encrypted separately are kept in their segments instead, and
+// removed from the file (see segment.go).
+func (s *Store) appendHistory() error {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return err
+	}
+	limit := settings.HistoryLimit()
+
+	pending := s.pending
+	s.pending = nil
+	if limit == 0 {
+		if len(s.segments) == 0 {
+			return nil
+		}
+		pending = nil
+	}
+
+	h, encrypted, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	kept := h.Changes[:0:0]
+	for _, c := range h.Changes {
+		if !s.inSegment(c.Key) {
+			kept = append(kept, c)
+		}
+	}
+	if len(pending) == 0 && encrypted == s.encrypted && len(kept) == len(h.Changes) {
+		return nil
+	}
+
+	h.Changes = append(kept, pending...)
+	if limit != 0 {
+		h.Changes = prune(h.Changes, limit)
+	}
+
+	data, err := yaml.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("marshal history: %w", err)
+	}
+	if data, err = s.encode(data); err != nil {
+		return err
+	}
+
+	path, err := config.HistoryPath()
+	if err != nil {
+		return fmt.Errorf("get history path: %w", err)
+	}
+	if err := config.AtomicWrite(path, data, config.PermSecure); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// prune keeps the newest limit changes of each key, in order.
+func prune(changes []Change, limit int) []Change {
+	count := make(map[string]int)
+	keep := make([]bool, len(changes))
+	for i := len(changes) - 1; i >= 0; i-- {
+		key := changes[i].Key
+		if count[key] < limit {
+			keep[i] = true
+			count[key]++
+		}
+	}
+
+	out := changes[:0:0]
+	for i, c := range changes {
+		if keep[i] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// For returns the changes to key, oldest first.
+func (h *History) For(key string) []Change {
+	var out []Change
+	for _, c := range h.Changes {
+		if c.Key == key {
+			out = append(out, c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+// ValueAt returns the value a key had right after its change n (1 is the
+// oldest recorded change) and whether the key existed then.
+func ValueAt(changes []Change, n int) (string, bool, error) {
+	if n < 1 || n > len(changes) {
+		return "", false, fmt.Errorf("no change #%d (history has %d)", n, len(changes))
+	}
+	c := changes[n-1]
+	if c.Op == OpDelete {
+		return "", false, nil
+	}
+	return c.Value, true, nil
+}
+
+// ValueAsOf returns the value a key had at time t and whether it existed.
+// Times before the oldest recorded change give the value before that change.
+func ValueAsOf(changes []Change, t time.Time) (string, bool, error) {
+	if len(changes) == 0 {
+		return "", false, fmt.Errorf("no history")
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if !changes[i].Time.After(t) {
+			return ValueAt(changes, i+1)
+		}
+	}
+	first := changes[0]
+	return first.Previous, first.Existed, nil
+}
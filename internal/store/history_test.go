@@ -0,0 +1,170 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+func TestHistoryRecordsChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeNow(t, t1)
+	Command = "store set"
+	defer func() { Command = "" }()
+
+	s := New()
+	s.Set("db.password", "first")
+	s.Set("db.host", "localhost")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	fakeNow(t, t1.Add(time.Hour))
+	s.Set("db.password", "second")
+	s.Set("db.host", "localhost") // unchanged, not recorded
+	s.Delete("db.password")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() error: %v", err)
+	}
+	changes := h.For("db.password")
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	if c := changes[0]; c.Op != OpSet || c.Existed || c.Value != "first" || !c.Time.Equal(t1) || c.Command != "store set" {
+		t.Errorf("change 1 = %+v", c)
+	}
+	if c := changes[1]; c.Previous != "first" || c.Value != "second" || !c.Existed {
+		t.Errorf("change 2 = %+v", c)
+	}
+	if c := changes[2]; c.Op != OpDelete || c.Previous != "second" {
+		t.Errorf("change 3 = %+v", c)
+	}
+	if got := len(h.For("db.host")); got != 1 {
+		t.Errorf("db.host changes = %d, want 1", got)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, config.DirName), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := config.ConfigPath()
+	if err := os.WriteFile(path, []byte("history:\n  limit: 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New()
+	for _, v := range []string{"a", "b", "c", "d"} {
+		s.Set("key", v)
+		s.Set("other", v)
+		if err := s.Save(); err != nil {
+			t.Fatalf("Save() error: %v", err)
+		}
+	}
+
+	h, _ := LoadHistory()
+	changes := h.For("key")
+	if len(changes) != 2 || changes[0].Value != "c" || changes[1].Value != "d" {
+		t.Errorf("expected the 2 newest changes, got %+v", changes)
+	}
+	if len(h.Changes) != 4 {
+		t.Errorf("expected 2 changes per key, got %d", len(h.Changes))
+	}
+
+	// A negative limit disables history
+	if err := os.WriteFile(path, []byte("history:\n  limit: -1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("key", "e")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	h, _ = LoadHistory()
+	if got := h.For("key"); got[len(got)-1].Value != "d" {
+		t.Errorf("history should not change when disabled, got %+v", got)
+	}
+}
+
+func TestHistoryEncrypted(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(crypto.PasswordEnvVar, "testpassword")
+
+	s := New()
+	s.Set("secret.key", "plain-before-encryption")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	// Enabling encryption rewrites the history encrypted, even with no
+	// new changes
+	if err := s.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	path, _ := config.HistoryPath()
+	data, _ := os.ReadFile(path)
+	if !crypto.IsEncrypted(data) || strings.Contains(string(data), "plain-before-encryption") {
+		t.Fatal("expected encrypted history")
+	}
+
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() error: %v", err)
+	}
+	if got := h.For("secret.key"); len(got) != 1 || got[0].Value != "plain-before-encryption" {
+		t.Errorf("unexpected history: %+v", got)
+	}
+
+	t.Setenv(crypto.PasswordEnvVar, "")
+	if _, err := LoadHistory(); err == nil {
+		t.Error("expected error loading encrypted history without password")
+	}
+}
+
+func TestValueAtAndAsOf(t *testing.T) {
+	t1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	changes := []Change{
+		{Op: OpSet, Existed: true, Previous: "orig", Value: "a", Time: t1},
+		{Op: OpSet, Existed: true, Previous: "a", Value: "b", Time: t1.Add(time.Hour)},
+		{Op: OpDelete, Existed: true, Previous: "b", Time: t1.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		exists bool
+		get    func() (string, bool, error)
+	}{
+		{"at 1", "a", true, func() (string, bool, error) { return ValueAt(changes, 1) }},
+		{"at 3", "", false, func() (string, bool, error) { return ValueAt(changes, 3) }},
+		{"as of before", "orig", true, func() (string, bool, error) { return ValueAsOf(changes, t1.Add(-time.Minute)) }},
+		{"as of between", "b", true, func() (string, bool, error) { return ValueAsOf(changes, t1.Add(90*time.Minute)) }},
+		{"as of exact", "a", true, func() (string, bool, error) { return ValueAsOf(changes, t1) }},
+	}
+	for _, tt := range tests {
+		value, exists, err := tt.get()
+		if err != nil || value != tt.value || exists != tt.exists {
+			t.Errorf("%s = %q, %v, %v; want %q, %v", tt.name, value, exists, err, tt.value, tt.exists)
+		}
+	}
+
+	if _, _, err := ValueAt(changes, 4); err == nil {
+		t.Error("expected error for out-of-range change")
+	}
+}
@@ -0,0 +1,185 @@
+// meta.go implements per-variable metadata and the version 2 file format.
+//
+// Version 1 stored bare values:
+//
+//	version: 1
+//	variables:
+//	  database.host: localhost
+//
+// Version 2 stores each value with its metadata:
+//
+//	version: 2
+//	variables:
+//	  database.password:
+//	    value: secret123
+//	    description: Postgres password for the app user
+//	    tags: [db]
+//	    secret: true
+//	    created: 2025-01-02T15:04:05Z
+//	    updated: 2025-01-03T09:00:00Z
+//
+// Version 1 files are migrated when loaded (values keep no timestamps,
+// since they are unknown) and written back as version 2 on the next save.
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the store file format written by Save.
+const CurrentVersion = 2
+
+// now returns the current time; replaced in tests.
+var now = time.Now
+
+// Meta holds everything about a variable except its value.
+type Meta struct {
+	Description string    `yaml:"description,omitempty" json:"description,omitempty"`
+	Tags        []string  `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Secret      bool      `yaml:"secret,omitempty" json:"secret,omitempty"`
+	Created     time.Time `yaml:"created,omitempty" json:"created,omitempty"`
+	Updated     time.Time `yaml:"updated,omitempty" json:"updated,omitempty"`
+}
+
+// entry is a variable as serialized in a version 2 store.
+type entry struct {
+	Value string `yaml:"value"`
+	Meta  `yaml:",inline"`
+}
+
+// fileFormat is the on-disk layout of a version 2 store.
+type fileFormat struct {
+	Version   int              `yaml:"version"`
+	Variables map[string]entry `yaml:"variables"`
+}
+
+// Meta returns the metadata for a key. Keys without metadata return the
+// zero Meta.
+func (s *Store) Meta(key string) Meta {
+	if m, ok := s.meta[key]; ok && m != nil {
+		meta := *m
+		meta.Tags = append([]string(nil), m.Tags...)
+		return meta
+	}
+	return Meta{}
+}
+
+// SetMeta replaces the metadata for an existing key. Timestamps are
+// managed by Set and are kept as they are.
+// Does not persist - call Save() after making changes.
+func (s *Store) SetMeta(key string, m Meta) error {
+	if _, ok := s.Variables[key]; !ok {
+		return fmt.Errorf("key not found: %s", key)
+	}
+	prev := s.Meta(key)
+	m.Created = prev.Created
+	m.Updated = prev.Updated
+	m.Tags = normalizeTags(m.Tags)
+	if s.meta == nil {
+		s.meta = make(map[string]*Meta)
+	}
+	s.meta[key] = &m
+	s.dirty = true
+	s.markSegment(key)
+	return nil
+}
+
+// IsSecret reports whether a key is flagged as secret.
+func (s *Store) IsSecret(key string) bool {
+	return s.Meta(key).Secret
+}
+
+// touch records a write to key: created on first write, updated always.
+func (s *Store) touch(key string) {
+	if s.meta == nil {
+		s.meta = make(map[string]*Meta)
+	}
+	m, ok := s.meta[key]
+	if !ok || m == nil {
+		m = &Meta{}
+		s.meta[key] = m
+	}
+	t := now().UTC().Truncate(time.Second)
+	if m.Created.IsZero() {
+		m.Created = t
+	}
+	m.Updated = t
+}
+
+// normalizeTags sorts tags and drops empty and duplicate ones.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var out []string
+	for _, t := range tags {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// MarshalYAML writes the store in the current (version 2) format. The
+// variables of projects encrypted separately are left out (see segment.go).
+func (s Store) MarshalYAML() (interface{}, error) {
+	out := fileFormat{
+		Version:   CurrentVersion,
+		Variables: make(map[string]entry, len(s.Variables)),
+	}
+	for key, value := range s.Variables {
+		if s.inSegment(key) {
+			continue
+		}
+		e := entry{Value: value}
+		if m, ok := s.meta[key]; ok && m != nil {
+			e.Meta = *m
+		}
+		out.Variables[key] = e
+	}
+	return out, nil
+}
+
+// UnmarshalYAML reads version 1 and version 2 stores. Each variable may be
+// a bare value (version 1) or a mapping with value and metadata.
+func (s *Store) UnmarshalYAML(node *yaml.Node) error {
+	var raw struct {
+		Version   int                  `yaml:"version"`
+		Variables map[string]yaml.Node `yaml:"variables"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	if raw.Version > CurrentVersion {
+		return fmt.Errorf("store version %d is newer than this varnish supports (%d)", raw.Version, CurrentVersion)
+	}
+
+	s.Version = CurrentVersion
+	s.Variables = make(map[string]string, len(raw.Variables))
+	s.meta = make(map[string]*Meta, len(raw.Variables))
+
+	for key, n := range raw.Variables {
+		switch n.Kind {
+		case yaml.MappingNode:
+			var e entry
+			if err := n.Decode(&e); err != nil {
+				return fmt.Errorf("variable %s: %w", key, err)
+			}
+			s.Variables[key] = e.Value
+			m := e.Meta
+			s.meta[key] = &m
+		default:
+			// Version 1: bare value
+			var value string
+			if err := n.Decode(&value); err != nil {
+				return fmt.Errorf("variable %s: %w", key, err)
+			}
+			s.Variables[key] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNow makes now() return t for the duration of the test.
+func fakeNow(t *testing.T, at time.Time) {
+	t.Helper()
+	orig := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = orig })
+}
+
+func TestMigrateVersion1(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "store.yaml")
+	v1 := "version: 1\nvariables:\n  db.host: localhost\n  db.port: \"5432\"\n"
+	if err := os.WriteFile(path, []byte(v1), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadFrom(path)
+	if err != nil {
+		t.Fatalf("LoadFrom() error: %v", err)
+	}
+	if s.Version != CurrentVersion {
+		t.Errorf("Version = %d, want %d", s.Version, CurrentVersion)
+	}
+	if v, _ := s.Get("db.port"); v != "5432" {
+		t.Errorf("db.port = %q", v)
+	}
+	if m := s.Meta("db.host"); !reflect.DeepEqual(m, Meta{}) {
+		t.Errorf("migrated values should have no metadata, got %+v", m)
+	}
+
+	// Saving writes version 2
+	if err := s.SaveTo(path); err != nil {
+		t.Fatalf("SaveTo() error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "version: 2") || !strings.Contains(string(data), "value: localhost") {
+		t.Errorf("expected version 2 file, got:\n%s", data)
+	}
+}
+
+func TestMetaRoundTrip(t *testing.T) {
+	created := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	fakeNow(t, created)
+
+	s := New()
+	s.Set("db.password", "secret123")
+	if err := s.SetMeta("db.password", Meta{
+		Description: "Postgres password",
+		Tags:        []string{"prod", "db", "prod"},
+		Secret:      true,
+	}); err != nil {
+		t.Fatalf("SetMeta() error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "store.yaml")
+	if err := s.SaveTo(path); err != nil {
+		t.Fatalf("SaveTo() error: %v", err)
+	}
+	loaded, err := LoadFrom(path)
+	if err != nil {
+		t.Fatalf("LoadFrom() error: %v", err)
+	}
+
+	want := Meta{
+		Description: "Postgres password",
+		Tags:        []string{"db", "prod"},
+		Secret:      true,
+		Created:     created,
+		Updated:     created,
+	}
+	if got := loaded.Meta("db.password"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Meta = %+v, want %+v", got, want)
+	}
+	if !loaded.IsSecret("db.password") {
+		t.Error("IsSecret() = false")
+	}
+}
+
+func TestSetTimestamps(t *testing.T) {
+	t1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	s := New()
+	fakeNow(t, t1)
+	s.Set("key", "a")
+
+	fakeNow(t, t2)
+	s.Set("key", "a") // unchanged value is not an update
+	if m := s.Meta("key"); !m.Updated.Equal(t1) {
+		t.Errorf("Updated = %v after same value, want %v", m.Updated, t1)
+	}
+
+	s.Set("key", "b")
+	m := s.Meta("key")
+	if !m.Created.Equal(t1) || !m.Updated.Equal(t2) {
+		t.Errorf("Created = %v, Updated = %v", m.Created, m.Updated)
+	}
+
+	// SetMeta keeps timestamps
+	if err := s.SetMeta("key", Meta{Description: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := s.Meta("key"); !m.Created.Equal(t1) || m.Description != "d" {
+		t.Errorf("Meta after SetMeta = %+v", m)
+	}
+
+	if err := s.SetMeta("missing", Meta{}); err == nil {
+		t.Error("SetMeta on a missing key should fail")
+	}
+
+	s.Delete("key")
+	if m := s.Meta("key"); !reflect.DeepEqual(m, Meta{}) {
+		t.Errorf("metadata should be removed with the key, got %+v", m)
+	}
+}
+
+func TestLoadNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.yaml")
+	if err := os.WriteFile(path, []byte("version: 9\nvariables: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFrom(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected newer-version error, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// recipients.go encrypts the store to X25519 public keys, so a team can
+// share it with each developer unlocking it with their own identity (see
+// crypto/recipient.go).
+//
+// This file is used by:
+//   - cli/store.go: "store recipients add|remove|list"
+//
+// Changing the recipients rewrites store.yaml and history.yaml like a rekey
+// (see rekey.go), keeping backups of the previous ciphertext. Each file is
+// encrypted with a new data key, so someone removed from the recipients
+// can't decrypt anything written afterwards.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+// SetRecipients encrypts the store and its history to recipients, in
+// place of the password or the previous recipients. A plaintext store is
+// encrypted too. If the user's identity is one of the recipients, the new
+// ciphertext is decrypted again and compared before it replaces anything.
+// Returns the paths of the backups of the previous ciphertext.
+func SetRecipients(recipients []crypto.Recipient) ([]string, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients (use 'varnish store decrypt' to turn encryption off)")
+	}
+	identities, _ := crypto.LoadIdentities()
+
+	return rewrite(true, func(plain []byte, _ *crypto.Header) ([]byte, error) {
+		data, err := crypto.EncryptToRecipients(plain, recipients)
+		if err != nil {
+			return nil, err
+		}
+		check, err := crypto.DecryptWithIdentities(data, identities)
+		if errors.Is(err, crypto.ErrNoIdentityMatch) {
+			return data, nil
+		}
+		if err != nil || !bytes.Equal(check, plain) {
+			return nil, errors.New("re-encrypted data did not decrypt to the original")
+		}
+		return data, nil
+	})
+}
+
+// LoadRecipients returns the recipients the store is encrypted to, read
+// from its header without decrypting it. Returns nil if the store is not
+// encrypted to recipients.
+func LoadRecipients() ([]crypto.Recipient, error) {
+	path, err := config.StorePath()
+	if err != nil {
+		return nil, fmt.Errorf("get store path: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read store: %w", err)
+	}
+	if !crypto.IsEncrypted(data) {
+		return nil, nil
+	}
+	h, err := crypto.ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	return h.Recipients, nil
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+// writeIdentity generates an identity and makes it the user's.
+func writeIdentity(t *testing.T) *crypto.Identity {
+	t.Helper()
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	if err := os.WriteFile(path, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(crypto.IdentityEnvVar, path)
+	return id
+}
+
+func TestSetRecipientsFromPassword(t *testing.T) {
+	setupEncryptedStore(t, "password")
+	storePath, _ := config.StorePath()
+	historyPath, _ := config.HistoryPath()
+	me := writeIdentity(t)
+	other, _ := crypto.GenerateIdentity()
+
+	backups, err := SetRecipients([]crypto.Recipient{me.Recipient(), other.Recipient()})
+	if err != nil {
+		t.Fatalf("SetRecipients() error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("backups = %v, want store and history", backups)
+	}
+
+	recipients, err := LoadRecipients()
+	if err != nil {
+		t.Fatalf("LoadRecipients() error: %v", err)
+	}
+	if len(recipients) != 2 || !recipients[1].Equal(other.Recipient()) {
+		t.Errorf("LoadRecipients() = %v", recipients)
+	}
+	for _, path := range []string{storePath, historyPath} {
+		h, err := crypto.ParseHeader(readFile(t, path))
+		if err != nil || h.Version != crypto.VersionRecipients {
+			t.Errorf("%s should be encrypted to recipients", filepath.Base(path))
+		}
+	}
+
+	// No password needed any more
+	unsetenv(t, crypto.PasswordEnvVar)
+	s, err := Load()
+	if err != nil {
+		t.Fatalf("Load() with identity error: %v", err)
+	}
+	if v, _ := s.Get("app.name"); v != "second" {
+		t.Errorf("app.name = %q, want second", v)
+	}
+	if len(s.Recipients()) != 2 {
+		t.Errorf("Recipients() = %v", s.Recipients())
+	}
+
+	// Saving keeps the recipients, for the store and its history
+	s.Set("app.name", "third")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	if r, _ := LoadRecipients(); len(r) != 2 {
+		t.Errorf("recipients after save = %v", r)
+	}
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() error: %v", err)
+	}
+	if n := len(h.For("app.name")); n != 3 {
+		t.Errorf("history has %d changes, want 3", n)
+	}
+
+	// The other recipient can read it with their own identity
+	path := filepath.Join(t.TempDir(), "other.txt")
+	os.WriteFile(path, []byte(other.String()), 0600)
+	t.Setenv(crypto.IdentityEnvVar, path)
+	if s, err := Load(); err != nil {
+		t.Errorf("Load() as the other recipient error: %v", err)
+	} else if v, _ := s.Get("app.name"); v != "third" {
+		t.Errorf("app.name = %q, want third", v)
+	}
+}
+
+func TestSetRecipientsFromPlaintext(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	me := writeIdentity(t)
+	storePath, _ := config.StorePath()
+
+	s := New()
+	s.Set("app.name", "plain")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := SetRecipients([]crypto.Recipient{me.Recipient()})
+	if err != nil {
+		t.Fatalf("SetRecipients() error: %v", err)
+	}
+	if len(backups) != 0 {
+		t.Errorf("no plaintext backup should be kept, got %v", backups)
+	}
+	if _, err := os.Stat(storePath + BackupSuffix); !os.IsNotExist(err) {
+		t.Error("store.yaml.bak should not exist")
+	}
+	if !crypto.IsEncrypted(readFile(t, storePath)) {
+		t.Error("store should be encrypted")
+	}
+	if s, err := Load(); err != nil || !s.IsEncrypted() {
+		t.Errorf("Load() = %v, %v", s, err)
+	}
+}
+
+func TestSetRecipientsRemove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	me := writeIdentity(t)
+	other, _ := crypto.GenerateIdentity()
+	storePath, _ := config.StorePath()
+
+	s := New()
+	s.Set("app.name", "shared")
+	s.Save()
+	if _, err := SetRecipients([]crypto.Recipient{me.Recipient(), other.Recipient()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crypto.DecryptWithIdentities(readFile(t, storePath), []*crypto.Identity{other}); err != nil {
+		t.Fatalf("other recipient should decrypt before removal: %v", err)
+	}
+
+	if _, err := SetRecipients([]crypto.Recipient{me.Recipient()}); err != nil {
+		t.Fatalf("SetRecipients() error: %v", err)
+	}
+	if _, err := crypto.DecryptWithIdentities(readFile(t, storePath), []*crypto.Identity{other}); !errors.Is(err, crypto.ErrNoIdentityMatch) {
+		t.Errorf("removed recipient decrypt error = %v, want ErrNoIdentityMatch", err)
+	}
+
+	if _, err := SetRecipients(nil); err == nil {
+		t.Error("SetRecipients() without recipients should fail")
+	}
+}
+
+func TestRecipientsRekeyAndDecrypt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	me := writeIdentity(t)
+	s := New()
+	s.Set("app.name", "shared")
+	s.Save()
+	if _, err := SetRecipients([]crypto.Recipient{me.Recipient()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Rekey("new-password"); !errors.Is(err, ErrRecipients) {
+		t.Errorf("Rekey() error = %v, want ErrRecipients", err)
+	}
+
+	if _, err := Decrypt(); err != nil {
+		t.Fatalf("Decrypt() error: %v", err)
+	}
+	if r, _ := LoadRecipients(); r != nil {
+		t.Errorf("recipients after Decrypt = %v", r)
+	}
+
+	// Without an identity the store can't be read
+	setupEncryptedStore(t, "password")
+	SetRecipients([]crypto.Recipient{me.Recipient()})
+	t.Setenv(crypto.IdentityEnvVar, filepath.Join(t.TempDir(), "missing.txt"))
+	if _, err := Load(); !errors.Is(err, crypto.ErrIdentityRequired) {
+		t.Errorf("Load() without identity error = %v, want ErrIdentityRequired", err)
+	}
+}
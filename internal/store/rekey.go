@@ -0,0 +1,164 @@
+// rekey.go changes the store password or turns encryption off.
+//
+// This file is used by:
+//   - cli/store.go: "store rekey" and "store decrypt"
+//   - store/segment.go: re-encrypts encrypted projects the same way
+//   - store/recipients.go: changes the recipients the same way
+//
+// Both rewrite store.yaml and history.yaml under the store lock. Every file
+// is converted in memory first, so a wrong password or a failed check
+// changes nothing. The previous ciphertext of each file is then kept next
+// to it as <file>.bak before the file is replaced atomically.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/lock"
+)
+
+// BackupSuffix is appended to a file's path to name its backup.
+const BackupSuffix = ".bak"
+
+// ErrNotEncrypted is returned by Rekey and Decrypt for a plaintext store.
+var ErrNotEncrypted = errors.New("store is not encrypted")
+
+// ErrRecipients is returned by Rekey for a store encrypted to recipients.
+var ErrRecipients = errors.New("store is encrypted to recipients, not a password (see 'varnish store recipients')")
+
+// Rekey re-encrypts the store and its history with newPassword. They are
+// decrypted with the current password (crypto.GetPassword) and encrypted
+// again (see reencrypt) before anything is replaced. Projects encrypted
+// separately are not touched (see RekeyProject). Returns the paths of the
+// backups of the previous ciphertext.
+func Rekey(newPassword string) ([]string, error) {
+	return rewrite(false, func(plain []byte, h *crypto.Header) ([]byte, error) {
+		return reencrypt(plain, h, newPassword)
+	})
+}
+
+// reencrypt encrypts plain, decrypted from a file with header h, with
+// newPassword and the file's key derivation parameters (with config.yaml
+// applied), and checks the result decrypts again.
+func reencrypt(plain []byte, h *crypto.Header, newPassword string) ([]byte, error) {
+	if h.Version == crypto.VersionRecipients {
+		return nil, ErrRecipients
+	}
+	params, err := configuredKDFParams(h.KDF)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.EncryptWith(plain, newPassword, params)
+	if err != nil {
+		return nil, err
+	}
+	check, err := crypto.Decrypt(data, newPassword)
+	if err != nil || !bytes.Equal(check, plain) {
+		return nil, errors.New("re-encrypted data did not decrypt to the original")
+	}
+	return data, nil
+}
+
+// Decrypt writes the store and its history back in plaintext. Returns the
+// paths of the backups of the previous ciphertext.
+func Decrypt() ([]string, error) {
+	return rewrite(false, func(plain []byte, _ *crypto.Header) ([]byte, error) {
+		return plain, nil
+	})
+}
+
+// encryptedFile is a data file being rewritten.
+type encryptedFile struct {
+	path string
+	old  []byte // current ciphertext, nil if plaintext
+	data []byte // converted content
+}
+
+// rewrite decrypts the store and history, passes each through convert with
+// its header, and replaces them with the result, keeping backups of the
+// ciphertext. If plaintext is true, plaintext files are converted too, with
+// a nil header and no backup; otherwise they are left alone and a plaintext
+// store is ErrNotEncrypted.
+func rewrite(plaintext bool, convert func(plain []byte, h *crypto.Header) ([]byte, error)) ([]string, error) {
+	storePath, err := config.StorePath()
+	if err != nil {
+		return nil, fmt.Errorf("get store path: %w", err)
+	}
+	historyPath, err := config.HistoryPath()
+	if err != nil {
+		return nil, fmt.Errorf("get history path: %w", err)
+	}
+
+	// The history is written under the store lock too (see appendHistory)
+	lk, err := lock.Acquire(storePath)
+	if err != nil {
+		return nil, err
+	}
+	defer lk.Release()
+
+	var files []encryptedFile
+	for _, path := range []string{storePath, historyPath} {
+		name := filepath.Base(path)
+		old, err := os.ReadFile(path)
+		if os.IsNotExist(err) && path == historyPath {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		if !crypto.IsEncrypted(old) {
+			if !plaintext {
+				if path == storePath {
+					return nil, ErrNotEncrypted
+				}
+				continue
+			}
+			data, err := convert(old, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			files = append(files, encryptedFile{path: path, data: data})
+			continue
+		}
+
+		plain, _, err := decode(old)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		h, err := crypto.ParseHeader(old)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		data, err := convert(plain, h)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files = append(files, encryptedFile{path: path, old: old, data: data})
+	}
+
+	// Back up everything before replacing anything. A plaintext file is not
+	// kept, so encrypting it leaves no plaintext copy behind.
+	var backups []string
+	for _, f := range files {
+		if f.old == nil {
+			continue
+		}
+		backup := f.path + BackupSuffix
+		if err := config.AtomicWrite(backup, f.old, config.PermSecure); err != nil {
+			return nil, fmt.Errorf("back up %s: %w", filepath.Base(f.path), err)
+		}
+		backups = append(backups, backup)
+	}
+	for _, f := range files {
+		if err := config.AtomicWrite(f.path, f.data, config.PermSecure); err != nil {
+			return backups, fmt.Errorf("write %s: %w", filepath.Base(f.path), err)
+		}
+	}
+	return backups, nil
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+// setupEncryptedStore saves an encrypted store with two changes of
+// app.name under password, so both the store and history are encrypted.
+func setupEncryptedStore(t *testing.T, password string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(crypto.PasswordEnvVar, password)
+
+	s := New()
+	if err := s.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("app.name", "first")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("app.name", "second")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns a file's content, failing the test if it can't.
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRekey(t *testing.T) {
+	setupEncryptedStore(t, "old-password")
+	storePath, _ := config.StorePath()
+	historyPath, _ := config.HistoryPath()
+	oldStore := readFile(t, storePath)
+
+	backups, err := Rekey("new-password")
+	if err != nil {
+		t.Fatalf("Rekey() error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want store and history", backups)
+	}
+
+	// The old password no longer works
+	if _, err := Load(); err == nil {
+		t.Error("Load() with old password should fail")
+	}
+
+	t.Setenv(crypto.PasswordEnvVar, "new-password")
+	s, err := Load()
+	if err != nil {
+		t.Fatalf("Load() with new password error: %v", err)
+	}
+	if !s.IsEncrypted() {
+		t.Error("store should still be encrypted")
+	}
+	if v, _ := s.Get("app.name"); v != "second" {
+		t.Errorf("app.name = %q, want second", v)
+	}
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() with new password error: %v", err)
+	}
+	if n := len(h.For("app.name")); n != 2 {
+		t.Errorf("history has %d changes, want 2", n)
+	}
+	if !crypto.IsEncrypted(readFile(t, historyPath)) {
+		t.Error("history should still be encrypted")
+	}
+
+	// The backup is the previous ciphertext
+	if string(readFile(t, storePath+BackupSuffix)) != string(oldStore) {
+		t.Error("store backup should hold the previous ciphertext")
+	}
+	if _, err := crypto.Decrypt(readFile(t, historyPath+BackupSuffix), "old-password"); err != nil {
+		t.Errorf("history backup should decrypt with the old password: %v", err)
+	}
+}
+
+func TestRekeyWrongPassword(t *testing.T) {
+	setupEncryptedStore(t, "old-password")
+	storePath, _ := config.StorePath()
+	before := readFile(t, storePath)
+
+	t.Setenv(crypto.PasswordEnvVar, "wrong")
+	if _, err := Rekey("new-password"); err == nil {
+		t.Fatal("Rekey() with wrong current password should fail")
+	}
+
+	if string(readFile(t, storePath)) != string(before) {
+		t.Error("store should be unchanged")
+	}
+	if _, err := os.Stat(storePath + BackupSuffix); !os.IsNotExist(err) {
+		t.Error("no backup should be written")
+	}
+}
+
+func TestRekeyNotEncrypted(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if _, err := Rekey("new-password"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Rekey() without a store error = %v, want ErrNotEncrypted", err)
+	}
+
+	s := New()
+	s.Set("app.name", "plain")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rekey("new-password"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Rekey() on a plaintext store error = %v, want ErrNotEncrypted", err)
+	}
+	if _, err := Decrypt(); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Decrypt() on a plaintext store error = %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	setupEncryptedStore(t, "old-password")
+	storePath, _ := config.StorePath()
+	historyPath, _ := config.HistoryPath()
+
+	backups, err := Decrypt()
+	if err != nil {
+		t.Fatalf("Decrypt() error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want store and history", backups)
+	}
+
+	if crypto.IsEncrypted(readFile(t, storePath)) || crypto.IsEncrypted(readFile(t, historyPath)) {
+		t.Error("store and history should be plaintext")
+	}
+	if !crypto.IsEncrypted(readFile(t, storePath+BackupSuffix)) {
+		t.Error("store backup should be the previous ciphertext")
+	}
+
+	// Readable without a password
+	unsetenv(t, crypto.PasswordEnvVar)
+	unsetenv(t, crypto.PasswordCommandEnvVar)
+	s, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if s.IsEncrypted() {
+		t.Error("store should not be encrypted")
+	}
+	if v, _ := s.Get("app.name"); v != "second" {
+		t.Errorf("app.name = %q, want second", v)
+	}
+
+	// Later saves stay in plaintext
+	s.Set("app.name", "third")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	if crypto.IsEncrypted(readFile(t, historyPath)) {
+		t.Error("history should stay plaintext")
+	}
+}
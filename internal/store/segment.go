@@ -0,0 +1,454 @@
+// segment.go encrypts projects separately from the rest of the store, each
+// with its own password.
+//
+// This file is used by:
+//   - store/store.go: loads segments locked and writes the changed ones on save
+//   - cli/store.go: "store encrypt/rekey/decrypt --project"
+//   - cli/env.go, cli/run.go and the other resolving commands: Unlock the
+//     projects they resolve
+//
+// An encrypted project's variables (myapp.* and myapp@<profile>.*) and their
+// history move out of store.yaml and history.yaml into a segment,
+// ~/.varnish/segments/myapp.yaml, encrypted with the project's password
+// (see crypto.ProjectPassword). Decrypted, a segment looks like the store
+// with the project's history appended:
+//
+//	version: 2
+//	variables:
+//	  myapp.database.password:
+//	    value: secret123
+//	changes:
+//	  - key: myapp.database.password
+//	    op: set
+//	    ...
+//
+// Load leaves every segment locked: its variables are not in the store
+// until Unlock decrypts it, so a command needs only the passwords of the
+// projects it uses. Save writes back only the segments that changed, and
+// changing a locked project's variables is an error.
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/lock"
+	"gopkg.in/yaml.v3"
+)
+
+// segment is a project encrypted separately.
+type segment struct {
+	unlocked bool
+	dirty    bool // changed since unlocked or saved
+
+	// Key derivation parameters and salt the segment was encrypted with
+	kdf  crypto.KDFParams
+	salt []byte
+
+	changes []Change // the project's history
+}
+
+// segmentFile is the decrypted content of a segment.
+type segmentFile struct {
+	Version   int              `yaml:"version"`
+	Variables map[string]entry `yaml:"variables"`
+	Changes   []Change         `yaml:"changes,omitempty"`
+}
+
+// ProjectOf returns the project a store key belongs to: "myapp" for
+// myapp.db.host and myapp@test.db.host, "" for a key without a namespace.
+func ProjectOf(key string) string {
+	ns, _, ok := strings.Cut(key, ".")
+	if !ok {
+		return ""
+	}
+	// As project.SplitNamespace
+	proj, _, _ := strings.Cut(ns, "@")
+	return proj
+}
+
+// validProjectName reports an error if name can't be a segment file name.
+func validProjectName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `.@/\`) {
+		return fmt.Errorf("invalid project name %q", name)
+	}
+	return nil
+}
+
+// loadSegments finds the encrypted projects, all locked.
+func loadSegments() (map[string]*segment, error) {
+	paths, err := filepath.Glob(filepath.Join(config.SegmentsDir(), "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("find encrypted projects: %w", err)
+	}
+	segments := make(map[string]*segment, len(paths))
+	for _, path := range paths {
+		segments[strings.TrimSuffix(filepath.Base(path), ".yaml")] = &segment{}
+	}
+	return segments, nil
+}
+
+// EncryptedProjects returns the projects encrypted separately, sorted.
+func (s *Store) EncryptedProjects() []string {
+	names := make([]string, 0, len(s.segments))
+	for name := range s.segments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsLocked reports whether project is encrypted separately and its
+// variables are not unlocked.
+func (s *Store) IsLocked(project string) bool {
+	seg, ok := s.segments[project]
+	return ok && !seg.unlocked
+}
+
+// Unlock decrypts the segments of projects and adds their variables to the
+// store. Projects that aren't encrypted separately, or are already
+// unlocked, are skipped.
+func (s *Store) Unlock(projects ...string) error {
+	for _, name := range projects {
+		seg, ok := s.segments[name]
+		if !ok || seg.unlocked {
+			continue
+		}
+		data, err := os.ReadFile(config.SegmentPathFor(name))
+		if err != nil {
+			return fmt.Errorf("read project %s: %w", name, err)
+		}
+		f, h, err := decodeSegment(name, data)
+		if err != nil {
+			return err
+		}
+
+		for key, e := range f.Variables {
+			if ProjectOf(key) != name {
+				return fmt.Errorf("project %s: variable %s belongs to another project", name, key)
+			}
+			s.Variables[key] = e.Value
+			m := e.Meta
+			s.meta[key] = &m
+		}
+		seg.changes = f.Changes
+		seg.kdf = h.KDF
+		seg.salt = append([]byte(nil), h.Salt...)
+		seg.unlocked = true
+	}
+	return nil
+}
+
+// decodeSegment decrypts a segment with a key from the unlock agent or the
+// project's password.
+func decodeSegment(name string, data []byte) (*segmentFile, *crypto.Header, error) {
+	plain, h, err := decryptSegment(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var f segmentFile
+	if err := yaml.Unmarshal(plain, &f); err != nil {
+		return nil, nil, fmt.Errorf("parse project %s: %w", name, err)
+	}
+	if f.Version > CurrentVersion {
+		return nil, nil, fmt.Errorf("project %s: version %d is newer than this varnish supports (%d)", name, f.Version, CurrentVersion)
+	}
+	return &f, h, nil
+}
+
+// decryptSegment returns the plaintext of a segment and its header.
+func decryptSegment(name string, data []byte) ([]byte, *crypto.Header, error) {
+	h, err := crypto.ParseHeader(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("project %s: %w", name, err)
+	}
+	if h.Version == crypto.VersionRecipients {
+		return nil, nil, fmt.Errorf("project %s: %w", name, crypto.ErrRecipientsEncrypted)
+	}
+	if plain, ok := decryptWithAgent(data); ok {
+		return plain, h, nil
+	}
+	password, err := crypto.ProjectPassword(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := crypto.Decrypt(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypt project %s: %w", name, err)
+	}
+	return plain, h, nil
+}
+
+// EncryptProject moves project's variables and history into a segment of
+// their own when the store is saved, encrypted with the project's password
+// (crypto.ProjectPassword).
+func (s *Store) EncryptProject(project string) error {
+	if err := validProjectName(project); err != nil {
+		return err
+	}
+	if _, ok := s.segments[project]; ok {
+		return fmt.Errorf("project %s is already encrypted separately", project)
+	}
+	if _, err := crypto.ProjectPassword(project); err != nil {
+		return err
+	}
+
+	h, _, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	seg := &segment{unlocked: true, dirty: true}
+	for _, c := range h.Changes {
+		if ProjectOf(c.Key) == project {
+			seg.changes = append(seg.changes, c)
+		}
+	}
+
+	if s.segments == nil {
+		s.segments = make(map[string]*segment)
+	}
+	s.segments[project] = seg
+	s.removed = removeString(s.removed, project)
+	s.dirty = true
+	return nil
+}
+
+// DecryptProject moves an encrypted project's variables and history back
+// into the store when it is saved, and deletes its segment.
+func (s *Store) DecryptProject(project string) error {
+	seg, ok := s.segments[project]
+	if !ok {
+		return fmt.Errorf("project %s is not encrypted separately", project)
+	}
+	if err := s.Unlock(project); err != nil {
+		return err
+	}
+	s.pending = append(append([]Change(nil), seg.changes...), s.pending...)
+	delete(s.segments, project)
+	s.removed = append(s.removed, project)
+	s.dirty = true
+	return nil
+}
+
+// DropProject deletes an encrypted project's segment, with its variables
+// and history, when the store is saved. It doesn't need the project's
+// password, and the deletion is not recorded in any history.
+func (s *Store) DropProject(project string) error {
+	if _, ok := s.segments[project]; !ok {
+		return fmt.Errorf("project %s is not encrypted separately", project)
+	}
+	for key := range s.Variables {
+		if ProjectOf(key) == project {
+			delete(s.Variables, key)
+			delete(s.meta, key)
+		}
+	}
+	kept := s.pending[:0]
+	for _, c := range s.pending {
+		if ProjectOf(c.Key) != project {
+			kept = append(kept, c)
+		}
+	}
+	s.pending = kept
+	delete(s.segments, project)
+	s.removed = append(s.removed, project)
+	s.dirty = true
+	return nil
+}
+
+// RekeyProject re-encrypts an encrypted project with newPassword, like
+// Rekey does for the store. Other projects and the store are not touched.
+// Returns the path of the backup of the previous ciphertext.
+func RekeyProject(project, newPassword string) ([]string, error) {
+	storePath, err := config.StorePath()
+	if err != nil {
+		return nil, fmt.Errorf("get store path: %w", err)
+	}
+	// Segments are written under the store lock (see save)
+	lk, err := lock.Acquire(storePath)
+	if err != nil {
+		return nil, err
+	}
+	defer lk.Release()
+
+	path := config.SegmentPathFor(project)
+	old, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("project %s is not encrypted separately", project)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read project %s: %w", project, err)
+	}
+	plain, h, err := decryptSegment(project, old)
+	if err != nil {
+		return nil, err
+	}
+	data, err := reencrypt(plain, h, newPassword)
+	if err != nil {
+		return nil, fmt.Errorf("project %s: %w", project, err)
+	}
+
+	backup := path + BackupSuffix
+	if err := config.AtomicWrite(backup, old, config.PermSecure); err != nil {
+		return nil, fmt.Errorf("back up project %s: %w", project, err)
+	}
+	if err := config.AtomicWrite(path, data, config.PermSecure); err != nil {
+		return []string{backup}, fmt.Errorf("write project %s: %w", project, err)
+	}
+	return []string{backup}, nil
+}
+
+// markSegment notes that key changed, so its segment is written on save.
+func (s *Store) markSegment(key string) {
+	if seg, ok := s.segments[ProjectOf(key)]; ok {
+		seg.dirty = true
+	}
+}
+
+// inSegment reports whether key belongs to an encrypted project.
+func (s *Store) inSegment(key string) bool {
+	_, ok := s.segments[ProjectOf(key)]
+	return ok
+}
+
+// saveSegments moves the pending changes of encrypted projects into their
+// history and writes the segments that changed. The caller holds the store
+// lock.
+func (s *Store) saveSegments() error {
+	for _, c := range s.pending {
+		if s.IsLocked(ProjectOf(c.Key)) {
+			return fmt.Errorf("project %s is encrypted separately and locked", ProjectOf(c.Key))
+		}
+	}
+
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return err
+	}
+	limit := settings.HistoryLimit()
+
+	kept := s.pending[:0:0]
+	for _, c := range s.pending {
+		seg, ok := s.segments[ProjectOf(c.Key)]
+		if !ok {
+			kept = append(kept, c)
+			continue
+		}
+		if limit != 0 {
+			seg.changes = prune(append(seg.changes, c), limit)
+		}
+	}
+	s.pending = kept
+
+	for _, name := range s.EncryptedProjects() {
+		seg := s.segments[name]
+		if !seg.unlocked || !seg.dirty {
+			continue
+		}
+		if err := s.writeSegment(name, seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSegments deletes the segments of projects no longer encrypted
+// separately. The caller holds the store lock and has saved the store, so
+// their variables are not lost if this fails.
+func (s *Store) removeSegments() error {
+	for len(s.removed) > 0 {
+		name := s.removed[0]
+		if err := os.Remove(config.SegmentPathFor(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove project %s: %w", name, err)
+		}
+		s.removed = s.removed[1:]
+	}
+	return nil
+}
+
+// writeSegment encrypts a project's variables and history to its segment,
+// with the unlock agent's key if it holds the one the segment was read
+// with, else with the project's password.
+func (s *Store) writeSegment(name string, seg *segment) error {
+	f := segmentFile{
+		Version:   CurrentVersion,
+		Variables: make(map[string]entry),
+		Changes:   seg.changes,
+	}
+	for key, value := range s.Variables {
+		if ProjectOf(key) != name {
+			continue
+		}
+		e := entry{Value: value}
+		if m, ok := s.meta[key]; ok && m != nil {
+			e.Meta = *m
+		}
+		f.Variables[key] = e
+	}
+	plain, err := yaml.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("marshal project %s: %w", name, err)
+	}
+
+	params, err := configuredKDFParams(seg.kdf)
+	if err != nil {
+		return err
+	}
+	data, ok := encryptWithAgent(plain, params, seg.salt)
+	if !ok {
+		password, err := crypto.ProjectPassword(name)
+		if err != nil {
+			return err
+		}
+		if data, err = crypto.EncryptWith(plain, password, params); err != nil {
+			return fmt.Errorf("encrypt project %s: %w", name, err)
+		}
+	}
+
+	if err := config.EnsureSegmentsDir(); err != nil {
+		return fmt.Errorf("create segments dir: %w", err)
+	}
+	if err := config.AtomicWrite(config.SegmentPathFor(name), data, config.PermSecure); err != nil {
+		return fmt.Errorf("write project %s: %w", name, err)
+	}
+
+	h, err := crypto.ParseHeader(data)
+	if err != nil {
+		return err
+	}
+	seg.kdf = h.KDF
+	seg.salt = append([]byte(nil), h.Salt...)
+	seg.dirty = false
+	return nil
+}
+
+// History returns the change history of the store and of its unlocked
+// encrypted projects.
+func (s *Store) History() (*History, error) {
+	h, err := LoadHistory()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range s.EncryptedProjects() {
+		if seg := s.segments[name]; seg.unlocked {
+			h.Changes = append(h.Changes, seg.changes...)
+		}
+	}
+	return h, nil
+}
+
+// removeString returns list without s.
+func removeString(list []string, s string) []string {
+	out := list[:0:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
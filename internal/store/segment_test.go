@@ -0,0 +1,287 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dk/varnish/internal/config"
+	"github.com/dk/varnish/internal/crypto"
+)
+
+// setupProjects saves a plaintext store with variables of myapp (twice, so
+// it has history), myapp@test and api, then encrypts myapp separately with
+// password.
+func setupProjects(t *testing.T, password string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	unsetenv(t, crypto.PasswordEnvVar)
+	unsetenv(t, crypto.ProjectPasswordCommandEnvVar)
+	unsetenv(t, "VARNISH_PASSWORD_API")
+	t.Setenv("VARNISH_PASSWORD_MYAPP", password)
+
+	s := New()
+	s.Set("myapp.db.host", "first")
+	s.Set("api.url", "https://api.example.com")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("myapp.db.host", "second")
+	s.Set("myapp@test.db.host", "test-host")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Update(func(s *Store) error {
+		return s.EncryptProject("myapp")
+	})
+	if err != nil {
+		t.Fatalf("EncryptProject() error: %v", err)
+	}
+}
+
+func TestProjectOf(t *testing.T) {
+	tests := map[string]string{
+		"myapp.db.host":      "myapp",
+		"myapp@test.db.host": "myapp",
+		"port":               "",
+	}
+	for key, want := range tests {
+		if got := ProjectOf(key); got != want {
+			t.Errorf("ProjectOf(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestEncryptProject(t *testing.T) {
+	setupProjects(t, "myapp-password")
+	storePath, _ := config.StorePath()
+	historyPath, _ := config.HistoryPath()
+	segmentPath := config.SegmentPathFor("myapp")
+
+	// The project's variables and history left the plaintext files
+	for _, path := range []string{storePath, historyPath} {
+		if bytes.Contains(readFile(t, path), []byte("myapp")) {
+			t.Errorf("%s still mentions myapp", path)
+		}
+	}
+	if !crypto.IsEncrypted(readFile(t, segmentPath)) {
+		t.Error("segment should be encrypted")
+	}
+	if info, err := os.Stat(segmentPath); err != nil || info.Mode().Perm() != config.PermSecure {
+		t.Errorf("segment permissions: %v, %v", info, err)
+	}
+
+	// Loaded locked: other projects work without the password
+	unsetenv(t, "VARNISH_PASSWORD_MYAPP")
+	s, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !s.IsLocked("myapp") || s.IsLocked("api") {
+		t.Errorf("IsLocked(myapp) = %v, IsLocked(api) = %v", s.IsLocked("myapp"), s.IsLocked("api"))
+	}
+	if _, ok := s.Get("myapp.db.host"); ok {
+		t.Error("a locked project's variables should not be in the store")
+	}
+	if v, _ := s.Get("api.url"); v != "https://api.example.com" {
+		t.Errorf("api.url = %q", v)
+	}
+	if err := s.Unlock("myapp"); !errors.Is(err, crypto.ErrProjectPasswordRequired) {
+		t.Errorf("Unlock() without password error = %v, want ErrProjectPasswordRequired", err)
+	}
+
+	t.Setenv("VARNISH_PASSWORD_MYAPP", "myapp-password")
+	if err := s.Unlock("myapp", "api"); err != nil {
+		t.Fatalf("Unlock() error: %v", err)
+	}
+	if v, _ := s.Get("myapp.db.host"); v != "second" {
+		t.Errorf("myapp.db.host = %q, want second", v)
+	}
+	if v, _ := s.Get("myapp@test.db.host"); v != "test-host" {
+		t.Errorf("myapp@test.db.host = %q, want test-host", v)
+	}
+	h, err := s.History()
+	if err != nil {
+		t.Fatalf("History() error: %v", err)
+	}
+	if n := len(h.For("myapp.db.host")); n != 2 {
+		t.Errorf("history of myapp.db.host has %d changes, want 2", n)
+	}
+	if got := s.EncryptedProjects(); len(got) != 1 || got[0] != "myapp" {
+		t.Errorf("EncryptedProjects() = %v", got)
+	}
+}
+
+func TestEncryptProjectErrors(t *testing.T) {
+	setupProjects(t, "myapp-password")
+
+	err := Update(func(s *Store) error {
+		return s.EncryptProject("myapp")
+	})
+	if err == nil {
+		t.Error("encrypting a project twice should fail")
+	}
+
+	err = Update(func(s *Store) error {
+		return s.EncryptProject("../escape")
+	})
+	if err == nil {
+		t.Error("expected an error for an invalid project name")
+	}
+
+	err = Update(func(s *Store) error {
+		return s.EncryptProject("api")
+	})
+	if !errors.Is(err, crypto.ErrProjectPasswordRequired) {
+		t.Errorf("EncryptProject() without password error = %v, want ErrProjectPasswordRequired", err)
+	}
+}
+
+func TestLockedProjectWrite(t *testing.T) {
+	setupProjects(t, "myapp-password")
+	segmentPath := config.SegmentPathFor("myapp")
+	before := readFile(t, segmentPath)
+
+	// Other projects change without unlocking or rewriting myapp
+	err := Update(func(s *Store) error {
+		s.Set("api.url", "https://new.example.com")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if !bytes.Equal(readFile(t, segmentPath), before) {
+		t.Error("changing another project rewrote the segment")
+	}
+
+	err = Update(func(s *Store) error {
+		s.Set("myapp.db.port", "5432")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("writing to a locked project should fail")
+	}
+
+	err = Update(func(s *Store) error {
+		if err := s.Unlock("myapp"); err != nil {
+			return err
+		}
+		s.Set("myapp.db.port", "5432")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() of unlocked project error: %v", err)
+	}
+
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlock("myapp"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("myapp.db.port"); v != "5432" {
+		t.Errorf("myapp.db.port = %q, want 5432", v)
+	}
+	h, err := s.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.For("myapp.db.port")); n != 1 {
+		t.Errorf("history of myapp.db.port has %d changes, want 1", n)
+	}
+	if main, _ := LoadHistory(); len(main.For("myapp.db.port")) != 0 {
+		t.Error("an encrypted project's changes should not be in history.yaml")
+	}
+}
+
+func TestRekeyProject(t *testing.T) {
+	setupProjects(t, "old-password")
+	t.Setenv("VARNISH_PASSWORD_API", "api-password")
+	if err := Update(func(s *Store) error { return s.EncryptProject("api") }); err != nil {
+		t.Fatal(err)
+	}
+	apiBefore := readFile(t, config.SegmentPathFor("api"))
+
+	backups, err := RekeyProject("myapp", "new-password")
+	if err != nil {
+		t.Fatalf("RekeyProject() error: %v", err)
+	}
+	if len(backups) != 1 || backups[0] != config.SegmentPathFor("myapp")+BackupSuffix {
+		t.Errorf("backups = %v", backups)
+	}
+	if !bytes.Equal(readFile(t, config.SegmentPathFor("api")), apiBefore) {
+		t.Error("rekeying myapp changed api")
+	}
+
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlock("myapp"); err == nil {
+		t.Error("Unlock() with the old password should fail")
+	}
+	t.Setenv("VARNISH_PASSWORD_MYAPP", "new-password")
+	if err := s.Unlock("myapp"); err != nil {
+		t.Fatalf("Unlock() with the new password error: %v", err)
+	}
+	if v, _ := s.Get("myapp.db.host"); v != "second" {
+		t.Errorf("myapp.db.host = %q, want second", v)
+	}
+
+	if _, err := RekeyProject("other", "pw"); err == nil {
+		t.Error("rekeying a project that isn't encrypted should fail")
+	}
+}
+
+func TestDecryptProject(t *testing.T) {
+	setupProjects(t, "myapp-password")
+
+	if err := Update(func(s *Store) error { return s.DecryptProject("myapp") }); err != nil {
+		t.Fatalf("DecryptProject() error: %v", err)
+	}
+	if _, err := os.Stat(config.SegmentPathFor("myapp")); !os.IsNotExist(err) {
+		t.Errorf("segment should be removed, stat error = %v", err)
+	}
+
+	unsetenv(t, "VARNISH_PASSWORD_MYAPP")
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("myapp.db.host"); v != "second" {
+		t.Errorf("myapp.db.host = %q, want second", v)
+	}
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.For("myapp.db.host")); n != 2 {
+		t.Errorf("history.yaml has %d changes of myapp.db.host, want 2", n)
+	}
+}
+
+func TestDropProject(t *testing.T) {
+	setupProjects(t, "myapp-password")
+	unsetenv(t, "VARNISH_PASSWORD_MYAPP")
+
+	// No password needed
+	if err := Update(func(s *Store) error { return s.DropProject("myapp") }); err != nil {
+		t.Fatalf("DropProject() error: %v", err)
+	}
+	if _, err := os.Stat(config.SegmentPathFor("myapp")); !os.IsNotExist(err) {
+		t.Errorf("segment should be removed, stat error = %v", err)
+	}
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.EncryptedProjects()) != 0 {
+		t.Errorf("EncryptedProjects() = %v, want none", s.EncryptedProjects())
+	}
+	if _, ok := s.Get("myapp.db.host"); ok {
+		t.Error("dropped project's variables should be gone")
+	}
+}
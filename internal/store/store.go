@@ -6,8 +6,20 @@
 //	database.password: secret123
 //	aws.region: us-east-1
 //
+// Each variable can carry metadata: a description, tags, a secret flag and
+// created/updated timestamps (see meta.go).
+//
 // Writes are atomic: we write to a temp file then rename, so a crash
-// mid-write won't corrupt the store.
+// mid-write won't corrupt the store. Every change is also recorded in the
+// history (see history.go), so previous values can be restored.
+//
+// Projects can be encrypted separately, each with its own password (see
+// segment.go); their variables are only in the store once unlocked.
+//
+// Load, Save and Remove hold an advisory lock on the store file (see
+// lock/lock.go). Read-modify-write sequences should use Update, which
+// holds the lock from load to save so concurrent commands can't lose each
+// other's changes.
 package store
 
 import (
@@ -18,70 +30,102 @@ import (
 
 	"github.com/dk/varnish/internal/config"
 	"github.com/dk/varnish/internal/crypto"
+	"github.com/dk/varnish/internal/lock"
 	"gopkg.in/yaml.v3"
 )
 
 // Store holds all variables in the central store.
 // The YAML file looks like:
 //
-//	version: 1
+//	version: 2
 //	variables:
-//	  database.host: localhost
-//	  database.password: secret123
+//	  database.host:
+//	    value: localhost
+//	  database.password:
+//	    value: secret123
+//	    secret: true
+//
+// Variables holds the values; metadata is kept alongside (see meta.go).
 type Store struct {
-	Version   int               `yaml:"version"`
-	Variables map[string]string `yaml:"variables"`
-	encrypted bool              // runtime flag, not serialized
+	Version   int
+	Variables map[string]string
+	meta      map[string]*Meta // per-variable metadata
+	pending   []Change         // changes not yet in the history (see history.go)
+	dirty     bool             // changed since loaded or saved
+	encrypted bool             // runtime flag, not serialized
+
+	// Key derivation parameters and salt the store was encrypted with, and
+	// any parameters set with SetKDFParams (see KDFParams)
+	kdf         crypto.KDFParams
+	salt        []byte
+	kdfOverride *crypto.KDFParams
+
+	// Public keys the store is encrypted to instead of a password (see
+	// recipients.go)
+	recipients []crypto.Recipient
+
+	// Projects encrypted separately, and those to stop encrypting
+	// separately on save (see segment.go)
+	segments map[string]*segment
+	removed  []string
 }
 
-// New creates an empty store with version 1.
+// New creates an empty store in the current format version.
 func New() *Store {
 	return &Store{
-		Version:   1,
+		Version:   CurrentVersion,
 		Variables: make(map[string]string),
+		meta:      make(map[string]*Meta),
 	}
 }
 
 // Load reads the store from ~/.varnish/store.yaml.
 // If the file doesn't exist, returns an empty store (not an error).
-// If the store is encrypted, requires VARNISH_PASSWORD to be set.
+// If the store is encrypted, requires the unlock agent or a password, or
+// an identity if it is encrypted to recipients.
 func Load() (*Store, error) {
 	path, err := config.StorePath()
 	if err != nil {
 		return nil, fmt.Errorf("get store path: %w", err)
 	}
 
-	data, err := os.ReadFile(path)
-	if os.IsNotExist(err) {
-		// No store yet, return empty one
-		return New(), nil
-	}
+	lk, err := lock.Acquire(path)
 	if err != nil {
+		return nil, err
+	}
+	defer lk.Release()
+
+	return load(path)
+}
+
+// load reads the store at path, with its encrypted projects locked. The
+// caller holds the lock.
+func load(path string) (*Store, error) {
+	var s *Store
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		// No store yet, start an empty one
+		s = New()
+	case err != nil:
 		return nil, fmt.Errorf("read store: %w", err)
+	default:
+		if s, err = parseStoreData(data); err != nil {
+			return nil, err
+		}
 	}
 
-	return parseStoreData(data)
+	if s.segments, err = loadSegments(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // parseStoreData parses store data, handling both encrypted and plain formats.
 func parseStoreData(data []byte) (*Store, error) {
-	var yamlData []byte
-	var isEncrypted bool
-
-	if crypto.IsEncrypted(data) {
-		password, err := crypto.GetPassword()
-		if err != nil {
-			return nil, fmt.Errorf("encrypted store requires password: %w", err)
-		}
-
-		decrypted, err := crypto.Decrypt(data, password)
-		if err != nil {
-			return nil, fmt.Errorf("decrypt store: %w", err)
-		}
-		yamlData = decrypted
-		isEncrypted = true
-	} else {
-		yamlData = data
+	yamlData, isEncrypted, err := decode(data)
+	if err != nil {
+		return nil, err
 	}
 
 	var s Store
@@ -89,12 +133,27 @@ func parseStoreData(data []byte) (*Store, error) {
 		return nil, fmt.Errorf("parse store: %w", err)
 	}
 
-	// Ensure map is initialized even if YAML had empty variables
+	// Ensure maps are initialized even if YAML had no variables
 	if s.Variables == nil {
 		s.Variables = make(map[string]string)
 	}
+	if s.meta == nil {
+		s.meta = make(map[string]*Meta)
+	}
+	if s.Version == 0 {
+		s.Version = CurrentVersion
+	}
 
 	s.encrypted = isEncrypted
+	if isEncrypted {
+		h, err := crypto.ParseHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		s.kdf = h.KDF
+		s.salt = append([]byte(nil), h.Salt...)
+		s.recipients = h.Recipients
+	}
 	return &s, nil
 }
 
@@ -102,6 +161,9 @@ func parseStoreData(data []byte) (*Store, error) {
 // Atomic write: write to temp file, then rename. This prevents corruption
 // if the process is killed mid-write.
 // If encryption is enabled, encrypts the data before writing.
+//
+// Save overwrites changes other processes made since the store was loaded;
+// use Update to modify the store safely.
 func (s *Store) Save() error {
 	// Ensure the directory exists
 	if err := config.EnsureVarnishDir(); err != nil {
@@ -113,6 +175,57 @@ func (s *Store) Save() error {
 		return fmt.Errorf("get store path: %w", err)
 	}
 
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	return s.save(path)
+}
+
+// Update loads the store, passes it to fn, and saves it if fn succeeded
+// and changed anything, holding the store lock throughout. If fn returns
+// an error nothing is saved and the error is returned as is.
+func Update(fn func(*Store) error) error {
+	if err := config.EnsureVarnishDir(); err != nil {
+		return fmt.Errorf("create varnish dir: %w", err)
+	}
+
+	path, err := config.StorePath()
+	if err != nil {
+		return fmt.Errorf("get store path: %w", err)
+	}
+
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	s, err := load(path)
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+	if !s.dirty {
+		return nil
+	}
+	if err := s.save(path); err != nil {
+		return fmt.Errorf("save store: %w", err)
+	}
+	return nil
+}
+
+// save writes the store to path. The caller holds the lock.
+func (s *Store) save(path string) error {
+	// Encrypted projects first, so their variables are never only in memory
+	if err := s.saveSegments(); err != nil {
+		return err
+	}
+
 	// Marshal to YAML
 	yamlData, err := yaml.Marshal(s)
 	if err != nil {
@@ -120,19 +233,9 @@ func (s *Store) Save() error {
 	}
 
 	// Encrypt if enabled
-	var data []byte
-	if s.encrypted {
-		password, err := crypto.GetPassword()
-		if err != nil {
-			return fmt.Errorf("encryption requires password: %w", err)
-		}
-		encrypted, err := crypto.Encrypt(yamlData, password)
-		if err != nil {
-			return fmt.Errorf("encrypt store: %w", err)
-		}
-		data = encrypted
-	} else {
-		data = yamlData
+	data, err := s.encode(yamlData)
+	if err != nil {
+		return err
 	}
 
 	// Write to temp file in same directory (same filesystem for atomic rename)
@@ -179,7 +282,14 @@ func (s *Store) Save() error {
 	// Clear tmpPath so defer doesn't try to remove it
 	tmpPath = ""
 
-	return nil
+	s.dirty = false
+
+	// Record what changed
+	if err := s.appendHistory(); err != nil {
+		return fmt.Errorf("save history: %w", err)
+	}
+
+	return s.removeSegments()
 }
 
 // SaveTo writes the store to a specific path (for testing).
@@ -190,22 +300,77 @@ func (s *Store) SaveTo(path string) error {
 		return fmt.Errorf("marshal store: %w", err)
 	}
 
-	var data []byte
-	if s.encrypted {
-		password, err := crypto.GetPassword()
+	data, err := s.encode(yamlData)
+	if err != nil {
+		return err
+	}
+
+	return config.AtomicWrite(path, data, config.PermSecure)
+}
+
+// encode encrypts data if the store is encrypted: to its recipients if it
+// has any, else with the unlock agent's key if it holds the one the store
+// was loaded with, else with the store password. It always writes the
+// current format, so older files are upgraded when saved.
+func (s *Store) encode(data []byte) ([]byte, error) {
+	if !s.encrypted {
+		return data, nil
+	}
+	if len(s.recipients) > 0 {
+		out, err := crypto.EncryptToRecipients(data, s.recipients)
 		if err != nil {
-			return fmt.Errorf("encryption requires password: %w", err)
+			return nil, fmt.Errorf("encrypt store: %w", err)
 		}
-		encrypted, err := crypto.Encrypt(yamlData, password)
+		return out, nil
+	}
+	params, err := s.KDFParams()
+	if err != nil {
+		return nil, err
+	}
+	if out, ok := encryptWithAgent(data, params, s.salt); ok {
+		return out, nil
+	}
+	password, err := crypto.GetPassword()
+	if err != nil {
+		return nil, fmt.Errorf("encryption requires password: %w", err)
+	}
+	out, err := crypto.EncryptWith(data, password, params)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt store: %w", err)
+	}
+	return out, nil
+}
+
+// decode decrypts data if it is encrypted, with the user's identity if it
+// is encrypted to recipients, else with a key from the unlock agent or the
+// store password, and reports whether it was.
+func decode(data []byte) ([]byte, bool, error) {
+	if !crypto.IsEncrypted(data) {
+		return data, false, nil
+	}
+	if h, err := crypto.ParseHeader(data); err == nil && h.Version == crypto.VersionRecipients {
+		identities, err := crypto.LoadIdentities()
+		if err != nil {
+			return nil, false, fmt.Errorf("encrypted store requires identity: %w", err)
+		}
+		plain, err := crypto.DecryptWithIdentities(data, identities)
 		if err != nil {
-			return fmt.Errorf("encrypt store: %w", err)
+			return nil, false, fmt.Errorf("decrypt store: %w", err)
 		}
-		data = encrypted
-	} else {
-		data = yamlData
+		return plain, true, nil
 	}
-
-	return config.AtomicWrite(path, data, config.PermSecure)
+	if plain, ok := decryptWithAgent(data); ok {
+		return plain, true, nil
+	}
+	password, err := crypto.GetPassword()
+	if err != nil {
+		return nil, false, fmt.Errorf("encrypted store requires password: %w", err)
+	}
+	decrypted, err := crypto.Decrypt(data, password)
+	if err != nil {
+		return nil, false, fmt.Errorf("decrypt store: %w", err)
+	}
+	return decrypted, true, nil
 }
 
 // LoadFrom reads a store from a specific path (for testing).
@@ -219,10 +384,17 @@ func LoadFrom(path string) (*Store, error) {
 	return parseStoreData(data)
 }
 
-// Set adds or updates a variable in the store.
+// Set adds or updates a variable in the store, recording when it was
+// created and last changed. Setting the same value again is not a change.
 // Does not persist - call Save() after making changes.
 func (s *Store) Set(key, value string) {
+	prev, existed := s.Variables[key]
+	if existed && prev == value {
+		return
+	}
+	s.record(key, OpSet, prev, existed, value)
 	s.Variables[key] = value
+	s.touch(key)
 }
 
 // Get retrieves a variable from the store.
@@ -236,10 +408,13 @@ func (s *Store) Get(key string) (string, bool) {
 // Returns true if the key existed, false if it didn't.
 // Does not persist - call Save() after making changes.
 func (s *Store) Delete(key string) bool {
-	if _, ok := s.Variables[key]; !ok {
+	prev, ok := s.Variables[key]
+	if !ok {
 		return false
 	}
+	s.record(key, OpDelete, prev, true, "")
 	delete(s.Variables, key)
+	delete(s.meta, key)
 	return true
 }
 
@@ -263,16 +438,79 @@ func (s *Store) IsEncrypted() bool {
 	return s.encrypted
 }
 
+// Recipients returns the public keys the store is encrypted to, or nil if
+// it is not encrypted to recipients.
+func (s *Store) Recipients() []crypto.Recipient {
+	return s.recipients
+}
+
 // EnableEncryption enables encryption for the store.
 // Requires VARNISH_PASSWORD to be set.
 func (s *Store) EnableEncryption() error {
 	if _, err := crypto.GetPassword(); err != nil {
 		return err
 	}
-	s.encrypted = true
+	if !s.encrypted {
+		s.encrypted = true
+		s.dirty = true
+	}
+	return nil
+}
+
+// KDFParams returns the key derivation parameters the store is encrypted
+// with when saved: those set with SetKDFParams, else the store's current
+// parameters (the defaults for a new or version 1 store) with any set in
+// ~/.varnish/config.yaml applied on top.
+func (s *Store) KDFParams() (crypto.KDFParams, error) {
+	if s.kdfOverride != nil {
+		return *s.kdfOverride, nil
+	}
+	return configuredKDFParams(s.kdf)
+}
+
+// SetKDFParams sets the key derivation parameters for the next save,
+// overriding ~/.varnish/config.yaml.
+func (s *Store) SetKDFParams(params crypto.KDFParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	s.kdfOverride = &params
+	s.dirty = true
 	return nil
 }
 
+// configuredKDFParams applies the parameters in ~/.varnish/config.yaml to
+// current, or to the defaults if current is zero.
+func configuredKDFParams(current crypto.KDFParams) (crypto.KDFParams, error) {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return crypto.KDFParams{}, err
+	}
+
+	params := current
+	if params == (crypto.KDFParams{}) {
+		params = crypto.DefaultKDFParams
+	}
+	kdf := settings.Encryption.KDF
+	if kdf.Memory > crypto.MaxKDFMemory/1024 || kdf.Time > crypto.MaxKDFTime || kdf.Threads > crypto.MaxKDFThreads {
+		return crypto.KDFParams{}, fmt.Errorf("encryption.kdf in config.yaml: values too large (max memory %d, time %d, threads %d)",
+			crypto.MaxKDFMemory/1024, crypto.MaxKDFTime, crypto.MaxKDFThreads)
+	}
+	if kdf.Memory > 0 {
+		params.Memory = uint32(kdf.Memory) * 1024
+	}
+	if kdf.Time > 0 {
+		params.Time = uint32(kdf.Time)
+	}
+	if kdf.Threads > 0 {
+		params.Threads = uint8(kdf.Threads)
+	}
+	if err := params.Validate(); err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("encryption.kdf in config.yaml: %w", err)
+	}
+	return params, nil
+}
+
 // Remove deletes the store file from disk.
 // Returns nil if the file doesn't exist.
 func Remove() error {
@@ -281,6 +519,12 @@ func Remove() error {
 		return fmt.Errorf("get store path: %w", err)
 	}
 
+	lk, err := lock.Acquire(path)
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("remove store: %w", err)
 	}
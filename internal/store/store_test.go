@@ -1,11 +1,15 @@
 package store
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/dk/varnish/internal/config"
 	"github.com/dk/varnish/internal/crypto"
 )
 
@@ -26,8 +30,8 @@ func unsetenv(t *testing.T, key string) {
 func TestNew(t *testing.T) {
 	s := New()
 
-	if s.Version != 1 {
-		t.Errorf("expected version 1, got %d", s.Version)
+	if s.Version != CurrentVersion {
+		t.Errorf("expected version %d, got %d", CurrentVersion, s.Version)
 	}
 	if s.Variables == nil {
 		t.Error("expected Variables to be initialized")
@@ -197,8 +201,8 @@ func TestSaveLoad(t *testing.T) {
 	}
 
 	// Verify
-	if loaded.Version != 1 {
-		t.Errorf("loaded version = %d, want 1", loaded.Version)
+	if loaded.Version != CurrentVersion {
+		t.Errorf("loaded version = %d, want %d", loaded.Version, CurrentVersion)
 	}
 
 	val, ok := loaded.Get("project.db.host")
@@ -573,3 +577,149 @@ func TestRemove(t *testing.T) {
 		t.Errorf("Remove() on non-existent store should not error: %v", err)
 	}
 }
+
+// writeVersion1Store writes plaintext YAML as a version 1 encrypted store,
+// the format written before key derivation parameters were recorded.
+func writeVersion1Store(t *testing.T, yamlData, password string) string {
+	t.Helper()
+	salt := bytes.Repeat([]byte{1}, 16)
+	nonce := bytes.Repeat([]byte{2}, 12)
+	block, err := aes.NewCipher(crypto.DeriveKey(password, salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{}, crypto.MagicBytes...)
+	data = append(data, crypto.VersionLegacy)
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	data = gcm.Seal(data, nonce, []byte(yamlData), nil)
+
+	if err := config.EnsureVarnishDir(); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := config.StorePath()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// storeHeader parses the header of the store file at path.
+func storeHeader(t *testing.T, path string) *crypto.Header {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := crypto.ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader() error: %v", err)
+	}
+	return h
+}
+
+func TestSaveUpgradesVersion1(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(crypto.PasswordEnvVar, "testpassword")
+	path := writeVersion1Store(t, "version: 2\nvariables:\n  app.name:\n    value: legacy\n", "testpassword")
+
+	err := Update(func(s *Store) error {
+		if v, _ := s.Get("app.name"); v != "legacy" {
+			t.Errorf("app.name = %q, want legacy", v)
+		}
+		s.Set("app.name", "upgraded")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+
+	h := storeHeader(t, path)
+	if h.Version != crypto.Version || h.KDF != crypto.DefaultKDFParams {
+		t.Errorf("header = version %d, %v; want %d, defaults", h.Version, h.KDF, crypto.Version)
+	}
+}
+
+func TestKDFParamsFromConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(crypto.PasswordEnvVar, "testpassword")
+	if err := config.EnsureVarnishDir(); err != nil {
+		t.Fatal(err)
+	}
+	configPath, _ := config.ConfigPath()
+	settings := "encryption:\n  kdf:\n    memory: 8\n    time: 2\n"
+	if err := os.WriteFile(configPath, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New()
+	s.Set("app.name", "tuned")
+	if err := s.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	path, _ := config.StorePath()
+	want := crypto.KDFParams{Time: 2, Memory: 8 * 1024, Threads: crypto.DefaultKDFParams.Threads}
+	if h := storeHeader(t, path); h.KDF != want {
+		t.Errorf("KDF = %v, want %v", h.KDF, want)
+	}
+
+	// Out of range settings are an error, not silently ignored
+	if err := os.WriteFile(configPath, []byte("encryption:\n  kdf:\n    time: 1000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("app.name", "again")
+	if err := s.Save(); err == nil {
+		t.Error("Save() should fail with out of range KDF settings")
+	}
+}
+
+func TestSetKDFParamsKeptOnSave(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(crypto.PasswordEnvVar, "testpassword")
+
+	params := crypto.KDFParams{Time: 3, Memory: 16 * 1024, Threads: 2}
+	s := New()
+	if err := s.SetKDFParams(params); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	// A later command keeps the parameters the store was encrypted with
+	err := Update(func(s *Store) error {
+		s.Set("app.name", "later")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	path, _ := config.StorePath()
+	if h := storeHeader(t, path); h.KDF != params {
+		t.Errorf("KDF = %v, want %v", h.KDF, params)
+	}
+
+	// Rekeying keeps them too
+	if _, err := Rekey("new-password"); err != nil {
+		t.Fatalf("Rekey() error: %v", err)
+	}
+	if h := storeHeader(t, path); h.KDF != params {
+		t.Errorf("KDF after rekey = %v, want %v", h.KDF, params)
+	}
+
+	if err := s.SetKDFParams(crypto.KDFParams{}); err == nil {
+		t.Error("SetKDFParams() should reject invalid parameters")
+	}
+}
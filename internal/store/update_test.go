@@ -0,0 +1,108 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// helperEnv makes the test binary act as a store client instead of
+// running tests (see TestHelperIncrement).
+const helperEnv = "VARNISH_TEST_STORE_HELPER"
+
+// TestHelperIncrement increments "counter" in the store several times. It
+// only runs as a subprocess of TestUpdateConcurrentProcesses.
+func TestHelperIncrement(t *testing.T) {
+	if os.Getenv(helperEnv) != "increment" {
+		t.Skip("helper process")
+	}
+	for i := 0; i < 10; i++ {
+		err := Update(func(s *Store) error {
+			v, _ := s.Get("counter")
+			n, _ := strconv.Atoi(v)
+			s.Set("counter", strconv.Itoa(n+1))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update() error: %v", err)
+		}
+	}
+}
+
+func TestUpdateConcurrentProcesses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	const procs = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, procs)
+	for i := 0; i < procs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperIncrement$")
+			cmd.Env = append(os.Environ(), helperEnv+"=increment")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- errors.New(string(out))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("helper process failed: %v", err)
+	}
+
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("counter"); v != strconv.Itoa(procs*10) {
+		t.Errorf("counter = %s, want %d (lost updates)", v, procs*10)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	err := Update(func(s *Store) error {
+		s.Set("app.name", "demo")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	s, _ := Load()
+	if v, _ := s.Get("app.name"); v != "demo" {
+		t.Errorf("app.name = %q, want demo", v)
+	}
+
+	// An error from fn discards the changes
+	errBoom := errors.New("boom")
+	err = Update(func(s *Store) error {
+		s.Set("app.name", "changed")
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("Update() error = %v, want boom", err)
+	}
+	s, _ = Load()
+	if v, _ := s.Get("app.name"); v != "demo" {
+		t.Errorf("app.name = %q after failed update, want demo", v)
+	}
+
+	// No change, no write: the history stays the same
+	err = Update(func(s *Store) error {
+		s.Set("app.name", "demo")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := LoadHistory()
+	if got := len(h.For("app.name")); got != 1 {
+		t.Errorf("history has %d changes, want 1", got)
+	}
+}